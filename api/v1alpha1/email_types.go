@@ -27,6 +27,18 @@ type EmailSpec struct {
 	CustomerID string `json:"customerId,omitempty"`
 }
 
+// ProviderMessageIDLabel is set on an Email once sent, to the ID the
+// provider assigned it, so webhook events can be matched to the resource.
+const ProviderMessageIDLabel = "email.example.com/provider-message-id"
+
+// DeliveryEvent is an event reported by the provider after sending, such
+// as a delivery, bounce or open.
+type DeliveryEvent struct {
+	Type string `json:"type"`
+	Timestamp metav1.Time `json:"timestamp"`
+	Details string `json:"details,omitempty"`
+}
+
 type EmailStatus struct {
 	DeliveryStatus string `json:"deliveryStatus,omitempty"`
 	MessageID string `json:"messageId,omitempty"`
@@ -35,8 +47,14 @@ type EmailStatus struct {
 	SentAt *metav1.Time `json:"sentAt,omitempty"`
 	LastAttemptAt *metav1.Time `json:"lastAttemptAt,omitempty"`
 	FailureReason string `json:"failureReason,omitempty"`
+	// Provider is the provider that sent the email and SenderConfig the
+	// EmailSenderConfig it was sent through, which differs from
+	// SenderConfigRef when that is a composite config.
 	Provider string `json:"provider,omitempty"`
+	SenderConfig string `json:"senderConfig,omitempty"`
 	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Events holds the most recent provider events, oldest first.
+	Events []DeliveryEvent `json:"events,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -2,17 +2,81 @@ package v1alpha1
 
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 type EmailSenderConfigSpec struct {
+	// Provider names a registered provider: native-smtp, direct-mx, resend,
+	// sendgrid, mailgun, postmark or ses. A composite config routes across
+	// other configs, listed in Routing.
 	Provider string `json:"provider"`
-	APITokenSecretRef string `json:"apiTokenSecretRef"`
+	// APITokenSecretRef names a secret whose password key holds the API
+	// key or SMTP password. For ses it holds the secret access key, and the
+	// username key holds the access key ID. Composite and direct-mx
+	// configs have none.
+	APITokenSecretRef string `json:"apiTokenSecretRef,omitempty"`
 	SenderEmail string `json:"senderEmail"`
 	FromName string `json:"fromName,omitempty"`
+	// Domain is the SMTP host for native-smtp. Port is its port, or for
+	// direct-mx the port of MX hosts, which defaults to 25.
 	Domain string `json:"domain,omitempty"`
 	Port int `json:"port,omitempty"`
 	Timeout int `json:"timeout,omitempty"`
+	// TLSMode controls TLS for native-smtp: opportunistic (default), required,
+	// implicit (SMTPS) or none.
+	TLSMode string `json:"tlsMode,omitempty"`
+	// Options are provider-specific settings such as a region, validated
+	// by the provider. GET /api/v1/providers lists what each accepts.
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	Options *runtime.RawExtension `json:"options,omitempty"`
 	CustomerID string `json:"customerId,omitempty"`
+	// Routing lists the backends of a composite config.
+	Routing *RoutingSpec `json:"routing,omitempty"`
+	// DKIM signs mail sent by native-smtp and direct-mx configs.
+	DKIM *DKIMSpec `json:"dkim,omitempty"`
+}
+
+// DKIMSpec is the key mail is signed with. Its public key must be
+// published as a TXT record at <selector>._domainkey.<domain>.
+type DKIMSpec struct {
+	Selector string `json:"selector"`
+	// Domain is the signing domain (d=). It defaults to the domain of
+	// SenderEmail.
+	Domain string `json:"domain,omitempty"`
+	// PrivateKeySecretRef names a secret whose private-key key holds the
+	// PEM-encoded RSA or Ed25519 private key.
+	PrivateKeySecretRef string `json:"privateKeySecretRef"`
+}
+
+// RoutingSpec is how a composite config picks a backend. With the priority
+// strategy the lowest priority backend is used while it is healthy; with
+// the weighted strategy mail is spread across backends by weight. Either
+// way, transient failures fail over to the remaining backends. Mail is sent
+// as the composite config's sender through every backend.
+type RoutingSpec struct {
+	// Strategy is priority (default) or weighted.
+	Strategy string `json:"strategy,omitempty"`
+	Backends []RoutingBackend `json:"backends"`
+}
+
+type RoutingBackend struct {
+	// SenderConfigRef names an EmailSenderConfig in the same namespace,
+	// which must not itself be composite.
+	SenderConfigRef string `json:"senderConfigRef"`
+	Priority int `json:"priority,omitempty"`
+	Weight int `json:"weight,omitempty"`
+}
+
+// BackendStatus is the health of a composite config's backend, as seen by
+// the operator's circuit breaker for it.
+type BackendStatus struct {
+	Name string `json:"name"`
+	// State is closed (healthy), open (skipped) or half-open (on trial).
+	State string `json:"state"`
+	ConsecutiveFailures int `json:"consecutiveFailures,omitempty"`
+	LastError string `json:"lastError,omitempty"`
+	LastFailureAt *metav1.Time `json:"lastFailureAt,omitempty"`
 }
 
 type EmailSenderConfigStatus struct {
@@ -22,6 +86,8 @@ type EmailSenderConfigStatus struct {
 	LastError string `json:"lastError,omitempty"`
 	ProviderVerified bool `json:"providerVerified,omitempty"`
 	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Backends reports the health of a composite config's backends.
+	Backends []BackendStatus `json:"backends,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -0,0 +1,49 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type SuppressionEntry struct {
+	Email string `json:"email"`
+	// Reason is one of bounce, complaint, unsubscribe or manual.
+	Reason string `json:"reason,omitempty"`
+	Details string `json:"details,omitempty"`
+	AddedAt *metav1.Time `json:"addedAt,omitempty"`
+}
+
+// EmailSuppressionSpec lists addresses that Emails in the same namespace must
+// not be sent to. Entries are stored in the object itself, so a single list
+// is best kept to tens of thousands of addresses.
+type EmailSuppressionSpec struct {
+	// CustomerID limits the list to Emails with the same spec.customerId.
+	// When empty the list applies to every Email in the namespace.
+	CustomerID string `json:"customerId,omitempty"`
+	// AutoSuppress lets the operator append recipients whose sends fail
+	// permanently.
+	AutoSuppress bool `json:"autoSuppress,omitempty"`
+	Entries []SuppressionEntry `json:"entries,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:shortName=esup
+//+kubebuilder:printcolumn:name="Customer",type=string,JSONPath=`.spec.customerId`
+//+kubebuilder:printcolumn:name="Auto",type=boolean,JSONPath=`.spec.autoSuppress`
+
+type EmailSuppression struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec EmailSuppressionSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+type EmailSuppressionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []EmailSuppression `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&EmailSuppression{}, &EmailSuppressionList{})
+}
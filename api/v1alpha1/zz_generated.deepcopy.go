@@ -33,8 +33,59 @@ func (in *EmailSenderConfig) DeepCopyInto(out *EmailSenderConfig) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+func (in *EmailSenderConfigSpec) DeepCopyInto(out *EmailSenderConfigSpec) {
+	*out = *in
+	if in.Options != nil {
+		in, out := &in.Options, &out.Options
+		*out = new(runtime.RawExtension)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Routing != nil {
+		in, out := &in.Routing, &out.Routing
+		*out = new(RoutingSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DKIM != nil {
+		in, out := &in.DKIM, &out.DKIM
+		*out = new(DKIMSpec)
+		**out = **in
+	}
+}
+
+func (in *RoutingSpec) DeepCopyInto(out *RoutingSpec) {
+	*out = *in
+	if in.Backends != nil {
+		in, out := &in.Backends, &out.Backends
+		*out = make([]RoutingBackend, len(*in))
+		copy(*out, *in)
+	}
+}
+
+func (in *EmailSenderConfigStatus) DeepCopyInto(out *EmailSenderConfigStatus) {
+	*out = *in
+	if in.LastValidated != nil {
+		in, out := &in.LastValidated, &out.LastValidated
+		*out = (*in).DeepCopy()
+	}
+	if in.Backends != nil {
+		in, out := &in.Backends, &out.Backends
+		*out = make([]BackendStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+func (in *BackendStatus) DeepCopyInto(out *BackendStatus) {
+	*out = *in
+	if in.LastFailureAt != nil {
+		in, out := &in.LastFailureAt, &out.LastFailureAt
+		*out = (*in).DeepCopy()
+	}
 }
 
 func (in *EmailSenderConfigList) DeepCopy() *EmailSenderConfigList {
@@ -73,7 +124,31 @@ func (in *Email) DeepCopyInto(out *Email) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+func (in *EmailStatus) DeepCopyInto(out *EmailStatus) {
+	*out = *in
+	if in.SentAt != nil {
+		in, out := &in.SentAt, &out.SentAt
+		*out = (*in).DeepCopy()
+	}
+	if in.LastAttemptAt != nil {
+		in, out := &in.LastAttemptAt, &out.LastAttemptAt
+		*out = (*in).DeepCopy()
+	}
+	if in.Events != nil {
+		in, out := &in.Events, &out.Events
+		*out = make([]DeliveryEvent, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+func (in *DeliveryEvent) DeepCopyInto(out *DeliveryEvent) {
+	*out = *in
+	in.Timestamp.DeepCopyInto(&out.Timestamp)
 }
 
 func (in *EmailList) DeepCopy() *EmailList {
@@ -97,3 +172,68 @@ func (in *EmailList) DeepCopyInto(out *EmailList) {
 		}
 	}
 }
+
+func (in *EmailSuppression) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+func (in *EmailSuppressionList) DeepCopyObject() runtime.Object {
+	return in.DeepCopy()
+}
+
+func (in *EmailSuppression) DeepCopy() *EmailSuppression {
+	if in == nil {
+		return nil
+	}
+	out := new(EmailSuppression)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *EmailSuppression) DeepCopyInto(out *EmailSuppression) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+func (in *EmailSuppressionSpec) DeepCopyInto(out *EmailSuppressionSpec) {
+	*out = *in
+	if in.Entries != nil {
+		in, out := &in.Entries, &out.Entries
+		*out = make([]SuppressionEntry, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+func (in *SuppressionEntry) DeepCopyInto(out *SuppressionEntry) {
+	*out = *in
+	if in.AddedAt != nil {
+		in, out := &in.AddedAt, &out.AddedAt
+		*out = (*in).DeepCopy()
+	}
+}
+
+func (in *EmailSuppressionList) DeepCopy() *EmailSuppressionList {
+	if in == nil {
+		return nil
+	}
+	out := new(EmailSuppressionList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+func (in *EmailSuppressionList) DeepCopyInto(out *EmailSuppressionList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]EmailSuppression, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
@@ -1,14 +1,33 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/gin-gonic/gin"
 	"github.com/Gatete-Bruno/besend/pkg/database"
 	"github.com/Gatete-Bruno/besend/pkg/api/handlers"
-	"github.com/Gatete-Bruno/besend/pkg/api/middleware"
+	"github.com/Gatete-Bruno/besend/pkg/bounce"
+	"github.com/Gatete-Bruno/besend/pkg/kubernetes"
+	"github.com/Gatete-Bruno/besend/pkg/migrate"
+	"github.com/Gatete-Bruno/besend/pkg/secrets"
+	"github.com/Gatete-Bruno/besend/pkg/svix"
+	"github.com/Gatete-Bruno/besend/pkg/tracking"
+	"github.com/Gatete-Bruno/besend/pkg/unsubscribe"
+)
+
+const (
+	// exportJobStaleAfter is how long an export job may go without progress
+	// before it is assumed to have died with its replica.
+	exportJobStaleAfter = 30 * time.Minute
+	// exportCleanupInterval is how often expired and stale export jobs are
+	// cleaned up.
+	exportCleanupInterval = 10 * time.Minute
+	// webhookPollInterval is how often queued webhook events are sent.
+	webhookPollInterval = 5 * time.Second
 )
 
 func main() {
@@ -34,39 +53,99 @@ func main() {
 	}
 	defer database.Close()
 
-	if err := database.InitSchema(); err != nil {
-		log.Fatalf("Failed to initialize schema: %v", err)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrator, err := database.NewMigrator()
+		if err != nil {
+			log.Fatalf("Failed to load migrations: %v", err)
+		}
+		if err := migrate.Run(context.Background(), migrator, os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
 	}
 
-	r := gin.Default()
-
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{"status": "healthy"})
-	})
-
-	api := r.Group("/api/v1")
-	{
-		api.POST("/register", handlers.Register)
-		api.POST("/login", handlers.Login)
-
-		protected := api.Group("")
-		protected.Use(middleware.AuthMiddleware())
-		{
-			protected.GET("/me", handlers.GetCustomerInfo)
+	// Set AUTO_MIGRATE=false where migrations run as a separate step.
+	if getEnv("AUTO_MIGRATE", "true") != "false" {
+		if err := database.Migrate(context.Background()); err != nil {
+			log.Fatalf("Failed to initialize schema: %v", err)
+		}
+	}
 
-			protected.POST("/smtp", handlers.CreateSMTPConfig)
-			protected.GET("/smtp", handlers.GetSMTPConfigs)
-			protected.DELETE("/smtp/:id", handlers.DeleteSMTPConfig)
+	keyring, err := secrets.LoadKeyring()
+	if err != nil {
+		log.Fatalf("Failed to load encryption keys: %v", err)
+	}
+	database.Keyring = keyring
 
-			protected.POST("/emails/send", handlers.SendEmail)
-			protected.GET("/emails", handlers.GetEmailHistory)
-			protected.GET("/emails/stats", handlers.GetEmailStats)
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1])
+		return
+	}
 
-			protected.POST("/keys", handlers.CreateAPIKey)
-			protected.GET("/keys", handlers.GetAPIKeys)
-			protected.DELETE("/keys/:id", handlers.DeleteAPIKey)
+	if secret := os.Getenv("UNSUBSCRIBE_SECRET"); secret != "" {
+		signer, err := unsubscribe.NewSigner([]byte(secret))
+		if err != nil {
+			log.Fatalf("Invalid UNSUBSCRIBE_SECRET: %v", err)
+		}
+		handlers.UnsubscribeSigner = signer
+	}
+	handlers.PublicBaseURL = strings.TrimRight(os.Getenv("PUBLIC_BASE_URL"), "/")
+	if handlers.UnsubscribeSigner == nil || handlers.PublicBaseURL == "" {
+		log.Println("UNSUBSCRIBE_SECRET or PUBLIC_BASE_URL not set; List-Unsubscribe headers are disabled")
+	}
+	if secret := os.Getenv("TRACKING_SECRET"); secret != "" && handlers.PublicBaseURL != "" {
+		tracker, err := tracking.NewSigner([]byte(secret), handlers.PublicBaseURL)
+		if err != nil {
+			log.Fatalf("Invalid tracking configuration: %v", err)
+		}
+		handlers.Tracker = tracker
+	} else {
+		log.Println("TRACKING_SECRET or PUBLIC_BASE_URL not set; open and click tracking are disabled")
+	}
+	if address, secret := os.Getenv("BOUNCE_ADDRESS"), os.Getenv("BOUNCE_SECRET"); address != "" && secret != "" {
+		returnPath, err := bounce.NewReturnPath(address, []byte(secret))
+		if err != nil {
+			log.Fatalf("Invalid bounce configuration: %v", err)
 		}
+		handlers.ReturnPath = returnPath
 	}
+	if secret := os.Getenv("RESEND_WEBHOOK_SECRET"); secret != "" {
+		verifier, err := svix.NewVerifier(secret)
+		if err != nil {
+			log.Fatalf("Invalid RESEND_WEBHOOK_SECRET: %v", err)
+		}
+		handlers.ResendWebhookVerifier = verifier
+	}
+	if os.Getenv("DKIM_SIGNING") == "true" {
+		// Keys are in dkim_keys (migration 0002) and are managed through
+		// smtp-manager.
+		handlers.DKIMSigners = database.DKIMSigner
+	}
+	// Mail is only sent from verified sending domains unless this is
+	// explicitly turned off, e.g. for local development.
+	if os.Getenv("ALLOW_UNVERIFIED_DOMAINS") != "true" {
+		handlers.DomainVerified = database.SendingDomainVerified
+	}
+	database.AuditHashChain = os.Getenv("AUDIT_HASH_CHAIN") == "true"
+	handlers.AllowPrivateSMTPHosts = os.Getenv("ALLOW_PRIVATE_SMTP_HOSTS") == "true"
+	handlers.AllowPrivateWebhookURLs = os.Getenv("ALLOW_PRIVATE_WEBHOOK_URLS") == "true"
+	if os.Getenv("EMAIL_RESOURCES_ENABLED") == "true" {
+		k8sClient, err := kubernetes.NewK8sClient(os.Getenv("KUBECONFIG"))
+		if err != nil {
+			log.Fatalf("Failed to create Kubernetes client: %v", err)
+		}
+		handlers.EmailResources = k8sClient
+	}
+
+	cleanUpExportJobs()
+	go func() {
+		for range time.Tick(exportCleanupInterval) {
+			cleanUpExportJobs()
+		}
+	}()
+	go handlers.DeliverWebhooks(webhookPollInterval)
+
+	r := handlers.NewServer(database.Postgres()).Router()
 
 	port := getEnv("API_PORT", "8080")
 	log.Printf("API Server starting on port %s", port)
@@ -75,6 +154,35 @@ func main() {
 	}
 }
 
+// cleanUpExportJobs deletes expired export jobs and fails the ones whose
+// replica stopped running them.
+func cleanUpExportJobs() {
+	if failed, deleted, err := database.ExpireExportJobs(exportJobStaleAfter); err != nil {
+		log.Printf("Failed to clean up export jobs: %v", err)
+	} else if failed+deleted > 0 {
+		log.Printf("Cleaned up export jobs: %d interrupted, %d expired", failed, deleted)
+	}
+}
+
+// runCommand executes a one-off maintenance command instead of starting the server.
+func runCommand(name string) {
+	switch name {
+	case "reencrypt-secrets":
+		n, err := database.ReencryptSecrets()
+		if err != nil {
+			log.Fatalf("Failed to re-encrypt secrets: %v", err)
+		}
+		log.Printf("Re-encrypted %d secrets", n)
+	case "rebuild-stats":
+		if err := database.RebuildEmailStats(); err != nil {
+			log.Fatalf("Failed to rebuild stats: %v", err)
+		}
+		log.Println("Rebuilt email stats rollup")
+	default:
+		log.Fatalf("Unknown command %q (available: migrate, reencrypt-secrets, rebuild-stats)", name)
+	}
+}
+
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
 	if value == "" {
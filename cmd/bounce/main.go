@@ -0,0 +1,190 @@
+// Command bounce processes asynchronous bounces and complaint reports sent
+// to the bounce mailbox, updating email statuses and the suppression list.
+//
+//	bounce [serve]          receive reports over SMTP on BOUNCE_LISTEN_ADDR
+//	bounce maildir <dir>    process new messages in a Maildir once
+//	bounce mbox <file>      process every message in an mbox file
+//	bounce parse <file>...  print the parsed reports without a database
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/Gatete-Bruno/besend/pkg/bounce"
+	"github.com/Gatete-Bruno/besend/pkg/database"
+)
+
+func main() {
+	command, args := "serve", []string(nil)
+	if len(os.Args) > 1 {
+		command, args = os.Args[1], os.Args[2:]
+	}
+
+	if command == "parse" {
+		if len(args) == 0 {
+			log.Fatal("usage: bounce parse <file>...")
+		}
+		os.Exit(parseFiles(args))
+	}
+
+	returnPath, err := loadReturnPath()
+	if err != nil {
+		log.Fatalf("Invalid bounce configuration: %v", err)
+	}
+	if returnPath == nil {
+		log.Println("BOUNCE_ADDRESS or BOUNCE_SECRET not set; reports are matched by Message-ID only")
+	}
+
+	dbPort, _ := strconv.Atoi(getEnv("DATABASE_PORT", "5432"))
+	if err := database.Connect(database.Config{
+		Host:     getEnv("DATABASE_HOST", "localhost"),
+		Port:     dbPort,
+		User:     getEnv("DATABASE_USER", "besenduser"),
+		Password: getEnv("DATABASE_PASSWORD", "besend"),
+		DBName:   getEnv("DATABASE_NAME", "besend"),
+		SSLMode:  getEnv("SSL_MODE", "disable"),
+	}); err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer database.Close()
+
+	processor := &bounce.Processor{ReturnPath: returnPath}
+	handle := func(envelopeTo []string, data []byte) error {
+		result, err := processor.Process(envelopeTo, data)
+		if errors.Is(err, bounce.ErrNotReport) {
+			log.Printf("Ignoring message that is not a report")
+			return nil
+		}
+		if err != nil {
+			var parseErr bounce.ParseError
+			if errors.As(err, &parseErr) {
+				// Retrying will not make a malformed message parse.
+				log.Printf("Ignoring malformed report: %v", err)
+				return nil
+			}
+			return err
+		}
+		logResult(result)
+		return nil
+	}
+
+	switch command {
+	case "serve":
+		serve(handle)
+	case "maildir":
+		if len(args) != 1 {
+			log.Fatal("usage: bounce maildir <dir>")
+		}
+		n, err := bounce.ProcessMaildir(args[0], func(data []byte) error {
+			return handle(nil, data)
+		})
+		log.Printf("Processed %d messages", n)
+		if err != nil {
+			log.Fatalf("Failed to process maildir: %v", err)
+		}
+	case "mbox":
+		if len(args) != 1 {
+			log.Fatal("usage: bounce mbox <file>")
+		}
+		f, err := os.Open(args[0])
+		if err != nil {
+			log.Fatalf("Failed to open mbox: %v", err)
+		}
+		defer f.Close()
+		if err := bounce.ReadMbox(f, func(data []byte) error {
+			return handle(nil, data)
+		}); err != nil {
+			log.Fatalf("Failed to process mbox: %v", err)
+		}
+	default:
+		log.Fatalf("Unknown command %q", command)
+	}
+}
+
+func serve(handle bounce.HandlerFunc) {
+	server := &bounce.Server{
+		Addr:     getEnv("BOUNCE_LISTEN_ADDR", ":2525"),
+		Hostname: getEnv("BOUNCE_HOSTNAME", "localhost"),
+		Handler:  handle,
+	}
+	if address := os.Getenv("BOUNCE_ADDRESS"); address != "" {
+		_, domain, _ := strings.Cut(address, "@")
+		server.Domains = []string{domain}
+	}
+
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+		<-stop
+		server.Close()
+	}()
+
+	log.Printf("Bounce processor listening on %s", server.Addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, bounce.ErrServerClosed) {
+		log.Fatalf("Bounce server failed: %v", err)
+	}
+}
+
+func loadReturnPath() (*bounce.ReturnPath, error) {
+	address, secret := os.Getenv("BOUNCE_ADDRESS"), os.Getenv("BOUNCE_SECRET")
+	if address == "" || secret == "" {
+		return nil, nil
+	}
+	return bounce.NewReturnPath(address, []byte(secret))
+}
+
+func logResult(r *bounce.Result) {
+	switch {
+	case r.EmailID == 0:
+		log.Printf("No email matched %s report (message-id %q)", r.Report.Type, r.Report.OriginalMessageID)
+	case r.Status == "":
+		log.Printf("Email %d: %s report needs no action", r.EmailID, r.Report.Type)
+	default:
+		log.Printf("Email %d: %s (changed=%t, suppressed=%t)", r.EmailID, r.Status, r.Changed, r.Suppressed)
+	}
+}
+
+// parseFiles prints each file's report as JSON, for checking how a sample
+// is understood. It returns the exit code.
+func parseFiles(paths []string) int {
+	code := 0
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+			continue
+		}
+		report, err := bounce.Parse(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			code = 1
+			continue
+		}
+		enc.Encode(struct {
+			File string `json:"file"`
+			*bounce.Report
+		}{path, report})
+	}
+	return code
+}
+
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -3,6 +3,7 @@ package main
 import (
     "flag"
     "os"
+    "strconv"
 
     "k8s.io/apimachinery/pkg/runtime"
     utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -14,6 +15,9 @@ import (
 
     emailv1alpha1 "github.com/Gatete-Bruno/besend/api/v1alpha1"
     "github.com/Gatete-Bruno/besend/internal/controller"
+    "github.com/Gatete-Bruno/besend/internal/provider"
+    "github.com/Gatete-Bruno/besend/internal/webhook"
+    "github.com/Gatete-Bruno/besend/pkg/database"
 )
 
 var (
@@ -54,14 +58,51 @@ func main() {
         os.Exit(1)
     }
 
+    // EmailSuppression resources are always consulted. The API's suppression
+    // table is added when a database is configured.
+    suppressions := controller.SuppressionLists{
+        controller.ResourceSuppressionList{Client: mgr.GetClient()},
+    }
+    if host := os.Getenv("DATABASE_HOST"); host != "" {
+        port, _ := strconv.Atoi(getEnv("DATABASE_PORT", "5432"))
+        if err := database.Connect(database.Config{
+            Host:     host,
+            Port:     port,
+            User:     getEnv("DATABASE_USER", "besenduser"),
+            Password: os.Getenv("DATABASE_PASSWORD"),
+            DBName:   getEnv("DATABASE_NAME", "besend"),
+            SSLMode:  getEnv("SSL_MODE", "disable"),
+        }); err != nil {
+            setupLog.Error(err, "unable to connect to database")
+            os.Exit(1)
+        }
+        defer database.Close()
+        suppressions = append(suppressions, controller.DatabaseSuppressionList{})
+    }
+
+    smtpPools := provider.NewSMTPPools()
+    defer smtpPools.Close()
+
     if err = (&controller.EmailReconciler{
-        Client: mgr.GetClient(),
-        Scheme: mgr.GetScheme(),
+        Client:       mgr.GetClient(),
+        Scheme:       mgr.GetScheme(),
+        Suppressions: suppressions,
+        Breakers:     provider.NewBreakers(provider.DefaultBreakerThreshold, provider.DefaultBreakerCooldown),
+        SMTPPools:    smtpPools,
     }).SetupWithManager(mgr); err != nil {
         setupLog.Error(err, "unable to create controller", "controller", "Email")
         os.Exit(1)
     }
 
+    // The validating webhook needs a serving certificate, see
+    // k8s/operator-webhook.yaml, so it is opt-in.
+    if os.Getenv("ENABLE_WEBHOOKS") == "true" {
+        if err := (&webhook.EmailSenderConfigValidator{}).SetupWithManager(mgr); err != nil {
+            setupLog.Error(err, "unable to create webhook", "webhook", "EmailSenderConfig")
+            os.Exit(1)
+        }
+    }
+
     if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
         setupLog.Error(err, "unable to set up health check")
         os.Exit(1)
@@ -77,3 +118,11 @@ func main() {
         os.Exit(1)
     }
 }
+
+func getEnv(key, defaultValue string) string {
+    value := os.Getenv(key)
+    if value == "" {
+        return defaultValue
+    }
+    return value
+}
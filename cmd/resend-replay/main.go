@@ -0,0 +1,111 @@
+// Command resend-replay signs recorded Resend webhook payloads and posts
+// them to a besend API, for exercising webhook handling locally without a
+// Resend account. Files are sent in the order given.
+//
+//	resend-replay -secret whsec_... cmd/resend-replay/testdata/*.json
+//
+// Each payload gets a message ID derived from its content, so replaying a
+// file twice checks that retries are deduplicated; -unique gives every
+// request a fresh ID instead. -email-id rewrites data.email_id so the
+// recordings can target an email that exists in the local database.
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Gatete-Bruno/besend/pkg/svix"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:8080/webhooks/resend", "webhook endpoint to post to")
+	secret := flag.String("secret", os.Getenv("RESEND_WEBHOOK_SECRET"), "webhook signing secret (whsec_...)")
+	emailID := flag.String("email-id", "", "replace data.email_id in every payload")
+	unique := flag.Bool("unique", false, "give every request a new message ID")
+	flag.Parse()
+
+	if *secret == "" || flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: resend-replay -secret whsec_... [-url URL] [-email-id ID] [-unique] payload.json...")
+		os.Exit(2)
+	}
+	signer, err := svix.NewVerifier(*secret)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		body, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *emailID != "" {
+			if body, err = setEmailID(body, *emailID); err != nil {
+				log.Fatalf("%s: %v", path, err)
+			}
+		}
+
+		sum := sha256.Sum256(body)
+		id := "msg_replay_" + hex.EncodeToString(sum[:8])
+		if *unique {
+			random := make([]byte, 8)
+			rand.Read(random)
+			id = "msg_replay_" + hex.EncodeToString(random)
+		}
+
+		status, reply, err := post(*url, signer, id, body)
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+		fmt.Printf("%s %s -> %d %s\n", path, id, status, reply)
+		if status >= 300 {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func setEmailID(body []byte, emailID string) ([]byte, error) {
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	data, ok := payload["data"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("payload has no data object")
+	}
+	data["email_id"] = emailID
+	return json.Marshal(payload)
+}
+
+func post(url string, signer *svix.Verifier, id string, body []byte) (int, string, error) {
+	now := time.Now()
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("svix-id", id)
+	req.Header.Set("svix-timestamp", fmt.Sprint(now.Unix()))
+	req.Header.Set("svix-signature", signer.Sign(id, now, body))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	reply, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return resp.StatusCode, string(bytes.TrimSpace(reply)), nil
+}
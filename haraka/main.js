@@ -21,7 +21,9 @@ const server = new SMTPServer({
     }
     try {
       const result = await pgClient.query(
-        'SELECT id FROM smtp_credentials WHERE username = $1 AND password = crypt($2, password)',
+        `SELECT id FROM smtp_credentials WHERE username = $1 AND revoked_at IS NULL
+         AND (password = crypt($2, password)
+           OR (previous_expires_at > NOW() AND previous_password = crypt($2, previous_password)))`,
         [auth.username, auth.password]
       );
       if (result.rows.length === 0) {
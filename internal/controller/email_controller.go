@@ -2,23 +2,44 @@ package controller
 
 import (
         "context"
+        "fmt"
+        "sort"
+        "strings"
         "time"
 
+        "k8s.io/apimachinery/pkg/api/equality"
         "k8s.io/apimachinery/pkg/runtime"
         "k8s.io/apimachinery/pkg/types"
+        "k8s.io/apimachinery/pkg/util/validation"
         ctrl "sigs.k8s.io/controller-runtime"
+        "sigs.k8s.io/controller-runtime/pkg/builder"
         "sigs.k8s.io/controller-runtime/pkg/client"
         "sigs.k8s.io/controller-runtime/pkg/log"
+        "sigs.k8s.io/controller-runtime/pkg/predicate"
         corev1 "k8s.io/api/core/v1"
         metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
         emailv1alpha1 "github.com/Gatete-Bruno/besend/api/v1alpha1"
         "github.com/Gatete-Bruno/besend/internal/provider"
+        "github.com/Gatete-Bruno/besend/pkg/dkim"
 )
 
+// retryInterval is how long a send that failed temporarily waits before
+// it is attempted again.
+const retryInterval = 5 * time.Minute
+
 type EmailReconciler struct {
         client.Client
         Scheme *runtime.Scheme
+        // Suppressions is consulted before every send when set.
+        Suppressions SuppressionList
+        // Breakers remembers the health of composite configs' backends
+        // between reconciles. When nil, each send starts with every backend
+        // healthy.
+        Breakers *provider.Breakers
+        // SMTPPools keeps SMTP sessions open between reconciles so that
+        // bulk sends reuse them. When nil, every send connects afresh.
+        SMTPPools *provider.SMTPPools
 }
 
 func (r *EmailReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -29,10 +50,34 @@ func (r *EmailReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
                 return ctrl.Result{}, client.IgnoreNotFound(err)
         }
 
-        if email.Status.DeliveryStatus == "Sent" {
+        switch email.Status.DeliveryStatus {
+        case "Sent", "Delivered", "Bounced", "Complained", "Suppressed", "Rejected":
+                // Terminal states: the email was handed off, or will never be
+                // sent. The reconciler does not resend it.
                 return ctrl.Result{}, nil
         }
 
+        if email.Status.DeliveryStatus == "Failed" && email.Status.LastAttemptAt != nil {
+                if wait := retryInterval - time.Since(email.Status.LastAttemptAt.Time); wait > 0 {
+                        return ctrl.Result{RequeueAfter: wait}, nil
+                }
+        }
+
+        if r.Suppressions != nil {
+                reason, suppressed, err := r.Suppressions.Check(ctx, email)
+                if err != nil {
+                        log.Error(err, "failed to check suppression list")
+                        return ctrl.Result{}, err
+                }
+                if suppressed {
+                        log.Info("recipient is suppressed", "recipient", email.Spec.RecipientEmail, "reason", reason)
+                        email.Status.DeliveryStatus = "Suppressed"
+                        email.Status.Error = "recipient is on the suppression list"
+                        email.Status.FailureReason = reason
+                        return ctrl.Result{}, r.Status().Update(ctx, email)
+                }
+        }
+
         config := &emailv1alpha1.EmailSenderConfig{}
         if err := r.Get(ctx, types.NamespacedName{
                 Namespace: email.Namespace,
@@ -45,29 +90,7 @@ func (r *EmailReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
                 return ctrl.Result{}, nil
         }
 
-        secret := &corev1.Secret{}
-        if err := r.Get(ctx, types.NamespacedName{
-                Namespace: email.Namespace,
-                Name:      config.Spec.APITokenSecretRef,
-        }, secret); err != nil {
-                log.Error(err, "failed to get secret")
-                email.Status.DeliveryStatus = "Failed"
-                email.Status.Error = "Secret not found"
-                r.Status().Update(ctx, email)
-                return ctrl.Result{}, nil
-        }
-
-        providerConfig := &provider.Config{
-                Provider:    config.Spec.Provider,
-                Host:        config.Spec.Domain,
-                Port:        config.Spec.Port,
-                Username:    config.Spec.SenderEmail,
-                Password:    string(secret.Data["password"]),
-                Timeout:     config.Spec.Timeout,
-                SenderEmail: config.Spec.SenderEmail,
-        }
-
-        emailProvider, err := provider.NewProvider(providerConfig)
+        emailProvider, err := r.newProvider(ctx, config)
         if err != nil {
                 log.Error(err, "failed to create provider")
                 email.Status.DeliveryStatus = "Failed"
@@ -83,9 +106,13 @@ func (r *EmailReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
                 Subject:   email.Spec.Subject,
                 Body:      email.Spec.Body,
                 HTMLBody:  email.Spec.HTMLBody,
+                Headers:   email.Spec.CustomHeaders,
         }
 
         resp, err := emailProvider.Send(ctx, emailReq)
+        if composite, ok := emailProvider.(*provider.CompositeProvider); ok {
+                r.reportBackendHealth(ctx, config, composite)
+        }
         if err != nil {
                 log.Error(err, "failed to send email")
                 email.Status.DeliveryStatus = "Failed"
@@ -93,8 +120,32 @@ func (r *EmailReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
                 email.Status.AttemptCount++
                 now := metav1.Now()
                 email.Status.LastAttemptAt = &now
+                if provider.IsHardBounce(err) && r.Suppressions != nil {
+                        if err := r.Suppressions.Suppress(ctx, email, "bounce", err.Error()); err != nil {
+                                log.Error(err, "failed to suppress recipient")
+                        }
+                }
+                if provider.IsPermanentFailure(err) {
+                        // Retrying would be rejected the same way, or would
+                        // deliver twice to recipients that already accepted.
+                        email.Status.DeliveryStatus = "Rejected"
+                        email.Status.FailureReason = "PermanentFailure"
+                        r.Status().Update(ctx, email)
+                        return ctrl.Result{}, nil
+                }
                 r.Status().Update(ctx, email)
-                return ctrl.Result{RequeueAfter: 5 * time.Minute}, nil
+                return ctrl.Result{RequeueAfter: retryInterval}, nil
+        }
+
+        if resp.MessageID != "" && len(validation.IsValidLabelValue(resp.MessageID)) == 0 {
+                patch := client.MergeFrom(email.DeepCopy())
+                if email.Labels == nil {
+                        email.Labels = map[string]string{}
+                }
+                email.Labels[emailv1alpha1.ProviderMessageIDLabel] = resp.MessageID
+                if err := r.Patch(ctx, email, patch); err != nil {
+                        log.Error(err, "failed to label email with provider message ID")
+                }
         }
 
         email.Status.DeliveryStatus = "Sent"
@@ -102,6 +153,11 @@ func (r *EmailReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
         now := metav1.Now()
         email.Status.SentAt = &now
         email.Status.Provider = config.Spec.Provider
+        email.Status.SenderConfig = config.Name
+        if resp.Backend != "" {
+                email.Status.Provider = resp.Provider
+                email.Status.SenderConfig = resp.Backend
+        }
         if err := r.Status().Update(ctx, email); err != nil {
                 return ctrl.Result{}, err
         }
@@ -109,8 +165,152 @@ func (r *EmailReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
         return ctrl.Result{}, nil
 }
 
+// newProvider builds the provider for a sender config. A composite config
+// is built from its backends; backends that cannot be built are left out
+// so the rest can still be used.
+func (r *EmailReconciler) newProvider(ctx context.Context, config *emailv1alpha1.EmailSenderConfig) (provider.Provider, error) {
+        if config.Spec.Provider != provider.Composite {
+                return r.newBackendProvider(ctx, config)
+        }
+        if config.Spec.Routing == nil {
+                return nil, fmt.Errorf("composite config %s has no routing", config.Name)
+        }
+
+        log := log.FromContext(ctx)
+        var backends []provider.Backend
+        for _, ref := range config.Spec.Routing.Backends {
+                backend := &emailv1alpha1.EmailSenderConfig{}
+                if err := r.Get(ctx, types.NamespacedName{Namespace: config.Namespace, Name: ref.SenderConfigRef}, backend); err != nil {
+                        log.Error(err, "skipping backend", "backend", ref.SenderConfigRef)
+                        continue
+                }
+                if backend.Spec.Provider == provider.Composite {
+                        log.Info("skipping nested composite backend", "backend", ref.SenderConfigRef)
+                        continue
+                }
+                p, err := r.newBackendProvider(ctx, backend)
+                if err != nil {
+                        log.Error(err, "skipping backend", "backend", ref.SenderConfigRef)
+                        continue
+                }
+                backends = append(backends, provider.Backend{
+                        Name:     backend.Name,
+                        Key:      backend.Namespace + "/" + backend.Name,
+                        Provider: p,
+                        Priority: ref.Priority,
+                        Weight:   ref.Weight,
+                })
+        }
+        if len(backends) == 0 {
+                return nil, fmt.Errorf("composite config %s has no usable backends", config.Name)
+        }
+        return provider.NewCompositeProvider(config.Spec.Routing.Strategy, backends, r.Breakers)
+}
+
+// newBackendProvider builds a single provider from a sender config and its
+// credentials secret.
+func (r *EmailReconciler) newBackendProvider(ctx context.Context, config *emailv1alpha1.EmailSenderConfig) (provider.Provider, error) {
+        // Providers such as direct-mx need no secret.
+        secret := &corev1.Secret{}
+        if config.Spec.APITokenSecretRef != "" {
+                if err := r.Get(ctx, types.NamespacedName{
+                        Namespace: config.Namespace,
+                        Name:      config.Spec.APITokenSecretRef,
+                }, secret); err != nil {
+                        return nil, fmt.Errorf("secret %s for %s not found: %w", config.Spec.APITokenSecretRef, config.Name, err)
+                }
+        }
+
+        username := config.Spec.SenderEmail
+        if u, ok := secret.Data["username"]; ok {
+                username = string(u)
+        }
+
+        providerConfig := &provider.Config{
+                Provider:    config.Spec.Provider,
+                Host:        config.Spec.Domain,
+                Port:        config.Spec.Port,
+                Username:    username,
+                Password:    string(secret.Data["password"]),
+                Timeout:     config.Spec.Timeout,
+                SenderEmail: config.Spec.SenderEmail,
+                TLSMode:     config.Spec.TLSMode,
+                Pools:       r.SMTPPools,
+                PoolKey:     config.Namespace + "/" + config.Name,
+        }
+        if config.Spec.Options != nil {
+                providerConfig.Options = config.Spec.Options.Raw
+        }
+        if config.Spec.DKIM != nil {
+                signer, err := r.dkimSigner(ctx, config)
+                if err != nil {
+                        return nil, err
+                }
+                providerConfig.DKIM = signer
+        }
+        return provider.NewProvider(providerConfig)
+}
+
+// dkimSigner loads the signing key of a sender config from its secret.
+func (r *EmailReconciler) dkimSigner(ctx context.Context, config *emailv1alpha1.EmailSenderConfig) (*dkim.Signer, error) {
+        spec := config.Spec.DKIM
+        secret := &corev1.Secret{}
+        if err := r.Get(ctx, types.NamespacedName{
+                Namespace: config.Namespace,
+                Name:      spec.PrivateKeySecretRef,
+        }, secret); err != nil {
+                return nil, fmt.Errorf("DKIM secret %s for %s not found: %w", spec.PrivateKeySecretRef, config.Name, err)
+        }
+        key, err := dkim.ParsePrivateKey(secret.Data["private-key"])
+        if err != nil {
+                return nil, fmt.Errorf("DKIM key of %s: %w", config.Name, err)
+        }
+        domain := spec.Domain
+        if domain == "" {
+                _, domain, _ = strings.Cut(config.Spec.SenderEmail, "@")
+        }
+        return &dkim.Signer{Domain: domain, Selector: spec.Selector, Key: key}, nil
+}
+
+// reportBackendHealth copies the composite provider's circuit breaker
+// states to the config's status when they have changed.
+func (r *EmailReconciler) reportBackendHealth(ctx context.Context, config *emailv1alpha1.EmailSenderConfig, composite *provider.CompositeProvider) {
+        health := composite.BackendStatus()
+        names := make([]string, 0, len(health))
+        for name := range health {
+                names = append(names, name)
+        }
+        sort.Strings(names)
+
+        backends := make([]emailv1alpha1.BackendStatus, 0, len(names))
+        for _, name := range names {
+                h := health[name]
+                status := emailv1alpha1.BackendStatus{
+                        Name:                name,
+                        State:               h.State,
+                        ConsecutiveFailures: h.ConsecutiveFailures,
+                        LastError:           h.LastError,
+                }
+                if h.LastFailureAt != nil {
+                        t := metav1.NewTime(*h.LastFailureAt)
+                        status.LastFailureAt = &t
+                }
+                backends = append(backends, status)
+        }
+        if equality.Semantic.DeepEqual(backends, config.Status.Backends) {
+                return
+        }
+
+        config.Status.Backends = backends
+        if err := r.Status().Update(ctx, config); err != nil {
+                log.FromContext(ctx).Error(err, "failed to update backend health", "config", config.Name)
+        }
+}
+
 func (r *EmailReconciler) SetupWithManager(mgr ctrl.Manager) error {
         return ctrl.NewControllerManagedBy(mgr).
-                For(&emailv1alpha1.Email{}).
+                // Status updates, including the ones made here, must not
+                // trigger another send; retries are scheduled by requeueing.
+                For(&emailv1alpha1.Email{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
                 Complete(r)
 }
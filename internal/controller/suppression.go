@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	emailv1alpha1 "github.com/Gatete-Bruno/besend/api/v1alpha1"
+	"github.com/Gatete-Bruno/besend/pkg/database"
+)
+
+// SuppressionList lets the reconciler skip suppressed recipients and record
+// hard bounces.
+type SuppressionList interface {
+	// Check returns the suppression reason when the Email's recipient must
+	// not be mailed.
+	Check(ctx context.Context, email *emailv1alpha1.Email) (reason string, suppressed bool, err error)
+	// Suppress adds the Email's recipient to the list.
+	Suppress(ctx context.Context, email *emailv1alpha1.Email, reason, details string) error
+}
+
+// DatabaseSuppressionList uses the API's suppression table, scoped by the
+// Email's spec.customerId. Emails without a customer ID are never
+// suppressed.
+type DatabaseSuppressionList struct{}
+
+func (DatabaseSuppressionList) Check(ctx context.Context, email *emailv1alpha1.Email) (string, bool, error) {
+	customerID, err := strconv.Atoi(email.Spec.CustomerID)
+	if err != nil {
+		return "", false, nil
+	}
+	s, err := database.CheckSuppression(customerID, email.Spec.RecipientEmail, email.Spec.Tags)
+	if err != nil || s == nil {
+		return "", false, err
+	}
+	return s.Reason, true, nil
+}
+
+func (DatabaseSuppressionList) Suppress(ctx context.Context, email *emailv1alpha1.Email, reason, details string) error {
+	customerID, err := strconv.Atoi(email.Spec.CustomerID)
+	if err != nil {
+		return nil
+	}
+	_, _, err = database.AddSuppression(customerID, email.Spec.RecipientEmail, "", reason, &details)
+	return err
+}
+
+// SuppressionLists checks every list and suppresses in all of them.
+type SuppressionLists []SuppressionList
+
+func (l SuppressionLists) Check(ctx context.Context, email *emailv1alpha1.Email) (string, bool, error) {
+	for _, list := range l {
+		reason, suppressed, err := list.Check(ctx, email)
+		if err != nil || suppressed {
+			return reason, suppressed, err
+		}
+	}
+	return "", false, nil
+}
+
+func (l SuppressionLists) Suppress(ctx context.Context, email *emailv1alpha1.Email, reason, details string) error {
+	var firstErr error
+	for _, list := range l {
+		if err := list.Suppress(ctx, email, reason, details); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ResourceSuppressionList uses EmailSuppression resources in the Email's
+// namespace. A resource applies when its customerId is empty or matches the
+// Email's.
+type ResourceSuppressionList struct {
+	client.Client
+}
+
+func suppressionApplies(s *emailv1alpha1.EmailSuppression, email *emailv1alpha1.Email) bool {
+	return s.Spec.CustomerID == "" || s.Spec.CustomerID == email.Spec.CustomerID
+}
+
+func (r ResourceSuppressionList) Check(ctx context.Context, email *emailv1alpha1.Email) (string, bool, error) {
+	lists := &emailv1alpha1.EmailSuppressionList{}
+	if err := r.List(ctx, lists, client.InNamespace(email.Namespace)); err != nil {
+		return "", false, err
+	}
+	for i := range lists.Items {
+		if !suppressionApplies(&lists.Items[i], email) {
+			continue
+		}
+		for _, entry := range lists.Items[i].Spec.Entries {
+			if strings.EqualFold(entry.Email, email.Spec.RecipientEmail) {
+				reason := entry.Reason
+				if reason == "" {
+					reason = database.SuppressionManual
+				}
+				return reason, true, nil
+			}
+		}
+	}
+	return "", false, nil
+}
+
+// Suppress appends the recipient to an applicable list with autoSuppress
+// set, preferring one scoped to the Email's customer. Without such a list it
+// does nothing.
+func (r ResourceSuppressionList) Suppress(ctx context.Context, email *emailv1alpha1.Email, reason, details string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		lists := &emailv1alpha1.EmailSuppressionList{}
+		if err := r.List(ctx, lists, client.InNamespace(email.Namespace)); err != nil {
+			return err
+		}
+
+		var target *emailv1alpha1.EmailSuppression
+		for i := range lists.Items {
+			s := &lists.Items[i]
+			if !s.Spec.AutoSuppress || !suppressionApplies(s, email) {
+				continue
+			}
+			if target == nil || (target.Spec.CustomerID == "" && s.Spec.CustomerID != "") {
+				target = s
+			}
+		}
+		if target == nil {
+			return nil
+		}
+		for _, entry := range target.Spec.Entries {
+			if strings.EqualFold(entry.Email, email.Spec.RecipientEmail) {
+				return nil
+			}
+		}
+
+		now := metav1.Now()
+		target.Spec.Entries = append(target.Spec.Entries, emailv1alpha1.SuppressionEntry{
+			Email:   strings.ToLower(email.Spec.RecipientEmail),
+			Reason:  reason,
+			Details: details,
+			AddedAt: &now,
+		})
+		return r.Update(ctx, target)
+	})
+}
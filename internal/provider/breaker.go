@@ -0,0 +1,142 @@
+package provider
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker states.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// Defaults for NewBreakers: a backend is taken out of rotation after five
+// consecutive transient failures and retried a minute later.
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = time.Minute
+)
+
+// CircuitBreaker tracks the health of one backend. It opens after a number
+// of consecutive failures, so the backend is skipped, and after a cooldown
+// lets a single trial request through to decide whether to close again.
+type CircuitBreaker struct {
+	mu          sync.Mutex
+	threshold   int
+	cooldown    time.Duration
+	state       string
+	failures    int
+	openedAt    time.Time
+	trial       bool
+	lastError   string
+	lastFailure time.Time
+}
+
+// BreakerStatus is a snapshot of a breaker, for reporting backend health.
+type BreakerStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastFailureAt       *time.Time `json:"last_failure_at,omitempty"`
+}
+
+// Allow reports whether a request may be sent to the backend. Every allowed
+// request must be followed by Success, Failure or Abort.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.trial = true
+		return true
+	case BreakerHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Success records that the backend handled a request.
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = BreakerClosed
+	b.failures = 0
+	b.trial = false
+}
+
+// Failure records a failure of the backend itself, opening the breaker when
+// the threshold is reached or a trial request fails.
+func (b *CircuitBreaker) Failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastError = err.Error()
+	b.lastFailure = time.Now()
+	b.trial = false
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = b.lastFailure
+	}
+}
+
+// Abort releases an allowed request that ended without saying anything
+// about the backend, e.g. because the caller gave up.
+func (b *CircuitBreaker) Abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// Status returns a snapshot of the breaker.
+func (b *CircuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := BreakerStatus{State: b.state, ConsecutiveFailures: b.failures, LastError: b.lastError}
+	if s.State == "" {
+		s.State = BreakerClosed
+	}
+	if !b.lastFailure.IsZero() {
+		t := b.lastFailure
+		s.LastFailureAt = &t
+	}
+	return s
+}
+
+// Breakers holds a circuit breaker per backend key. Providers are built per
+// send, so the breakers are kept here for as long as the process runs.
+type Breakers struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	breakers  map[string]*CircuitBreaker
+}
+
+func NewBreakers(threshold int, cooldown time.Duration) *Breakers {
+	if threshold < 1 {
+		threshold = DefaultBreakerThreshold
+	}
+	return &Breakers{threshold: threshold, cooldown: cooldown, breakers: map[string]*CircuitBreaker{}}
+}
+
+// Get returns the breaker for key, creating a closed one if needed.
+func (bs *Breakers) Get(key string) *CircuitBreaker {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b, ok := bs.breakers[key]
+	if !ok {
+		b = &CircuitBreaker{threshold: bs.threshold, cooldown: bs.cooldown, state: BreakerClosed}
+		bs.breakers[key] = b
+	}
+	return b
+}
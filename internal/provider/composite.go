@@ -0,0 +1,189 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Composite is the provider name of sender configs that route across other
+// configs instead of sending themselves.
+const Composite = "composite"
+
+// Routing strategies of a composite provider.
+const (
+	RoutingPriority = "priority"
+	RoutingWeighted = "weighted"
+)
+
+// ErrNoBackendAvailable is returned when every backend's circuit breaker is
+// open. It is not a permanent failure: the breakers close again.
+var ErrNoBackendAvailable = errors.New("no backend available: all circuit breakers are open")
+
+// Backend is one provider a composite provider routes to.
+type Backend struct {
+	// Name identifies the backend in EmailResponse.Backend.
+	Name string
+	// Key identifies the backend's circuit breaker. It defaults to Name.
+	Key      string
+	Provider Provider
+	// Priority orders backends for the priority strategy, lowest first.
+	Priority int
+	// Weight is the backend's share of traffic for the weighted strategy.
+	// Values below 1 count as 1.
+	Weight int
+}
+
+func (b Backend) key() string {
+	if b.Key != "" {
+		return b.Key
+	}
+	return b.Name
+}
+
+// CompositeProvider sends through one of several backends. The priority
+// strategy always tries backends in priority order; the weighted strategy
+// spreads mail across them by weight. Either way, a transient failure moves
+// on to the next backend, and backends whose circuit breaker is open are
+// skipped. Permanent failures are returned at once, since another backend
+// would reject the same message.
+type CompositeProvider struct {
+	strategy string
+	backends []Backend
+	breakers *Breakers
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewCompositeProvider builds a composite provider. breakers should outlive
+// the provider so backend health is remembered between sends; when nil, a
+// private set is used.
+func NewCompositeProvider(strategy string, backends []Backend, breakers *Breakers) (*CompositeProvider, error) {
+	switch strategy {
+	case "":
+		strategy = RoutingPriority
+	case RoutingPriority, RoutingWeighted:
+	default:
+		return nil, fmt.Errorf("unsupported routing strategy: %s", strategy)
+	}
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("composite provider needs at least one backend")
+	}
+	if breakers == nil {
+		breakers = NewBreakers(DefaultBreakerThreshold, DefaultBreakerCooldown)
+	}
+
+	sorted := append([]Backend(nil), backends...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+	return &CompositeProvider{
+		strategy: strategy,
+		backends: sorted,
+		breakers: breakers,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+func (p *CompositeProvider) Send(ctx context.Context, req *EmailRequest) (*EmailResponse, error) {
+	var errs []error
+	for _, b := range p.order() {
+		breaker := p.breakers.Get(b.key())
+		if !breaker.Allow() {
+			continue
+		}
+
+		started := time.Now()
+		resp, err := b.Provider.Send(ctx, req)
+		switch {
+		case err == nil:
+			breaker.Success()
+			if resp == nil {
+				resp = &EmailResponse{MessageID: req.MessageID, Status: "Sent"}
+			}
+			resp.Backend = b.Name
+			if resp.Provider == "" {
+				resp.Provider = b.Provider.GetProviderName()
+			}
+			return resp, nil
+		case ctx.Err() != nil:
+			breaker.Abort()
+			return nil, err
+		case IsPermanentFailure(err):
+			// The message was refused, which says nothing bad about the
+			// backend.
+			breaker.Success()
+			return nil, err
+		}
+
+		breaker.Failure(err)
+		recordStep(ctx, Step{Name: "failover", Command: b.Name}, started, err)
+		errs = append(errs, fmt.Errorf("%s: %w", b.Name, err))
+	}
+	if len(errs) == 0 {
+		return nil, ErrNoBackendAvailable
+	}
+	return nil, errors.Join(errs...)
+}
+
+// order returns the backends in the order to try them.
+func (p *CompositeProvider) order() []Backend {
+	if p.strategy != RoutingWeighted {
+		return p.backends
+	}
+
+	// Weighted shuffle: draw backends one at a time with probability
+	// proportional to their weight, so the rest remain as fallbacks.
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	remaining := append([]Backend(nil), p.backends...)
+	ordered := make([]Backend, 0, len(remaining))
+	for len(remaining) > 0 {
+		total := 0
+		for _, b := range remaining {
+			total += weight(b)
+		}
+		n := p.rand.Intn(total)
+		i := 0
+		for ; n >= weight(remaining[i]); i++ {
+			n -= weight(remaining[i])
+		}
+		ordered = append(ordered, remaining[i])
+		remaining = append(remaining[:i], remaining[i+1:]...)
+	}
+	return ordered
+}
+
+func weight(b Backend) int {
+	if b.Weight < 1 {
+		return 1
+	}
+	return b.Weight
+}
+
+// VerifyCredentials verifies every backend and reports all that fail.
+func (p *CompositeProvider) VerifyCredentials(ctx context.Context) error {
+	var errs []error
+	for _, b := range p.backends {
+		if err := b.Provider.VerifyCredentials(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", b.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *CompositeProvider) GetProviderName() string {
+	return Composite
+}
+
+// BackendStatus reports the circuit breaker of each backend by name.
+func (p *CompositeProvider) BackendStatus() map[string]BreakerStatus {
+	status := make(map[string]BreakerStatus, len(p.backends))
+	for _, b := range p.backends {
+		status[b.Name] = p.breakers.Get(b.key()).Status()
+	}
+	return status
+}
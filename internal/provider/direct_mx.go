@@ -0,0 +1,403 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Gatete-Bruno/besend/pkg/dkim"
+)
+
+// DirectMX is the provider that delivers to recipients' MX hosts itself,
+// for deployments without a relay.
+const DirectMX = "direct-mx"
+
+// TLS policies of the direct-mx provider.
+const (
+	MXTLSOpportunistic = "opportunistic"
+	MXTLSMTASTS        = "mta-sts"
+	MXTLSRequired      = "required"
+)
+
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+
+func init() {
+	Register(Registration{
+		Name:        DirectMX,
+		Description: "Delivers straight to each recipient domain's MX hosts, without a relay.",
+		Options: []OptionSchema{
+			{
+				Name: "tlsPolicy", Type: "string", Enum: []string{MXTLSOpportunistic, MXTLSMTASTS, MXTLSRequired},
+				Description: "opportunistic (default) uses STARTTLS when offered without checking certificates; " +
+					"mta-sts also enforces the MTA-STS policies recipient domains publish; " +
+					"required insists on STARTTLS with a valid certificate from every MX host.",
+			},
+			{Name: "heloName", Type: "string", Description: "Name sent in EHLO. It should resolve to the sending address and match its reverse DNS; defaults to the host name."},
+		},
+		New:           NewDirectMXProvider,
+		NewOptions:    func() Options { return &directMXOptions{} },
+		NoCredentials: true,
+		DKIM:          true,
+	})
+}
+
+type directMXOptions struct {
+	TLSPolicy string `json:"tlsPolicy,omitempty"`
+	HeloName  string `json:"heloName,omitempty"`
+}
+
+func (o *directMXOptions) Validate() error {
+	switch o.TLSPolicy {
+	case "", MXTLSOpportunistic, MXTLSMTASTS, MXTLSRequired:
+	default:
+		return fmt.Errorf("tlsPolicy must be %s, %s or %s", MXTLSOpportunistic, MXTLSMTASTS, MXTLSRequired)
+	}
+	if o.HeloName != "" && !hostnamePattern.MatchString(o.HeloName) {
+		return fmt.Errorf("heloName must be a host name")
+	}
+	return nil
+}
+
+// DirectMXProvider looks up each recipient domain's MX hosts and delivers
+// to them in order of preference, one transaction per domain. Recipients
+// deferred by one host are tried on the next. Config.Port overrides port
+// 25, and Config.Resolver and Config.Dial let tests use a fake DNS server
+// and in-process SMTP servers.
+type DirectMXProvider struct {
+	config    *Config
+	resolver  Resolver
+	dialer    smtpDialer
+	port      string
+	heloName  string
+	tlsPolicy string
+	dkim      *dkim.Signer
+}
+
+func NewDirectMXProvider(cfg *Config) (Provider, error) {
+	var opts directMXOptions
+	if err := decodeOptions(cfg.Options, &opts); err != nil {
+		return nil, err
+	}
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if cfg.Timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	port := cfg.Port
+	if port == 0 {
+		port = 25
+	}
+	var resolver Resolver = net.DefaultResolver
+	if cfg.Resolver != nil {
+		resolver = cfg.Resolver
+	}
+	p := &DirectMXProvider{
+		config:    cfg,
+		resolver:  resolver,
+		dialer:    smtpDialer{dial: cfg.Dial, tlsConfig: cfg.TLSConfig, timeout: timeout},
+		port:      strconv.Itoa(port),
+		heloName:  opts.HeloName,
+		tlsPolicy: opts.TLSPolicy,
+		dkim:      cfg.DKIM,
+	}
+	if p.heloName == "" {
+		p.heloName = localHostname()
+	}
+	if p.tlsPolicy == "" {
+		p.tlsPolicy = MXTLSOpportunistic
+	}
+	return p, nil
+}
+
+// Send delivers to every address in req.To, which may list several. When
+// any recipient is not accepted the error is a *RecipientError, returned
+// along with the response.
+func (p *DirectMXProvider) Send(ctx context.Context, req *EmailRequest) (*EmailResponse, error) {
+	addresses, err := mail.ParseAddressList(req.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipients: %w", err)
+	}
+	envelopeFrom := req.EnvelopeFrom
+	if envelopeFrom == "" {
+		envelopeFrom = req.From
+	}
+	msg, err := smtpMessage(req, p.dkim)
+	if err != nil {
+		return nil, err
+	}
+
+	// Group recipients by domain, keeping the order they were given in.
+	var domains []string
+	byDomain := map[string][]string{}
+	for _, addr := range addresses {
+		at := strings.LastIndex(addr.Address, "@")
+		domain := strings.ToLower(addr.Address[at+1:])
+		if _, ok := byDomain[domain]; !ok {
+			domains = append(domains, domain)
+		}
+		byDomain[domain] = append(byDomain[domain], addr.Address)
+	}
+
+	var results []RecipientResult
+	failed := false
+	for _, domain := range domains {
+		for _, r := range p.deliverDomain(ctx, domain, byDomain[domain], envelopeFrom, msg) {
+			failed = failed || r.Status != RecipientAccepted
+			results = append(results, r)
+		}
+	}
+
+	resp := &EmailResponse{MessageID: req.MessageID, Status: "Sent", Recipients: results}
+	if failed {
+		resp.Status = "Failed"
+		return resp, &RecipientError{Results: results}
+	}
+	return resp, nil
+}
+
+// deliverDomain delivers to the recipients of one domain, trying its MX
+// hosts in order until every recipient has a final answer.
+func (p *DirectMXProvider) deliverDomain(ctx context.Context, domain string, recipients []string, from string, msg []byte) []RecipientResult {
+	results := make([]RecipientResult, len(recipients))
+	pending := make([]int, len(recipients))
+	for i, rcpt := range recipients {
+		results[i] = RecipientResult{Address: rcpt, Status: RecipientDeferred}
+		pending[i] = i
+	}
+	fail := func(err error, status string) []RecipientResult {
+		for _, i := range pending {
+			results[i].Status, results[i].Message, results[i].err = status, err.Error(), err
+		}
+		return results
+	}
+
+	hosts, permanent, err := p.lookupMX(ctx, domain)
+	if err != nil {
+		status := RecipientDeferred
+		if permanent {
+			status = RecipientRejected
+		}
+		return fail(err, status)
+	}
+
+	var policy *mtaSTSPolicy
+	if p.tlsPolicy == MXTLSMTASTS {
+		started := time.Now()
+		policy, err = lookupMTASTS(ctx, p.resolver, newHTTPClient(p.config), domain)
+		reply := "no policy"
+		if policy != nil {
+			reply = "mode " + policy.mode
+		}
+		recordStep(ctx, Step{Name: "mta-sts", Command: domain, Reply: reply}, started, err)
+	}
+
+	lastErr := fmt.Errorf("no MX host of %s could be used", domain)
+	for _, host := range hosts {
+		if policy != nil && policy.mode != stsModeNone && !policy.matches(host) {
+			lastErr = fmt.Errorf("MX host %s is not allowed by the MTA-STS policy of %s", host, domain)
+			recordStep(ctx, Step{Name: "mta-sts", Command: host}, time.Now(), lastErr)
+			if policy.enforced() {
+				continue
+			}
+		}
+		verifyTLS := p.tlsPolicy == MXTLSRequired || policy.enforced()
+		pending, lastErr = p.deliverHost(ctx, host, verifyTLS, from, msg, results, pending)
+		if len(pending) == 0 {
+			return results
+		}
+	}
+	for _, i := range pending {
+		if results[i].err == nil {
+			results[i].Message, results[i].err = lastErr.Error(), lastErr
+		}
+	}
+	return results
+}
+
+// lookupMX returns the hosts to try for a domain, most preferred first. A
+// domain without MX records is its own mail host (RFC 5321 section 5.1);
+// one with a null MX (RFC 7505) accepts no mail. permanent is set for
+// failures that retrying will not fix.
+func (p *DirectMXProvider) lookupMX(ctx context.Context, domain string) (hosts []string, permanent bool, err error) {
+	started := time.Now()
+	records, err := p.resolver.LookupMX(ctx, domain)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		records, err = nil, nil
+	}
+	if err != nil {
+		err = fmt.Errorf("MX lookup for %s failed: %w", domain, err)
+		recordStep(ctx, Step{Name: "mx-lookup", Command: domain}, started, err)
+		return nil, false, err
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Pref < records[j].Pref })
+	for _, mx := range records {
+		if host := strings.TrimSuffix(mx.Host, "."); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(records) > 0 && len(hosts) == 0 {
+		err = fmt.Errorf("%s does not accept mail (null MX)", domain)
+		recordStep(ctx, Step{Name: "mx-lookup", Command: domain}, started, err)
+		return nil, true, err
+	}
+	if len(hosts) == 0 {
+		hosts = []string{domain}
+	}
+	recordStep(ctx, Step{Name: "mx-lookup", Command: domain, Reply: strings.Join(hosts, " ")}, started, nil)
+	return hosts, false, nil
+}
+
+// deliverHost runs one transaction with an MX host for the pending
+// recipients and returns those still deferred, along with the error to
+// report for them if no later host does better.
+func (p *DirectMXProvider) deliverHost(ctx context.Context, host string, verifyTLS bool, from string, msg []byte, results []RecipientResult, pending []int) ([]int, error) {
+	conn, err := p.open(ctx, host, verifyTLS)
+	if err != nil {
+		return pending, err
+	}
+	defer conn.quit()
+
+	settle := func(i int, err error) {
+		r := &results[i]
+		r.Host, r.err = host, err
+		if err == nil {
+			r.Status, r.Code, r.Message = RecipientAccepted, 250, "accepted"
+			return
+		}
+		r.Message = err.Error()
+		r.Status = RecipientDeferred
+		if IsPermanentFailure(err) {
+			r.Status = RecipientRejected
+		}
+		var tpErr *textproto.Error
+		if errors.As(err, &tpErr) {
+			r.Code = tpErr.Code
+		}
+	}
+	stillPending := func() []int {
+		var deferred []int
+		for _, i := range pending {
+			if results[i].Status == RecipientDeferred {
+				deferred = append(deferred, i)
+			}
+		}
+		return deferred
+	}
+
+	if err := conn.mail(from); err != nil {
+		for _, i := range pending {
+			settle(i, err)
+		}
+		return stillPending(), err
+	}
+	var accepted []int
+	for _, i := range pending {
+		if err := conn.rcpt(results[i].Address); err != nil {
+			settle(i, err)
+			continue
+		}
+		accepted = append(accepted, i)
+	}
+	if len(accepted) == 0 {
+		return stillPending(), results[pending[0]].err
+	}
+
+	if _, _, err = conn.cmd("data", 354, "DATA"); err != nil {
+		err = fmt.Errorf("data failed: %w", err)
+	} else {
+		err = conn.message(msg)
+	}
+	for _, i := range accepted {
+		settle(i, err)
+	}
+	return stillPending(), err
+}
+
+// open connects to an MX host, trying each of its addresses, and
+// negotiates STARTTLS. Certificates are only checked with verifyTLS;
+// otherwise a failed handshake falls back to a plaintext session, as is
+// usual between MTAs.
+func (p *DirectMXProvider) open(ctx context.Context, host string, verifyTLS bool) (*smtpConn, error) {
+	started := time.Now()
+	addrs, err := p.resolver.LookupHost(ctx, host)
+	if err != nil {
+		err = fmt.Errorf("resolving %s failed: %w", host, err)
+		recordStep(ctx, Step{Name: "connect", Command: host}, started, err)
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		var conn *smtpConn
+		conn, err = p.session(ctx, host, addr, verifyTLS, true)
+		if err != nil && !verifyTLS && errors.Is(err, errTLSFallback) {
+			conn, err = p.session(ctx, host, addr, false, false)
+		}
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// errTLSFallback marks a failed opportunistic STARTTLS, after which the
+// session is unusable and a plaintext one is opened instead.
+var errTLSFallback = errors.New("opportunistic TLS failed")
+
+func (p *DirectMXProvider) session(ctx context.Context, host, addr string, verifyTLS, tryTLS bool) (*smtpConn, error) {
+	conn, err := dialSMTP(ctx, p.dialer, net.JoinHostPort(addr, p.port), host, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.hello(p.heloName); err != nil {
+		conn.close()
+		return nil, err
+	}
+	if !tryTLS {
+		return conn, nil
+	}
+
+	if ok, _ := conn.extension("STARTTLS"); !ok {
+		if verifyTLS {
+			err := fmt.Errorf("%s does not offer STARTTLS", host)
+			recordStep(ctx, Step{Name: "starttls", Command: host}, time.Now(), err)
+			conn.quit()
+			return nil, err
+		}
+		return conn, nil
+	}
+	// Most MX certificates do not name the MX host, so opportunistic TLS
+	// only encrypts; enforced policies check the certificate.
+	conn.tlsConfig.InsecureSkipVerify = !verifyTLS
+	if err := conn.startTLS(p.heloName); err != nil {
+		conn.close()
+		if !verifyTLS {
+			return nil, fmt.Errorf("%w: %w", errTLSFallback, err)
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// VerifyCredentials checks that MX records can be looked up, using the
+// sender's domain. direct-mx has no credentials.
+func (p *DirectMXProvider) VerifyCredentials(ctx context.Context) error {
+	at := strings.LastIndex(p.config.SenderEmail, "@")
+	if at < 0 {
+		return nil
+	}
+	_, _, err := p.lookupMX(ctx, p.config.SenderEmail[at+1:])
+	return err
+}
+
+func (p *DirectMXProvider) GetProviderName() string {
+	return DirectMX
+}
@@ -0,0 +1,397 @@
+package provider
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeResolver answers DNS lookups from maps; names it does not know are
+// reported as not found.
+type fakeResolver struct {
+	mx    map[string][]*net.MX
+	hosts map[string][]string
+	txt   map[string][]string
+}
+
+func notFound(name string) error {
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	if records, ok := r.mx[name]; ok {
+		return records, nil
+	}
+	return nil, notFound(name)
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, notFound(host)
+}
+
+func (r *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if records, ok := r.txt[name]; ok {
+		return records, nil
+	}
+	return nil, notFound(name)
+}
+
+// fakeMX is an in-process SMTP server. It accepts every recipient unless
+// rcptReplies says otherwise, and offers STARTTLS when tlsConfig is set.
+// extensions are advertised in addition, e.g. PIPELINING.
+type fakeMX struct {
+	name        string
+	greeting    string
+	rcptReplies map[string]string
+	tlsConfig   *tls.Config
+	extensions  []string
+
+	mu        sync.Mutex
+	commands  []string
+	delivered []string
+	overTLS   bool
+}
+
+func (s *fakeMX) serve(conn net.Conn) {
+	// Closing the pipe itself rather than a TLS session on it, whose
+	// close_notify would block on the unbuffered pipe.
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	if s.greeting != "" {
+		text.PrintfLine("%s", s.greeting)
+		return
+	}
+	text.PrintfLine("220 %s ESMTP", s.name)
+
+	secure := false
+	var rcpts []string
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			text.PrintfLine("250-%s", s.name)
+			if s.tlsConfig != nil && !secure {
+				text.PrintfLine("250-STARTTLS")
+			}
+			for _, ext := range s.extensions {
+				text.PrintfLine("250-%s", ext)
+			}
+			text.PrintfLine("250 8BITMIME")
+		case "STARTTLS":
+			text.PrintfLine("220 2.0.0 ready")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			text, secure = textproto.NewConn(tlsConn), true
+		case "MAIL":
+			rcpts = nil
+			text.PrintfLine("250 2.1.0 ok")
+		case "RCPT":
+			addr := strings.Trim(strings.TrimPrefix(strings.ToUpper(arg), "TO:"), "<>")
+			reply := "250 2.1.5 ok"
+			for rcpt, r := range s.rcptReplies {
+				if strings.EqualFold(rcpt, addr) {
+					reply = r
+				}
+			}
+			if strings.HasPrefix(reply, "250") {
+				rcpts = append(rcpts, strings.ToLower(addr))
+			}
+			text.PrintfLine("%s", reply)
+		case "DATA":
+			text.PrintfLine("354 go ahead")
+			if _, err := text.ReadDotBytes(); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.delivered = append(s.delivered, rcpts...)
+			s.overTLS = secure
+			s.mu.Unlock()
+			text.PrintfLine("250 2.0.0 queued")
+		case "QUIT":
+			text.PrintfLine("221 2.0.0 bye")
+			return
+		default:
+			text.PrintfLine("250 ok")
+		}
+	}
+}
+
+// mxNetwork routes dials to fake servers by address and records the order
+// in which hosts were dialed.
+type mxNetwork struct {
+	servers map[string]*fakeMX
+
+	mu     sync.Mutex
+	dialed []string
+}
+
+func (n *mxNetwork) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, _ := net.SplitHostPort(addr)
+	server, ok := n.servers[host]
+	if !ok {
+		return nil, fmt.Errorf("dial %s: connection refused", addr)
+	}
+	n.mu.Lock()
+	n.dialed = append(n.dialed, server.name)
+	n.mu.Unlock()
+
+	client, conn := net.Pipe()
+	go server.serve(conn)
+	return client, nil
+}
+
+// newTestDirectMX returns a direct-mx provider that resolves names with
+// resolver and reaches the given servers, each listening on its own
+// address.
+func newTestDirectMX(t *testing.T, cfg Config, resolver *fakeResolver, servers ...*fakeMX) (Provider, *mxNetwork) {
+	t.Helper()
+	network := &mxNetwork{servers: map[string]*fakeMX{}}
+	if resolver.hosts == nil {
+		resolver.hosts = map[string][]string{}
+	}
+	for i, server := range servers {
+		addr := fmt.Sprintf("192.0.2.%d", i+1)
+		resolver.hosts[server.name] = []string{addr}
+		network.servers[addr] = server
+	}
+
+	cfg.Provider = DirectMX
+	cfg.Resolver = resolver
+	cfg.Dial = network.dial
+	cfg.Timeout = 5
+	p, err := NewProvider(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, network
+}
+
+func testMXRequest(to string) *EmailRequest {
+	return &EmailRequest{From: "sender@sender.test", To: to, Subject: "Hello", Body: "Hi there\r\n"}
+}
+
+func TestDirectMXPreferenceOrder(t *testing.T) {
+	resolver := &fakeResolver{mx: map[string][]*net.MX{
+		"pref.test": {
+			{Host: "mx-c.pref.test.", Pref: 30},
+			{Host: "mx-a.pref.test.", Pref: 10},
+			{Host: "mx-b.pref.test.", Pref: 20},
+		},
+	}}
+	busy := "421 4.3.2 too busy"
+	p, network := newTestDirectMX(t, Config{}, resolver,
+		&fakeMX{name: "mx-a.pref.test", greeting: busy},
+		&fakeMX{name: "mx-b.pref.test", greeting: busy},
+		&fakeMX{name: "mx-c.pref.test", greeting: busy},
+	)
+
+	_, err := p.Send(context.Background(), testMXRequest("rcpt@pref.test"))
+	if err == nil {
+		t.Fatal("Send() succeeded with every host busy")
+	}
+	want := []string{"mx-a.pref.test", "mx-b.pref.test", "mx-c.pref.test"}
+	if strings.Join(network.dialed, " ") != strings.Join(want, " ") {
+		t.Errorf("dialed %v, want %v", network.dialed, want)
+	}
+	if IsPermanentFailure(err) {
+		t.Errorf("IsPermanentFailure(%v) = true for busy hosts", err)
+	}
+}
+
+func TestDirectMXUsesMostPreferredHost(t *testing.T) {
+	resolver := &fakeResolver{mx: map[string][]*net.MX{
+		"pref.test": {{Host: "backup.pref.test.", Pref: 50}, {Host: "primary.pref.test.", Pref: 5}},
+	}}
+	primary := &fakeMX{name: "primary.pref.test"}
+	p, network := newTestDirectMX(t, Config{}, resolver, &fakeMX{name: "backup.pref.test"}, primary)
+
+	resp, err := p.Send(context.Background(), testMXRequest("rcpt@pref.test"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(network.dialed) != 1 || network.dialed[0] != "primary.pref.test" {
+		t.Errorf("dialed %v, want only primary.pref.test", network.dialed)
+	}
+	if r := resp.Recipients[0]; r.Status != RecipientAccepted || r.Host != "primary.pref.test" {
+		t.Errorf("result = %+v, want accepted by primary.pref.test", r)
+	}
+	if len(primary.delivered) != 1 || primary.delivered[0] != "rcpt@pref.test" {
+		t.Errorf("primary received for %v", primary.delivered)
+	}
+}
+
+func TestDirectMXNullMX(t *testing.T) {
+	resolver := &fakeResolver{mx: map[string][]*net.MX{
+		"nomail.test": {{Host: ".", Pref: 0}},
+	}}
+	p, network := newTestDirectMX(t, Config{}, resolver)
+
+	resp, err := p.Send(context.Background(), testMXRequest("rcpt@nomail.test"))
+	var rcptErr *RecipientError
+	if !errors.As(err, &rcptErr) {
+		t.Fatalf("Send() error = %v, want a *RecipientError", err)
+	}
+	if r := resp.Recipients[0]; r.Status != RecipientRejected || !strings.Contains(r.Message, "null MX") {
+		t.Errorf("result = %+v, want rejected for the null MX", r)
+	}
+	if !IsPermanentFailure(err) {
+		t.Error("IsPermanentFailure() = false for a null MX")
+	}
+	if len(network.dialed) != 0 {
+		t.Errorf("dialed %v for a domain without mail hosts", network.dialed)
+	}
+}
+
+func TestDirectMXDeferredMovesToNextHost(t *testing.T) {
+	resolver := &fakeResolver{mx: map[string][]*net.MX{
+		"retry.test": {{Host: "mx1.retry.test", Pref: 10}, {Host: "mx2.retry.test", Pref: 20}},
+	}}
+	first := &fakeMX{name: "mx1.retry.test", rcptReplies: map[string]string{"rcpt@retry.test": "451 4.3.0 try again later"}}
+	second := &fakeMX{name: "mx2.retry.test"}
+	p, _ := newTestDirectMX(t, Config{}, resolver, first, second)
+
+	resp, err := p.Send(context.Background(), testMXRequest("rcpt@retry.test"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if r := resp.Recipients[0]; r.Status != RecipientAccepted || r.Host != "mx2.retry.test" {
+		t.Errorf("result = %+v, want accepted by mx2.retry.test", r)
+	}
+	if len(first.delivered) != 0 || len(second.delivered) != 1 {
+		t.Errorf("delivered by mx1 %v, mx2 %v; want only mx2", first.delivered, second.delivered)
+	}
+}
+
+func TestDirectMXMixedResultsArePermanent(t *testing.T) {
+	resolver := &fakeResolver{mx: map[string][]*net.MX{
+		"mixed.test": {{Host: "mx.mixed.test", Pref: 10}},
+	}}
+	server := &fakeMX{name: "mx.mixed.test", rcptReplies: map[string]string{"later@mixed.test": "452 4.2.2 mailbox full"}}
+	p, _ := newTestDirectMX(t, Config{}, resolver, server)
+
+	resp, err := p.Send(context.Background(), testMXRequest("now@mixed.test, later@mixed.test"))
+	var rcptErr *RecipientError
+	if !errors.As(err, &rcptErr) {
+		t.Fatalf("Send() error = %v, want a *RecipientError", err)
+	}
+	statuses := map[string]string{}
+	for _, r := range resp.Recipients {
+		statuses[r.Address] = r.Status
+	}
+	if statuses["now@mixed.test"] != RecipientAccepted || statuses["later@mixed.test"] != RecipientDeferred {
+		t.Errorf("statuses = %v, want now accepted and later deferred", statuses)
+	}
+	// Resending would deliver to now@mixed.test twice.
+	if !IsPermanentFailure(err) {
+		t.Error("IsPermanentFailure() = false with a recipient already accepted")
+	}
+	if IsHardBounce(err) {
+		t.Error("IsHardBounce() = true for a deferred recipient")
+	}
+}
+
+func TestDirectMXMTASTSEnforceSkipsUnlistedHost(t *testing.T) {
+	cert, roots := testCertificate(t, "mx2.sts.test")
+	resolver := &fakeResolver{
+		mx: map[string][]*net.MX{
+			"sts.test": {{Host: "mx1.sts.test", Pref: 10}, {Host: "mx2.sts.test", Pref: 20}},
+		},
+		txt: map[string][]string{"_mta-sts.sts.test": {"v=STSv1; id=20240101"}},
+	}
+	policy := "version: STSv1\nmode: enforce\nmx: mx2.sts.test\nmax_age: 86400\n"
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.String() != "https://mta-sts.sts.test/.well-known/mta-sts.txt" {
+			return nil, fmt.Errorf("unexpected request for %s", req.URL)
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"text/plain"}},
+			Body:       io.NopCloser(strings.NewReader(policy)),
+			Request:    req,
+		}, nil
+	})}
+
+	unlisted := &fakeMX{name: "mx1.sts.test"}
+	listed := &fakeMX{name: "mx2.sts.test", tlsConfig: &tls.Config{Certificates: []tls.Certificate{cert}}}
+	options, _ := json.Marshal(map[string]string{"tlsPolicy": MXTLSMTASTS})
+	cfg := Config{Options: options, HTTPClient: client, TLSConfig: &tls.Config{RootCAs: roots}}
+	p, network := newTestDirectMX(t, cfg, resolver, unlisted, listed)
+
+	resp, err := p.Send(context.Background(), testMXRequest("rcpt@sts.test"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(network.dialed) != 1 || network.dialed[0] != "mx2.sts.test" {
+		t.Errorf("dialed %v, want only the listed mx2.sts.test", network.dialed)
+	}
+	if r := resp.Recipients[0]; r.Status != RecipientAccepted || r.Host != "mx2.sts.test" {
+		t.Errorf("result = %+v, want accepted by mx2.sts.test", r)
+	}
+	if !listed.overTLS {
+		t.Error("message was not sent over TLS despite an enforced policy")
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// testCertificate returns a self-signed certificate for host and a pool
+// that trusts it.
+func testCertificate(t *testing.T, host string) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, roots
+}
@@ -0,0 +1,179 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+// enhancedStatus matches an RFC 3463 enhanced status code at the start of an
+// SMTP reply, e.g. "5.1.1".
+var enhancedStatus = regexp.MustCompile(`^([245])\.(\d{1,3})\.(\d{1,3})\b`)
+
+// Error is a failure reported by an HTTP provider's API. Each provider maps
+// its own error format onto it so callers can decide whether to retry
+// without knowing which provider they are using.
+type Error struct {
+	Provider   string
+	StatusCode int
+	// Code is the provider's own error code or name, when it sends one.
+	Code    string
+	Message string
+	// Temporary is set when the same request may succeed later: rate
+	// limiting, provider outages, and rejected credentials, which can be
+	// fixed without changing the message.
+	Temporary bool
+	// RecipientRejected is set when the provider refuses the recipient
+	// address itself, e.g. because it is known to bounce.
+	RecipientRejected bool
+}
+
+func (e *Error) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	if e.Code != "" {
+		return fmt.Sprintf("%s API error %d (%s): %s", e.Provider, e.StatusCode, e.Code, msg)
+	}
+	return fmt.Sprintf("%s API error %d: %s", e.Provider, e.StatusCode, msg)
+}
+
+// newAPIError builds an Error, classifying it as temporary from the HTTP
+// status alone. Providers adjust the result when their error codes say more.
+func newAPIError(provider string, statusCode int, code, message string) *Error {
+	return &Error{
+		Provider:   provider,
+		StatusCode: statusCode,
+		Code:       code,
+		Message:    message,
+		Temporary: statusCode == http.StatusTooManyRequests || statusCode >= 500 ||
+			statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden ||
+			statusCode == http.StatusRequestTimeout,
+	}
+}
+
+// errInvalidAddress is returned for an envelope address containing CR or
+// LF, which is never sent to the server.
+var errInvalidAddress = errors.New("address contains CR or LF")
+
+// IsPermanentFailure reports whether err carries a 5xx SMTP reply, a
+// non-temporary API error or an invalid address, meaning the same message
+// will keep being rejected if retried. A RecipientError also counts once any recipient has
+// accepted the message, since resending it would deliver it twice.
+func IsPermanentFailure(err error) bool {
+	var rcptErr *RecipientError
+	if errors.As(err, &rcptErr) {
+		return rcptErr.permanent()
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return !apiErr.Temporary
+	}
+	if errors.Is(err, errInvalidAddress) {
+		return true
+	}
+	var tpErr *textproto.Error
+	return errors.As(err, &tpErr) && tpErr.Code >= 500 && tpErr.Code < 600
+}
+
+// RecipientError is returned when some recipients of a send were not
+// accepted. Results covers every recipient, including accepted ones, so
+// callers can tell which ones a retry should be limited to.
+type RecipientError struct {
+	Results []RecipientResult
+}
+
+func (e *RecipientError) Error() string {
+	var failed []string
+	for _, r := range e.Results {
+		if r.Status != RecipientAccepted {
+			failed = append(failed, fmt.Sprintf("%s (%s): %s", r.Address, r.Status, r.Message))
+		}
+	}
+	return fmt.Sprintf("%d of %d recipients not accepted: %s", len(failed), len(e.Results), strings.Join(failed, "; "))
+}
+
+// Unwrap returns the errors of the recipients that were not accepted.
+func (e *RecipientError) Unwrap() []error {
+	var errs []error
+	for _, r := range e.Results {
+		if r.err != nil {
+			errs = append(errs, r.err)
+		}
+	}
+	return errs
+}
+
+// permanent reports whether resending the whole message is pointless or
+// harmful: when every failure was a rejection, or when some recipients
+// already accepted it and would receive it twice.
+func (e *RecipientError) permanent() bool {
+	for _, r := range e.Results {
+		if r.Status == RecipientAccepted {
+			return true
+		}
+	}
+	for _, r := range e.Results {
+		if r.Status == RecipientDeferred {
+			return false
+		}
+	}
+	return true
+}
+
+// senderRejectedError marks a rejected MAIL FROM, whose 5xx replies concern
+// the sender rather than the recipient.
+type senderRejectedError struct {
+	err error
+}
+
+func (e *senderRejectedError) Error() string { return e.err.Error() }
+func (e *senderRejectedError) Unwrap() error { return e.err }
+
+// IsHardBounce reports whether err is a permanent failure caused by the
+// recipient address itself: enhanced status 5.1.x (bad mailbox) or 5.2.1
+// (mailbox disabled), or a bare 550, 551 or 553 when the server sends no
+// enhanced status. Rejections of the sender never count. Callers using
+// net/smtp directly should only apply it to replies to RCPT TO or to the end
+// of DATA. For API errors it reports Error.RecipientRejected.
+func IsHardBounce(err error) bool {
+	var rcptErr *RecipientError
+	if errors.As(err, &rcptErr) {
+		// Only when every recipient that failed bounced.
+		bounced := false
+		for _, r := range rcptErr.Results {
+			if r.Status == RecipientAccepted {
+				continue
+			}
+			if !IsHardBounce(r.err) {
+				return false
+			}
+			bounced = true
+		}
+		return bounced
+	}
+	var sender *senderRejectedError
+	if errors.As(err, &sender) {
+		return false
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.RecipientRejected
+	}
+	var tpErr *textproto.Error
+	if !errors.As(err, &tpErr) || tpErr.Code < 500 || tpErr.Code >= 600 {
+		return false
+	}
+	if m := enhancedStatus.FindStringSubmatch(tpErr.Msg); m != nil {
+		return m[1] == "5" && (m[2] == "1" || (m[2] == "2" && m[3] == "1"))
+	}
+	switch tpErr.Code {
+	case 550, 551, 553:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxResponseBody bounds how much of an API response is read.
+const maxResponseBody = 1 << 20
+
+// errorParser turns a non-2xx API response into the common Error type.
+type errorParser func(resp *http.Response, body []byte) *Error
+
+// newHTTPClient returns a client for an HTTP provider, using the configured
+// timeout or 30 seconds.
+func newHTTPClient(cfg *Config) *http.Client {
+	if cfg.HTTPClient != nil {
+		return cfg.HTTPClient
+	}
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	if cfg.Timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	return &http.Client{Timeout: timeout}
+}
+
+// apiBaseURL returns the configured base URL, which lets tests point a
+// provider at a stand-in server, or the provider's default.
+func apiBaseURL(o httpOptions, defaultURL string) string {
+	if o.BaseURL != "" {
+		return strings.TrimRight(o.BaseURL, "/")
+	}
+	return defaultURL
+}
+
+// doAPI sends an API request and returns the body of a successful response.
+// Other responses are converted with parseError. The exchange is recorded as
+// a transcript step.
+func doAPI(ctx context.Context, client *http.Client, req *http.Request, stepName string, parseError errorParser) ([]byte, http.Header, error) {
+	started := time.Now()
+	step := Step{Name: stepName, Command: req.Method + " " + req.URL.Path}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		err = fmt.Errorf("failed to send request: %w", err)
+		recordStep(ctx, step, started, err)
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	step.Code = resp.StatusCode
+	step.Reply = resp.Status
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	if err != nil {
+		err = fmt.Errorf("failed to read response: %w", err)
+	} else if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err = parseError(resp, body)
+	}
+	recordStep(ctx, step, started, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, resp.Header, nil
+}
+
+// namedHeader is an extra message header in the list form used by several
+// provider APIs.
+type namedHeader struct {
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+}
+
+// namedHeaders returns extra headers in a stable order, dropping any with
+// line breaks so they cannot inject further headers.
+func namedHeaders(headers map[string]string) []namedHeader {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]namedHeader, 0, len(names))
+	for _, name := range names {
+		value := headers[name]
+		if strings.ContainsAny(name, "\r\n:") || strings.ContainsAny(value, "\r\n") {
+			continue
+		}
+		list = append(list, namedHeader{Name: name, Value: value})
+	}
+	return list
+}
+
+// trimReply shortens a non-JSON error body for use as an error message.
+func trimReply(body []byte) string {
+	s := strings.TrimSpace(string(body))
+	if len(s) > 200 {
+		s = s[:200] + "..."
+	}
+	return s
+}
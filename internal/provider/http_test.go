@@ -0,0 +1,95 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestHTTPProvider starts a stand-in API server with handler and returns
+// the named provider pointed at it.
+func newTestHTTPProvider(t *testing.T, name string, cfg Config, handler http.HandlerFunc) Provider {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	options := map[string]interface{}{}
+	if len(cfg.Options) > 0 {
+		if err := json.Unmarshal(cfg.Options, &options); err != nil {
+			t.Fatal(err)
+		}
+	}
+	options["baseURL"] = srv.URL
+	cfg.Options, _ = json.Marshal(options)
+	cfg.Provider = name
+
+	p, err := NewProvider(&cfg)
+	if err != nil {
+		t.Fatalf("NewProvider(%s): %v", name, err)
+	}
+	return p
+}
+
+// replyWith returns a handler that answers every request the same way.
+func replyWith(status int, header http.Header, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+// errorCase is a failed API response and how it should be classified.
+type errorCase struct {
+	name              string
+	status            int
+	header            http.Header
+	body              string
+	temporary         bool
+	recipientRejected bool
+}
+
+// testErrorMapping sends through the provider for each case and checks the
+// resulting Error.
+func testErrorMapping(t *testing.T, name string, cfg Config, cases []errorCase) {
+	t.Helper()
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestHTTPProvider(t, name, cfg, replyWith(tt.status, tt.header, tt.body))
+			_, err := p.Send(context.Background(), testEmailRequest())
+
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("Send() error = %v, want an *Error", err)
+			}
+			if apiErr.StatusCode != tt.status {
+				t.Errorf("StatusCode = %d, want %d", apiErr.StatusCode, tt.status)
+			}
+			if apiErr.Temporary != tt.temporary {
+				t.Errorf("Temporary = %t, want %t (%v)", apiErr.Temporary, tt.temporary, err)
+			}
+			if apiErr.RecipientRejected != tt.recipientRejected {
+				t.Errorf("RecipientRejected = %t, want %t (%v)", apiErr.RecipientRejected, tt.recipientRejected, err)
+			}
+			if IsPermanentFailure(err) == tt.temporary {
+				t.Errorf("IsPermanentFailure() = %t with Temporary = %t", IsPermanentFailure(err), tt.temporary)
+			}
+		})
+	}
+}
+
+func testEmailRequest() *EmailRequest {
+	return &EmailRequest{
+		From:     "sender@example.test",
+		To:       "rcpt@example.test",
+		Subject:  "Hello",
+		Body:     "plain text",
+		HTMLBody: "<p>html</p>",
+		Headers:  map[string]string{"List-Unsubscribe": "<https://example.test/u>"},
+	}
+}
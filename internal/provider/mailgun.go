@@ -0,0 +1,159 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+func init() {
+	Register(Registration{
+		Name:        "mailgun",
+		Description: "Mailgun Messages API.",
+		Options: []OptionSchema{
+			{Name: "domain", Type: "string", Description: "Sending domain; defaults to the domain of the sender address."},
+			{Name: "region", Type: "string", Enum: []string{"us", "eu"}, Description: "Region the domain was created in; defaults to us."},
+			baseURLOption,
+		},
+		New:        NewMailgunProvider,
+		NewOptions: func() Options { return &mailgunOptions{} },
+	})
+}
+
+type mailgunOptions struct {
+	httpOptions
+	Domain string `json:"domain,omitempty"`
+	Region string `json:"region,omitempty"`
+}
+
+func (o *mailgunOptions) Validate() error {
+	switch o.Region {
+	case "", "us", "eu":
+	default:
+		return fmt.Errorf("region must be us or eu")
+	}
+	if strings.ContainsAny(o.Domain, "/@ ") {
+		return fmt.Errorf("domain must be a bare domain name")
+	}
+	return o.httpOptions.Validate()
+}
+
+// MailgunProvider sends through the Mailgun Messages API.
+type MailgunProvider struct {
+	config  *Config
+	domain  string
+	baseURL string
+	client  *http.Client
+}
+
+type mailgunResponse struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+func NewMailgunProvider(cfg *Config) (Provider, error) {
+	if cfg.Password == "" {
+		return nil, fmt.Errorf("mailgun API key is required")
+	}
+	var opts mailgunOptions
+	if err := decodeOptions(cfg.Options, &opts); err != nil {
+		return nil, err
+	}
+	domain := opts.Domain
+	if domain == "" {
+		if _, d, ok := strings.Cut(cfg.SenderEmail, "@"); ok {
+			domain = d
+		}
+	}
+	if domain == "" {
+		return nil, fmt.Errorf("mailgun sending domain is required")
+	}
+	defaultURL := "https://api.mailgun.net"
+	if opts.Region == "eu" {
+		defaultURL = "https://api.eu.mailgun.net"
+	}
+	return &MailgunProvider{
+		config:  cfg,
+		domain:  domain,
+		baseURL: apiBaseURL(opts.httpOptions, defaultURL),
+		client:  newHTTPClient(cfg),
+	}, nil
+}
+
+func (p *MailgunProvider) Send(ctx context.Context, req *EmailRequest) (*EmailResponse, error) {
+	form := url.Values{}
+	form.Set("from", req.From)
+	form.Set("to", req.To)
+	form.Set("subject", req.Subject)
+	if req.Body != "" || req.HTMLBody == "" {
+		form.Set("text", req.Body)
+	}
+	if req.HTMLBody != "" {
+		form.Set("html", req.HTMLBody)
+	}
+	for _, h := range namedHeaders(req.Headers) {
+		form.Set("h:"+h.Name, h.Value)
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, p.baseURL+"/v3/"+url.PathEscape(p.domain)+"/messages", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.SetBasicAuth("api", p.config.Password)
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	body, _, err := doAPI(ctx, p.client, httpReq, "send", parseMailgunError)
+	if err != nil {
+		return nil, err
+	}
+	var resp mailgunResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	// Mailgun's webhooks refer to the Message-ID without angle brackets.
+	return &EmailResponse{MessageID: strings.Trim(resp.ID, "<>"), Status: "Sent"}, nil
+}
+
+// VerifyCredentials looks up the sending domain, which checks both the API
+// key and that the domain belongs to the account and is verified.
+func (p *MailgunProvider) VerifyCredentials(ctx context.Context) error {
+	httpReq, err := http.NewRequest(http.MethodGet, p.baseURL+"/v3/domains/"+url.PathEscape(p.domain), nil)
+	if err != nil {
+		return err
+	}
+	httpReq.SetBasicAuth("api", p.config.Password)
+
+	body, _, err := doAPI(ctx, p.client, httpReq, "api", parseMailgunError)
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Domain struct {
+			State string `json:"state"`
+		} `json:"domain"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	if resp.Domain.State != "active" {
+		return fmt.Errorf("mailgun domain %s is %s", p.domain, resp.Domain.State)
+	}
+	return nil
+}
+
+func (p *MailgunProvider) GetProviderName() string {
+	return "mailgun"
+}
+
+// parseMailgunError reads Mailgun's {"message": ...} errors. Authentication
+// failures come back as plain text.
+func parseMailgunError(resp *http.Response, body []byte) *Error {
+	var errResp mailgunResponse
+	if err := json.Unmarshal(body, &errResp); err != nil || errResp.Message == "" {
+		return newAPIError("mailgun", resp.StatusCode, "", trimReply(body))
+	}
+	return newAPIError("mailgun", resp.StatusCode, "", errResp.Message)
+}
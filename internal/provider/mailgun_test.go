@@ -0,0 +1,69 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestMailgunSend(t *testing.T) {
+	cfg := Config{Password: "key-123", SenderEmail: "sender@mg.example.test"}
+	p := newTestHTTPProvider(t, "mailgun", cfg, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v3/mg.example.test/messages" {
+			t.Errorf("request = %s %s, want POST /v3/mg.example.test/messages", r.Method, r.URL.Path)
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "api" || pass != "key-123" {
+			t.Errorf("basic auth = %q, %q, %t", user, pass, ok)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		for field, want := range map[string]string{
+			"from":               "sender@example.test",
+			"to":                 "rcpt@example.test",
+			"subject":            "Hello",
+			"text":               "plain text",
+			"html":               "<p>html</p>",
+			"h:List-Unsubscribe": "<https://example.test/u>",
+		} {
+			if got := r.PostForm.Get(field); got != want {
+				t.Errorf("form %s = %q, want %q", field, got, want)
+			}
+		}
+		io.WriteString(w, `{"id":"<20240101.abc@mg.example.test>","message":"Queued. Thank you."}`)
+	})
+
+	resp, err := p.Send(context.Background(), testEmailRequest())
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if resp.MessageID != "20240101.abc@mg.example.test" {
+		t.Errorf("MessageID = %q, want it without angle brackets", resp.MessageID)
+	}
+}
+
+func TestMailgunDomainOption(t *testing.T) {
+	options, _ := json.Marshal(map[string]string{"domain": "news.example.test"})
+	cfg := Config{Password: "key-123", SenderEmail: "sender@example.test", Options: options}
+	p := newTestHTTPProvider(t, "mailgun", cfg, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/domains/news.example.test" {
+			t.Errorf("path = %s, want /v3/domains/news.example.test", r.URL.Path)
+		}
+		io.WriteString(w, `{"domain":{"state":"unverified"}}`)
+	})
+	if err := p.VerifyCredentials(context.Background()); err == nil {
+		t.Error("VerifyCredentials() accepted an unverified domain")
+	}
+}
+
+func TestMailgunErrors(t *testing.T) {
+	cfg := Config{Password: "key-123", SenderEmail: "sender@mg.example.test"}
+	testErrorMapping(t, "mailgun", cfg, []errorCase{
+		{name: "bad request", status: 400, body: `{"message":"'to' parameter is not a valid address."}`},
+		{name: "bad key", status: 401, body: `Forbidden`, temporary: true},
+		{name: "rate limited", status: 429, body: `{"message":"Too many requests"}`, temporary: true},
+		{name: "outage", status: 500, body: `{"message":"Internal error"}`, temporary: true},
+	})
+}
@@ -0,0 +1,195 @@
+package provider
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MTA-STS policy modes (RFC 8461).
+const (
+	stsModeEnforce = "enforce"
+	stsModeTesting = "testing"
+	stsModeNone    = "none"
+)
+
+// maxSTSMaxAge is the longest a policy may be cached, about a year.
+const maxSTSMaxAge = 31557600
+
+// mtaSTSPolicy is the MTA-STS policy a recipient domain publishes: which
+// MX hosts may receive its mail and whether TLS to them is mandatory.
+type mtaSTSPolicy struct {
+	id      string
+	mode    string
+	mx      []string
+	expires time.Time
+}
+
+// enforced reports whether deliveries must follow the policy.
+func (p *mtaSTSPolicy) enforced() bool {
+	return p != nil && p.mode == stsModeEnforce
+}
+
+// matches reports whether host is one of the policy's MX patterns. A
+// leading "*." matches exactly one label.
+func (p *mtaSTSPolicy) matches(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range p.mx {
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			label, found := strings.CutSuffix(host, suffix)
+			if found && label != "" && !strings.Contains(label, ".") {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// stsPolicies caches fetched policies by domain for the life of the
+// process, as RFC 8461 expects senders to, so a policy keeps applying
+// when its host cannot be reached.
+var stsPolicies = struct {
+	sync.Mutex
+	byDomain map[string]*mtaSTSPolicy
+}{byDomain: map[string]*mtaSTSPolicy{}}
+
+// lookupMTASTS returns the domain's MTA-STS policy, or nil when it
+// publishes none. A policy is only fetched when the _mta-sts TXT record
+// announces a new ID; otherwise the cached one is used while it is valid.
+func lookupMTASTS(ctx context.Context, resolver Resolver, client *http.Client, domain string) (*mtaSTSPolicy, error) {
+	domain = strings.ToLower(domain)
+	stsPolicies.Lock()
+	cached := stsPolicies.byDomain[domain]
+	stsPolicies.Unlock()
+	if cached != nil && time.Now().After(cached.expires) {
+		cached = nil
+	}
+
+	id, err := lookupSTSRecord(ctx, resolver, domain)
+	if err != nil || id == "" || (cached != nil && cached.id == id) {
+		// Without a record a cached policy still applies until it
+		// expires, which is what protects against a stripped record.
+		return cached, err
+	}
+
+	policy, err := fetchSTSPolicy(ctx, client, domain)
+	if err != nil {
+		return cached, err
+	}
+	policy.id = id
+	stsPolicies.Lock()
+	stsPolicies.byDomain[domain] = policy
+	stsPolicies.Unlock()
+	return policy, nil
+}
+
+// lookupSTSRecord returns the policy ID from the domain's _mta-sts TXT
+// record, or "" when there is no single valid record.
+func lookupSTSRecord(ctx context.Context, resolver Resolver, domain string) (string, error) {
+	records, err := resolver.LookupTXT(ctx, "_mta-sts."+domain)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var id string
+	count := 0
+	for _, record := range records {
+		if !strings.HasPrefix(record, "v=STSv1") {
+			continue
+		}
+		count++
+		for _, field := range strings.Split(record, ";") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(field), "id="); ok {
+				id = value
+			}
+		}
+	}
+	if count != 1 {
+		return "", nil
+	}
+	return id, nil
+}
+
+// fetchSTSPolicy downloads and parses the policy file. Redirects are not
+// followed, as the RFC requires.
+func fetchSTSPolicy(ctx context.Context, client *http.Client, domain string) (*mtaSTSPolicy, error) {
+	noRedirects := *client
+	noRedirects.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	url := "https://mta-sts." + domain + "/.well-known/mta-sts.txt"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := noRedirects.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching MTA-STS policy: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching MTA-STS policy: HTTP %d", resp.StatusCode)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/plain" {
+		return nil, fmt.Errorf("MTA-STS policy has content type %q", resp.Header.Get("Content-Type"))
+	}
+	return parseSTSPolicy(io.LimitReader(resp.Body, 64<<10))
+}
+
+func parseSTSPolicy(r io.Reader) (*mtaSTSPolicy, error) {
+	policy := &mtaSTSPolicy{}
+	var version string
+	maxAge := -1
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "version":
+			version = value
+		case "mode":
+			policy.mode = value
+		case "mx":
+			policy.mx = append(policy.mx, strings.ToLower(value))
+		case "max_age":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("MTA-STS policy has invalid max_age %q", value)
+			}
+			maxAge = min(n, maxSTSMaxAge)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case version != "STSv1":
+		return nil, fmt.Errorf("MTA-STS policy has unsupported version %q", version)
+	case policy.mode != stsModeEnforce && policy.mode != stsModeTesting && policy.mode != stsModeNone:
+		return nil, fmt.Errorf("MTA-STS policy has invalid mode %q", policy.mode)
+	case maxAge < 0:
+		return nil, fmt.Errorf("MTA-STS policy has no max_age")
+	case policy.mode != stsModeNone && len(policy.mx) == 0:
+		return nil, fmt.Errorf("MTA-STS policy lists no mx")
+	}
+	policy.expires = time.Now().Add(time.Duration(maxAge) * time.Second)
+	return policy, nil
+}
@@ -2,93 +2,234 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
-	"net/smtp"
+	"net/textproto"
+	"strconv"
 	"time"
+
+	"github.com/Gatete-Bruno/besend/pkg/dkim"
+)
+
+// TLS modes accepted in Config.TLSMode.
+const (
+	TLSOpportunistic = "opportunistic"
+	TLSRequired      = "required"
+	TLSImplicit      = "implicit"
+	TLSNone          = "none"
 )
 
+func init() {
+	Register(Registration{
+		Name:        "native-smtp",
+		Description: "Any SMTP server, using the host, port and TLS mode of the sender config.",
+		Options: []OptionSchema{
+			{Name: "maxMessagesPerConnection", Type: "integer", Description: "Messages sent over one pooled session before it is closed; defaults to 100."},
+			{Name: "idleTimeoutSeconds", Type: "integer", Description: "How long an unused pooled session is kept open; defaults to 30."},
+		},
+		DKIM:       true,
+		New:        NewNativeSMTPProvider,
+		NewOptions: func() Options { return &nativeSMTPOptions{} },
+	})
+}
+
+// nativeSMTPOptions tune session pooling, which applies when the provider
+// is given SMTPPools.
+type nativeSMTPOptions struct {
+	MaxMessagesPerConnection int `json:"maxMessagesPerConnection,omitempty"`
+	IdleTimeoutSeconds       int `json:"idleTimeoutSeconds,omitempty"`
+}
+
+func (o *nativeSMTPOptions) Validate() error {
+	if o.MaxMessagesPerConnection < 0 {
+		return fmt.Errorf("maxMessagesPerConnection must not be negative")
+	}
+	if o.IdleTimeoutSeconds < 0 || o.IdleTimeoutSeconds > 300 {
+		return fmt.Errorf("idleTimeoutSeconds must be between 0 and 300")
+	}
+	return nil
+}
+
 type NativeSMTPProvider struct {
 	host     string
 	port     int
 	username string
 	password string
+	tlsMode  string
 	timeout  time.Duration
+	dialer   smtpDialer
+	// pool is nil when sessions are not pooled.
+	pool *smtpPool
+	dkim *dkim.Signer
 }
 
 func NewNativeSMTPProvider(cfg *Config) (Provider, error) {
 	if cfg.Host == "" || cfg.Port == 0 {
 		return nil, fmt.Errorf("host and port required")
 	}
+	var opts nativeSMTPOptions
+	if err := decodeOptions(cfg.Options, &opts); err != nil {
+		return nil, err
+	}
 	timeout := time.Duration(cfg.Timeout) * time.Second
 	if cfg.Timeout == 0 {
 		timeout = 30 * time.Second
 	}
-	return &NativeSMTPProvider{
+	tlsMode := cfg.TLSMode
+	switch tlsMode {
+	case "":
+		tlsMode = TLSOpportunistic
+		if cfg.Port == 465 {
+			tlsMode = TLSImplicit
+		}
+	case TLSOpportunistic, TLSRequired, TLSImplicit, TLSNone:
+	default:
+		return nil, fmt.Errorf("unsupported TLS mode: %s", cfg.TLSMode)
+	}
+	p := &NativeSMTPProvider{
 		host:     cfg.Host,
 		port:     cfg.Port,
 		username: cfg.Username,
 		password: cfg.Password,
+		tlsMode:  tlsMode,
 		timeout:  timeout,
-	}, nil
+		dialer:   smtpDialer{dial: cfg.Dial, tlsConfig: cfg.TLSConfig, timeout: timeout},
+		dkim:     cfg.DKIM,
+	}
+	if cfg.Pools != nil {
+		maxMessages := opts.MaxMessagesPerConnection
+		if maxMessages == 0 {
+			maxMessages = DefaultMaxMessagesPerConnection
+		}
+		idleTimeout := time.Duration(opts.IdleTimeoutSeconds) * time.Second
+		if idleTimeout == 0 {
+			idleTimeout = DefaultPoolIdleTimeout
+		}
+		p.pool = cfg.Pools.get(poolKey(cfg, tlsMode, maxMessages, idleTimeout), maxMessages, idleTimeout)
+	}
+	return p, nil
 }
 
-func (p *NativeSMTPProvider) Send(ctx context.Context, req *EmailRequest) (*EmailResponse, error) {
-	addr := fmt.Sprintf("%s:%d", p.host, p.port)
-	conn, err := net.DialTimeout("tcp", addr, p.timeout)
+// connect opens a session that is ready for MAIL FROM: greeting read, EHLO
+// sent, STARTTLS negotiated according to the TLS mode, and AUTH completed when
+// a username is configured.
+func (p *NativeSMTPProvider) connect(ctx context.Context) (*smtpConn, error) {
+	addr := net.JoinHostPort(p.host, strconv.Itoa(p.port))
+	conn, err := dialSMTP(ctx, p.dialer, addr, p.host, p.tlsMode == TLSImplicit)
 	if err != nil {
-		return nil, fmt.Errorf("dial failed: %w", err)
+		return nil, err
 	}
-	defer conn.Close()
 
-	client, err := smtp.NewClient(conn, p.host)
-	if err != nil {
-		return nil, fmt.Errorf("smtp client failed: %w", err)
+	if err := conn.hello(localHostname()); err != nil {
+		conn.close()
+		return nil, err
 	}
-	defer client.Close()
 
-	if err := client.Mail(req.From); err != nil {
-		return nil, fmt.Errorf("mail from failed: %w", err)
+	if !conn.tls && p.tlsMode != TLSNone {
+		if ok, _ := conn.extension("STARTTLS"); ok {
+			if err := conn.startTLS(localHostname()); err != nil {
+				conn.close()
+				return nil, err
+			}
+		} else if p.tlsMode == TLSRequired {
+			err := fmt.Errorf("server does not support STARTTLS")
+			recordStep(ctx, Step{Name: "starttls"}, time.Now(), err)
+			conn.close()
+			return nil, err
+		}
 	}
 
-	if err := client.Rcpt(req.To); err != nil {
-		return nil, fmt.Errorf("rcpt to failed: %w", err)
+	if p.username != "" {
+		if err := conn.auth(p.username, p.password, p.tlsMode == TLSNone); err != nil {
+			conn.close()
+			return nil, err
+		}
 	}
+	return conn, nil
+}
 
-	wc, err := client.Data()
-	if err != nil {
-		return nil, fmt.Errorf("data failed: %w", err)
+// session returns a pooled session that is still alive, or a new one.
+func (p *NativeSMTPProvider) session(ctx context.Context) (*smtpConn, error) {
+	if p.pool == nil {
+		return p.connect(ctx)
+	}
+	for {
+		conn := p.pool.take()
+		if conn == nil {
+			return p.connect(ctx)
+		}
+		conn.use(ctx, p.timeout)
+		idle := time.Since(conn.idleSince)
+		recordStep(ctx, Step{Name: "reuse", Reply: fmt.Sprintf("pooled session, %d messages sent, idle %s", conn.messages, idle.Round(time.Millisecond))}, time.Now(), nil)
+		if idle < noopAfter {
+			return conn, nil
+		}
+		if err := conn.noop(); err == nil {
+			return conn, nil
+		}
+		conn.close()
 	}
+}
 
-	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s", req.From, req.To, req.Subject, req.Body)
-	if _, err := wc.Write([]byte(msg)); err != nil {
-		wc.Close()
-		return nil, fmt.Errorf("write failed: %w", err)
+// release ends a send on a session. Pooled sessions go back to the pool
+// when the server is still in a known state: after a delivered message, or
+// after a rejected command once RSET has cleared the transaction.
+func (p *NativeSMTPProvider) release(conn *smtpConn, sendErr error) {
+	var replyErr *textproto.Error
+	switch {
+	case p.pool == nil:
+		_ = conn.quit()
+		return
+	case conn.broken:
+		conn.close()
+		return
+	case sendErr != nil && !errors.As(sendErr, &replyErr):
+		conn.close()
+		return
+	case sendErr != nil:
+		if err := conn.reset(); err != nil {
+			conn.close()
+			return
+		}
 	}
+	p.pool.put(conn)
+}
 
-	if err := wc.Close(); err != nil {
-		return nil, fmt.Errorf("close failed: %w", err)
+func (p *NativeSMTPProvider) Send(ctx context.Context, req *EmailRequest) (*EmailResponse, error) {
+	envelopeFrom := req.EnvelopeFrom
+	if envelopeFrom == "" {
+		envelopeFrom = req.From
+	}
+	msg, err := smtpMessage(req, p.dkim)
+	if err != nil {
+		return nil, err
 	}
 
-	_ = client.Quit()
+	conn, err := p.session(ctx)
+	if err != nil {
+		return nil, err
+	}
 
+	err = conn.envelope(envelopeFrom, req.To)
+	if err == nil {
+		err = conn.message(msg)
+	}
+	p.release(conn, err)
+	if err != nil {
+		return nil, err
+	}
 	return &EmailResponse{MessageID: req.MessageID, Status: "Sent"}, nil
 }
 
+// VerifyCredentials connects, negotiates TLS and authenticates without
+// sending a message.
 func (p *NativeSMTPProvider) VerifyCredentials(ctx context.Context) error {
-	addr := fmt.Sprintf("%s:%d", p.host, p.port)
-	conn, err := net.DialTimeout("tcp", addr, p.timeout)
+	conn, err := p.connect(ctx)
 	if err != nil {
-		return fmt.Errorf("dial failed: %w", err)
+		return err
 	}
-	defer conn.Close()
-
-	client, err := smtp.NewClient(conn, p.host)
-	if err != nil {
-		return fmt.Errorf("smtp client failed: %w", err)
-	}
-	defer client.Close()
-
+	_ = conn.quit()
 	return nil
 }
 
@@ -0,0 +1,79 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// newTestNativeSMTP returns a native-smtp provider connected to server.
+func newTestNativeSMTP(t *testing.T, cfg Config, server *fakeMX) Provider {
+	t.Helper()
+	network := &mxNetwork{servers: map[string]*fakeMX{"192.0.2.1": server}}
+	cfg.Provider = "native-smtp"
+	cfg.Host = "192.0.2.1"
+	cfg.Port = 587
+	cfg.Dial = network.dial
+	cfg.Timeout = 5
+	p, err := NewProvider(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestNativeSMTPRefusesCRLFInAddresses(t *testing.T) {
+	tests := []struct {
+		name       string
+		extensions []string
+		from, to   string
+	}{
+		{"pipelined recipient", []string{"PIPELINING"}, "sender@sender.test", "a@b\r\nRCPT TO:<x@y>"},
+		{"pipelined sender", []string{"PIPELINING"}, "sender@sender.test\r\nRCPT TO:<x@y>", "rcpt@example.test"},
+		{"recipient", nil, "sender@sender.test", "a@b\nRCPT TO:<x@y>"},
+		{"sender", nil, "sender@sender.test\rRCPT TO:<x@y>", "rcpt@example.test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &fakeMX{name: "smtp.example.test", extensions: tt.extensions}
+			p := newTestNativeSMTP(t, Config{TLSMode: TLSNone}, server)
+
+			req := testMXRequest(tt.to)
+			req.From = tt.from
+			_, err := p.Send(context.Background(), req)
+			if err == nil {
+				t.Fatal("Send() succeeded, want an error")
+			}
+			if !IsPermanentFailure(err) {
+				t.Errorf("IsPermanentFailure(%v) = false, want true", err)
+			}
+
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			for _, command := range server.commands {
+				verb, _, _ := strings.Cut(command, " ")
+				if verb == "MAIL" || verb == "RCPT" || verb == "DATA" {
+					t.Errorf("server received %q, want no envelope commands", command)
+				}
+			}
+		})
+	}
+}
+
+// TestNativeSMTPRequiresAdvertisedAUTH checks that configured credentials
+// are not silently dropped when the server, or someone between us and it,
+// leaves AUTH out of the EHLO reply.
+func TestNativeSMTPRequiresAdvertisedAUTH(t *testing.T) {
+	server := &fakeMX{name: "smtp.example.test"}
+	p := newTestNativeSMTP(t, Config{Username: "mailer", Password: "s3cret"}, server)
+
+	_, err := p.Send(context.Background(), testMXRequest("rcpt@example.test"))
+	if err == nil || !strings.Contains(err.Error(), "AUTH") {
+		t.Fatalf("Send() error = %v, want one about AUTH", err)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.delivered) != 0 {
+		t.Errorf("delivered to %v without authenticating", server.delivered)
+	}
+}
@@ -0,0 +1,145 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Postmark API error codes that change how a failure is classified.
+const (
+	postmarkInvalidToken      = 10
+	postmarkInactiveRecipient = 406
+)
+
+func init() {
+	Register(Registration{
+		Name:        "postmark",
+		Description: "Postmark Email API.",
+		Options: []OptionSchema{
+			{Name: "messageStream", Type: "string", Description: "Message stream to send through; defaults to outbound."},
+			baseURLOption,
+		},
+		New:        NewPostmarkProvider,
+		NewOptions: func() Options { return &postmarkOptions{} },
+	})
+}
+
+type postmarkOptions struct {
+	httpOptions
+	MessageStream string `json:"messageStream,omitempty"`
+}
+
+// PostmarkProvider sends through the Postmark Email API.
+type PostmarkProvider struct {
+	config        *Config
+	messageStream string
+	baseURL       string
+	client        *http.Client
+}
+
+type postmarkEmailRequest struct {
+	From          string        `json:"From"`
+	To            string        `json:"To"`
+	Subject       string        `json:"Subject"`
+	TextBody      string        `json:"TextBody,omitempty"`
+	HTMLBody      string        `json:"HtmlBody,omitempty"`
+	Headers       []namedHeader `json:"Headers,omitempty"`
+	MessageStream string        `json:"MessageStream,omitempty"`
+}
+
+type postmarkResponse struct {
+	ErrorCode int    `json:"ErrorCode"`
+	Message   string `json:"Message"`
+	MessageID string `json:"MessageID"`
+}
+
+func NewPostmarkProvider(cfg *Config) (Provider, error) {
+	if cfg.Password == "" {
+		return nil, fmt.Errorf("postmark server token is required")
+	}
+	var opts postmarkOptions
+	if err := decodeOptions(cfg.Options, &opts); err != nil {
+		return nil, err
+	}
+	return &PostmarkProvider{
+		config:        cfg,
+		messageStream: opts.MessageStream,
+		baseURL:       apiBaseURL(opts.httpOptions, "https://api.postmarkapp.com"),
+		client:        newHTTPClient(cfg),
+	}, nil
+}
+
+func (p *PostmarkProvider) Send(ctx context.Context, req *EmailRequest) (*EmailResponse, error) {
+	pmReq := postmarkEmailRequest{
+		From:          req.From,
+		To:            req.To,
+		Subject:       req.Subject,
+		TextBody:      req.Body,
+		HTMLBody:      req.HTMLBody,
+		Headers:       namedHeaders(req.Headers),
+		MessageStream: p.messageStream,
+	}
+	jsonData, err := json.Marshal(pmReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, p.baseURL+"/email", bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	p.setHeaders(httpReq)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	body, _, err := doAPI(ctx, p.client, httpReq, "send", parsePostmarkError)
+	if err != nil {
+		return nil, err
+	}
+	var resp postmarkResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return &EmailResponse{MessageID: resp.MessageID, Status: "Sent"}, nil
+}
+
+// VerifyCredentials fetches the server the token belongs to.
+func (p *PostmarkProvider) VerifyCredentials(ctx context.Context) error {
+	httpReq, err := http.NewRequest(http.MethodGet, p.baseURL+"/server", nil)
+	if err != nil {
+		return err
+	}
+	p.setHeaders(httpReq)
+
+	_, _, err = doAPI(ctx, p.client, httpReq, "api", parsePostmarkError)
+	return err
+}
+
+func (p *PostmarkProvider) GetProviderName() string {
+	return "postmark"
+}
+
+func (p *PostmarkProvider) setHeaders(req *http.Request) {
+	req.Header.Set("X-Postmark-Server-Token", p.config.Password)
+	req.Header.Set("Accept", "application/json")
+}
+
+// parsePostmarkError reads Postmark's errors, which carry an ErrorCode that
+// is more specific than the HTTP status (usually 422).
+func parsePostmarkError(resp *http.Response, body []byte) *Error {
+	var errResp postmarkResponse
+	if err := json.Unmarshal(body, &errResp); err != nil || errResp.ErrorCode == 0 {
+		return newAPIError("postmark", resp.StatusCode, "", trimReply(body))
+	}
+	apiErr := newAPIError("postmark", resp.StatusCode, strconv.Itoa(errResp.ErrorCode), errResp.Message)
+	switch errResp.ErrorCode {
+	case postmarkInvalidToken:
+		apiErr.Temporary = true
+	case postmarkInactiveRecipient:
+		apiErr.RecipientRejected = true
+	}
+	return apiErr
+}
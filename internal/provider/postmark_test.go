@@ -0,0 +1,58 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestPostmarkSend(t *testing.T) {
+	options, _ := json.Marshal(map[string]string{"messageStream": "broadcast"})
+	var got postmarkEmailRequest
+	p := newTestHTTPProvider(t, "postmark", Config{Password: "server-token", Options: options}, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/email" {
+			t.Errorf("request = %s %s, want POST /email", r.Method, r.URL.Path)
+		}
+		if token := r.Header.Get("X-Postmark-Server-Token"); token != "server-token" {
+			t.Errorf("X-Postmark-Server-Token = %q", token)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding body: %v", err)
+		}
+		io.WriteString(w, `{"ErrorCode":0,"Message":"OK","MessageID":"b7bc2f4a-e38e-4336-af7d-e6c392c2f817"}`)
+	})
+
+	resp, err := p.Send(context.Background(), testEmailRequest())
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if resp.MessageID != "b7bc2f4a-e38e-4336-af7d-e6c392c2f817" {
+		t.Errorf("MessageID = %q", resp.MessageID)
+	}
+
+	want := postmarkEmailRequest{
+		From:          "sender@example.test",
+		To:            "rcpt@example.test",
+		Subject:       "Hello",
+		TextBody:      "plain text",
+		HTMLBody:      "<p>html</p>",
+		Headers:       []namedHeader{{Name: "List-Unsubscribe", Value: "<https://example.test/u>"}},
+		MessageStream: "broadcast",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("request body = %+v, want %+v", got, want)
+	}
+}
+
+func TestPostmarkErrors(t *testing.T) {
+	testErrorMapping(t, "postmark", Config{Password: "server-token"}, []errorCase{
+		{name: "inactive recipient", status: 422, body: `{"ErrorCode":406,"Message":"You tried to send to a recipient that has been marked as inactive."}`, recipientRejected: true},
+		{name: "invalid token", status: 401, body: `{"ErrorCode":10,"Message":"Bad or missing Server API token."}`, temporary: true},
+		{name: "invalid token as 422", status: 422, body: `{"ErrorCode":10,"Message":"Bad or missing Server API token."}`, temporary: true},
+		{name: "invalid request", status: 422, body: `{"ErrorCode":300,"Message":"Invalid email request"}`},
+		{name: "outage", status: 503, body: `Service Unavailable`, temporary: true},
+	})
+}
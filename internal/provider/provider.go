@@ -2,7 +2,15 @@ package provider
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Gatete-Bruno/besend/pkg/dkim"
 )
 
 type EmailRequest struct {
@@ -12,12 +20,70 @@ type EmailRequest struct {
 	Subject   string
 	Body      string
 	HTMLBody  string
+	// Headers are extra message headers such as List-Unsubscribe.
+	Headers map[string]string
+	// EnvelopeFrom is the SMTP envelope sender, e.g. a VERP return path.
+	// It defaults to From.
+	EnvelopeFrom string
+	// Raw is a complete message to send as is. SMTP providers use it
+	// instead of building one from the fields above, only adding a DKIM
+	// signature when configured; HTTP providers ignore it.
+	Raw []byte
+}
+
+// formatHeaders renders extra headers in a stable order, dropping any with
+// line breaks so they cannot inject further headers.
+func formatHeaders(headers map[string]string) string {
+	var b strings.Builder
+	for _, h := range namedHeaders(headers) {
+		b.WriteString(h.Name + ": " + h.Value + "\r\n")
+	}
+	return b.String()
+}
+
+// smtpMessage returns the message an SMTP provider transmits: req.Raw, or
+// one built from the request's fields, signed when signer is set.
+func smtpMessage(req *EmailRequest, signer *dkim.Signer) ([]byte, error) {
+	msg := req.Raw
+	if msg == nil {
+		msg = []byte(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n%s\r\n%s", req.From, req.To, req.Subject, formatHeaders(req.Headers), req.Body))
+	}
+	if signer == nil {
+		return msg, nil
+	}
+	return signer.Sign(msg, time.Now())
 }
 
 type EmailResponse struct {
 	MessageID string
 	Status    string
 	Error     string
+	// Provider and Backend are set by composite providers to the name of
+	// the provider and backend that sent the email.
+	Provider string
+	Backend  string
+	// Recipients is set by providers that report each recipient's outcome
+	// separately, such as direct-mx.
+	Recipients []RecipientResult
+}
+
+// Recipient outcomes reported in RecipientResult.
+const (
+	RecipientAccepted = "accepted"
+	RecipientDeferred = "deferred"
+	RecipientRejected = "rejected"
+)
+
+// RecipientResult is the outcome of a send for one recipient. Host is the
+// server that gave the final answer and Code its SMTP reply code, when
+// there was one.
+type RecipientResult struct {
+	Address string
+	Status  string
+	Host    string
+	Code    int
+	Message string
+	err     error
 }
 
 type Provider interface {
@@ -34,15 +100,37 @@ type Config struct {
 	Password    string
 	Timeout     int
 	SenderEmail string
+	TLSMode     string
+	// Options holds provider-specific settings as JSON, decoded by each
+	// provider into its own typed options. See Registered for what each
+	// provider accepts.
+	Options json.RawMessage
+	// Pools, when set, lets SMTP providers keep sessions open between
+	// sends. PoolKey identifies the sender config whose sessions they are.
+	Pools   *SMTPPools
+	PoolKey string
+
+	// Resolver, Dial, HTTPClient and TLSConfig replace the network access
+	// of providers, e.g. to reach in-process servers in tests. When nil,
+	// the system resolver, a plain net.Dialer, an http.Client with the
+	// configured timeout and the system roots are used.
+	Resolver   Resolver
+	Dial       DialFunc
+	HTTPClient *http.Client
+	TLSConfig  *tls.Config
+
+	// DKIM, when set, signs messages sent by the SMTP providers.
+	DKIM *dkim.Signer
 }
 
-func NewProvider(cfg *Config) (Provider, error) {
-	switch cfg.Provider {
-	case "native-smtp":
-		return NewNativeSMTPProvider(cfg)
-	case "resend":
-		return NewResendProvider(cfg)
-	default:
-		return nil, fmt.Errorf("unsupported provider: %s", cfg.Provider)
-	}
+// Resolver is the subset of *net.Resolver that providers use.
+type Resolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
 }
+
+var _ Resolver = (*net.Resolver)(nil)
+
+// DialFunc opens a network connection, like net.Dialer.DialContext.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
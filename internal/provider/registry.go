@@ -0,0 +1,166 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// Factory creates a provider from its configuration. It decodes and
+// validates cfg.Options itself.
+type Factory func(cfg *Config) (Provider, error)
+
+// Options is implemented by each provider's typed options, which are decoded
+// from the raw JSON in Config.Options.
+type Options interface {
+	Validate() error
+}
+
+// OptionSchema describes one provider option, for clients building a form
+// and for documentation.
+type OptionSchema struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Required    bool     `json:"required,omitempty"`
+	Enum        []string `json:"enum,omitempty"`
+	Description string   `json:"description"`
+}
+
+// Registration is what a provider registers under its name.
+type Registration struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Options     []OptionSchema `json:"options"`
+	// NoCredentials is set for providers that do not need a secret.
+	NoCredentials bool `json:"noCredentials,omitempty"`
+	// DKIM is set for providers that sign mail with Config.DKIM.
+	DKIM bool    `json:"dkim,omitempty"`
+	New  Factory `json:"-"`
+	// NewOptions returns the provider's typed options with their defaults.
+	// It is nil for providers that take no options.
+	NewOptions func() Options `json:"-"`
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Registration{}
+)
+
+// Register makes a provider available by name. It panics if the name is
+// already taken, as that can only be a programming error.
+func Register(r Registration) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if r.Name == "" || r.New == nil {
+		panic("provider: Register needs a name and a factory")
+	}
+	if _, dup := registry[r.Name]; dup {
+		panic("provider: Register called twice for " + r.Name)
+	}
+	if r.Options == nil {
+		r.Options = []OptionSchema{}
+	}
+	registry[r.Name] = r
+}
+
+// Lookup returns the registration for a provider name.
+func Lookup(name string) (Registration, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	r, ok := registry[name]
+	return r, ok
+}
+
+// Registered returns every registered provider, sorted by name.
+func Registered() []Registration {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	list := make([]Registration, 0, len(registry))
+	for _, r := range registry {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Names returns the names of every registered provider, sorted.
+func Names() []string {
+	var names []string
+	for _, r := range Registered() {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+func NewProvider(cfg *Config) (Provider, error) {
+	r, ok := Lookup(cfg.Provider)
+	if !ok {
+		return nil, fmt.Errorf("unsupported provider: %s", cfg.Provider)
+	}
+	return r.New(cfg)
+}
+
+// ValidateOptions checks a provider name and its options without creating
+// the provider, so configuration can be validated before credentials are
+// available.
+func ValidateOptions(name string, raw json.RawMessage) error {
+	r, ok := Lookup(name)
+	if !ok {
+		return fmt.Errorf("unsupported provider: %s", name)
+	}
+	if r.NewOptions == nil {
+		if !emptyOptions(raw) {
+			return fmt.Errorf("%s takes no options", name)
+		}
+		return nil
+	}
+	return decodeOptions(raw, r.NewOptions())
+}
+
+// decodeOptions decodes raw into opts and validates the result. Unknown
+// fields are rejected so that misspelt options are reported rather than
+// silently ignored.
+func decodeOptions(raw json.RawMessage, opts Options) error {
+	if !emptyOptions(raw) {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(opts); err != nil {
+			return fmt.Errorf("invalid options: %w", err)
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		return fmt.Errorf("invalid options: %w", err)
+	}
+	return nil
+}
+
+func emptyOptions(raw json.RawMessage) bool {
+	raw = bytes.TrimSpace(raw)
+	return len(raw) == 0 || string(raw) == "null" || string(raw) == "{}"
+}
+
+// httpOptions are the options shared by the HTTP providers.
+type httpOptions struct {
+	// BaseURL overrides the API endpoint, e.g. to point at a test server.
+	BaseURL string `json:"baseURL,omitempty"`
+}
+
+var baseURLOption = OptionSchema{
+	Name:        "baseURL",
+	Type:        "string",
+	Description: "Overrides the API endpoint, e.g. to point at a test server.",
+}
+
+func (o httpOptions) Validate() error {
+	if o.BaseURL == "" {
+		return nil
+	}
+	u, err := url.Parse(o.BaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("baseURL must be an http or https URL")
+	}
+	return nil
+}
@@ -4,21 +4,37 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 )
 
+func init() {
+	Register(Registration{
+		Name:        "resend",
+		Description: "Resend email API.",
+		Options:     []OptionSchema{baseURLOption},
+		New:         NewResendProvider,
+		NewOptions:  func() Options { return &resendOptions{} },
+	})
+}
+
+type resendOptions struct {
+	httpOptions
+}
+
 type ResendProvider struct {
-	config *Config
-	client *http.Client
+	config  *Config
+	baseURL string
+	client  *http.Client
 }
 
 type resendEmailRequest struct {
-	From    string   `json:"from"`
-	To      []string `json:"to"`
-	Subject string   `json:"subject"`
-	HTML    string   `json:"html"`
+	From    string            `json:"from"`
+	To      []string          `json:"to"`
+	Subject string            `json:"subject"`
+	HTML    string            `json:"html"`
+	Headers map[string]string `json:"headers,omitempty"`
 }
 
 type resendEmailResponse struct {
@@ -35,10 +51,15 @@ func NewResendProvider(cfg *Config) (Provider, error) {
 	if cfg.Password == "" {
 		return nil, fmt.Errorf("resend API key is required")
 	}
+	var opts resendOptions
+	if err := decodeOptions(cfg.Options, &opts); err != nil {
+		return nil, err
+	}
 
 	return &ResendProvider{
-		config: cfg,
-		client: &http.Client{},
+		config:  cfg,
+		baseURL: apiBaseURL(opts.httpOptions, "https://api.resend.com"),
+		client:  newHTTPClient(cfg),
 	}, nil
 }
 
@@ -53,6 +74,7 @@ func (p *ResendProvider) Send(ctx context.Context, req *EmailRequest) (*EmailRes
 		To:      []string{req.To},
 		Subject: req.Subject,
 		HTML:    body,
+		Headers: req.Headers,
 	}
 
 	jsonData, err := json.Marshal(resendReq)
@@ -60,45 +82,20 @@ func (p *ResendProvider) Send(ctx context.Context, req *EmailRequest) (*EmailRes
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	httpReq, err := http.NewRequestWithContext(
-		ctx,
-		"POST",
-		"https://api.resend.com/emails",
-		bytes.NewBuffer(jsonData),
-	)
+	httpReq, err := http.NewRequest(http.MethodPost, p.baseURL+"/emails", bytes.NewReader(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
-
 	httpReq.Header.Set("Authorization", "Bearer "+p.config.Password)
 	httpReq.Header.Set("Content-Type", "application/json")
 
-	resp, err := p.client.Do(httpReq)
+	respBody, _, err := doAPI(ctx, p.client, httpReq, "send", parseResendError)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	bodyBytes, _ := io.ReadAll(resp.Body)
-
-	if resp.StatusCode != 200 {
-		var errResp resendErrorResponse
-		if err := json.Unmarshal(bodyBytes, &errResp); err != nil {
-			return &EmailResponse{
-				MessageID: req.MessageID,
-				Status:    "Failed",
-				Error:     fmt.Sprintf("resend API error %d: %s", resp.StatusCode, string(bodyBytes)),
-			}, fmt.Errorf("resend API error %d", resp.StatusCode)
-		}
-		return &EmailResponse{
-			MessageID: req.MessageID,
-			Status:    "Failed",
-			Error:     errResp.Message,
-		}, fmt.Errorf("resend error: %s", errResp.Message)
+		return nil, err
 	}
 
 	var emailResp resendEmailResponse
-	if err := json.Unmarshal(bodyBytes, &emailResp); err != nil {
+	if err := json.Unmarshal(respBody, &emailResp); err != nil {
 		return nil, fmt.Errorf("failed to parse response: %w", err)
 	}
 
@@ -109,32 +106,32 @@ func (p *ResendProvider) Send(ctx context.Context, req *EmailRequest) (*EmailRes
 	}, nil
 }
 
+// VerifyCredentials lists domains, which any full-access key may do. Keys
+// restricted to sending are refused with restricted_api_key, which still
+// proves the key is valid.
 func (p *ResendProvider) VerifyCredentials(ctx context.Context) error {
-	httpReq, err := http.NewRequestWithContext(
-		ctx,
-		"GET",
-		"https://api.resend.com/domains",
-		nil,
-	)
+	httpReq, err := http.NewRequest(http.MethodGet, p.baseURL+"/domains", nil)
 	if err != nil {
 		return err
 	}
-
 	httpReq.Header.Set("Authorization", "Bearer "+p.config.Password)
 
-	resp, err := p.client.Do(httpReq)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == 401 {
-		return fmt.Errorf("invalid Resend API key")
+	_, _, err = doAPI(ctx, p.client, httpReq, "api", parseResendError)
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.Code == "restricted_api_key" {
+		return nil
 	}
-
-	return nil
+	return err
 }
 
 func (p *ResendProvider) GetProviderName() string {
 	return "resend"
 }
+
+func parseResendError(resp *http.Response, body []byte) *Error {
+	var errResp resendErrorResponse
+	if err := json.Unmarshal(body, &errResp); err != nil || errResp.Message == "" {
+		return newAPIError("resend", resp.StatusCode, "", trimReply(body))
+	}
+	return newAPIError("resend", resp.StatusCode, errResp.Name, errResp.Message)
+}
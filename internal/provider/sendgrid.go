@@ -0,0 +1,175 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+func init() {
+	Register(Registration{
+		Name:        "sendgrid",
+		Description: "SendGrid v3 Mail Send API.",
+		Options: []OptionSchema{
+			{Name: "region", Type: "string", Enum: []string{"global", "eu"}, Description: "Use the EU endpoint for EU regional subusers."},
+			baseURLOption,
+		},
+		New:        NewSendGridProvider,
+		NewOptions: func() Options { return &sendGridOptions{} },
+	})
+}
+
+type sendGridOptions struct {
+	httpOptions
+	Region string `json:"region,omitempty"`
+}
+
+func (o *sendGridOptions) Validate() error {
+	switch o.Region {
+	case "", "global", "eu":
+	default:
+		return fmt.Errorf("region must be global or eu")
+	}
+	return o.httpOptions.Validate()
+}
+
+// SendGridProvider sends through the SendGrid v3 Mail Send API.
+type SendGridProvider struct {
+	config  *Config
+	baseURL string
+	client  *http.Client
+}
+
+type sendGridAddress struct {
+	Email string `json:"email"`
+}
+
+type sendGridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendGridPersonalization struct {
+	To []sendGridAddress `json:"to"`
+}
+
+type sendGridEmailRequest struct {
+	Personalizations []sendGridPersonalization `json:"personalizations"`
+	From             sendGridAddress           `json:"from"`
+	Subject          string                    `json:"subject"`
+	Content          []sendGridContent         `json:"content"`
+	Headers          map[string]string         `json:"headers,omitempty"`
+}
+
+type sendGridErrorResponse struct {
+	Errors []struct {
+		Message string `json:"message"`
+		Field   string `json:"field"`
+	} `json:"errors"`
+}
+
+func NewSendGridProvider(cfg *Config) (Provider, error) {
+	if cfg.Password == "" {
+		return nil, fmt.Errorf("sendgrid API key is required")
+	}
+	var opts sendGridOptions
+	if err := decodeOptions(cfg.Options, &opts); err != nil {
+		return nil, err
+	}
+	defaultURL := "https://api.sendgrid.com"
+	if opts.Region == "eu" {
+		defaultURL = "https://api.eu.sendgrid.com"
+	}
+	return &SendGridProvider{
+		config:  cfg,
+		baseURL: apiBaseURL(opts.httpOptions, defaultURL),
+		client:  newHTTPClient(cfg),
+	}, nil
+}
+
+func (p *SendGridProvider) Send(ctx context.Context, req *EmailRequest) (*EmailResponse, error) {
+	sgReq := sendGridEmailRequest{
+		Personalizations: []sendGridPersonalization{{To: []sendGridAddress{{Email: req.To}}}},
+		From:             sendGridAddress{Email: req.From},
+		Subject:          req.Subject,
+	}
+
+	// SendGrid requires text/plain to come before text/html.
+	if req.Body != "" || req.HTMLBody == "" {
+		sgReq.Content = append(sgReq.Content, sendGridContent{Type: "text/plain", Value: req.Body})
+	}
+	if req.HTMLBody != "" {
+		sgReq.Content = append(sgReq.Content, sendGridContent{Type: "text/html", Value: req.HTMLBody})
+	}
+	if headers := namedHeaders(req.Headers); len(headers) > 0 {
+		sgReq.Headers = make(map[string]string, len(headers))
+		for _, h := range headers {
+			sgReq.Headers[h.Name] = h.Value
+		}
+	}
+
+	jsonData, err := json.Marshal(sgReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, p.baseURL+"/v3/mail/send", bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+p.config.Password)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	_, header, err := doAPI(ctx, p.client, httpReq, "send", parseSendGridError)
+	if err != nil {
+		return nil, err
+	}
+	return &EmailResponse{MessageID: header.Get("X-Message-Id"), Status: "Sent"}, nil
+}
+
+// VerifyCredentials checks that the API key exists and has the mail.send
+// scope.
+func (p *SendGridProvider) VerifyCredentials(ctx context.Context) error {
+	httpReq, err := http.NewRequest(http.MethodGet, p.baseURL+"/v3/scopes", nil)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+p.config.Password)
+
+	body, _, err := doAPI(ctx, p.client, httpReq, "api", parseSendGridError)
+	if err != nil {
+		return err
+	}
+	var scopes struct {
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.Unmarshal(body, &scopes); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	if !contains(scopes.Scopes, "mail.send") {
+		return fmt.Errorf("sendgrid API key lacks the mail.send scope")
+	}
+	return nil
+}
+
+func (p *SendGridProvider) GetProviderName() string {
+	return "sendgrid"
+}
+
+func parseSendGridError(resp *http.Response, body []byte) *Error {
+	var errResp sendGridErrorResponse
+	if err := json.Unmarshal(body, &errResp); err != nil || len(errResp.Errors) == 0 {
+		return newAPIError("sendgrid", resp.StatusCode, "", trimReply(body))
+	}
+	messages := make([]string, 0, len(errResp.Errors))
+	for _, e := range errResp.Errors {
+		if e.Field != "" {
+			messages = append(messages, e.Field+": "+e.Message)
+		} else {
+			messages = append(messages, e.Message)
+		}
+	}
+	return newAPIError("sendgrid", resp.StatusCode, "", strings.Join(messages, "; "))
+}
@@ -0,0 +1,89 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestSendGridSend(t *testing.T) {
+	var got sendGridEmailRequest
+	p := newTestHTTPProvider(t, "sendgrid", Config{Password: "SG.key"}, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v3/mail/send" {
+			t.Errorf("request = %s %s, want POST /v3/mail/send", r.Method, r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer SG.key" {
+			t.Errorf("Authorization = %q", auth)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding body: %v", err)
+		}
+		w.Header().Set("X-Message-Id", "sg-123")
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	resp, err := p.Send(context.Background(), testEmailRequest())
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if resp.MessageID != "sg-123" {
+		t.Errorf("MessageID = %q, want sg-123", resp.MessageID)
+	}
+
+	want := sendGridEmailRequest{
+		Personalizations: []sendGridPersonalization{{To: []sendGridAddress{{Email: "rcpt@example.test"}}}},
+		From:             sendGridAddress{Email: "sender@example.test"},
+		Subject:          "Hello",
+		Content: []sendGridContent{
+			{Type: "text/plain", Value: "plain text"},
+			{Type: "text/html", Value: "<p>html</p>"},
+		},
+		Headers: map[string]string{"List-Unsubscribe": "<https://example.test/u>"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("request body = %+v, want %+v", got, want)
+	}
+}
+
+func TestSendGridErrors(t *testing.T) {
+	testErrorMapping(t, "sendgrid", Config{Password: "SG.key"}, []errorCase{
+		{name: "bad request", status: 400, body: `{"errors":[{"message":"Does not contain a valid address.","field":"personalizations.0.to.0.email"}]}`},
+		{name: "rate limited", status: 429, body: `{"errors":[{"message":"too many requests"}]}`, temporary: true},
+		{name: "outage", status: 503, body: `upstream unavailable`, temporary: true},
+		{name: "bad key", status: 401, body: `{"errors":[{"message":"The provided authorization grant is invalid"}]}`, temporary: true},
+	})
+}
+
+func TestSendGridErrorMessage(t *testing.T) {
+	p := newTestHTTPProvider(t, "sendgrid", Config{Password: "SG.key"}, replyWith(400, nil,
+		`{"errors":[{"message":"Does not contain a valid address.","field":"personalizations.0.to.0.email"}]}`))
+	_, err := p.Send(context.Background(), testEmailRequest())
+	want := "sendgrid API error 400: personalizations.0.to.0.email: Does not contain a valid address."
+	if err == nil || err.Error() != want {
+		t.Errorf("err = %v, want %q", err, want)
+	}
+}
+
+func TestSendGridVerifyCredentials(t *testing.T) {
+	tests := []struct {
+		scopes  string
+		wantErr bool
+	}{
+		{`{"scopes":["mail.send","stats.read"]}`, false},
+		{`{"scopes":["stats.read"]}`, true},
+	}
+	for _, tt := range tests {
+		p := newTestHTTPProvider(t, "sendgrid", Config{Password: "SG.key"}, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v3/scopes" {
+				t.Errorf("path = %s, want /v3/scopes", r.URL.Path)
+			}
+			io.WriteString(w, tt.scopes)
+		})
+		if err := p.VerifyCredentials(context.Background()); (err != nil) != tt.wantErr {
+			t.Errorf("scopes %s: VerifyCredentials() = %v, wantErr %t", tt.scopes, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register(Registration{
+		Name:        "ses",
+		Description: "Amazon SES v2 API. The username is the access key ID and the password the secret access key.",
+		Options: []OptionSchema{
+			{Name: "region", Type: "string", Required: true, Description: "AWS region, e.g. eu-west-1."},
+			baseURLOption,
+		},
+		New:        NewSESProvider,
+		NewOptions: func() Options { return &sesOptions{} },
+	})
+}
+
+var awsRegion = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d+$`)
+
+type sesOptions struct {
+	httpOptions
+	Region string `json:"region"`
+}
+
+func (o *sesOptions) Validate() error {
+	if !awsRegion.MatchString(o.Region) {
+		return fmt.Errorf("region must be an AWS region such as eu-west-1")
+	}
+	return o.httpOptions.Validate()
+}
+
+// SESProvider sends through the Amazon SES v2 API. Username holds the
+// access key ID and Password the secret access key.
+type SESProvider struct {
+	region      string
+	baseURL     string
+	credentials awsCredentials
+	client      *http.Client
+}
+
+type sesContent struct {
+	Data    string `json:"Data"`
+	Charset string `json:"Charset,omitempty"`
+}
+
+type sesEmailRequest struct {
+	FromEmailAddress string `json:"FromEmailAddress"`
+	Destination      struct {
+		ToAddresses []string `json:"ToAddresses"`
+	} `json:"Destination"`
+	Content struct {
+		Simple struct {
+			Subject sesContent `json:"Subject"`
+			Body    struct {
+				Text *sesContent `json:"Text,omitempty"`
+				HTML *sesContent `json:"Html,omitempty"`
+			} `json:"Body"`
+			Headers []namedHeader `json:"Headers,omitempty"`
+		} `json:"Simple"`
+	} `json:"Content"`
+}
+
+func NewSESProvider(cfg *Config) (Provider, error) {
+	if cfg.Username == "" || cfg.Password == "" {
+		return nil, fmt.Errorf("ses access key ID and secret access key are required")
+	}
+	var opts sesOptions
+	if err := decodeOptions(cfg.Options, &opts); err != nil {
+		return nil, err
+	}
+	return &SESProvider{
+		region:  opts.Region,
+		baseURL: apiBaseURL(opts.httpOptions, "https://email."+opts.Region+".amazonaws.com"),
+		credentials: awsCredentials{
+			accessKeyID:     cfg.Username,
+			secretAccessKey: cfg.Password,
+			region:          opts.Region,
+			service:         "ses",
+		},
+		client: newHTTPClient(cfg),
+	}, nil
+}
+
+func (p *SESProvider) Send(ctx context.Context, req *EmailRequest) (*EmailResponse, error) {
+	var sesReq sesEmailRequest
+	sesReq.FromEmailAddress = req.From
+	sesReq.Destination.ToAddresses = []string{req.To}
+	simple := &sesReq.Content.Simple
+	simple.Subject = sesContent{Data: req.Subject, Charset: "UTF-8"}
+	if req.Body != "" || req.HTMLBody == "" {
+		simple.Body.Text = &sesContent{Data: req.Body, Charset: "UTF-8"}
+	}
+	if req.HTMLBody != "" {
+		simple.Body.HTML = &sesContent{Data: req.HTMLBody, Charset: "UTF-8"}
+	}
+	simple.Headers = namedHeaders(req.Headers)
+
+	jsonData, err := json.Marshal(sesReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	httpReq, err := p.newRequest(http.MethodPost, "/v2/email/outbound-emails", jsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	body, _, err := doAPI(ctx, p.client, httpReq, "send", parseSESError)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		MessageID string `json:"MessageId"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return &EmailResponse{MessageID: resp.MessageID, Status: "Sent"}, nil
+}
+
+// VerifyCredentials fetches the account details, which checks the signature
+// and that sending has not been paused for the account.
+func (p *SESProvider) VerifyCredentials(ctx context.Context) error {
+	httpReq, err := p.newRequest(http.MethodGet, "/v2/email/account", nil)
+	if err != nil {
+		return err
+	}
+	body, _, err := doAPI(ctx, p.client, httpReq, "api", parseSESError)
+	if err != nil {
+		return err
+	}
+	var account struct {
+		SendingEnabled bool `json:"SendingEnabled"`
+	}
+	if err := json.Unmarshal(body, &account); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	if !account.SendingEnabled {
+		return fmt.Errorf("sending is disabled for this SES account in %s", p.region)
+	}
+	return nil
+}
+
+func (p *SESProvider) GetProviderName() string {
+	return "ses"
+}
+
+func (p *SESProvider) newRequest(method, path string, body []byte) (*http.Request, error) {
+	httpReq, err := http.NewRequest(method, p.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	p.credentials.sign(httpReq, body, time.Now())
+	return httpReq, nil
+}
+
+// parseSESError reads the error type from the X-Amzn-ErrorType header, e.g.
+// "MessageRejected:http://...", and the message from the body.
+func parseSESError(resp *http.Response, body []byte) *Error {
+	// Decoding is case-insensitive, which covers both "message" and
+	// "Message" as SES uses them.
+	var errResp struct {
+		Message string `json:"message"`
+	}
+	message := trimReply(body)
+	if err := json.Unmarshal(body, &errResp); err == nil && errResp.Message != "" {
+		message = errResp.Message
+	}
+	code, _, _ := strings.Cut(resp.Header.Get("X-Amzn-ErrorType"), ":")
+
+	apiErr := newAPIError("ses", resp.StatusCode, code, message)
+	switch code {
+	case "TooManyRequestsException", "LimitExceededException", "SendingPausedException", "AccountSuspendedException":
+		apiErr.Temporary = true
+	}
+	return apiErr
+}
@@ -0,0 +1,82 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSESSend(t *testing.T) {
+	credentials := awsCredentials{
+		accessKeyID:     "AKIDEXAMPLE",
+		secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		region:          "eu-west-1",
+		service:         "ses",
+	}
+	options, _ := json.Marshal(map[string]string{"region": "eu-west-1"})
+	cfg := Config{Username: credentials.accessKeyID, Password: credentials.secretAccessKey, Options: options}
+
+	var got sesEmailRequest
+	p := newTestHTTPProvider(t, "ses", cfg, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v2/email/outbound-emails" {
+			t.Errorf("request = %s %s, want POST /v2/email/outbound-emails", r.Method, r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+
+		// Sign the request as received and compare, which checks the
+		// signature covers what was actually sent.
+		signedAt, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+		if err != nil {
+			t.Errorf("X-Amz-Date: %v", err)
+		}
+		check, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+		check.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+		credentials.sign(check, body, signedAt)
+		if auth := r.Header.Get("Authorization"); auth != check.Header.Get("Authorization") {
+			t.Errorf("Authorization = %q, want %q", auth, check.Header.Get("Authorization"))
+		}
+
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("decoding body: %v", err)
+		}
+		io.WriteString(w, `{"MessageId":"0102018c-ses-id"}`)
+	})
+
+	resp, err := p.Send(context.Background(), testEmailRequest())
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if resp.MessageID != "0102018c-ses-id" {
+		t.Errorf("MessageID = %q", resp.MessageID)
+	}
+
+	var want sesEmailRequest
+	want.FromEmailAddress = "sender@example.test"
+	want.Destination.ToAddresses = []string{"rcpt@example.test"}
+	want.Content.Simple.Subject = sesContent{Data: "Hello", Charset: "UTF-8"}
+	want.Content.Simple.Body.Text = &sesContent{Data: "plain text", Charset: "UTF-8"}
+	want.Content.Simple.Body.HTML = &sesContent{Data: "<p>html</p>", Charset: "UTF-8"}
+	want.Content.Simple.Headers = []namedHeader{{Name: "List-Unsubscribe", Value: "<https://example.test/u>"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("request body = %+v, want %+v", got, want)
+	}
+}
+
+func TestSESErrors(t *testing.T) {
+	options, _ := json.Marshal(map[string]string{"region": "us-east-1"})
+	cfg := Config{Username: "AKIDEXAMPLE", Password: "secret", Options: options}
+	errorType := func(code string) http.Header {
+		return http.Header{"X-Amzn-Errortype": {code + ":http://internal.amazon.com/coral/com.amazonaws.sesv2/"}}
+	}
+	testErrorMapping(t, "ses", cfg, []errorCase{
+		{name: "message rejected", status: 400, header: errorType("MessageRejected"), body: `{"message":"Email address is not verified."}`},
+		{name: "throttled", status: 429, header: errorType("TooManyRequestsException"), body: `{"message":"Maximum sending rate exceeded."}`, temporary: true},
+		{name: "quota", status: 400, header: errorType("LimitExceededException"), body: `{"message":"Daily message quota exceeded."}`, temporary: true},
+		{name: "paused", status: 400, header: errorType("SendingPausedException"), body: `{"Message":"Sending is paused for this account."}`, temporary: true},
+		{name: "bad signature", status: 403, header: errorType("SignatureDoesNotMatch"), body: `{"message":"The request signature we calculated does not match"}`, temporary: true},
+	})
+}
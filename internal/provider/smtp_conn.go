@@ -0,0 +1,411 @@
+package provider
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// smtpConn is a minimal SMTP client session. Unlike net/smtp it keeps every
+// server reply so it can be reported in a Transcript.
+type smtpConn struct {
+	ctx        context.Context
+	conn       net.Conn
+	text       *textproto.Conn
+	serverName string
+	tlsConfig  *tls.Config
+	ext        map[string]string
+	tls        bool
+
+	// messages counts the messages sent in the session, and idleSince is
+	// when it was last returned to a pool.
+	messages  int
+	idleSince time.Time
+	// broken is set when the session is in an unknown state and must not
+	// be reused.
+	broken bool
+}
+
+// smtpDialer holds how SMTP sessions are opened: dial replaces
+// net.Dialer when set, and tlsConfig is the base for every handshake.
+type smtpDialer struct {
+	dial      DialFunc
+	tlsConfig *tls.Config
+	timeout   time.Duration
+}
+
+// clientTLSConfig returns the TLS settings for a handshake with serverName.
+func (d smtpDialer) clientTLSConfig(serverName string) *tls.Config {
+	config := &tls.Config{}
+	if d.tlsConfig != nil {
+		config = d.tlsConfig.Clone()
+	}
+	config.ServerName = serverName
+	return config
+}
+
+// dialSMTP connects to addr and reads the server greeting. With implicitTLS
+// the TLS handshake happens before the greeting (SMTPS, usually port 465).
+func dialSMTP(ctx context.Context, d smtpDialer, addr, serverName string, implicitTLS bool) (*smtpConn, error) {
+	started := time.Now()
+	dial := d.dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: d.timeout}).DialContext
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	conn, err := dial(dialCtx, "tcp", addr)
+	if err == nil && implicitTLS {
+		tlsConn := tls.Client(conn, d.clientTLSConfig(serverName))
+		if err = tlsConn.HandshakeContext(dialCtx); err != nil {
+			conn.Close()
+		}
+		conn = tlsConn
+	}
+	if err != nil {
+		err = fmt.Errorf("dial failed: %w", err)
+		recordStep(ctx, Step{Name: "connect", Command: addr}, started, err)
+		return nil, err
+	}
+	c := &smtpConn{
+		conn:       conn,
+		text:       textproto.NewConn(conn),
+		serverName: serverName,
+		tlsConfig:  d.clientTLSConfig(serverName),
+		tls:        implicitTLS,
+	}
+	c.use(ctx, d.timeout)
+
+	code, msg, err := c.text.ReadResponse(220)
+	recordStep(ctx, Step{Name: "connect", Command: addr, Code: code, Reply: msg}, started, err)
+	if err != nil {
+		c.close()
+		return nil, fmt.Errorf("greeting failed: %w", err)
+	}
+	return c, nil
+}
+
+// use binds the session to the context of the send it is used for: steps
+// are recorded in that context's transcript and I/O is bounded by its
+// deadline, or by timeout when it has none.
+func (c *smtpConn) use(ctx context.Context, timeout time.Duration) {
+	c.ctx = ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetDeadline(deadline)
+	} else {
+		c.conn.SetDeadline(time.Now().Add(timeout))
+	}
+}
+
+func (c *smtpConn) cmd(step string, expectCode int, format string, args ...interface{}) (int, string, error) {
+	started := time.Now()
+	command := fmt.Sprintf(format, args...)
+
+	id, err := c.text.Cmd("%s", command)
+	if err != nil {
+		recordStep(c.ctx, Step{Name: step, Command: redact(command)}, started, err)
+		return 0, "", err
+	}
+	c.text.StartResponse(id)
+	code, msg, err := c.text.ReadResponse(expectCode)
+	c.text.EndResponse(id)
+
+	recordStep(c.ctx, Step{Name: step, Command: redact(command), Code: code, Reply: msg}, started, err)
+	return code, msg, err
+}
+
+func (c *smtpConn) hello(localName string) error {
+	_, msg, err := c.cmd("ehlo", 250, "EHLO %s", localName)
+	if err != nil {
+		if _, _, err := c.cmd("helo", 250, "HELO %s", localName); err != nil {
+			return fmt.Errorf("helo failed: %w", err)
+		}
+		c.ext = map[string]string{}
+		return nil
+	}
+
+	c.ext = map[string]string{}
+	lines := strings.Split(msg, "\n")
+	for _, line := range lines[1:] {
+		name, args, _ := strings.Cut(line, " ")
+		c.ext[strings.ToUpper(name)] = args
+	}
+	return nil
+}
+
+func (c *smtpConn) extension(name string) (bool, string) {
+	args, ok := c.ext[strings.ToUpper(name)]
+	return ok, args
+}
+
+func (c *smtpConn) startTLS(localName string) error {
+	if _, _, err := c.cmd("starttls", 220, "STARTTLS"); err != nil {
+		return fmt.Errorf("starttls failed: %w", err)
+	}
+
+	started := time.Now()
+	tlsConn := tls.Client(c.conn, c.tlsConfig)
+	err := tlsConn.HandshakeContext(c.ctx)
+	reply := ""
+	if err == nil {
+		state := tlsConn.ConnectionState()
+		reply = fmt.Sprintf("%s %s", tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+	}
+	recordStep(c.ctx, Step{Name: "tls-handshake", Reply: reply}, started, err)
+	if err != nil {
+		return fmt.Errorf("tls handshake failed: %w", err)
+	}
+
+	c.conn = tlsConn
+	c.text = textproto.NewConn(tlsConn)
+	c.tls = true
+	return c.hello(localName)
+}
+
+// auth authenticates with PLAIN or LOGIN. A server that does not advertise
+// AUTH is an error rather than a step to skip: sending unauthenticated would
+// hide a misconfigured server, or an attacker who stripped STARTTLS and AUTH
+// from the EHLO reply. Credentials are only sent in the clear when
+// allowPlaintext is set or the server is local.
+func (c *smtpConn) auth(username, password string, allowPlaintext bool) error {
+	ok, mechs := c.extension("AUTH")
+	if !ok {
+		err := errors.New("credentials are configured but the server does not advertise AUTH")
+		recordStep(c.ctx, Step{Name: "auth"}, time.Now(), err)
+		return err
+	}
+
+	if !c.tls && !allowPlaintext && !isLocalhost(c.serverName) {
+		err := errors.New("refusing to send credentials over an unencrypted connection")
+		recordStep(c.ctx, Step{Name: "auth"}, time.Now(), err)
+		return err
+	}
+
+	supported := strings.Fields(strings.ToUpper(mechs))
+	switch {
+	case contains(supported, "PLAIN"):
+		resp := base64.StdEncoding.EncodeToString([]byte("\x00" + username + "\x00" + password))
+		if _, _, err := c.cmd("auth", 235, "AUTH PLAIN %s", resp); err != nil {
+			return fmt.Errorf("auth failed: %w", err)
+		}
+	case contains(supported, "LOGIN"):
+		if _, _, err := c.cmd("auth", 334, "AUTH LOGIN"); err != nil {
+			return fmt.Errorf("auth failed: %w", err)
+		}
+		if _, _, err := c.cmd("auth", 334, "%s", base64.StdEncoding.EncodeToString([]byte(username))); err != nil {
+			return fmt.Errorf("auth failed: %w", err)
+		}
+		if _, _, err := c.cmd("auth", 235, "%s", base64.StdEncoding.EncodeToString([]byte(password))); err != nil {
+			return fmt.Errorf("auth failed: %w", err)
+		}
+	default:
+		err := fmt.Errorf("no supported AUTH mechanism in %q", mechs)
+		recordStep(c.ctx, Step{Name: "auth"}, time.Now(), err)
+		return err
+	}
+	return nil
+}
+
+func (c *smtpConn) mail(from string) error {
+	if err := c.checkAddress("mail", from); err != nil {
+		return err
+	}
+	if _, _, err := c.cmd("mail", 250, "MAIL FROM:<%s>", from); err != nil {
+		return &senderRejectedError{fmt.Errorf("mail from failed: %w", err)}
+	}
+	return nil
+}
+
+func (c *smtpConn) rcpt(to string) error {
+	if err := c.checkAddress("rcpt", to); err != nil {
+		return err
+	}
+	if _, _, err := c.cmd("rcpt", 25, "RCPT TO:<%s>", to); err != nil {
+		return fmt.Errorf("rcpt to failed: %w", err)
+	}
+	return nil
+}
+
+// envelope starts a transaction: MAIL FROM, RCPT TO and DATA. When the
+// server advertises PIPELINING (RFC 2920) the three commands are written
+// together and the replies read afterwards, saving two round trips.
+func (c *smtpConn) envelope(from, to string) error {
+	// Both addresses are checked before anything is written, so a bad
+	// recipient does not leave a transaction open behind it.
+	if err := c.checkAddress("mail", from); err != nil {
+		return err
+	}
+	if err := c.checkAddress("rcpt", to); err != nil {
+		return err
+	}
+	if ok, _ := c.extension("PIPELINING"); !ok {
+		if err := c.mail(from); err != nil {
+			return err
+		}
+		if err := c.rcpt(to); err != nil {
+			return err
+		}
+		if _, _, err := c.cmd("data", 354, "DATA"); err != nil {
+			return fmt.Errorf("data failed: %w", err)
+		}
+		return nil
+	}
+
+	commands := []struct {
+		step    string
+		expect  int
+		command string
+	}{
+		{"mail", 250, fmt.Sprintf("MAIL FROM:<%s>", from)},
+		{"rcpt", 25, fmt.Sprintf("RCPT TO:<%s>", to)},
+		{"data", 354, "DATA"},
+	}
+	started := time.Now()
+	ids := make([]uint, len(commands))
+	for i, command := range commands {
+		id, err := c.text.Cmd("%s", command.command)
+		if err != nil {
+			c.broken = true
+			recordStep(c.ctx, Step{Name: command.step, Command: command.command}, started, err)
+			return err
+		}
+		ids[i] = id
+	}
+
+	errs := make([]error, len(commands))
+	for i, command := range commands {
+		c.text.StartResponse(ids[i])
+		code, msg, err := c.text.ReadResponse(command.expect)
+		c.text.EndResponse(ids[i])
+		recordStep(c.ctx, Step{Name: command.step, Command: command.command, Code: code, Reply: msg}, started, err)
+		if _, ok := err.(*textproto.Error); err != nil && !ok {
+			c.broken = true
+			return err
+		}
+		errs[i] = err
+	}
+
+	switch {
+	case errs[0] != nil:
+		err := &senderRejectedError{fmt.Errorf("mail from failed: %w", errs[0])}
+		if errs[2] == nil {
+			// The server should refuse DATA without a transaction; if it
+			// did not, it is waiting for a message we will not send.
+			c.broken = true
+		}
+		return err
+	case errs[1] != nil:
+		if errs[2] == nil {
+			c.broken = true
+		}
+		return fmt.Errorf("rcpt to failed: %w", errs[1])
+	case errs[2] != nil:
+		return fmt.Errorf("data failed: %w", errs[2])
+	}
+	return nil
+}
+
+// checkAddress refuses an envelope address that would end the command it
+// is sent in and start another one.
+func (c *smtpConn) checkAddress(step, address string) error {
+	if !strings.ContainsAny(address, "\r\n") {
+		return nil
+	}
+	err := fmt.Errorf("%s %q: %w", step, address, errInvalidAddress)
+	recordStep(c.ctx, Step{Name: step}, time.Now(), err)
+	return err
+}
+
+// message sends the message after a successful DATA command and reads the
+// server's verdict.
+func (c *smtpConn) message(msg []byte) error {
+	started := time.Now()
+	w := c.text.DotWriter()
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		c.broken = true
+		recordStep(c.ctx, Step{Name: "message"}, started, err)
+		return fmt.Errorf("write failed: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		c.broken = true
+		recordStep(c.ctx, Step{Name: "message"}, started, err)
+		return fmt.Errorf("close failed: %w", err)
+	}
+	code, reply, err := c.text.ReadResponse(250)
+	recordStep(c.ctx, Step{Name: "message", Code: code, Reply: reply}, started, err)
+	if err != nil {
+		if _, ok := err.(*textproto.Error); !ok {
+			c.broken = true
+		}
+		return fmt.Errorf("message rejected: %w", err)
+	}
+	c.messages++
+	return nil
+}
+
+// reset aborts the current transaction so the session can be reused.
+func (c *smtpConn) reset() error {
+	_, _, err := c.cmd("rset", 250, "RSET")
+	return err
+}
+
+// noop checks that the server is still there.
+func (c *smtpConn) noop() error {
+	_, _, err := c.cmd("noop", 250, "NOOP")
+	return err
+}
+
+func (c *smtpConn) quit() error {
+	_, _, err := c.cmd("quit", 221, "QUIT")
+	c.close()
+	return err
+}
+
+func (c *smtpConn) close() error {
+	return c.text.Close()
+}
+
+// redact hides credentials sent during AUTH.
+func redact(command string) string {
+	upper := strings.ToUpper(command)
+	switch {
+	case strings.HasPrefix(upper, "AUTH PLAIN "):
+		return "AUTH PLAIN ****"
+	case strings.HasPrefix(upper, "AUTH "), strings.HasPrefix(upper, "EHLO"), strings.HasPrefix(upper, "HELO"),
+		strings.HasPrefix(upper, "MAIL"), strings.HasPrefix(upper, "RCPT"), upper == "DATA",
+		upper == "QUIT", upper == "STARTTLS", upper == "RSET", upper == "NOOP":
+		return command
+	default:
+		return "****"
+	}
+}
+
+func localHostname() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "localhost"
+	}
+	return name
+}
+
+func isLocalhost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
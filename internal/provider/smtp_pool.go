@@ -0,0 +1,203 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Defaults for pooled SMTP sessions.
+const (
+	DefaultMaxMessagesPerConnection = 100
+	DefaultPoolIdleTimeout          = 30 * time.Second
+	// maxIdleSessions caps the sessions a pool keeps open while unused.
+	maxIdleSessions = 4
+	// noopAfter is how long a session may sit idle before it is checked
+	// with NOOP on its next use, to catch servers that dropped it.
+	noopAfter = 5 * time.Second
+	// reapInterval is how often idle sessions are looked at for expiry.
+	reapInterval = 10 * time.Second
+)
+
+// SMTPPools keeps authenticated SMTP sessions open between sends, so bulk
+// sending does not pay for a new connection, TLS handshake and AUTH per
+// email. Sessions are pooled per sender config and connection settings:
+// a config whose credentials change gets a new pool, and the old one
+// empties as its sessions expire. Pass the same SMTPPools to every
+// provider, via Config.Pools, for as long as the process runs.
+type SMTPPools struct {
+	mu    sync.Mutex
+	pools map[string]*smtpPool
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// NewSMTPPools starts a set of pools along with a goroutine that closes
+// expired sessions. Call Close to stop it.
+func NewSMTPPools() *SMTPPools {
+	ps := &SMTPPools{pools: map[string]*smtpPool{}, stop: make(chan struct{})}
+	go ps.reapLoop()
+	return ps
+}
+
+// Close quits every idle session and stops the reaper. Sessions in use are
+// closed when they are returned.
+func (ps *SMTPPools) Close() {
+	ps.once.Do(func() { close(ps.stop) })
+	ps.mu.Lock()
+	pools := ps.pools
+	ps.pools = map[string]*smtpPool{}
+	ps.mu.Unlock()
+	for _, pool := range pools {
+		pool.closeIdle(time.Time{}, true)
+	}
+}
+
+func (ps *SMTPPools) reapLoop() {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ps.stop:
+			return
+		case now := <-ticker.C:
+			ps.reap(now)
+		}
+	}
+}
+
+// reap closes expired sessions and forgets pools that have been unused for
+// longer than their idle timeout.
+func (ps *SMTPPools) reap(now time.Time) {
+	ps.mu.Lock()
+	pools := make(map[string]*smtpPool, len(ps.pools))
+	for key, pool := range ps.pools {
+		pools[key] = pool
+	}
+	ps.mu.Unlock()
+
+	for key, pool := range pools {
+		if pool.closeIdle(now, false) {
+			ps.mu.Lock()
+			if ps.pools[key] == pool {
+				delete(ps.pools, key)
+			}
+			ps.mu.Unlock()
+		}
+	}
+}
+
+func (ps *SMTPPools) get(key string, maxMessages int, idleTimeout time.Duration) *smtpPool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	pool, ok := ps.pools[key]
+	if !ok {
+		pool = &smtpPool{maxMessages: maxMessages, idleTimeout: idleTimeout, closed: ps.closed}
+		ps.pools[key] = pool
+	}
+	pool.touch()
+	return pool
+}
+
+func (ps *SMTPPools) closed() bool {
+	select {
+	case <-ps.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// poolKey identifies the sessions that may be shared: those of one sender
+// config with identical connection settings. The password is hashed so it
+// is not kept around as a map key.
+func poolKey(cfg *Config, tlsMode string, maxMessages int, idleTimeout time.Duration) string {
+	password := sha256.Sum256([]byte(cfg.Password))
+	return fmt.Sprintf("%s|%s:%d|%s|%x|%s|%d|%s",
+		cfg.PoolKey, cfg.Host, cfg.Port, cfg.Username, password[:8], tlsMode, maxMessages, idleTimeout)
+}
+
+// smtpPool holds the idle sessions to one server, most recently used last.
+type smtpPool struct {
+	mu          sync.Mutex
+	idle        []*smtpConn
+	lastUsed    time.Time
+	maxMessages int
+	idleTimeout time.Duration
+	closed      func() bool
+}
+
+func (p *smtpPool) touch() {
+	p.mu.Lock()
+	p.lastUsed = time.Now()
+	p.mu.Unlock()
+}
+
+// take returns the most recently used idle session that has not expired,
+// or nil.
+func (p *smtpPool) take() *smtpConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.idle) > 0 {
+		conn := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if time.Since(conn.idleSince) < p.idleTimeout {
+			return conn
+		}
+		go quitIdle(conn)
+	}
+	return nil
+}
+
+// put returns a session to the pool, or quits it when it has sent its
+// share of messages or the pool is full.
+func (p *smtpPool) put(conn *smtpConn) {
+	if conn.messages >= p.maxMessages || p.closed() {
+		go quitIdle(conn)
+		return
+	}
+	conn.idleSince = time.Now()
+	conn.ctx = context.Background()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastUsed = conn.idleSince
+	if len(p.idle) >= maxIdleSessions {
+		go quitIdle(p.idle[0])
+		p.idle = p.idle[1:]
+	}
+	p.idle = append(p.idle, conn)
+}
+
+// closeIdle quits sessions idle since before now minus the idle timeout,
+// or all of them with all set. It reports whether the pool is empty and
+// unused for that long, so it can be dropped.
+func (p *smtpPool) closeIdle(now time.Time, all bool) bool {
+	cutoff := now.Add(-p.idleTimeout)
+	p.mu.Lock()
+	var expired, kept []*smtpConn
+	for _, conn := range p.idle {
+		if all || conn.idleSince.Before(cutoff) {
+			expired = append(expired, conn)
+		} else {
+			kept = append(kept, conn)
+		}
+	}
+	p.idle = kept
+	empty := len(kept) == 0 && p.lastUsed.Before(cutoff)
+	p.mu.Unlock()
+
+	for _, conn := range expired {
+		quitIdle(conn)
+	}
+	return empty
+}
+
+// quitIdle ends a session no send is waiting on, without holding up the
+// caller for long if the server does not answer.
+func quitIdle(conn *smtpConn) {
+	conn.use(context.Background(), 5*time.Second)
+	_ = conn.quit()
+}
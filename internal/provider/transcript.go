@@ -0,0 +1,69 @@
+package provider
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Step is one stage of a conversation with a provider, such as CONNECT, EHLO
+// or AUTH, along with the server's reply.
+type Step struct {
+	Name       string `json:"name"`
+	Command    string `json:"command,omitempty"`
+	OK         bool   `json:"ok"`
+	Code       int    `json:"code,omitempty"`
+	Reply      string `json:"reply,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// Transcript collects the steps a provider performs. Attach one to a context
+// with WithTranscript before calling Send or VerifyCredentials.
+type Transcript struct {
+	mu    sync.Mutex
+	steps []Step
+}
+
+// Steps returns a copy of the recorded steps.
+func (t *Transcript) Steps() []Step {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Step(nil), t.steps...)
+}
+
+// Failed returns the first step that did not succeed, if any.
+func (t *Transcript) Failed() *Step {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.steps {
+		if !t.steps[i].OK {
+			s := t.steps[i]
+			return &s
+		}
+	}
+	return nil
+}
+
+type transcriptKey struct{}
+
+// WithTranscript returns a context that records provider steps into t.
+func WithTranscript(ctx context.Context, t *Transcript) context.Context {
+	return context.WithValue(ctx, transcriptKey{}, t)
+}
+
+func recordStep(ctx context.Context, step Step, started time.Time, err error) {
+	t, ok := ctx.Value(transcriptKey{}).(*Transcript)
+	if !ok || t == nil {
+		return
+	}
+	step.OK = err == nil
+	if err != nil {
+		step.Error = err.Error()
+	}
+	step.DurationMS = time.Since(started).Milliseconds()
+
+	t.mu.Lock()
+	t.steps = append(t.steps, step)
+	t.mu.Unlock()
+}
@@ -0,0 +1,146 @@
+// Package webhook holds the operator's admission webhooks.
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	emailv1alpha1 "github.com/Gatete-Bruno/besend/api/v1alpha1"
+	"github.com/Gatete-Bruno/besend/internal/provider"
+)
+
+//+kubebuilder:webhook:path=/validate-email-example-com-v1alpha1-emailsenderconfig,mutating=false,failurePolicy=fail,sideEffects=None,groups=email.example.com,resources=emailsenderconfigs,verbs=create;update,versions=v1alpha1,name=vemailsenderconfig.besend.io,admissionReviewVersions=v1
+
+// EmailSenderConfigValidator rejects EmailSenderConfigs whose provider is
+// not registered in the operator, whose options that provider does not
+// accept, or, for composite configs, whose routing is invalid. Credentials
+// are not checked, as the secret may not exist yet.
+type EmailSenderConfigValidator struct{}
+
+var _ admission.CustomValidator = &EmailSenderConfigValidator{}
+
+func (v *EmailSenderConfigValidator) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&emailv1alpha1.EmailSenderConfig{}).
+		WithValidator(v).
+		Complete()
+}
+
+func (v *EmailSenderConfigValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, v.validate(obj)
+}
+
+func (v *EmailSenderConfigValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	return nil, v.validate(newObj)
+}
+
+func (v *EmailSenderConfigValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *EmailSenderConfigValidator) validate(obj runtime.Object) error {
+	config, ok := obj.(*emailv1alpha1.EmailSenderConfig)
+	if !ok {
+		return fmt.Errorf("expected an EmailSenderConfig, got %T", obj)
+	}
+
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+	if config.Spec.Provider == provider.Composite {
+		errs = append(errs, validateRouting(config, specPath)...)
+	} else if reg, ok := provider.Lookup(config.Spec.Provider); !ok {
+		errs = append(errs, field.NotSupported(specPath.Child("provider"), config.Spec.Provider, append(provider.Names(), provider.Composite)))
+	} else {
+		if config.Spec.Routing != nil {
+			errs = append(errs, field.Forbidden(specPath.Child("routing"), "only composite configs have routing"))
+		}
+		if config.Spec.APITokenSecretRef == "" && !reg.NoCredentials {
+			errs = append(errs, field.Required(specPath.Child("apiTokenSecretRef"), ""))
+		}
+		var raw []byte
+		if config.Spec.Options != nil {
+			raw = config.Spec.Options.Raw
+		}
+		if err := provider.ValidateOptions(config.Spec.Provider, raw); err != nil {
+			errs = append(errs, field.Invalid(specPath.Child("options"), string(raw), err.Error()))
+		}
+		errs = append(errs, validateDKIM(config, reg, specPath)...)
+	}
+	if config.Spec.Provider == provider.Composite && config.Spec.DKIM != nil {
+		errs = append(errs, field.Forbidden(specPath.Child("dkim"), "composite configs are signed by their backends"))
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(emailv1alpha1.GroupVersion.WithKind("EmailSenderConfig").GroupKind(), config.Name, errs)
+}
+
+// validateDKIM checks the signing key reference of providers that sign.
+func validateDKIM(config *emailv1alpha1.EmailSenderConfig, reg provider.Registration, specPath *field.Path) field.ErrorList {
+	dkim := config.Spec.DKIM
+	if dkim == nil {
+		return nil
+	}
+	dkimPath := specPath.Child("dkim")
+	if !reg.DKIM {
+		return field.ErrorList{field.Forbidden(dkimPath, fmt.Sprintf("%s does not sign mail itself", reg.Name))}
+	}
+	var errs field.ErrorList
+	if dkim.Selector == "" {
+		errs = append(errs, field.Required(dkimPath.Child("selector"), ""))
+	}
+	if dkim.PrivateKeySecretRef == "" {
+		errs = append(errs, field.Required(dkimPath.Child("privateKeySecretRef"), ""))
+	}
+	if dkim.Domain == "" && !strings.Contains(config.Spec.SenderEmail, "@") {
+		errs = append(errs, field.Required(dkimPath.Child("domain"), "senderEmail has no domain to default to"))
+	}
+	return errs
+}
+
+// validateRouting checks a composite config's backends. Whether they exist
+// is only known when sending, as they may be created in any order.
+func validateRouting(config *emailv1alpha1.EmailSenderConfig, specPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if config.Spec.Options != nil && len(config.Spec.Options.Raw) > 0 {
+		errs = append(errs, field.Forbidden(specPath.Child("options"), "composite configs take no options"))
+	}
+	routing := config.Spec.Routing
+	routingPath := specPath.Child("routing")
+	if routing == nil || len(routing.Backends) == 0 {
+		return append(errs, field.Required(routingPath.Child("backends"), "composite configs need at least one backend"))
+	}
+	switch routing.Strategy {
+	case "", provider.RoutingPriority, provider.RoutingWeighted:
+	default:
+		errs = append(errs, field.NotSupported(routingPath.Child("strategy"), routing.Strategy, []string{provider.RoutingPriority, provider.RoutingWeighted}))
+	}
+
+	seen := map[string]bool{}
+	for i, backend := range routing.Backends {
+		path := routingPath.Child("backends").Index(i)
+		switch {
+		case backend.SenderConfigRef == "":
+			errs = append(errs, field.Required(path.Child("senderConfigRef"), ""))
+		case backend.SenderConfigRef == config.Name:
+			errs = append(errs, field.Invalid(path.Child("senderConfigRef"), backend.SenderConfigRef, "a composite config cannot route to itself"))
+		case seen[backend.SenderConfigRef]:
+			errs = append(errs, field.Duplicate(path.Child("senderConfigRef"), backend.SenderConfigRef))
+		}
+		seen[backend.SenderConfigRef] = true
+		if backend.Priority < 0 {
+			errs = append(errs, field.Invalid(path.Child("priority"), backend.Priority, "must not be negative"))
+		}
+		if backend.Weight < 0 {
+			errs = append(errs, field.Invalid(path.Child("weight"), backend.Weight, "must not be negative"))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Gatete-Bruno/besend/pkg/database"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	redacted      = "[redacted]"
+	maxAuditLimit = 500
+)
+
+// recordAudit writes an audit event for the authenticated customer. Failures
+// are logged rather than surfaced, since the change itself has already been
+// committed.
+func (s *Server) recordAudit(c *gin.Context, action, resourceType string, resourceID int, changes map[string]database.AuditChange) {
+	customer := c.MustGet("customer").(*database.Customer)
+	actor := fmt.Sprintf("customer:%d via %s", customer.ID, c.GetString("auth_method"))
+	s.recordAuditFor(c, customer.ID, actor, action, resourceType, strconv.Itoa(resourceID), changes)
+}
+
+// recordAuditFor writes an audit event for a customer who need not be
+// authenticated yet, such as one logging in.
+func (s *Server) recordAuditFor(c *gin.Context, customerID int, actor, action, resourceType, resourceID string, changes map[string]database.AuditChange) {
+	event := &database.AuditEvent{
+		CustomerID:   customerID,
+		Actor:        actor,
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Changes:      changes,
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+	}
+	if err := s.Audit.Record(event); err != nil {
+		log.Printf("Failed to record audit event %s for %s %s: %v", action, resourceType, resourceID, err)
+	}
+}
+
+// addChange records a field in changes when its value differs.
+func addChange(changes map[string]database.AuditChange, field string, from, to interface{}) {
+	if from != to {
+		changes[field] = database.AuditChange{From: from, To: to}
+	}
+}
+
+// createdChanges records the fields of a new resource as changed from nothing.
+func createdChanges(fields map[string]interface{}) map[string]database.AuditChange {
+	changes := map[string]database.AuditChange{}
+	for field, value := range fields {
+		changes[field] = database.AuditChange{To: value}
+	}
+	return changes
+}
+
+// deletedChanges records the fields of a deleted resource as changed to
+// nothing.
+func deletedChanges(fields map[string]interface{}) map[string]database.AuditChange {
+	changes := map[string]database.AuditChange{}
+	for field, value := range fields {
+		changes[field] = database.AuditChange{From: value}
+	}
+	return changes
+}
+
+// ListAuditEvents returns the customer's audit log, newest first. It can
+// be filtered by action (exact, or a prefix such as api_key.*),
+// resource_type, resource_id, actor, and an RFC 3339 since/until range.
+func (s *Server) ListAuditEvents(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > maxAuditLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxAuditLimit)})
+		return
+	}
+	filter := database.AuditFilter{
+		Action:       c.Query("action"),
+		ResourceType: c.Query("resource_type"),
+		ResourceID:   c.Query("resource_id"),
+		Actor:        c.Query("actor"),
+	}
+	for param, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := c.Query(param); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": param + " must be an RFC 3339 time"})
+				return
+			}
+			*t = parsed.UTC()
+		}
+	}
+	var before int64
+	if v := c.Query("cursor"); v != "" {
+		if before, err = strconv.ParseInt(v, 10, 64); err != nil || before < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+	}
+
+	list, err := s.Audit.List(customer.ID, filter, before, limit+1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit events"})
+		return
+	}
+
+	var next string
+	hasMore := len(list) > limit
+	if hasMore {
+		list = list[:limit]
+		next = strconv.FormatInt(list[len(list)-1].ID, 10)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"events":      list,
+		"next_cursor": next,
+		"has_more":    hasMore,
+	})
+}
+
+// VerifyAuditChain checks the customer's audit events against their hash
+// chain.
+func (s *Server) VerifyAuditChain(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+
+	report, err := s.Audit.Verify(customer.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify audit log"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -3,8 +3,12 @@ package handlers
 import (
 	"crypto/rand"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/Gatete-Bruno/besend/pkg/database"
@@ -15,7 +19,7 @@ import (
 
 var jwtSecret = []byte("your-secret-key-change-in-production")
 
-func Register(c *gin.Context) {
+func (s *Server) Register(c *gin.Context) {
 	var req struct {
 		Email    string `json:"email" binding:"required,email"`
 		Password string `json:"password" binding:"required,min=6"`
@@ -32,18 +36,16 @@ func Register(c *gin.Context) {
 		return
 	}
 
-	var id int
-	err = database.DB.QueryRow(
-		"INSERT INTO customers (email, password_hash, plan) VALUES ($1, $2, $3) RETURNING id",
-		req.Email, string(hash), "starter",
-	).Scan(&id)
+	customer, err := s.Customers.Create(req.Email, string(hash), "starter")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Email already exists"})
 		return
 	}
+	s.recordAuditFor(c, customer.ID, fmt.Sprintf("customer:%d via password", customer.ID), "customer.register", "customer",
+		strconv.Itoa(customer.ID), createdChanges(map[string]interface{}{"email": req.Email}))
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"customer_id": id,
+		"customer_id": customer.ID,
 		"exp":         time.Now().Add(24 * time.Hour).Unix(),
 	})
 
@@ -59,7 +61,7 @@ func Register(c *gin.Context) {
 	})
 }
 
-func Login(c *gin.Context) {
+func (s *Server) Login(c *gin.Context) {
 	var req struct {
 		Email    string `json:"email" binding:"required,email"`
 		Password string `json:"password" binding:"required"`
@@ -70,13 +72,15 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	customer, err := database.GetCustomerByEmail(req.Email)
+	customer, err := s.Customers.GetByEmail(req.Email)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
+	actor := fmt.Sprintf("customer:%d via password", customer.ID)
 	if err := bcrypt.CompareHashAndPassword([]byte(customer.PasswordHash), []byte(req.Password)); err != nil {
+		s.recordAuditFor(c, customer.ID, actor, "auth.login_failed", "customer", strconv.Itoa(customer.ID), nil)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
@@ -91,6 +95,7 @@ func Login(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
 		return
 	}
+	s.recordAuditFor(c, customer.ID, actor, "auth.login", "customer", strconv.Itoa(customer.ID), nil)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Login successful",
@@ -98,7 +103,7 @@ func Login(c *gin.Context) {
 	})
 }
 
-func CreateAPIKey(c *gin.Context) {
+func (s *Server) CreateAPIKey(c *gin.Context) {
 	customer := c.MustGet("customer").(*database.Customer)
 
 	var req struct {
@@ -120,11 +125,12 @@ func CreateAPIKey(c *gin.Context) {
 	keyHash := sha256.Sum256([]byte(keyString))
 	keyHashStr := hex.EncodeToString(keyHash[:])
 
-	apiKey, err := database.CreateAPIKey(customer.ID, keyHashStr, req.Name)
+	apiKey, err := s.APIKeys.Create(customer.ID, keyHashStr, req.Name)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
 		return
 	}
+	s.recordAudit(c, "api_key.create", "api_key", apiKey.ID, createdChanges(map[string]interface{}{"name": apiKey.Name}))
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "API key created",
@@ -138,10 +144,10 @@ func CreateAPIKey(c *gin.Context) {
 	})
 }
 
-func GetAPIKeys(c *gin.Context) {
+func (s *Server) GetAPIKeys(c *gin.Context) {
 	customer := c.MustGet("customer").(*database.Customer)
 
-	keys, err := database.GetAPIKeysByCustomer(customer.ID)
+	keys, err := s.APIKeys.List(customer.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API keys"})
 		return
@@ -150,7 +156,9 @@ func GetAPIKeys(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"keys": keys})
 }
 
-func DeleteAPIKey(c *gin.Context) {
+func (s *Server) DeleteAPIKey(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+
 	var req struct {
 		ID int `json:"id" binding:"required"`
 	}
@@ -160,10 +168,92 @@ func DeleteAPIKey(c *gin.Context) {
 		return
 	}
 
-	if err := database.DeleteAPIKey(req.ID); err != nil {
+	// Look the key up first, for the audit record.
+	key, err := s.APIKeys.Get(customer.ID, req.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete API key"})
+		return
+	}
+
+	if err := s.APIKeys.Delete(customer.ID, req.ID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete API key"})
 		return
 	}
+	s.recordAudit(c, "api_key.delete", "api_key", key.ID, deletedChanges(map[string]interface{}{
+		"name":        key.Name,
+		"description": key.Description,
+	}))
 
 	c.JSON(http.StatusOK, gin.H{"message": "API key deleted"})
 }
+
+// UpdateAPIKey renames an API key or changes its description. PUT requires a
+// name; PATCH changes only the fields present.
+func (s *Server) UpdateAPIKey(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+	keyID, _ := strconv.Atoi(c.Param("id"))
+
+	var req struct {
+		Name        *string    `json:"name"`
+		Description *string    `json:"description"`
+		UpdatedAt   *time.Time `json:"updated_at"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	errs := map[string]string{}
+	if c.Request.Method == http.MethodPut && req.Name == nil {
+		errs["name"] = "is required"
+	}
+	if req.Name != nil && len(*req.Name) > 255 {
+		errs["name"] = "must be at most 255 characters"
+	}
+	if req.Description != nil && len(*req.Description) > 1000 {
+		errs["description"] = "must be at most 1000 characters"
+	}
+	if len(errs) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Validation failed", "fields": errs})
+		return
+	}
+	if c.Request.Method == http.MethodPut && req.Description == nil {
+		empty := ""
+		req.Description = &empty
+	}
+
+	expected, err := expectedVersion(c, req.UpdatedAt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, after, err := s.APIKeys.Update(customer.ID, keyID, req.Name, req.Description, expected)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	case errors.Is(err, database.ErrPreconditionFailed):
+		c.Header("ETag", etag(before.UpdatedAt))
+		c.JSON(http.StatusPreconditionFailed, gin.H{
+			"error":      "API key was modified by another request",
+			"updated_at": before.UpdatedAt,
+		})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update API key"})
+		return
+	}
+
+	changes := map[string]database.AuditChange{}
+	addChange(changes, "name", before.Name, after.Name)
+	addChange(changes, "description", before.Description, after.Description)
+	s.recordAudit(c, "api_key.update", "api_key", keyID, changes)
+
+	c.Header("ETag", etag(after.UpdatedAt))
+	c.JSON(http.StatusOK, gin.H{"key": after})
+}
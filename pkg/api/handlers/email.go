@@ -1,52 +1,215 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"net"
 	"net/smtp"
 	"net/http"
 	"strconv"
 	"github.com/gin-gonic/gin"
+	"github.com/Gatete-Bruno/besend/internal/provider"
 	"github.com/Gatete-Bruno/besend/pkg/database"
 )
 
-func SendEmail(c *gin.Context) {
+func (s *Server) SendEmail(c *gin.Context) {
 	var req struct {
-		SMTPConfigID int    `json:"smtp_config_id" binding:"required"`
-		To           string `json:"to" binding:"required"`
-		Subject      string `json:"subject" binding:"required"`
-		Body         string `json:"body" binding:"required"`
+		// Exactly one of SMTPConfigID and RouteID is given. A route fails
+		// over between, or spreads mail across, several SMTP configs.
+		SMTPConfigID int      `json:"smtp_config_id"`
+		RouteID      int      `json:"route_id"`
+		To           string   `json:"to" binding:"required"`
+		Subject      string   `json:"subject" binding:"required"`
+		Body         string   `json:"body" binding:"required"`
+		HTMLBody     string   `json:"html_body"`
+		Tags         []string `json:"tags" binding:"max=10"`
+		// TrackOpens and TrackClicks override the SMTP config's defaults.
+		// Tracking only applies to the HTML body.
+		TrackOpens  *bool `json:"track_opens"`
+		TrackClicks *bool `json:"track_clicks"`
+		// UnsubscribeCategory scopes the List-Unsubscribe link to one tag
+		// instead of all mail from the customer.
+		UnsubscribeCategory string `json:"unsubscribe_category"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if (req.SMTPConfigID == 0) == (req.RouteID == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of smtp_config_id and route_id is required"})
+		return
+	}
+	for _, tag := range req.Tags {
+		if !tagPattern.MatchString(tag) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid tag %q: use up to 64 letters, digits, '-', '_', '.' or ':'", tag)})
+			return
+		}
+	}
+	if req.UnsubscribeCategory != "" && !tagPattern.MatchString(req.UnsubscribeCategory) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid unsubscribe_category"})
+		return
+	}
+	if Tracker == nil && ((req.TrackOpens != nil && *req.TrackOpens) || (req.TrackClicks != nil && *req.TrackClicks)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tracking is not configured on this server"})
+		return
+	}
 
 	customer := c.MustGet("customer").(*database.Customer)
 
-	smtpConfig, err := database.GetSMTPConfigByID(customer.ID, req.SMTPConfigID)
+	// A route's emails get their SMTP config once one of its backends has
+	// sent them. Routes have no tracking defaults of their own.
+	var from string
+	var configIDPtr *int
+	var route *database.SenderRoute
+	var trackOpens, trackClicks bool
+	if req.RouteID != 0 {
+		var err error
+		route, err = database.GetSenderRoute(customer.ID, req.RouteID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Route not found"})
+			return
+		}
+		from = route.FromEmail
+	} else {
+		smtpConfig, err := s.SMTPConfigs.Get(customer.ID, req.SMTPConfigID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "SMTP config not found"})
+			return
+		}
+		from = smtpConfig.FromEmail
+		trackOpens, trackClicks = smtpConfig.TrackOpens, smtpConfig.TrackClicks
+		configIDPtr = &req.SMTPConfigID
+	}
+
+	if DomainVerified != nil {
+		domain, err := senderDomain(from)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid sender address %q", from)})
+			return
+		}
+		verified, err := DomainVerified(customer.ID, domain)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check sender domain"})
+			return
+		}
+		if !verified {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("sender domain %s is not verified", domain)})
+			return
+		}
+	}
+
+	email, err := s.Emails.Create(customer.ID, configIDPtr, req.To, req.Subject, req.Body, req.HTMLBody, req.Tags)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "SMTP config not found"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create email"})
 		return
 	}
 
-	// CreateEmail expects *int for smtpConfigID
-	configIDPtr := &req.SMTPConfigID
-	email, err := database.CreateEmail(customer.ID, configIDPtr, req.To, req.Subject, req.Body)
+	suppression, err := database.CheckSuppression(customer.ID, req.To, req.Tags)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create email"})
+		errorMsg := "suppression check failed"
+		s.Emails.UpdateStatus(email.ID, "failed", &errorMsg)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check suppression list"})
+		return
+	}
+	if suppression != nil {
+		errorMsg := fmt.Sprintf("recipient is suppressed (%s)", suppression.Reason)
+		if suppression.Category != "" {
+			errorMsg = fmt.Sprintf("recipient is suppressed for category %q (%s)", suppression.Category, suppression.Reason)
+		}
+		s.Emails.UpdateStatus(email.ID, database.EmailStatusSuppressed, &errorMsg)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":    "Recipient is on the suppression list",
+			"status":   database.EmailStatusSuppressed,
+			"reason":   suppression.Reason,
+			"category": suppression.Category,
+			"email_id": email.ID,
+		})
+		return
+	}
+
+	htmlBody := req.HTMLBody
+	if htmlBody != "" && Tracker != nil {
+		if req.TrackOpens != nil {
+			trackOpens = *req.TrackOpens
+		}
+		if req.TrackClicks != nil {
+			trackClicks = *req.TrackClicks
+		}
+		htmlBody = Tracker.Instrument(htmlBody, email.ID, trackOpens, trackClicks, PublicBaseURL+"/u/")
+	}
+	headers := unsubscribeHeaders(customer.ID, email.ID, req.To, req.UnsubscribeCategory)
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	messageID := newMessageID(email.ID, from)
+	headers["Message-ID"] = "<" + messageID + ">"
+	if err := s.Emails.SetMessageID(email.ID, messageID); err != nil {
+		errorMsg := "failed to record message ID"
+		s.Emails.UpdateStatus(email.ID, "failed", &errorMsg)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errorMsg})
+		return
+	}
+	msg, err := buildMessage(from, req.To, req.Subject, headers, req.Body, htmlBody)
+	if err != nil {
+		errorMsg := fmt.Sprintf("building message failed: %v", err)
+		s.Emails.UpdateStatus(email.ID, "failed", &errorMsg)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errorMsg})
+		return
+	}
+	msg, err = signMessage(customer.ID, from, msg)
+	if err != nil {
+		errorMsg := fmt.Sprintf("DKIM signing failed: %v", err)
+		s.Emails.UpdateStatus(email.ID, "failed", &errorMsg)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": errorMsg})
+		return
+	}
+	if route != nil {
+		configID, err := s.sendViaRoute(c.Request.Context(), customer.ID, route, &provider.EmailRequest{
+			MessageID:    messageID,
+			From:         from,
+			To:           req.To,
+			Subject:      req.Subject,
+			Body:         req.Body,
+			HTMLBody:     htmlBody,
+			Headers:      headers,
+			EnvelopeFrom: envelopeSender(email.ID, from),
+			Raw:          msg,
+		})
+		if err != nil {
+			if provider.IsHardBounce(err) {
+				suppressHardBounce(customer.ID, req.To, err)
+			}
+			errorMsg := fmt.Sprintf("sending via route %s failed: %v", route.Name, err)
+			s.Emails.UpdateStatus(email.ID, "failed", &errorMsg)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": errorMsg})
+			return
+		}
+		if err := database.SetEmailSMTPConfig(email.ID, configID); err != nil {
+			log.Printf("Failed to record SMTP config %d for email %d: %v", configID, email.ID, err)
+		}
+		if err := s.Emails.UpdateStatus(email.ID, "sent", nil); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update status"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":        "Email sent successfully",
+			"email_id":       email.ID,
+			"smtp_config_id": configID,
+		})
 		return
 	}
 
 	host := "haraka-smtp.smtp.svc.cluster.local"
 	port := 25
-	addr := fmt.Sprintf("%s:%d", host, port)
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
 
 	conn, err := net.Dial("tcp", addr)
 	if err != nil {
 		errorMsg := fmt.Sprintf("connection to Haraka failed: %v", err)
-		database.UpdateEmailStatus(email.ID, "failed", &errorMsg)
+		s.Emails.UpdateStatus(email.ID, "failed", &errorMsg)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": errorMsg})
 		return
 	}
@@ -55,23 +218,25 @@ func SendEmail(c *gin.Context) {
 	client, err := smtp.NewClient(conn, host)
 	if err != nil {
 		errorMsg := fmt.Sprintf("SMTP client creation failed: %v", err)
-		database.UpdateEmailStatus(email.ID, "failed", &errorMsg)
+		s.Emails.UpdateStatus(email.ID, "failed", &errorMsg)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": errorMsg})
 		return
 	}
 	defer client.Close()
 
-	from := smtpConfig.FromEmail
-	if err := client.Mail(from); err != nil {
+	if err := client.Mail(envelopeSender(email.ID, from)); err != nil {
 		errorMsg := fmt.Sprintf("MAIL command failed: %v", err)
-		database.UpdateEmailStatus(email.ID, "failed", &errorMsg)
+		s.Emails.UpdateStatus(email.ID, "failed", &errorMsg)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": errorMsg})
 		return
 	}
 
 	if err := client.Rcpt(req.To); err != nil {
+		if provider.IsHardBounce(err) {
+			suppressHardBounce(customer.ID, req.To, err)
+		}
 		errorMsg := fmt.Sprintf("RCPT command failed: %v", err)
-		database.UpdateEmailStatus(email.ID, "failed", &errorMsg)
+		s.Emails.UpdateStatus(email.ID, "failed", &errorMsg)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": errorMsg})
 		return
 	}
@@ -79,32 +244,34 @@ func SendEmail(c *gin.Context) {
 	wc, err := client.Data()
 	if err != nil {
 		errorMsg := fmt.Sprintf("DATA command failed: %v", err)
-		database.UpdateEmailStatus(email.ID, "failed", &errorMsg)
+		s.Emails.UpdateStatus(email.ID, "failed", &errorMsg)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": errorMsg})
 		return
 	}
 
-	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s", from, req.To, req.Subject, req.Body)
-	_, err = wc.Write([]byte(msg))
+	_, err = wc.Write(msg)
 	if err != nil {
 		wc.Close()
 		errorMsg := fmt.Sprintf("write failed: %v", err)
-		database.UpdateEmailStatus(email.ID, "failed", &errorMsg)
+		s.Emails.UpdateStatus(email.ID, "failed", &errorMsg)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": errorMsg})
 		return
 	}
 
 	err = wc.Close()
 	if err != nil {
+		if provider.IsHardBounce(err) {
+			suppressHardBounce(customer.ID, req.To, err)
+		}
 		errorMsg := fmt.Sprintf("close failed: %v", err)
-		database.UpdateEmailStatus(email.ID, "failed", &errorMsg)
+		s.Emails.UpdateStatus(email.ID, "failed", &errorMsg)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": errorMsg})
 		return
 	}
 
 	client.Quit()
 
-	if err := database.UpdateEmailStatus(email.ID, "sent", nil); err != nil {
+	if err := s.Emails.UpdateStatus(email.ID, "sent", nil); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update status"})
 		return
 	}
@@ -115,25 +282,86 @@ func SendEmail(c *gin.Context) {
 	})
 }
 
-func GetEmailHistory(c *gin.Context) {
+func (s *Server) GetEmailHistory(c *gin.Context) {
 	customer := c.MustGet("customer").(*database.Customer)
-	
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
-	
-	emails, err := database.GetEmailsByCustomer(customer.ID, limit, offset)
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > maxHistoryLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxHistoryLimit)})
+		return
+	}
+
+	filter, err := emailFilterFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sort := c.DefaultQuery("sort", "-created_at")
+	if !database.ValidEmailSort(sort) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be one of created_at, to_email, subject, status, optionally prefixed with '-'"})
+		return
+	}
+
+	view := c.DefaultQuery("view", "summary")
+	if view != "summary" && view != "full" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "view must be summary or full"})
+		return
+	}
+
+	page, err := s.Emails.List(customer.ID, database.EmailQuery{
+		Filter:      filter,
+		Sort:        sort,
+		Limit:       limit,
+		Cursor:      c.Query("cursor"),
+		IncludeBody: view == "full",
+	})
+	if errors.Is(err, database.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch emails"})
 		return
 	}
 
-	c.JSON(http.StatusOK, emails)
+	c.JSON(http.StatusOK, gin.H{
+		"emails":      page.Emails,
+		"next_cursor": page.NextCursor,
+		"has_more":    page.HasMore,
+	})
+}
+
+func (s *Server) GetEmail(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+	emailID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+		return
+	}
+
+	email, err := s.Emails.Get(customer.ID, emailID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Email not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch email"})
+		return
+	}
+
+	if email.Events, err = s.Emails.Events(email.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch email events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, email)
 }
 
-func GetEmailStats(c *gin.Context) {
+func (s *Server) GetEmailStats(c *gin.Context) {
 	customer := c.MustGet("customer").(*database.Customer)
 	
-	stats, err := database.GetEmailStats(customer.ID)
+	stats, err := s.Emails.Stats(customer.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stats"})
 		return
@@ -142,15 +370,19 @@ func GetEmailStats(c *gin.Context) {
 	c.JSON(http.StatusOK, stats)
 }
 
-func DeleteSMTPConfig(c *gin.Context) {
+func (s *Server) DeleteSMTPConfig(c *gin.Context) {
 	customer := c.MustGet("customer").(*database.Customer)
 	configID, _ := strconv.Atoi(c.Param("id"))
-	
-	err := database.DeleteSMTPConfig(customer.ID, configID)
+
+	before, lookupErr := s.SMTPConfigs.Get(customer.ID, configID)
+	err := s.SMTPConfigs.Delete(customer.ID, configID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete config"})
 		return
 	}
+	if lookupErr == nil {
+		s.recordAudit(c, "smtp_config.delete", "smtp_config", configID, deletedChanges(smtpConfigAuditFields(before)))
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "SMTP config deleted"})
 }
@@ -160,16 +392,18 @@ func GetCustomerInfo(c *gin.Context) {
 	c.JSON(http.StatusOK, customer)
 }
 
-func CreateSMTPConfig(c *gin.Context) {
+func (s *Server) CreateSMTPConfig(c *gin.Context) {
 	customer := c.MustGet("customer").(*database.Customer)
 
 	var req struct {
-		Name      string `json:"name" binding:"required"`
-		SMTPHost  string `json:"smtp_host" binding:"required"`
-		SMTPPort  int    `json:"smtp_port" binding:"required"`
+		Name      string `json:"name"`
+		SMTPHost  string `json:"smtp_host"`
+		SMTPPort  int    `json:"smtp_port"`
 		Username  string `json:"username"`
 		Password  string `json:"password"`
-		FromEmail string `json:"from_email" binding:"required"`
+		FromEmail string `json:"from_email"`
+		TrackOpens  bool `json:"track_opens"`
+		TrackClicks bool `json:"track_clicks"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -177,23 +411,59 @@ func CreateSMTPConfig(c *gin.Context) {
 		return
 	}
 
-	config, err := database.CreateSMTPConfig(
+	// The same rules as PUT /smtp/:id.
+	fields := smtpConfigFields{
+		Name:      &req.Name,
+		SMTPHost:  &req.SMTPHost,
+		SMTPPort:  &req.SMTPPort,
+		Username:  &req.Username,
+		FromEmail: &req.FromEmail,
+	}
+	if errs := fields.validate(true); len(errs) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Validation failed", "fields": errs})
+		return
+	}
+	if err := checkSMTPHost(c.Request.Context(), req.SMTPHost); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Validation failed", "fields": gin.H{"smtp_host": err.Error()}})
+		return
+	}
+
+	if validate, _ := strconv.ParseBool(c.Query("validate")); validate {
+		result := runSMTPTest(c.Request.Context(), &database.SMTPConfig{
+			Name:      req.Name,
+			SMTPHost:  req.SMTPHost,
+			SMTPPort:  req.SMTPPort,
+			Username:  req.Username,
+			Password:  req.Password,
+			FromEmail: req.FromEmail,
+		}, "")
+		if !result.Success {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":      "SMTP config validation failed",
+				"validation": result,
+			})
+			return
+		}
+	}
+
+	config, err := s.SMTPConfigs.Create(
 		customer.ID, req.Name, req.SMTPHost, req.SMTPPort,
-		req.Username, req.Password, req.FromEmail,
+		req.Username, req.Password, req.FromEmail, req.TrackOpens, req.TrackClicks,
 	)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create SMTP config"})
 		return
 	}
+	s.recordAudit(c, "smtp_config.create", "smtp_config", config.ID, createdChanges(smtpConfigAuditFields(config)))
 
 	c.JSON(http.StatusCreated, config)
 }
 
-func GetSMTPConfigs(c *gin.Context) {
+func (s *Server) GetSMTPConfigs(c *gin.Context) {
 	customer := c.MustGet("customer").(*database.Customer)
 
-	configs, err := database.GetSMTPConfigsByCustomer(customer.ID)
+	configs, err := s.SMTPConfigs.List(customer.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch configs"})
 		return
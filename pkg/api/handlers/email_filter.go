@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Gatete-Bruno/besend/pkg/database"
+	"github.com/gin-gonic/gin"
+)
+
+const maxHistoryLimit = 200
+
+var tagPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,64}$`)
+
+var emailStatuses = map[string]bool{
+	"pending":    true,
+	"sent":       true,
+	"delivered":  true,
+	"failed":     true,
+	"suppressed": true,
+	"bounced":    true,
+	"complained": true,
+}
+
+// emailFilterFromQuery parses the history filters: status (comma separated),
+// recipient, subject, created_after, created_before (RFC 3339),
+// smtp_config_id and tag.
+func emailFilterFromQuery(c *gin.Context) (database.EmailFilter, error) {
+	var f database.EmailFilter
+
+	if status := c.Query("status"); status != "" {
+		for _, s := range strings.Split(status, ",") {
+			s = strings.TrimSpace(s)
+			if !emailStatuses[s] {
+				return f, fmt.Errorf("unknown status %q", s)
+			}
+			f.Statuses = append(f.Statuses, s)
+		}
+	}
+
+	f.Recipient = strings.TrimSpace(c.Query("recipient"))
+
+	f.SubjectContains = c.Query("subject")
+	if len(f.SubjectContains) > 500 {
+		return f, fmt.Errorf("subject filter must be at most 500 characters")
+	}
+
+	for param, dst := range map[string]**time.Time{
+		"created_after":  &f.CreatedAfter,
+		"created_before": &f.CreatedBefore,
+	} {
+		if v := c.Query(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return f, fmt.Errorf("%s must be an RFC 3339 timestamp", param)
+			}
+			t = t.UTC()
+			*dst = &t
+		}
+	}
+	if f.CreatedAfter != nil && f.CreatedBefore != nil && !f.CreatedAfter.Before(*f.CreatedBefore) {
+		return f, fmt.Errorf("created_after must be before created_before")
+	}
+
+	if v := c.Query("smtp_config_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			return f, fmt.Errorf("smtp_config_id must be a positive integer")
+		}
+		f.SMTPConfigID = &id
+	}
+
+	if tag := c.Query("tag"); tag != "" {
+		if !tagPattern.MatchString(tag) {
+			return f, fmt.Errorf("invalid tag %q", tag)
+		}
+		f.Tag = tag
+	}
+	return f, nil
+}
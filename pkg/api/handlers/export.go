@@ -0,0 +1,438 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Gatete-Bruno/besend/pkg/database"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// exportChunkSize is the size of each stored chunk of an async export.
+	exportChunkSize = 1 << 20
+	// exportJobTTL is how long a finished export stays downloadable.
+	exportJobTTL = 7 * 24 * time.Hour
+	// exportFlushEvery is how many rows a streaming export writes between
+	// flushes to the client.
+	exportFlushEvery = 500
+)
+
+var exportColumns = []string{
+	"id", "smtp_config_id", "to_email", "subject", "body", "html_body", "status", "tags",
+	"created_at", "sent_at", "error_message",
+}
+
+// defaultExportColumns leaves out the body, which is usually the bulk of an
+// export and rarely needed for audits.
+var defaultExportColumns = []string{
+	"id", "smtp_config_id", "to_email", "subject", "status", "tags",
+	"created_at", "sent_at", "error_message",
+}
+
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+}
+
+// exportParams are the options of an export. They are stored with async jobs
+// so a job records exactly what it exported.
+type exportParams struct {
+	Format   string               `json:"format"`
+	Columns  []string             `json:"columns"`
+	MaskBody bool                 `json:"mask_body"`
+	Filter   database.EmailFilter `json:"filter"`
+}
+
+// exportParamsFromQuery parses format (csv or ndjson), columns (comma
+// separated), mask_body and the history filters.
+func exportParamsFromQuery(c *gin.Context) (*exportParams, error) {
+	p := &exportParams{Format: c.DefaultQuery("format", "csv")}
+	if _, ok := exportContentTypes[p.Format]; !ok {
+		return nil, fmt.Errorf("format must be csv or ndjson")
+	}
+
+	p.Columns = defaultExportColumns
+	if v := c.Query("columns"); v != "" {
+		p.Columns = nil
+		seen := map[string]bool{}
+		for _, col := range strings.Split(v, ",") {
+			col = strings.TrimSpace(col)
+			if !contains(exportColumns, col) {
+				return nil, fmt.Errorf("unknown column %q (available: %s)", col, strings.Join(exportColumns, ", "))
+			}
+			if !seen[col] {
+				seen[col] = true
+				p.Columns = append(p.Columns, col)
+			}
+		}
+	}
+
+	if v := c.Query("mask_body"); v != "" {
+		mask, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("mask_body must be true or false")
+		}
+		p.MaskBody = mask
+	}
+
+	filter, err := emailFilterFromQuery(c)
+	if err != nil {
+		return nil, err
+	}
+	p.Filter = filter
+	return p, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func exportValue(e *database.Email, column string, maskBody bool) interface{} {
+	switch column {
+	case "id":
+		return e.ID
+	case "smtp_config_id":
+		return e.SMTPConfigID
+	case "to_email":
+		return e.ToEmail
+	case "subject":
+		return e.Subject
+	case "body":
+		if maskBody {
+			return redacted
+		}
+		return e.Body
+	case "html_body":
+		if maskBody {
+			return redacted
+		}
+		return e.HTMLBody
+	case "status":
+		return e.Status
+	case "tags":
+		if e.Tags == nil {
+			return []string{}
+		}
+		return e.Tags
+	case "created_at":
+		return e.CreatedAt
+	case "sent_at":
+		return e.SentAt
+	case "error_message":
+		return e.ErrorMessage
+	}
+	return nil
+}
+
+// csvField renders an export value as a CSV cell. Nulls become empty cells
+// and tags are joined with ";".
+func csvField(v interface{}) string {
+	switch v := v.(type) {
+	case *int:
+		if v == nil {
+			return ""
+		}
+		return strconv.Itoa(*v)
+	case *string:
+		if v == nil {
+			return ""
+		}
+		return *v
+	case *time.Time:
+		if v == nil {
+			return ""
+		}
+		return v.UTC().Format(time.RFC3339Nano)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case []string:
+		return strings.Join(v, ";")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// writeExport streams matching emails to w in the requested format. flush,
+// if set, is called periodically with the rows written so far so progress is
+// visible. It returns the number of rows written.
+func writeExport(ctx context.Context, w io.Writer, customerID int, p *exportParams, flush func(rows int64) error) (int64, error) {
+	var rows int64
+	var writeRow func(*database.Email) error
+	var finish func() error
+
+	switch p.Format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(p.Columns); err != nil {
+			return 0, err
+		}
+		record := make([]string, len(p.Columns))
+		writeRow = func(e *database.Email) error {
+			for i, col := range p.Columns {
+				record[i] = csvField(exportValue(e, col, p.MaskBody))
+			}
+			return cw.Write(record)
+		}
+		finish = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		bw := bufio.NewWriter(w)
+		writeRow = func(e *database.Email) error {
+			// Build the object by hand so keys keep the requested column order.
+			bw.WriteByte('{')
+			for i, col := range p.Columns {
+				if i > 0 {
+					bw.WriteByte(',')
+				}
+				value, err := json.Marshal(exportValue(e, col, p.MaskBody))
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(bw, "%q:%s", col, value)
+			}
+			_, err := bw.WriteString("}\n")
+			return err
+		}
+		finish = bw.Flush
+	}
+
+	includeBody := contains(p.Columns, "body") || contains(p.Columns, "html_body")
+	err := database.StreamEmails(ctx, customerID, p.Filter, includeBody, func(e *database.Email) error {
+		if err := writeRow(e); err != nil {
+			return err
+		}
+		rows++
+		if flush != nil && rows%exportFlushEvery == 0 {
+			if err := finish(); err != nil {
+				return err
+			}
+			return flush(rows)
+		}
+		return nil
+	})
+	if err != nil {
+		return rows, err
+	}
+	if err := finish(); err != nil {
+		return rows, err
+	}
+	if flush != nil {
+		return rows, flush(rows)
+	}
+	return rows, nil
+}
+
+func exportFilename(format string, jobID int) string {
+	if jobID > 0 {
+		return fmt.Sprintf("emails-export-%d.%s", jobID, format)
+	}
+	return fmt.Sprintf("emails-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+}
+
+// ExportEmails streams every matching email as CSV or NDJSON without
+// buffering the result set.
+func ExportEmails(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+
+	params, err := exportParamsFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", exportContentTypes[params.Format])
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, exportFilename(params.Format, 0)))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	flush := func(int64) error {
+		c.Writer.Flush()
+		return nil
+	}
+	rows, err := writeExport(c.Request.Context(), c.Writer, customer.ID, params, flush)
+	if err != nil {
+		log.Printf("Export for customer %d failed after %d rows: %v", customer.ID, rows, err)
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Disposition")
+			c.Writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export emails"})
+			return
+		}
+		abortStream(c)
+	}
+}
+
+// abortStream drops the connection of a response whose status line has
+// already been sent, so the client sees a truncated transfer instead of a
+// complete but partial file.
+func abortStream(c *gin.Context) {
+	c.Abort()
+	if conn, _, err := c.Writer.Hijack(); err == nil {
+		conn.Close()
+	}
+}
+
+// CreateExportJob starts an asynchronous export with the same options as
+// ExportEmails and returns 202 with the job to poll.
+func CreateExportJob(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+
+	params, err := exportParamsFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	raw, err := json.Marshal(params)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create export job"})
+		return
+	}
+
+	job, err := database.CreateExportJob(customer.ID, params.Format, raw)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create export job"})
+		return
+	}
+
+	go runExportJob(job.ID, customer.ID, params)
+
+	c.Header("Location", fmt.Sprintf("/api/v1/emails/exports/%d", job.ID))
+	c.JSON(http.StatusAccepted, job)
+}
+
+// chunkWriter buffers output and stores it as export chunks of
+// exportChunkSize bytes.
+type chunkWriter struct {
+	jobID int
+	seq   int
+	rows  int64
+	buf   []byte
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= exportChunkSize {
+		if err := w.store(w.buf[:exportChunkSize]); err != nil {
+			return 0, err
+		}
+		w.buf = append(w.buf[:0], w.buf[exportChunkSize:]...)
+	}
+	return len(p), nil
+}
+
+func (w *chunkWriter) Close() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.store(w.buf)
+	w.buf = nil
+	return err
+}
+
+func (w *chunkWriter) store(data []byte) error {
+	err := database.AppendExportChunk(w.jobID, w.seq, data, w.rows)
+	w.seq++
+	return err
+}
+
+func runExportJob(jobID, customerID int, params *exportParams) {
+	if err := database.StartExportJob(jobID); err != nil {
+		log.Printf("Failed to start export job %d: %v", jobID, err)
+	}
+
+	w := &chunkWriter{jobID: jobID}
+	rows, err := writeExport(context.Background(), w, customerID, params, func(rows int64) error {
+		w.rows = rows
+		return nil
+	})
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		log.Printf("Export job %d failed: %v", jobID, err)
+	}
+	if err := database.FinishExportJob(jobID, rows, exportJobTTL, err); err != nil {
+		log.Printf("Failed to record result of export job %d: %v", jobID, err)
+	}
+}
+
+func GetExportJob(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+	jobID, _ := strconv.Atoi(c.Param("id"))
+
+	job, err := database.GetExportJob(customer.ID, jobID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export job not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch export job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+func DownloadExportJob(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+	jobID, _ := strconv.Atoi(c.Param("id"))
+
+	job, err := database.GetExportJob(customer.ID, jobID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export job not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch export job"})
+		return
+	}
+	if job.Status != database.ExportJobCompleted {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Export job is %s", job.Status), "status": job.Status})
+		return
+	}
+	// Expired jobs are only deleted periodically.
+	if job.ExpiresAt != nil && job.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusGone, gin.H{"error": "Export job has expired"})
+		return
+	}
+
+	c.Header("Content-Type", exportContentTypes[job.Format])
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, exportFilename(job.Format, job.ID)))
+	c.Header("Content-Length", strconv.FormatInt(job.SizeBytes, 10))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	err = database.StreamExportChunks(c.Request.Context(), job.ID, func(data []byte) error {
+		_, err := c.Writer.Write(data)
+		return err
+	})
+	if err != nil {
+		log.Printf("Download of export job %d failed: %v", job.ID, err)
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Disposition")
+			c.Writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to download export"})
+			return
+		}
+		abortStream(c)
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Gatete-Bruno/besend/pkg/bounce"
+	"github.com/Gatete-Bruno/besend/pkg/dkim"
+)
+
+// ReturnPath gives each email a VERP envelope sender so the bounce
+// processor can match asynchronous bounces to it. When unset, mail is sent
+// with the SMTP config's from address as the envelope sender.
+var ReturnPath *bounce.ReturnPath
+
+// envelopeSender returns the MAIL FROM address for an email.
+func envelopeSender(emailID int, from string) string {
+	if ReturnPath != nil {
+		return ReturnPath.Address(emailID)
+	}
+	return from
+}
+
+// DKIMSigners returns the signer for a customer's sending domain, or nil
+// when the domain has no DKIM key. Messages are sent unsigned when unset.
+var DKIMSigners func(customerID int, domain string) (*dkim.Signer, error)
+
+// DomainVerified reports whether a customer may send from a domain. When
+// set, mail is only sent from verified sending domains. The API sets it
+// unless ALLOW_UNVERIFIED_DOMAINS=true.
+var DomainVerified func(customerID int, domain string) (bool, error)
+
+// senderDomain returns the domain of a from address.
+func senderDomain(from string) (string, error) {
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		return "", err
+	}
+	_, domain, _ := strings.Cut(addr.Address, "@")
+	return domain, nil
+}
+
+// signMessage adds a DKIM signature for the from address's domain when the
+// customer has a key for it.
+func signMessage(customerID int, from string, msg []byte) ([]byte, error) {
+	if DKIMSigners == nil {
+		return msg, nil
+	}
+	domain, err := senderDomain(from)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := DKIMSigners(customerID, domain)
+	if err != nil || signer == nil {
+		return msg, err
+	}
+	return signer.Sign(msg, time.Now())
+}
+
+// newMessageID returns a Message-ID, without angle brackets, in the from
+// address's domain. Delivery reports quote it, which lets them be matched
+// when the envelope sender is not a VERP address.
+func newMessageID(emailID int, from string) string {
+	random := make([]byte, 6)
+	rand.Read(random)
+	domain := "localhost"
+	if _, d, ok := strings.Cut(from, "@"); ok && d != "" {
+		domain = d
+	}
+	return fmt.Sprintf("besend.%d.%s@%s", emailID, hex.EncodeToString(random), domain)
+}
+
+// buildMessage renders the message handed to the SMTP relay. With an HTML
+// body it becomes multipart/alternative, with the text body as the
+// fallback part.
+func buildMessage(from, to, subject string, headers map[string]string, text, html string) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\nTo: %s\r\nSubject: %s\r\n", from, to, subject)
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, headers[name])
+	}
+
+	if html == "" {
+		buf.WriteString("\r\n" + text)
+		return buf.Bytes(), nil
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\nContent-Type: multipart/alternative; boundary=%q\r\n\r\n", mw.Boundary())
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
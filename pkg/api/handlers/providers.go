@@ -0,0 +1,14 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/Gatete-Bruno/besend/internal/provider"
+	"github.com/gin-gonic/gin"
+)
+
+// ListProviders returns the sending providers this build implements, with
+// the options each accepts in an EmailSenderConfig's spec.options.
+func ListProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": provider.Registered()})
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	emailv1alpha1 "github.com/Gatete-Bruno/besend/api/v1alpha1"
+	"github.com/Gatete-Bruno/besend/pkg/database"
+	"github.com/Gatete-Bruno/besend/pkg/kubernetes"
+	"github.com/Gatete-Bruno/besend/pkg/svix"
+	"github.com/gin-gonic/gin"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ResendWebhookVerifier checks the signature of Resend webhooks. When
+// unset, the webhook endpoint is disabled.
+var ResendWebhookVerifier *svix.Verifier
+
+// EmailResources updates Email resources from webhook events. When unset,
+// only email rows are updated.
+var EmailResources *kubernetes.K8sClient
+
+const maxWebhookBody = 1 << 20
+
+type resendWebhookEvent struct {
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      struct {
+		EmailID string   `json:"email_id"`
+		To      []string `json:"to"`
+		Bounce  *struct {
+			Type    string `json:"type"`
+			SubType string `json:"subType"`
+			Message string `json:"message"`
+		} `json:"bounce"`
+		Click *struct {
+			Link      string `json:"link"`
+			UserAgent string `json:"userAgent"`
+		} `json:"click"`
+	} `json:"data"`
+}
+
+// resendEventMapping is how a Resend event type is recorded: as an event
+// in the email's history and, for some, a new status for the email row
+// and the Email resource.
+type resendEventMapping struct {
+	event          string
+	status         string
+	resourceStatus string
+}
+
+var resendEventMappings = map[string]resendEventMapping{
+	"email.delivered":        {database.EmailEventDelivered, database.EmailStatusDelivered, "Delivered"},
+	"email.delivery_delayed": {database.EmailEventDeliveryDelayed, "", ""},
+	"email.bounced":          {database.EmailEventBounce, database.EmailStatusBounced, "Bounced"},
+	"email.complained":       {database.EmailEventComplaint, database.EmailStatusComplained, "Complained"},
+	"email.opened":           {database.EmailEventOpen, "", ""},
+	"email.clicked":          {database.EmailEventClick, "", ""},
+}
+
+// ResendWebhook receives Resend's delivery events. Events are matched to
+// email rows and Email resources by the ID Resend returned when the email
+// was sent. Unknown event types and unmatched emails are acknowledged so
+// Resend does not retry them; only storage failures ask for a retry.
+func ResendWebhook(c *gin.Context) {
+	if ResendWebhookVerifier == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Resend webhooks are not configured"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBody+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+		return
+	}
+	if len(body) > maxWebhookBody {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Webhook body too large"})
+		return
+	}
+	eventID, err := ResendWebhookVerifier.Verify(c.Request.Header, body, time.Now())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	var payload resendWebhookEvent
+	if err := json.Unmarshal(body, &payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook payload"})
+		return
+	}
+	mapping, ok := resendEventMappings[payload.Type]
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{"status": "ignored"})
+		return
+	}
+	if payload.Data.EmailID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Webhook payload has no email_id"})
+		return
+	}
+	if payload.CreatedAt.IsZero() {
+		payload.CreatedAt = time.Now()
+	}
+
+	event := database.EmailEvent{Type: mapping.event, CreatedAt: payload.CreatedAt.UTC()}
+	if b := payload.Data.Bounce; b != nil {
+		event.Details = strings.TrimSpace(fmt.Sprintf("%s/%s: %s", b.Type, b.SubType, b.Message))
+	}
+	if click := payload.Data.Click; click != nil {
+		event.URL = click.Link
+		event.UserAgent = click.UserAgent
+	}
+
+	matched := false
+	email, recorded, err := database.RecordProviderEvent(payload.Data.EmailID, eventID, event, mapping.status)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		log.Printf("Failed to record Resend event %s: %v", eventID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record event"})
+		return
+	default:
+		matched = true
+		if recorded {
+			suppressFromWebhook(email, payload)
+		}
+	}
+
+	if EmailResources != nil {
+		found, err := EmailResources.RecordDeliveryEvent(c.Request.Context(), payload.Data.EmailID, emailv1alpha1.DeliveryEvent{
+			Type:      mapping.event,
+			Timestamp: metav1.NewTime(payload.CreatedAt),
+			Details:   event.Details,
+		}, mapping.resourceStatus)
+		if err != nil {
+			log.Printf("Failed to record Resend event %s on Email resource: %v", eventID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record event"})
+			return
+		}
+		matched = matched || found
+	}
+
+	if !matched {
+		log.Printf("Resend event %s (%s) matched no email for %s", eventID, payload.Type, payload.Data.EmailID)
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "recorded", "matched": matched})
+}
+
+// suppressFromWebhook adds permanently bounced and complaining recipients
+// to the customer's suppression list.
+func suppressFromWebhook(email *database.Email, payload resendWebhookEvent) {
+	var reason, details string
+	switch {
+	case payload.Type == "email.bounced" && payload.Data.Bounce != nil && payload.Data.Bounce.Type == "Permanent":
+		reason, details = database.SuppressionBounce, "resend: "+payload.Data.Bounce.Message
+	case payload.Type == "email.complained":
+		reason, details = database.SuppressionComplaint, "resend: complaint"
+	default:
+		return
+	}
+	if _, _, err := database.AddSuppression(email.CustomerID, email.ToEmail, "", reason, &details); err != nil {
+		log.Printf("Failed to suppress %s after %s: %v", email.ToEmail, payload.Type, err)
+	}
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/Gatete-Bruno/besend/internal/provider"
+	"github.com/Gatete-Bruno/besend/pkg/database"
+	"github.com/gin-gonic/gin"
+)
+
+// RouteBreakers keeps the health of route backends, by SMTP config, for as
+// long as the API runs.
+var RouteBreakers = provider.NewBreakers(provider.DefaultBreakerThreshold, provider.DefaultBreakerCooldown)
+
+func routeBreakerKey(smtpConfigID int) string {
+	return "smtp_config:" + strconv.Itoa(smtpConfigID)
+}
+
+type routeBackendResponse struct {
+	database.SenderRouteBackend
+	Health provider.BreakerStatus `json:"health"`
+}
+
+// senderRouteResponse is a route with the current health of its backends.
+type senderRouteResponse struct {
+	*database.SenderRoute
+	Backends []routeBackendResponse `json:"backends"`
+}
+
+func withHealth(route *database.SenderRoute) senderRouteResponse {
+	resp := senderRouteResponse{SenderRoute: route, Backends: []routeBackendResponse{}}
+	for _, b := range route.Backends {
+		resp.Backends = append(resp.Backends, routeBackendResponse{
+			SenderRouteBackend: b,
+			Health:             RouteBreakers.Get(routeBreakerKey(b.SMTPConfigID)).Status(),
+		})
+	}
+	return resp
+}
+
+// routeAuditFields are the fields of a route recorded when it is created
+// or deleted.
+func routeAuditFields(route *database.SenderRoute) map[string]interface{} {
+	return map[string]interface{}{
+		"name":       route.Name,
+		"strategy":   route.Strategy,
+		"from_email": route.FromEmail,
+		"backends":   route.Backends,
+	}
+}
+
+func (s *Server) CreateSenderRoute(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+
+	var req struct {
+		Name      string                        `json:"name"`
+		Strategy  string                        `json:"strategy"`
+		FromEmail string                        `json:"from_email"`
+		Backends  []database.SenderRouteBackend `json:"backends"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	errs := map[string]string{}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 255 {
+		errs["name"] = "is required and must be at most 255 characters"
+	}
+	if req.Strategy == "" {
+		req.Strategy = provider.RoutingPriority
+	}
+	if req.Strategy != provider.RoutingPriority && req.Strategy != provider.RoutingWeighted {
+		errs["strategy"] = "must be priority or weighted"
+	}
+	if addr, err := mail.ParseAddress(req.FromEmail); err != nil || addr.Address != req.FromEmail {
+		errs["from_email"] = "must be a plain email address"
+	}
+	seen := map[int]bool{}
+	for i := range req.Backends {
+		b := &req.Backends[i]
+		switch {
+		case seen[b.SMTPConfigID]:
+			errs["backends"] = "must not list an SMTP config twice"
+		case b.Priority < 0 || b.Weight < 0:
+			errs["backends"] = "priority and weight must not be negative"
+		}
+		seen[b.SMTPConfigID] = true
+		if b.Weight == 0 {
+			b.Weight = 1
+		}
+	}
+	if len(req.Backends) == 0 {
+		errs["backends"] = "must list at least one SMTP config"
+	}
+	if len(errs) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Validation failed", "fields": errs})
+		return
+	}
+
+	route, err := database.CreateSenderRoute(customer.ID, req.Name, req.Strategy, req.FromEmail, req.Backends)
+	switch {
+	case errors.Is(err, database.ErrUnknownSMTPConfig):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Validation failed", "fields": map[string]string{"backends": "unknown SMTP config"}})
+		return
+	case database.IsUniqueViolation(err):
+		c.JSON(http.StatusConflict, gin.H{"error": "A route with this name already exists"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create route"})
+		return
+	}
+
+	s.recordAudit(c, "sender_route.create", "sender_route", route.ID, createdChanges(routeAuditFields(route)))
+
+	c.JSON(http.StatusCreated, withHealth(route))
+}
+
+func ListSenderRoutes(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+
+	routes, err := database.ListSenderRoutes(customer.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch routes"})
+		return
+	}
+	resp := make([]senderRouteResponse, 0, len(routes))
+	for _, route := range routes {
+		resp = append(resp, withHealth(route))
+	}
+	c.JSON(http.StatusOK, gin.H{"routes": resp})
+}
+
+// GetSenderRoute returns a route with the health of each backend as seen
+// by this API instance's circuit breakers.
+func GetSenderRoute(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+	routeID, _ := strconv.Atoi(c.Param("id"))
+
+	route, err := database.GetSenderRoute(customer.ID, routeID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Route not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch route"})
+		return
+	}
+	c.JSON(http.StatusOK, withHealth(route))
+}
+
+func (s *Server) DeleteSenderRoute(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+	routeID, _ := strconv.Atoi(c.Param("id"))
+
+	route, err := database.GetSenderRoute(customer.ID, routeID)
+	if err == nil {
+		err = database.DeleteSenderRoute(customer.ID, routeID)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Route not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete route"})
+		return
+	}
+	s.recordAudit(c, "sender_route.delete", "sender_route", routeID, deletedChanges(routeAuditFields(route)))
+	c.JSON(http.StatusOK, gin.H{"message": "Route deleted"})
+}
+
+// sendViaRoute sends through the route's SMTP configs. Unlike other sends
+// it connects to each config's own SMTP server instead of the relay, so an
+// outage of one server can be routed around. It returns the ID of the
+// config that sent the email.
+func (s *Server) sendViaRoute(ctx context.Context, customerID int, route *database.SenderRoute, req *provider.EmailRequest) (int, error) {
+	var backends []provider.Backend
+	configIDs := map[string]int{}
+	for _, b := range route.Backends {
+		config, err := s.SMTPConfigs.Get(customerID, b.SMTPConfigID)
+		if err != nil {
+			log.Printf("Skipping SMTP config %d in route %d: %v", b.SMTPConfigID, route.ID, err)
+			continue
+		}
+		p, err := provider.NewProvider(smtpProviderConfig(config))
+		if err != nil {
+			log.Printf("Skipping SMTP config %d in route %d: %v", b.SMTPConfigID, route.ID, err)
+			continue
+		}
+		backends = append(backends, provider.Backend{
+			Name:     config.Name,
+			Key:      routeBreakerKey(config.ID),
+			Provider: p,
+			Priority: b.Priority,
+			Weight:   b.Weight,
+		})
+		configIDs[config.Name] = config.ID
+	}
+
+	composite, err := provider.NewCompositeProvider(route.Strategy, backends, RouteBreakers)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := composite.Send(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+	return configIDs[resp.Backend], nil
+}
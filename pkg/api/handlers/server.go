@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"github.com/Gatete-Bruno/besend/pkg/api/middleware"
+	"github.com/Gatete-Bruno/besend/pkg/database"
+	"github.com/gin-gonic/gin"
+)
+
+// Server serves the API. Customers, API keys, SMTP configs, emails and the
+// audit log are reached through its repositories, so with those from
+// package memory the routes on them can be exercised with httptest and no
+// database. The other handlers still use the database package directly and
+// need Postgres. That includes SendEmail, which looks up sender routes and
+// suppressions and records a route's SMTP config in the database.
+type Server struct {
+	database.Repositories
+}
+
+func NewServer(repos database.Repositories) *Server {
+	return &Server{Repositories: repos}
+}
+
+// Router returns the API's routes.
+func (s *Server) Router() *gin.Engine {
+	r := gin.Default()
+
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(200, gin.H{"status": "healthy"})
+	})
+
+	r.GET("/u/:token", UnsubscribeForm)
+	r.POST("/u/:token", Unsubscribe)
+	r.GET("/t/o/:token", TrackOpen)
+	r.GET("/t/c/:token", TrackClick)
+	r.POST("/webhooks/resend", ResendWebhook)
+
+	api := r.Group("/api/v1")
+	{
+		api.POST("/register", s.Register)
+		api.POST("/login", s.Login)
+
+		protected := api.Group("")
+		protected.Use(middleware.Authenticate(s.Customers))
+		{
+			protected.GET("/me", GetCustomerInfo)
+			protected.GET("/providers", ListProviders)
+
+			protected.POST("/routes", s.CreateSenderRoute)
+			protected.GET("/routes", ListSenderRoutes)
+			protected.GET("/routes/:id", GetSenderRoute)
+			protected.DELETE("/routes/:id", s.DeleteSenderRoute)
+
+			protected.POST("/smtp", s.CreateSMTPConfig)
+			protected.GET("/smtp", s.GetSMTPConfigs)
+			protected.GET("/smtp/:id", s.GetSMTPConfig)
+			protected.PUT("/smtp/:id", s.ReplaceSMTPConfig)
+			protected.PATCH("/smtp/:id", s.PatchSMTPConfig)
+			protected.DELETE("/smtp/:id", s.DeleteSMTPConfig)
+			protected.POST("/smtp/:id/test", s.VerifySMTPConfig)
+
+			protected.POST("/emails/send", s.SendEmail)
+			protected.GET("/emails", s.GetEmailHistory)
+			protected.GET("/emails/stats", s.GetEmailStats)
+			protected.GET("/emails/stats/timeseries", GetEmailTimeSeries)
+			protected.GET("/emails/export", ExportEmails)
+			protected.POST("/emails/exports", CreateExportJob)
+			protected.GET("/emails/exports/:id", GetExportJob)
+			protected.GET("/emails/exports/:id/download", DownloadExportJob)
+			protected.GET("/emails/:id", s.GetEmail)
+
+			protected.GET("/suppressions", ListSuppressions)
+			protected.POST("/suppressions", CreateSuppression)
+			protected.POST("/suppressions/import", ImportSuppressions)
+			protected.GET("/suppressions/export", ExportSuppressions)
+			protected.GET("/suppressions/:email", GetSuppression)
+			protected.DELETE("/suppressions/:email", DeleteSuppression)
+
+			protected.POST("/keys", s.CreateAPIKey)
+			protected.GET("/keys", s.GetAPIKeys)
+			protected.PUT("/keys/:id", s.UpdateAPIKey)
+			protected.PATCH("/keys/:id", s.UpdateAPIKey)
+			protected.DELETE("/keys/:id", s.DeleteAPIKey)
+
+			protected.POST("/webhooks", s.CreateWebhookEndpoint)
+			protected.GET("/webhooks", ListWebhookEndpoints)
+			protected.DELETE("/webhooks/:id", s.DeleteWebhookEndpoint)
+
+			protected.GET("/audit-events", s.ListAuditEvents)
+			protected.GET("/audit-events/verify", s.VerifyAuditChain)
+		}
+	}
+	return r
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Gatete-Bruno/besend/pkg/database"
+	"github.com/Gatete-Bruno/besend/pkg/database/memory"
+	"github.com/gin-gonic/gin"
+)
+
+// apiClient sends requests to a router and decodes the JSON replies.
+type apiClient struct {
+	t      *testing.T
+	router http.Handler
+	// header is sent with every request, e.g. credentials.
+	header http.Header
+}
+
+func (a *apiClient) do(method, path string, body interface{}, header http.Header) (*httptest.ResponseRecorder, map[string]interface{}) {
+	a.t.Helper()
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			a.t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range a.header {
+		req.Header[name] = values
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	rec := httptest.NewRecorder()
+	a.router.ServeHTTP(rec, req)
+	var reply map[string]interface{}
+	if rec.Body.Len() > 0 && rec.Body.Bytes()[0] == '{' {
+		if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil {
+			a.t.Fatalf("%s %s: decoding reply: %v", method, path, err)
+		}
+	}
+	return rec, reply
+}
+
+// expect sends a request and fails the test unless it gets status.
+func (a *apiClient) expect(status int, method, path string, body interface{}, header http.Header) (*httptest.ResponseRecorder, map[string]interface{}) {
+	a.t.Helper()
+	rec, reply := a.do(method, path, body, header)
+	if rec.Code != status {
+		a.t.Fatalf("%s %s = %d, want %d: %s", method, path, rec.Code, status, rec.Body.String())
+	}
+	return rec, reply
+}
+
+// TestServerFlow walks a customer through the API on in-memory
+// repositories: registering, logging in, creating an API key, editing an
+// SMTP config under If-Match, and reading back the audit events all of
+// that left.
+func TestServerFlow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	api := &apiClient{t: t, router: NewServer(memory.New()).Router()}
+
+	credentials := map[string]string{"email": "owner@example.test", "password": "correct horse"}
+	api.expect(http.StatusCreated, "POST", "/api/v1/register", credentials, nil)
+	api.expect(http.StatusUnauthorized, "POST", "/api/v1/login",
+		map[string]string{"email": "owner@example.test", "password": "wrong"}, nil)
+	_, login := api.expect(http.StatusOK, "POST", "/api/v1/login", credentials, nil)
+	token, _ := login["token"].(string)
+	if token == "" {
+		t.Fatalf("login returned no token: %v", login)
+	}
+
+	api.expect(http.StatusUnauthorized, "GET", "/api/v1/keys", nil, nil)
+	bearer := http.Header{"Authorization": {"Bearer " + token}}
+	_, created := api.expect(http.StatusCreated, "POST", "/api/v1/keys", map[string]string{"name": "ci"}, bearer)
+	key, _ := created["key"].(map[string]interface{})["key"].(string)
+	if key == "" {
+		t.Fatalf("API key creation returned no key: %v", created)
+	}
+
+	// Everything from here on authenticates with the API key.
+	api.header = http.Header{"X-Api-Key": {key}}
+	rec, _ := api.expect(http.StatusCreated, "POST", "/api/v1/smtp", map[string]interface{}{
+		"name":       "primary",
+		"smtp_host":  "smtp.example.test",
+		"smtp_port":  587,
+		"username":   "mailer",
+		"password":   "s3cret",
+		"from_email": "news@example.test",
+	}, nil)
+	config := decodeSMTPConfig(t, rec)
+	path := "/api/v1/smtp/" + strconv.Itoa(config.ID)
+
+	rec, _ = api.expect(http.StatusOK, "GET", path, nil, nil)
+	original := rec.Header().Get("ETag")
+	if original == "" {
+		t.Fatal("GET returned no ETag")
+	}
+
+	rec, _ = api.expect(http.StatusOK, "PUT", path, map[string]interface{}{
+		"name":       "primary",
+		"smtp_host":  "smtp2.example.test",
+		"smtp_port":  587,
+		"from_email": "news@example.test",
+	}, http.Header{"If-Match": {original}})
+	current := rec.Header().Get("ETag")
+	if current == "" || current == original {
+		t.Errorf("ETag after PUT = %q, want a new one (was %q)", current, original)
+	}
+	if replaced := decodeSMTPConfig(t, rec); replaced.SMTPHost != "smtp2.example.test" || replaced.Username != "" {
+		t.Errorf("after PUT = %+v, want the new host and the username cleared", replaced)
+	}
+
+	// A write based on the version before the PUT is refused, and told
+	// the current version.
+	rec, _ = api.expect(http.StatusPreconditionFailed, "PATCH", path,
+		map[string]interface{}{"smtp_port": 2525}, http.Header{"If-Match": {original}})
+	if got := rec.Header().Get("ETag"); got != current {
+		t.Errorf("ETag with 412 = %q, want the current %q", got, current)
+	}
+
+	rec, _ = api.expect(http.StatusOK, "PATCH", path,
+		map[string]interface{}{"smtp_port": 2525}, http.Header{"If-Match": {current}})
+	if patched := decodeSMTPConfig(t, rec); patched.SMTPPort != 2525 || patched.SMTPHost != "smtp2.example.test" {
+		t.Errorf("after PATCH = %+v, want port 2525 and the host kept", patched)
+	}
+	api.expect(http.StatusBadRequest, "PATCH", path,
+		map[string]interface{}{"smtp_port": 25}, http.Header{"If-Match": {`"not-a-version"`}})
+
+	var list struct {
+		Events  []database.AuditEvent `json:"events"`
+		HasMore bool                  `json:"has_more"`
+	}
+	rec, _ = api.expect(http.StatusOK, "GET", "/api/v1/audit-events", nil, nil)
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	wantActions := []string{
+		"smtp_config.update",
+		"smtp_config.update",
+		"smtp_config.create",
+		"api_key.create",
+		"auth.login",
+		"auth.login_failed",
+		"customer.register",
+	}
+	if len(list.Events) != len(wantActions) {
+		t.Fatalf("got %d audit events, want %d: %+v", len(list.Events), len(wantActions), list.Events)
+	}
+	for i, want := range wantActions {
+		if list.Events[i].Action != want {
+			t.Errorf("event %d action = %q, want %q", i, list.Events[i].Action, want)
+		}
+	}
+
+	customerID := list.Events[0].CustomerID
+	if want := "customer:" + strconv.Itoa(customerID) + " via api_key"; list.Events[0].Actor != want {
+		t.Errorf("PATCH actor = %q, want %q", list.Events[0].Actor, want)
+	}
+	if change := list.Events[0].Changes["smtp_port"]; change.From != float64(587) || change.To != float64(2525) {
+		t.Errorf("PATCH smtp_port change = %+v, want 587 to 2525", change)
+	}
+	if change := list.Events[2].Changes["password"]; change.To != redacted {
+		t.Errorf("create recorded password as %v, want it redacted", change.To)
+	}
+	if want := "customer:" + strconv.Itoa(customerID) + " via jwt"; list.Events[3].Actor != want {
+		t.Errorf("API key creation actor = %q, want %q", list.Events[3].Actor, want)
+	}
+
+	rec, _ = api.expect(http.StatusOK, "GET", "/api/v1/audit-events?action=smtp_config.*&limit=2", nil, nil)
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Events) != 2 || !list.HasMore {
+		t.Errorf("filtered page = %d events, has_more %t; want 2 and more to come", len(list.Events), list.HasMore)
+	}
+}
+
+func TestCreateSMTPConfigValidation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	api := &apiClient{t: t, router: NewServer(memory.New()).Router()}
+	credentials := map[string]string{"email": "owner@example.test", "password": "correct horse"}
+	api.expect(http.StatusCreated, "POST", "/api/v1/register", credentials, nil)
+	_, login := api.expect(http.StatusOK, "POST", "/api/v1/login", credentials, nil)
+	api.header = http.Header{"Authorization": {"Bearer " + login["token"].(string)}}
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"name":       "primary",
+			"smtp_host":  "smtp.example.test",
+			"smtp_port":  587,
+			"from_email": "news@example.test",
+		}
+	}
+	tests := []struct {
+		name, field string
+		value       interface{}
+	}{
+		{"missing name", "name", nil},
+		{"invalid host", "smtp_host", "smtp example test"},
+		{"port out of range", "smtp_port", 70000},
+		{"invalid sender", "from_email", "News <news@example.test>"},
+		{"loopback host", "smtp_host", "127.0.0.1"},
+		{"private host", "smtp_host", "10.0.0.25"},
+		{"link-local host", "smtp_host", "169.254.169.254"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api.t = t
+			body := valid()
+			if tt.value == nil {
+				delete(body, tt.field)
+			} else {
+				body[tt.field] = tt.value
+			}
+			_, reply := api.expect(http.StatusUnprocessableEntity, "POST", "/api/v1/smtp", body, nil)
+			if fields, _ := reply["fields"].(map[string]interface{}); fields[tt.field] == nil {
+				t.Errorf("reply %v does not mention %s", reply, tt.field)
+			}
+		})
+	}
+
+	api.t = t
+	rec, _ := api.expect(http.StatusOK, "GET", "/api/v1/smtp", nil, nil)
+	var configs []database.SMTPConfig
+	if err := json.Unmarshal(rec.Body.Bytes(), &configs); err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 0 {
+		t.Errorf("invalid configs were stored: %+v", configs)
+	}
+}
+
+func decodeSMTPConfig(t *testing.T, rec *httptest.ResponseRecorder) database.SMTPConfig {
+	t.Helper()
+	var config database.SMTPConfig
+	if err := json.Unmarshal(rec.Body.Bytes(), &config); err != nil {
+		t.Fatalf("decoding SMTP config: %v", err)
+	}
+	return config
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Gatete-Bruno/besend/pkg/database"
+	"github.com/gin-gonic/gin"
+)
+
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+
+// smtpConfigFields is the request body for PUT and PATCH. Pointer fields
+// distinguish "not sent" from "sent empty".
+type smtpConfigFields struct {
+	Name        *string    `json:"name"`
+	SMTPHost    *string    `json:"smtp_host"`
+	SMTPPort    *int       `json:"smtp_port"`
+	Username    *string    `json:"username"`
+	Password    *string    `json:"password"`
+	FromEmail   *string    `json:"from_email"`
+	TrackOpens  *bool      `json:"track_opens"`
+	TrackClicks *bool      `json:"track_clicks"`
+	UpdatedAt   *time.Time `json:"updated_at"`
+}
+
+// validate returns a message per invalid field. With replace set (PUT) the
+// required fields must be present.
+func (f *smtpConfigFields) validate(replace bool) map[string]string {
+	errs := map[string]string{}
+
+	if replace {
+		if f.Name == nil {
+			errs["name"] = "is required"
+		}
+		if f.SMTPHost == nil {
+			errs["smtp_host"] = "is required"
+		}
+		if f.SMTPPort == nil {
+			errs["smtp_port"] = "is required"
+		}
+		if f.FromEmail == nil {
+			errs["from_email"] = "is required"
+		}
+	}
+
+	if f.Name != nil {
+		if name := strings.TrimSpace(*f.Name); name == "" {
+			errs["name"] = "must not be empty"
+		} else if len(name) > 255 {
+			errs["name"] = "must be at most 255 characters"
+		}
+	}
+	if f.SMTPHost != nil {
+		if len(*f.SMTPHost) > 255 || !hostnamePattern.MatchString(*f.SMTPHost) {
+			errs["smtp_host"] = "must be a valid hostname"
+		}
+	}
+	if f.SMTPPort != nil && (*f.SMTPPort < 1 || *f.SMTPPort > 65535) {
+		errs["smtp_port"] = "must be between 1 and 65535"
+	}
+	if f.Username != nil && len(*f.Username) > 255 {
+		errs["username"] = "must be at most 255 characters"
+	}
+	if f.FromEmail != nil {
+		if addr, err := mail.ParseAddress(*f.FromEmail); err != nil || addr.Address != *f.FromEmail {
+			errs["from_email"] = "must be a plain email address"
+		}
+	}
+	return errs
+}
+
+// smtpConfigAuditFields are the fields of a config recorded when it is
+// created or deleted; the password only as whether one is set.
+func smtpConfigAuditFields(config *database.SMTPConfig) map[string]interface{} {
+	fields := map[string]interface{}{
+		"name":         config.Name,
+		"smtp_host":    config.SMTPHost,
+		"smtp_port":    config.SMTPPort,
+		"username":     config.Username,
+		"from_email":   config.FromEmail,
+		"track_opens":  config.TrackOpens,
+		"track_clicks": config.TrackClicks,
+	}
+	if config.Password != "" {
+		fields["password"] = redacted
+	}
+	return fields
+}
+
+// etag derives a strong ETag from a row's updated_at.
+func etag(updatedAt time.Time) string {
+	return fmt.Sprintf(`"%d"`, updatedAt.UnixMicro())
+}
+
+// expectedVersion reads the version a client expects to modify, from If-Match
+// or from an updated_at field in the body. It returns nil when neither is set.
+func expectedVersion(c *gin.Context, bodyUpdatedAt *time.Time) (*time.Time, error) {
+	if match := c.GetHeader("If-Match"); match != "" && match != "*" {
+		raw := strings.Trim(strings.TrimPrefix(match, "W/"), `"`)
+		micros, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid If-Match header")
+		}
+		t := time.UnixMicro(micros)
+		return &t, nil
+	}
+	return bodyUpdatedAt, nil
+}
+
+func (s *Server) GetSMTPConfig(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+	configID, _ := strconv.Atoi(c.Param("id"))
+
+	config, err := s.SMTPConfigs.Get(customer.ID, configID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "SMTP config not found"})
+		return
+	}
+
+	c.Header("ETag", etag(config.UpdatedAt))
+	c.JSON(http.StatusOK, config)
+}
+
+// ReplaceSMTPConfig handles PUT. Omitting the password keeps the stored one,
+// since passwords are never returned to clients.
+func (s *Server) ReplaceSMTPConfig(c *gin.Context) {
+	s.updateSMTPConfig(c, true)
+}
+
+// PatchSMTPConfig handles PATCH, changing only the fields present.
+func (s *Server) PatchSMTPConfig(c *gin.Context) {
+	s.updateSMTPConfig(c, false)
+}
+
+func (s *Server) updateSMTPConfig(c *gin.Context, replace bool) {
+	customer := c.MustGet("customer").(*database.Customer)
+	configID, _ := strconv.Atoi(c.Param("id"))
+
+	var req smtpConfigFields
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errs := req.validate(replace); len(errs) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Validation failed", "fields": errs})
+		return
+	}
+	if req.SMTPHost != nil {
+		if err := checkSMTPHost(c.Request.Context(), *req.SMTPHost); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Validation failed", "fields": gin.H{"smtp_host": err.Error()}})
+			return
+		}
+	}
+	if replace {
+		empty, off := "", false
+		if req.Username == nil {
+			req.Username = &empty
+		}
+		if req.TrackOpens == nil {
+			req.TrackOpens = &off
+		}
+		if req.TrackClicks == nil {
+			req.TrackClicks = &off
+		}
+	}
+
+	expected, err := expectedVersion(c, req.UpdatedAt)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before, after, err := s.SMTPConfigs.Update(customer.ID, configID, database.SMTPConfigUpdate{
+		Name:        req.Name,
+		SMTPHost:    req.SMTPHost,
+		SMTPPort:    req.SMTPPort,
+		Username:    req.Username,
+		Password:    req.Password,
+		FromEmail:   req.FromEmail,
+		TrackOpens:  req.TrackOpens,
+		TrackClicks: req.TrackClicks,
+	}, expected)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "SMTP config not found"})
+		return
+	case errors.Is(err, database.ErrPreconditionFailed):
+		c.Header("ETag", etag(before.UpdatedAt))
+		c.JSON(http.StatusPreconditionFailed, gin.H{
+			"error":      "SMTP config was modified by another request",
+			"updated_at": before.UpdatedAt,
+		})
+		return
+	case database.IsUniqueViolation(err):
+		c.JSON(http.StatusConflict, gin.H{"error": "An SMTP config with this name already exists"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update SMTP config"})
+		return
+	}
+
+	changes := map[string]database.AuditChange{}
+	addChange(changes, "name", before.Name, after.Name)
+	addChange(changes, "smtp_host", before.SMTPHost, after.SMTPHost)
+	addChange(changes, "smtp_port", before.SMTPPort, after.SMTPPort)
+	addChange(changes, "username", before.Username, after.Username)
+	addChange(changes, "from_email", before.FromEmail, after.FromEmail)
+	addChange(changes, "track_opens", before.TrackOpens, after.TrackOpens)
+	addChange(changes, "track_clicks", before.TrackClicks, after.TrackClicks)
+	if req.Password != nil {
+		changes["password"] = database.AuditChange{From: redacted, To: redacted}
+	}
+	s.recordAudit(c, "smtp_config.update", "smtp_config", configID, changes)
+
+	c.Header("ETag", etag(after.UpdatedAt))
+	c.JSON(http.StatusOK, after)
+}
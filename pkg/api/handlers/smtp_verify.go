@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/Gatete-Bruno/besend/internal/provider"
+	"github.com/Gatete-Bruno/besend/pkg/database"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	smtpTestTimeout = 30 * time.Second
+	// smtpHostLookupTimeout bounds the check of a host when a config is
+	// saved.
+	smtpHostLookupTimeout = 5 * time.Second
+)
+
+// AllowPrivateSMTPHosts lets SMTP configs connect to loopback, private and
+// link-local addresses, e.g. an in-cluster MailHog during development.
+// Otherwise they are refused, so customers cannot use the API to reach
+// services on its own network.
+var AllowPrivateSMTPHosts bool
+
+var errPrivateHost = errors.New("host resolves to a private or internal address")
+
+// nonPublicPrefixes are ranges that netip does not classify as private but
+// which are not reachable on the internet either.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+// smtpProviderConfig maps a stored SMTP config onto the native SMTP provider.
+func smtpProviderConfig(config *database.SMTPConfig) *provider.Config {
+	cfg := &provider.Config{
+		Provider:    "native-smtp",
+		Host:        config.SMTPHost,
+		Port:        config.SMTPPort,
+		Username:    config.Username,
+		Password:    config.Password,
+		SenderEmail: config.FromEmail,
+	}
+	if !AllowPrivateSMTPHosts {
+		cfg.Dial = (&net.Dialer{Control: refuseNonPublic}).DialContext
+	}
+	return cfg
+}
+
+// refuseNonPublic is a net.Dialer Control function. It runs on the resolved
+// address, so a host name cannot pass a check and then resolve elsewhere.
+func refuseNonPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || !publicAddr(ip) {
+		return errPrivateHost
+	}
+	return nil
+}
+
+// checkSMTPHost refuses a host that resolves to a private or internal
+// address, so the mistake is reported when a config is saved rather than
+// on its first send. Dialing checks again, since DNS can change; a host
+// that does not resolve yet is accepted.
+func checkSMTPHost(ctx context.Context, host string) error {
+	if AllowPrivateSMTPHosts {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, smtpHostLookupTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil
+	}
+	for _, ip := range addrs {
+		if !publicAddr(ip) {
+			return errPrivateHost
+		}
+	}
+	return nil
+}
+
+// publicAddr reports whether ip is a unicast address routable on the
+// internet.
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+type smtpTestResult struct {
+	Success    bool            `json:"success"`
+	FailedStep string          `json:"failed_step,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Steps      []provider.Step `json:"steps"`
+}
+
+// runSMTPTest builds a provider for config and runs VerifyCredentials, which
+// covers connect, EHLO, STARTTLS and AUTH. When testRecipient is set a test
+// message is sent afterwards.
+func runSMTPTest(ctx context.Context, config *database.SMTPConfig, testRecipient string) *smtpTestResult {
+	transcript := &provider.Transcript{}
+	ctx, cancel := context.WithTimeout(provider.WithTranscript(ctx, transcript), smtpTestTimeout)
+	defer cancel()
+
+	err := func() error {
+		p, err := provider.NewProvider(smtpProviderConfig(config))
+		if err != nil {
+			return err
+		}
+		if err := p.VerifyCredentials(ctx); err != nil {
+			return err
+		}
+		if testRecipient == "" {
+			return nil
+		}
+		_, err = p.Send(ctx, &provider.EmailRequest{
+			From:    config.FromEmail,
+			To:      testRecipient,
+			Subject: "Besend SMTP connection test",
+			Body:    fmt.Sprintf("This is a test message for SMTP config %q. If you received it, the relay works.", config.Name),
+		})
+		return err
+	}()
+
+	result := &smtpTestResult{Success: err == nil, Steps: transcript.Steps()}
+	if err != nil {
+		result.Error = err.Error()
+		if failed := transcript.Failed(); failed != nil {
+			result.FailedStep = failed.Name
+		}
+	}
+	return result
+}
+
+func (s *Server) VerifySMTPConfig(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+	configID, _ := strconv.Atoi(c.Param("id"))
+
+	var req struct {
+		To string `json:"to" binding:"omitempty,email"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	config, err := s.SMTPConfigs.Get(customer.ID, configID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "SMTP config not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, runSMTPTest(c.Request.Context(), config, req.To))
+}
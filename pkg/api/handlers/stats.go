@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Gatete-Bruno/besend/pkg/database"
+	"github.com/gin-gonic/gin"
+)
+
+// timeSeriesIntervals maps each supported interval to its default range and
+// the widest range a single request may cover.
+var timeSeriesIntervals = map[string]struct {
+	defaultRange time.Duration
+	maxRange     time.Duration
+}{
+	"hour": {24 * time.Hour, 31 * 24 * time.Hour},
+	"day":  {30 * 24 * time.Hour, 366 * 24 * time.Hour},
+}
+
+var timeSeriesGroups = map[string]string{
+	"smtp_config": database.StatsDimensionSMTPConfig,
+	"tag":         database.StatsDimensionTag,
+	"domain":      database.StatsDimensionDomain,
+}
+
+// GetEmailTimeSeries serves bucketed counts from the stats rollup. Query
+// parameters: interval (hour or day), from and to (RFC 3339) and group_by
+// (smtp_config, tag or domain).
+func GetEmailTimeSeries(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+
+	interval := c.DefaultQuery("interval", "hour")
+	limits, ok := timeSeriesIntervals[interval]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "interval must be hour or day"})
+		return
+	}
+
+	to := time.Now().UTC()
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be an RFC 3339 timestamp"})
+			return
+		}
+		to = t.UTC()
+	}
+	from := to.Add(-limits.defaultRange)
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be an RFC 3339 timestamp"})
+			return
+		}
+		from = t.UTC()
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+	if to.Sub(from) > limits.maxRange {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("range is limited to %d days for interval=%s", int(limits.maxRange.Hours()/24), interval)})
+		return
+	}
+
+	var groupBy string
+	if v := c.Query("group_by"); v != "" {
+		if groupBy, ok = timeSeriesGroups[v]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "group_by must be smtp_config, tag or domain"})
+			return
+		}
+	}
+
+	series, err := database.GetEmailTimeSeries(customer.ID, database.TimeSeriesQuery{
+		From:     from,
+		To:       to,
+		Interval: interval,
+		GroupBy:  groupBy,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"interval": interval,
+		"from":     from,
+		"to":       to,
+		"group_by": c.Query("group_by"),
+		"series":   series,
+	})
+}
@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Gatete-Bruno/besend/pkg/database"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxSuppressionLimit = 500
+	// maxSuppressionImport caps the entries accepted in one import request.
+	maxSuppressionImport = 100000
+)
+
+func validSuppressionAddress(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == strings.TrimSpace(email) && len(email) <= 255
+}
+
+func ListSuppressions(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > maxSuppressionLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxSuppressionLimit)})
+		return
+	}
+	reason := c.Query("reason")
+	if reason != "" && !database.ValidSuppressionReason(reason) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason must be bounce, complaint, unsubscribe or manual"})
+		return
+	}
+	var after int
+	if v := c.Query("cursor"); v != "" {
+		if after, err = strconv.Atoi(v); err != nil || after < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+	}
+
+	list, err := database.ListSuppressions(customer.ID, reason, after, limit+1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch suppressions"})
+		return
+	}
+
+	var next string
+	hasMore := len(list) > limit
+	if hasMore {
+		list = list[:limit]
+		next = strconv.Itoa(list[len(list)-1].ID)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"suppressions": list,
+		"next_cursor":  next,
+		"has_more":     hasMore,
+	})
+}
+
+// GetSuppression returns every entry for an address: at most one for all
+// mail and one per suppressed category.
+func GetSuppression(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+
+	list, err := database.ListAddressSuppressions(customer.ID, c.Param("email"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch suppression"})
+		return
+	}
+	if len(list) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Address is not suppressed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"email": list[0].Email, "suppressions": list})
+}
+
+func CreateSuppression(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+
+	var req struct {
+		Email    string  `json:"email" binding:"required"`
+		Category string  `json:"category"`
+		Reason   string  `json:"reason"`
+		Details  *string `json:"details"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = database.SuppressionManual
+	}
+	fields := map[string]string{}
+	if !validSuppressionAddress(req.Email) {
+		fields["email"] = "must be a plain email address"
+	}
+	if !database.ValidSuppressionReason(req.Reason) {
+		fields["reason"] = "must be bounce, complaint, unsubscribe or manual"
+	}
+	if req.Category != "" && !tagPattern.MatchString(req.Category) {
+		fields["category"] = "must be a valid tag"
+	}
+	if len(fields) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Validation failed", "fields": fields})
+		return
+	}
+
+	s, created, err := database.AddSuppression(customer.ID, req.Email, req.Category, req.Reason, req.Details)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add suppression"})
+		return
+	}
+	if !created {
+		c.JSON(http.StatusOK, s)
+		return
+	}
+	c.JSON(http.StatusCreated, s)
+}
+
+// DeleteSuppression removes the entry for all mail, or for the category
+// given in the query.
+func DeleteSuppression(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+
+	deleted, err := database.DeleteSuppression(customer.ID, c.Param("email"), c.Query("category"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete suppression"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Address is not suppressed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Suppression deleted"})
+}
+
+type suppressionImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ImportSuppressions bulk-adds entries from a CSV body (email, reason,
+// details, category; header optional) or from NDJSON when the Content-Type is
+// application/x-ndjson. Missing reasons default to the reason query
+// parameter, or manual. Invalid lines are reported and nothing is imported
+// unless all lines are valid.
+func ImportSuppressions(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+
+	defaultReason := c.DefaultQuery("reason", database.SuppressionManual)
+	if !database.ValidSuppressionReason(defaultReason) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason must be bounce, complaint, unsubscribe or manual"})
+		return
+	}
+
+	var entries []database.Suppression
+	var lineErrs []suppressionImportError
+	add := func(line int, email, reason, details, category string) {
+		if reason == "" {
+			reason = defaultReason
+		}
+		switch {
+		case !validSuppressionAddress(email):
+			lineErrs = append(lineErrs, suppressionImportError{line, fmt.Sprintf("invalid email %q", email)})
+		case !database.ValidSuppressionReason(reason):
+			lineErrs = append(lineErrs, suppressionImportError{line, fmt.Sprintf("invalid reason %q", reason)})
+		case category != "" && !tagPattern.MatchString(category):
+			lineErrs = append(lineErrs, suppressionImportError{line, fmt.Sprintf("invalid category %q", category)})
+		default:
+			e := database.Suppression{Email: email, Category: category, Reason: reason}
+			if details != "" {
+				e.Details = &details
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	var err error
+	if c.ContentType() == "application/x-ndjson" {
+		err = readSuppressionNDJSON(c.Request.Body, add)
+	} else {
+		err = readSuppressionCSV(c.Request.Body, add)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(lineErrs) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Import contains invalid lines", "lines": lineErrs})
+		return
+	}
+
+	added, err := database.ImportSuppressions(customer.ID, entries)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import suppressions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"received": len(entries),
+		"imported": added,
+		"skipped":  len(entries) - added,
+	})
+}
+
+func readSuppressionCSV(r io.Reader, add func(line int, email, reason, details, category string)) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	for n := 0; ; n++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid CSV: %w", err)
+		}
+		if n >= maxSuppressionImport {
+			return fmt.Errorf("import is limited to %d entries", maxSuppressionImport)
+		}
+		line, _ := cr.FieldPos(0)
+		if n == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "email") {
+			continue
+		}
+		var reason, details, category string
+		if len(record) > 1 {
+			reason = strings.TrimSpace(record[1])
+		}
+		if len(record) > 2 {
+			details = record[2]
+		}
+		if len(record) > 3 {
+			category = strings.TrimSpace(record[3])
+		}
+		add(line, strings.TrimSpace(record[0]), reason, details, category)
+	}
+}
+
+func readSuppressionNDJSON(r io.Reader, add func(line int, email, reason, details, category string)) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if line > maxSuppressionImport {
+			return fmt.Errorf("import is limited to %d entries", maxSuppressionImport)
+		}
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var entry struct {
+			Email    string `json:"email"`
+			Reason   string `json:"reason"`
+			Details  string `json:"details"`
+			Category string `json:"category"`
+		}
+		if err := json.Unmarshal([]byte(text), &entry); err != nil {
+			return fmt.Errorf("invalid JSON on line %d", line)
+		}
+		add(line, strings.TrimSpace(entry.Email), entry.Reason, entry.Details, entry.Category)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read body: %w", err)
+	}
+	return nil
+}
+
+// ExportSuppressions streams the list as CSV or NDJSON, optionally filtered
+// by reason.
+func ExportSuppressions(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+
+	format := c.DefaultQuery("format", "csv")
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or ndjson"})
+		return
+	}
+	reason := c.Query("reason")
+	if reason != "" && !database.ValidSuppressionReason(reason) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason must be bounce, complaint, unsubscribe or manual"})
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="suppressions.%s"`, format))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	var writeRow func(*database.Suppression) error
+	var finish func() error
+	if format == "csv" {
+		cw := csv.NewWriter(c.Writer)
+		cw.Write([]string{"email", "reason", "details", "category", "created_at"})
+		writeRow = func(s *database.Suppression) error {
+			return cw.Write([]string{s.Email, s.Reason, csvField(s.Details), s.Category, csvField(s.CreatedAt)})
+		}
+		finish = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	} else {
+		enc := json.NewEncoder(c.Writer)
+		writeRow = func(s *database.Suppression) error {
+			return enc.Encode(gin.H{
+				"email":      s.Email,
+				"reason":     s.Reason,
+				"details":    s.Details,
+				"category":   s.Category,
+				"created_at": s.CreatedAt.UTC().Format(time.RFC3339Nano),
+			})
+		}
+		finish = func() error { return nil }
+	}
+
+	err := database.StreamSuppressions(customer.ID, reason, writeRow)
+	if err == nil {
+		err = finish()
+	}
+	if err != nil {
+		log.Printf("Suppression export for customer %d failed: %v", customer.ID, err)
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Disposition")
+			c.Writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export suppressions"})
+			return
+		}
+		abortStream(c)
+	}
+}
+
+// suppressHardBounce adds a bounce suppression after a permanent recipient
+// failure. Errors are logged since the send has already failed.
+func suppressHardBounce(customerID int, email string, cause error) {
+	details := cause.Error()
+	if _, _, err := database.AddSuppression(customerID, email, "", database.SuppressionBounce, &details); err != nil {
+		log.Printf("Failed to suppress %s for customer %d: %v", email, customerID, err)
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/Gatete-Bruno/besend/pkg/database"
+	"github.com/Gatete-Bruno/besend/pkg/tracking"
+	"github.com/gin-gonic/gin"
+)
+
+// Tracker signs open and click tracking URLs. When unset, tracking cannot
+// be enabled.
+var Tracker *tracking.Signer
+
+// transparentGIF is a 1x1 transparent GIF served as the open pixel.
+var transparentGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+func recordTrackingEvent(emailID int, eventType, url string, c *gin.Context) {
+	err := database.RecordEmailEvent(emailID, eventType, url, c.Request.UserAgent())
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Failed to record %s for email %d: %v", eventType, emailID, err)
+	}
+}
+
+// TrackOpen serves the open pixel. The image is returned even for invalid
+// tokens so mail clients never show a broken image.
+func TrackOpen(c *gin.Context) {
+	if Tracker != nil {
+		if emailID, err := Tracker.VerifyOpen(c.Param("token")); err == nil {
+			recordTrackingEvent(emailID, database.EmailEventOpen, "", c)
+		}
+	}
+	c.Header("Cache-Control", "no-store, no-cache, must-revalidate, private")
+	c.Data(http.StatusOK, "image/gif", transparentGIF)
+}
+
+// TrackClick records a click and redirects to the original link. The
+// signature covers the target, so the endpoint cannot be used as an open
+// redirect.
+func TrackClick(c *gin.Context) {
+	target := c.Query("u")
+	if Tracker == nil {
+		c.String(http.StatusNotFound, "Not found")
+		return
+	}
+	emailID, err := Tracker.VerifyClick(c.Param("token"), target)
+	if err != nil {
+		c.String(http.StatusBadRequest, "This link is invalid.")
+		return
+	}
+	recordTrackingEvent(emailID, database.EmailEventClick, target, c)
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, target)
+}
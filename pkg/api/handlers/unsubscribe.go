@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/Gatete-Bruno/besend/pkg/database"
+	"github.com/Gatete-Bruno/besend/pkg/unsubscribe"
+	"github.com/gin-gonic/gin"
+)
+
+// UnsubscribeSigner signs List-Unsubscribe tokens. When it or PublicBaseURL
+// is unset, outgoing mail carries no List-Unsubscribe headers.
+var UnsubscribeSigner *unsubscribe.Signer
+
+// PublicBaseURL is the externally reachable base URL of the API, without a
+// trailing slash, used to build unsubscribe links.
+var PublicBaseURL string
+
+// unsubscribeHeaders returns the RFC 8058 one-click unsubscribe headers for
+// one recipient, or nil when unsubscribe links are not configured.
+func unsubscribeHeaders(customerID, emailID int, to, category string) map[string]string {
+	if UnsubscribeSigner == nil || PublicBaseURL == "" {
+		return nil
+	}
+	token := UnsubscribeSigner.Sign(unsubscribe.Token{
+		CustomerID: customerID,
+		Email:      to,
+		Category:   category,
+		EmailID:    emailID,
+	})
+	return map[string]string{
+		"List-Unsubscribe":      fmt.Sprintf("<%s/u/%s>", PublicBaseURL, token),
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
+
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width"><title>Unsubscribe</title></head>
+<body style="font-family: sans-serif; max-width: 32em; margin: 4em auto;">
+{{if .Done}}
+<p>{{.Email}} has been unsubscribed from {{if .Category}}&ldquo;{{.Category}}&rdquo; emails{{else}}all emails{{end}}.</p>
+{{else}}
+<p>Stop sending emails to {{.Email}}?</p>
+<form method="post">
+{{if .Category}}
+<button type="submit" name="scope" value="category">Unsubscribe from &ldquo;{{.Category}}&rdquo; emails</button>
+{{end}}
+<button type="submit" name="scope" value="all">Unsubscribe from all emails</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+func verifyUnsubscribeToken(c *gin.Context) *unsubscribe.Token {
+	if UnsubscribeSigner == nil {
+		c.String(http.StatusNotFound, "Not found")
+		return nil
+	}
+	token, err := UnsubscribeSigner.Verify(c.Param("token"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "This unsubscribe link is invalid.")
+		return nil
+	}
+	return token
+}
+
+// UnsubscribeForm serves the page behind the List-Unsubscribe link. It only
+// asks for confirmation, since link scanners follow GET links.
+func UnsubscribeForm(c *gin.Context) {
+	token := verifyUnsubscribeToken(c)
+	if token == nil {
+		return
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	unsubscribePage.Execute(c.Writer, gin.H{"Email": token.Email, "Category": token.Category})
+}
+
+// Unsubscribe records the opt-out as a suppression. Mail clients post
+// "List-Unsubscribe=One-Click" (RFC 8058), which unsubscribes from the
+// token's category; the confirmation page may widen that to all mail.
+func Unsubscribe(c *gin.Context) {
+	token := verifyUnsubscribeToken(c)
+	if token == nil {
+		return
+	}
+
+	oneClick := c.PostForm("List-Unsubscribe") == "One-Click"
+	category := token.Category
+	if c.PostForm("scope") == "all" {
+		category = ""
+	}
+
+	details := "unsubscribe page"
+	if oneClick {
+		details = "one-click unsubscribe"
+	}
+	if token.EmailID != 0 {
+		details = fmt.Sprintf("%s (email %d)", details, token.EmailID)
+	}
+	if _, _, err := database.AddSuppression(token.CustomerID, token.Email, category, database.SuppressionUnsubscribe, &details); err != nil {
+		log.Printf("Failed to record unsubscribe for customer %d: %v", token.CustomerID, err)
+		c.String(http.StatusInternalServerError, "Something went wrong, please try again later.")
+		return
+	}
+
+	if oneClick {
+		c.String(http.StatusOK, "Unsubscribed")
+		return
+	}
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	unsubscribePage.Execute(c.Writer, gin.H{"Email": token.Email, "Category": category, "Done": true})
+}
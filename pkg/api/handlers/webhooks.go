@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Gatete-Bruno/besend/pkg/database"
+	"github.com/Gatete-Bruno/besend/pkg/svix"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	webhookTimeout   = 10 * time.Second
+	webhookBatchSize = 50
+	// webhookLease is how long a claimed delivery is left alone before
+	// another replica may retry it. It must exceed webhookTimeout.
+	webhookLease = time.Minute
+)
+
+// webhookRetryDelays are the waits after each failed attempt. A delivery
+// is given up on once they are used up.
+var webhookRetryDelays = []time.Duration{
+	30 * time.Second, 2 * time.Minute, 10 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour,
+}
+
+// AllowPrivateWebhookURLs lets webhook endpoints point at loopback, private
+// and link-local addresses. As with SMTP hosts, they are refused otherwise.
+var AllowPrivateWebhookURLs bool
+
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			d := &net.Dialer{}
+			if !AllowPrivateWebhookURLs {
+				d.Control = refuseNonPublic
+			}
+			return d.DialContext(ctx, network, addr)
+		},
+	},
+	// A redirect is reported as a failure rather than followed.
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// newWebhookSecret returns a signing secret in the form receivers using
+// Standard Webhooks libraries expect.
+func newWebhookSecret() (string, error) {
+	key := make([]byte, 24)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return "whsec_" + base64.StdEncoding.EncodeToString(key), nil
+}
+
+// CreateWebhookEndpoint registers a URL for the customer's events. The
+// signing secret is only returned here.
+func (s *Server) CreateWebhookEndpoint(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+
+	var req struct {
+		URL        string   `json:"url"`
+		EventTypes []string `json:"event_types"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	errs := map[string]string{}
+	if u, err := url.Parse(req.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.User != nil {
+		errs["url"] = "must be an http or https URL without credentials"
+	}
+	if len(req.EventTypes) == 0 {
+		req.EventTypes = database.WebhookEventTypes()
+	}
+	for _, t := range req.EventTypes {
+		if !contains(database.WebhookEventTypes(), t) {
+			errs["event_types"] = fmt.Sprintf("unknown event type %q", t)
+		}
+	}
+	if len(errs) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Validation failed", "fields": errs})
+		return
+	}
+
+	secret, err := newWebhookSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+	endpoint, err := database.CreateWebhookEndpoint(customer.ID, req.URL, secret, req.EventTypes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+
+	s.recordAudit(c, "webhook.create", "webhook", endpoint.ID, createdChanges(map[string]interface{}{
+		"url":         endpoint.URL,
+		"event_types": endpoint.EventTypes,
+		"secret":      redacted,
+	}))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"webhook": endpoint,
+		"secret":  secret,
+		"warning": "Store this secret securely. It won't be shown again.",
+	})
+}
+
+func ListWebhookEndpoints(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+
+	endpoints, err := database.ListWebhookEndpoints(customer.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhooks"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": endpoints})
+}
+
+// DeleteWebhookEndpoint removes an endpoint. Deliveries still pending for
+// it are dropped.
+func (s *Server) DeleteWebhookEndpoint(c *gin.Context) {
+	customer := c.MustGet("customer").(*database.Customer)
+	endpointID, _ := strconv.Atoi(c.Param("id"))
+
+	endpoint, err := database.GetWebhookEndpoint(customer.ID, endpointID)
+	if err == nil {
+		err = database.DeleteWebhookEndpoint(customer.ID, endpointID)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+		return
+	}
+	s.recordAudit(c, "webhook.delete", "webhook", endpointID, deletedChanges(map[string]interface{}{
+		"url":         endpoint.URL,
+		"event_types": endpoint.EventTypes,
+	}))
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
+
+// DeliverWebhooks posts queued webhook events every interval until the
+// process exits. Deliveries are claimed in the database, so every replica
+// can run it.
+func DeliverWebhooks(interval time.Duration) {
+	for range time.Tick(interval) {
+		for {
+			batch, err := database.ClaimWebhookDeliveries(webhookBatchSize, webhookLease)
+			if err != nil {
+				log.Printf("Failed to claim webhook deliveries: %v", err)
+				break
+			}
+			var wg sync.WaitGroup
+			for _, d := range batch {
+				wg.Add(1)
+				go func(d database.WebhookDelivery) {
+					defer wg.Done()
+					deliverWebhook(d)
+				}(d)
+			}
+			wg.Wait()
+			if len(batch) < webhookBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// deliverWebhook makes one attempt and records its outcome.
+func deliverWebhook(d database.WebhookDelivery) {
+	err := postWebhook(d, time.Now())
+	var retryAt *time.Time
+	if err != nil && d.Attempts <= len(webhookRetryDelays) {
+		t := time.Now().Add(webhookRetryDelays[d.Attempts-1])
+		retryAt = &t
+	}
+	if err := database.FinishWebhookDelivery(d.ID, err, retryAt); err != nil {
+		log.Printf("Failed to record webhook delivery %d: %v", d.ID, err)
+	}
+}
+
+// postWebhook posts the payload signed as Standard Webhooks specifies.
+// The message ID stays the same across retries so receivers can drop
+// duplicates. Any 2xx response counts as delivered.
+func postWebhook(d database.WebhookDelivery, now time.Time) error {
+	signer, err := svix.NewVerifier(d.Secret)
+	if err != nil {
+		return err
+	}
+	msgID := "msg_" + strconv.FormatInt(d.ID, 10)
+
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "besend-webhooks")
+	req.Header.Set("webhook-id", msgID)
+	req.Header.Set("webhook-timestamp", strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set("webhook-signature", signer.Sign(msgID, now, d.Payload))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return nil
+}
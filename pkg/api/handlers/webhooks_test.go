@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Gatete-Bruno/besend/pkg/database"
+	"github.com/Gatete-Bruno/besend/pkg/svix"
+)
+
+func TestPostWebhookSignsPayload(t *testing.T) {
+	secret, err := newWebhookSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := svix.NewVerifier(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gotID string
+	var verifyErr error
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotID, verifyErr = verifier.Verify(r.Header, body, time.Now())
+	}))
+	defer srv.Close()
+
+	AllowPrivateWebhookURLs = true
+	defer func() { AllowPrivateWebhookURLs = false }()
+
+	d := database.WebhookDelivery{
+		ID:        42,
+		EventType: database.WebhookEventEmailOpened,
+		Payload:   []byte(`{"type":"email.opened","data":{"email_id":7}}`),
+		Attempts:  1,
+		URL:       srv.URL,
+		Secret:    secret,
+	}
+	if err := postWebhook(d, time.Now()); err != nil {
+		t.Fatalf("postWebhook: %v", err)
+	}
+	if verifyErr != nil {
+		t.Fatalf("receiver could not verify the signature: %v", verifyErr)
+	}
+	if gotID != "msg_42" {
+		t.Errorf("message ID = %q, want msg_42", gotID)
+	}
+}
+
+func TestPostWebhookFailures(t *testing.T) {
+	secret, _ := newWebhookSecret()
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"server error", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}, "500"},
+		{"redirect", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "http://169.254.169.254/", http.StatusFound)
+		}, "302"},
+	}
+	AllowPrivateWebhookURLs = true
+	defer func() { AllowPrivateWebhookURLs = false }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.handler)
+			defer srv.Close()
+			err := postWebhook(database.WebhookDelivery{ID: 1, Payload: []byte(`{}`), URL: srv.URL, Secret: secret}, time.Now())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want one mentioning %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostWebhookRefusesPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached a loopback endpoint")
+	}))
+	defer srv.Close()
+
+	secret, _ := newWebhookSecret()
+	err := postWebhook(database.WebhookDelivery{ID: 1, Payload: []byte(`{}`), URL: srv.URL, Secret: secret}, time.Now())
+	if err == nil || !strings.Contains(err.Error(), errPrivateHost.Error()) {
+		t.Errorf("err = %v, want the private address refusal", err)
+	}
+}
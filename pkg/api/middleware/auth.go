@@ -13,19 +13,28 @@ import (
 
 var jwtSecret = []byte("your-secret-key-change-in-production")
 
+// AuthMiddleware authenticates requests against the customers in the
+// database.
 func AuthMiddleware() gin.HandlerFunc {
+	return Authenticate(database.Postgres().Customers)
+}
+
+// Authenticate sets the customer a request's API key or bearer token
+// belongs to, looking them up in customers, or rejects the request.
+func Authenticate(customers database.CustomerRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		apiKey := c.GetHeader("X-API-Key")
 		if apiKey != "" {
 			keyHash := sha256.Sum256([]byte(apiKey))
 			keyHashStr := hex.EncodeToString(keyHash[:])
-			customer, err := database.GetCustomerByAPIKey(keyHashStr)
+			customer, err := customers.GetByAPIKey(keyHashStr)
 			if err != nil {
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
 				c.Abort()
 				return
 			}
 			c.Set("customer", customer)
+			c.Set("auth_method", "api_key")
 			c.Next()
 			return
 		}
@@ -58,7 +67,7 @@ func AuthMiddleware() gin.HandlerFunc {
 		claims := token.Claims.(jwt.MapClaims)
 		customerID := int(claims["customer_id"].(float64))
 
-		customer, err := database.GetCustomer(customerID)
+		customer, err := customers.Get(customerID)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Customer not found"})
 			c.Abort()
@@ -66,6 +75,7 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		c.Set("customer", customer)
+		c.Set("auth_method", "jwt")
 		c.Next()
 	}
 }
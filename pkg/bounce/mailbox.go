@@ -0,0 +1,94 @@
+package bounce
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ProcessMaildir hands each message in dir/new to fn and, when fn succeeds,
+// moves it to dir/cur marked as seen, so a later run skips it. Messages fn
+// fails on stay in new and are retried on the next run. It returns the
+// number of messages processed successfully.
+func ProcessMaildir(dir string, fn func(data []byte) error) (int, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		return 0, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	done := 0
+	var firstErr error
+	for _, name := range names {
+		path := filepath.Join(dir, "new", name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if err := fn(data); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if err := os.Rename(path, filepath.Join(dir, "cur", name+":2,S")); err != nil {
+			return done, err
+		}
+		done++
+	}
+	return done, firstErr
+}
+
+// ReadMbox splits an mbox file into messages and hands each to fn, undoing
+// the ">From " quoting of mboxrd. It stops at the first error from fn.
+func ReadMbox(r io.Reader, fn func(data []byte) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), defaultMaxSize)
+
+	var msg bytes.Buffer
+	started := false
+	flush := func() error {
+		if !started {
+			return nil
+		}
+		data := bytes.TrimSuffix(msg.Bytes(), []byte("\n"))
+		msg.Reset()
+		return fn(append([]byte(nil), data...))
+	}
+
+	for sc.Scan() {
+		line := sc.Bytes()
+		if bytes.HasPrefix(line, []byte("From ")) {
+			if err := flush(); err != nil {
+				return err
+			}
+			started = true
+			continue
+		}
+		if !started {
+			continue
+		}
+		if unquoted := bytes.TrimLeft(line, ">"); len(unquoted) < len(line) && bytes.HasPrefix(unquoted, []byte("From ")) {
+			line = line[1:]
+		}
+		msg.Write(line)
+		msg.WriteString("\r\n")
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
@@ -0,0 +1,128 @@
+package bounce
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/Gatete-Bruno/besend/pkg/database"
+)
+
+// Result describes what processing a report did.
+type Result struct {
+	Report *Report `json:"report"`
+	// EmailID is the matched email, zero when no email matched.
+	EmailID int `json:"email_id,omitempty"`
+	// Status is the status the report maps to, empty when it calls for no
+	// change, such as a delayed-delivery notice.
+	Status     string `json:"status,omitempty"`
+	Changed    bool   `json:"changed"`
+	Suppressed bool   `json:"suppressed"`
+}
+
+// Processor matches reports to emails, updates their status and feeds the
+// suppression list.
+type Processor struct {
+	// ReturnPath decodes VERP envelope recipients. When nil, reports are
+	// only matched by the Message-ID in the returned headers.
+	ReturnPath *ReturnPath
+}
+
+// Process handles one inbound message. envelopeTo holds the SMTP envelope
+// recipients when known; for mailbox input the delivery headers added by
+// the local MTA are used instead.
+func (p *Processor) Process(envelopeTo []string, raw []byte) (*Result, error) {
+	report, err := Parse(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Report: report}
+
+	email, err := p.match(report, envelopeTo, raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.EmailID = email.ID
+
+	var reason, details string
+	switch report.Type {
+	case TypeDSN:
+		rcpt, ok := recipientFor(report, email.ToEmail)
+		if !ok || !rcpt.Failed() {
+			return result, nil
+		}
+		result.Status = database.EmailStatusBounced
+		details = strings.TrimSpace(rcpt.Status + " " + rcpt.DiagnosticCode)
+		if rcpt.Permanent() {
+			reason = database.SuppressionBounce
+		}
+	case TypeComplaint:
+		if report.FeedbackType == "not-spam" {
+			return result, nil
+		}
+		result.Status = database.EmailStatusComplained
+		details = "complaint"
+		if report.FeedbackType != "" {
+			details = fmt.Sprintf("complaint (%s)", report.FeedbackType)
+		}
+		reason = database.SuppressionComplaint
+	}
+
+	if result.Changed, err = database.RecordDeliveryReport(email.ID, result.Status, details); err != nil {
+		return nil, err
+	}
+	if reason != "" {
+		if _, _, err := database.AddSuppression(email.CustomerID, email.ToEmail, "", reason, &details); err != nil {
+			return nil, err
+		}
+		result.Suppressed = true
+	}
+	return result, nil
+}
+
+// match finds the reported email, preferring a VERP return path over the
+// Message-ID since only the former is signed.
+func (p *Processor) match(report *Report, envelopeTo []string, raw []byte) (*database.Email, error) {
+	if p.ReturnPath != nil {
+		candidates := append([]string{}, envelopeTo...)
+		if msg, err := mail.ReadMessage(bytes.NewReader(raw)); err == nil {
+			for _, name := range []string{"Delivered-To", "X-Original-To", "Envelope-To", "To"} {
+				candidates = append(candidates, msg.Header[name]...)
+			}
+		}
+		candidates = append(candidates, report.OriginalEnvelopeFrom)
+		for _, candidate := range candidates {
+			if addr, err := mail.ParseAddress(candidate); err == nil {
+				candidate = addr.Address
+			}
+			if id, ok := p.ReturnPath.EmailID(candidate); ok {
+				return database.FindReportedEmail(id, "")
+			}
+		}
+	}
+	if report.OriginalMessageID != "" {
+		return database.FindReportedEmail(0, report.OriginalMessageID)
+	}
+	return nil, sql.ErrNoRows
+}
+
+// recipientFor picks the DSN block for the address an email was sent to.
+// A report with a single block is assumed to be about that address, since
+// relays may rewrite it.
+func recipientFor(report *Report, address string) (Recipient, bool) {
+	for _, r := range report.Recipients {
+		if strings.EqualFold(r.Address, address) {
+			return r, true
+		}
+	}
+	if len(report.Recipients) == 1 {
+		return report.Recipients[0], true
+	}
+	return Recipient{}, false
+}
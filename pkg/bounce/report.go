@@ -0,0 +1,284 @@
+// Package bounce parses asynchronous delivery reports: RFC 3464 delivery
+// status notifications and RFC 5965 (ARF) abuse reports.
+package bounce
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// Report types.
+const (
+	TypeDSN       = "dsn"
+	TypeComplaint = "complaint"
+)
+
+// ErrNotReport is returned for messages that are not a multipart/report,
+// such as auto-replies that land in the bounce mailbox.
+var ErrNotReport = errors.New("message is not a delivery or feedback report")
+
+// ParseError is returned for messages that cannot be parsed at all.
+type ParseError struct {
+	Err error
+}
+
+func (e ParseError) Error() string { return "malformed report: " + e.Err.Error() }
+
+func (e ParseError) Unwrap() error { return e.Err }
+
+// Recipient is one per-recipient block of a DSN.
+type Recipient struct {
+	Address        string `json:"address"`
+	Action         string `json:"action"`
+	Status         string `json:"status"`
+	DiagnosticCode string `json:"diagnostic_code,omitempty"`
+	RemoteMTA      string `json:"remote_mta,omitempty"`
+}
+
+// Failed reports whether delivery to the recipient was abandoned.
+func (r Recipient) Failed() bool {
+	return strings.EqualFold(r.Action, "failed")
+}
+
+// Permanent reports whether the failure is permanent (a 5.x.x status), as
+// opposed to a transient one the reporting MTA gave up retrying.
+func (r Recipient) Permanent() bool {
+	return r.Failed() && strings.HasPrefix(r.Status, "5")
+}
+
+// Report is a parsed delivery status notification or complaint.
+type Report struct {
+	Type string `json:"type"`
+	// ReportingMTA is the Reporting-MTA of a DSN.
+	ReportingMTA string `json:"reporting_mta,omitempty"`
+	// Recipients are the per-recipient blocks of a DSN. For a complaint it
+	// holds the complaining address, when known, with no action or status.
+	Recipients []Recipient `json:"recipients"`
+	// FeedbackType is the ARF Feedback-Type, such as "abuse".
+	FeedbackType string `json:"feedback_type,omitempty"`
+	// UserAgent is the ARF User-Agent of the reporting system.
+	UserAgent string `json:"user_agent,omitempty"`
+	// OriginalMessageID is the Message-ID of the message being reported
+	// on, taken from the returned headers, without angle brackets.
+	OriginalMessageID string `json:"original_message_id,omitempty"`
+	// OriginalEnvelopeFrom is the return path the original message was
+	// sent with, when the returned headers include it.
+	OriginalEnvelopeFrom string `json:"original_envelope_from,omitempty"`
+}
+
+// Parse reads a message and extracts the report it carries. A report may
+// be wrapped in another multipart, as some forwarders do.
+func Parse(r io.Reader) (*Report, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, ParseError{err}
+	}
+	report := &Report{}
+	found, err := walk(report, textproto.MIMEHeader(msg.Header), msg.Body, 0)
+	if err != nil {
+		return nil, ParseError{err}
+	}
+	if !found {
+		return nil, ErrNotReport
+	}
+	return report, nil
+}
+
+// maxDepth bounds multipart nesting.
+const maxDepth = 5
+
+func walk(report *Report, header textproto.MIMEHeader, body io.Reader, depth int) (bool, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	body = decodeTransfer(header.Get("Content-Transfer-Encoding"), body)
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		if depth >= maxDepth || params["boundary"] == "" {
+			return false, nil
+		}
+		found := false
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return found, err
+			}
+			ok, err := walk(report, part.Header, part, depth+1)
+			if err != nil {
+				return found, err
+			}
+			found = found || ok
+		}
+		return found, nil
+
+	case mediaType == "message/delivery-status" || mediaType == "message/global-delivery-status":
+		report.Type = TypeDSN
+		return true, parseDeliveryStatus(report, body)
+
+	case mediaType == "message/feedback-report":
+		report.Type = TypeComplaint
+		return true, parseFeedbackReport(report, body)
+
+	case mediaType == "message/rfc822" || mediaType == "text/rfc822-headers" ||
+		mediaType == "message/rfc822-headers" || mediaType == "message/global" ||
+		mediaType == "message/global-headers":
+		parseOriginalHeaders(report, body)
+	}
+	return false, nil
+}
+
+func decodeTransfer(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, newlineStripper{r})
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+// newlineStripper drops line breaks so base64 bodies wrapped at 76
+// columns decode.
+type newlineStripper struct{ r io.Reader }
+
+func (n newlineStripper) Read(p []byte) (int, error) {
+	for {
+		read, err := n.r.Read(p)
+		kept := 0
+		for _, b := range p[:read] {
+			if b != '\r' && b != '\n' && b != ' ' && b != '\t' {
+				p[kept] = b
+				kept++
+			}
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+// readFieldBlocks splits a delivery-status body into header blocks
+// separated by blank lines.
+func readFieldBlocks(r io.Reader) ([]textproto.MIMEHeader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+
+	var blocks []textproto.MIMEHeader
+	for _, chunk := range bytes.Split(data, []byte("\n\n")) {
+		chunk = bytes.TrimLeft(chunk, "\n")
+		if len(bytes.TrimSpace(chunk)) == 0 {
+			continue
+		}
+		tr := textproto.NewReader(bufio.NewReader(bytes.NewReader(append(chunk, '\n', '\n'))))
+		h, err := tr.ReadMIMEHeader()
+		if err != nil && len(h) == 0 {
+			continue
+		}
+		blocks = append(blocks, h)
+	}
+	return blocks, nil
+}
+
+// typedValue strips the address or diagnostic type from a field such as
+// "rfc822; user@example.com" or "smtp; 550 5.1.1 unknown user".
+func typedValue(v string) string {
+	if _, value, ok := strings.Cut(v, ";"); ok {
+		return strings.TrimSpace(value)
+	}
+	return strings.TrimSpace(v)
+}
+
+func parseDeliveryStatus(report *Report, r io.Reader) error {
+	blocks, err := readFieldBlocks(r)
+	if err != nil {
+		return err
+	}
+	for i, h := range blocks {
+		if i == 0 && h.Get("Action") == "" {
+			report.ReportingMTA = typedValue(h.Get("Reporting-MTA"))
+			continue
+		}
+		address := typedValue(h.Get("Final-Recipient"))
+		if address == "" {
+			address = typedValue(h.Get("Original-Recipient"))
+		}
+		if address == "" {
+			continue
+		}
+		report.Recipients = append(report.Recipients, Recipient{
+			Address:        strings.Trim(address, "<>"),
+			Action:         strings.ToLower(strings.TrimSpace(h.Get("Action"))),
+			Status:         statusCode(h.Get("Status")),
+			DiagnosticCode: typedValue(h.Get("Diagnostic-Code")),
+			RemoteMTA:      typedValue(h.Get("Remote-MTA")),
+		})
+	}
+	return nil
+}
+
+// statusCode keeps the numeric part of a Status field, which some MTAs
+// follow with a comment.
+func statusCode(v string) string {
+	v = strings.TrimSpace(v)
+	if i := strings.IndexAny(v, " \t("); i >= 0 {
+		v = v[:i]
+	}
+	return v
+}
+
+func parseFeedbackReport(report *Report, r io.Reader) error {
+	blocks, err := readFieldBlocks(r)
+	if err != nil || len(blocks) == 0 {
+		return err
+	}
+	h := blocks[0]
+	report.FeedbackType = strings.ToLower(strings.TrimSpace(h.Get("Feedback-Type")))
+	report.UserAgent = strings.TrimSpace(h.Get("User-Agent"))
+	if from := strings.Trim(strings.TrimSpace(h.Get("Original-Mail-From")), "<>"); from != "" {
+		report.OriginalEnvelopeFrom = from
+	}
+	if rcpt := strings.Trim(strings.TrimSpace(h.Get("Original-Rcpt-To")), "<>"); rcpt != "" {
+		report.Recipients = append(report.Recipients, Recipient{Address: rcpt})
+	}
+	return nil
+}
+
+// parseOriginalHeaders reads the returned copy of the original message,
+// of which only the header is needed.
+func parseOriginalHeaders(report *Report, r io.Reader) {
+	tr := textproto.NewReader(bufio.NewReader(r))
+	h, _ := tr.ReadMIMEHeader()
+	if id := strings.Trim(strings.TrimSpace(h.Get("Message-Id")), "<>"); id != "" {
+		report.OriginalMessageID = id
+	}
+	if report.OriginalEnvelopeFrom == "" {
+		if rp := strings.Trim(strings.TrimSpace(h.Get("Return-Path")), "<>"); rp != "" {
+			report.OriginalEnvelopeFrom = rp
+		}
+	}
+	// ARF reports do not always carry Original-Rcpt-To; the complaining
+	// user is then the recipient of the original message.
+	if report.Type == TypeComplaint && len(report.Recipients) == 0 {
+		if addr, err := mail.ParseAddress(h.Get("To")); err == nil {
+			report.Recipients = append(report.Recipients, Recipient{Address: addr.Address})
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package bounce
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseFixtures(t *testing.T) {
+	tests := []struct {
+		file         string
+		typ          string
+		recipient    string
+		action       string
+		status       string
+		permanent    bool
+		feedbackType string
+		messageID    string
+	}{
+		{
+			file:      "gmail-hard-bounce.eml",
+			typ:       TypeDSN,
+			recipient: "no.such.user.81@gmail.com",
+			action:    "failed",
+			status:    "5.1.1",
+			permanent: true,
+			messageID: "besend.2071.51d0aa3f8e27@sender.test",
+		},
+		{
+			file:      "postfix-hard-bounce.eml",
+			typ:       TypeDSN,
+			recipient: "nobody@customer-domain.test",
+			action:    "failed",
+			status:    "5.1.1",
+			permanent: true,
+			messageID: "besend.1042.9c2f7e1ab04d@sender.test",
+		},
+		{
+			// The delivery-status part is base64-encoded.
+			file:      "exchange-hard-bounce.eml",
+			typ:       TypeDSN,
+			recipient: "finance@contoso.test",
+			action:    "failed",
+			status:    "5.1.10",
+			permanent: true,
+			messageID: "besend.3318.a7c90e224b61@sender.test",
+		},
+		{
+			// The comment after the status code is dropped.
+			file:      "soft-bounce-mailbox-full.eml",
+			typ:       TypeDSN,
+			recipient: "full@inbox.test",
+			action:    "failed",
+			status:    "4.2.2",
+			permanent: false,
+			messageID: "besend.1209.03bd61f7a9e8@sender.test",
+		},
+		{
+			file:      "postfix-delayed.eml",
+			typ:       TypeDSN,
+			recipient: "ops@slow-host.test",
+			action:    "delayed",
+			status:    "4.4.1",
+			permanent: false,
+			messageID: "besend.1177.e1f3a9b02c5d@sender.test",
+		},
+		{
+			file:         "arf-abuse.eml",
+			typ:          TypeComplaint,
+			recipient:    "shopper1988@yahoo.com",
+			feedbackType: "abuse",
+			messageID:    "besend.4480.7fe2c04b9d13@sender.test",
+		},
+		{
+			// No Original-Rcpt-To; the recipient comes from the returned
+			// message's To header.
+			file:         "arf-no-rcpt.eml",
+			typ:          TypeComplaint,
+			recipient:    "pat.example@outlook.test",
+			feedbackType: "abuse",
+			messageID:    "besend.4502.d93a6b01c7e4@sender.test",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			report := parseFixture(t, tt.file)
+			if report.Type != tt.typ {
+				t.Errorf("Type = %q, want %q", report.Type, tt.typ)
+			}
+			if len(report.Recipients) != 1 {
+				t.Fatalf("got %d recipients, want 1: %+v", len(report.Recipients), report.Recipients)
+			}
+			r := report.Recipients[0]
+			if r.Address != tt.recipient {
+				t.Errorf("recipient = %q, want %q", r.Address, tt.recipient)
+			}
+			if r.Action != tt.action {
+				t.Errorf("Action = %q, want %q", r.Action, tt.action)
+			}
+			if r.Status != tt.status {
+				t.Errorf("Status = %q, want %q", r.Status, tt.status)
+			}
+			if r.Permanent() != tt.permanent {
+				t.Errorf("Permanent() = %t, want %t", r.Permanent(), tt.permanent)
+			}
+			if report.FeedbackType != tt.feedbackType {
+				t.Errorf("FeedbackType = %q, want %q", report.FeedbackType, tt.feedbackType)
+			}
+			if report.OriginalMessageID != tt.messageID {
+				t.Errorf("OriginalMessageID = %q, want %q", report.OriginalMessageID, tt.messageID)
+			}
+		})
+	}
+}
+
+func TestParseAutoReplyIsNotReport(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "autoreply.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := Parse(f); !errors.Is(err, ErrNotReport) {
+		t.Fatalf("Parse() error = %v, want ErrNotReport", err)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	_, err := Parse(strings.NewReader("not a message"))
+	var parseErr ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("Parse() error = %v, want a ParseError", err)
+	}
+}
+
+func parseFixture(t *testing.T, name string) *Report {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	report, err := Parse(f)
+	if err != nil {
+		t.Fatalf("Parse(%s): %v", name, err)
+	}
+	return report
+}
@@ -0,0 +1,249 @@
+package bounce
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HandlerFunc receives one message accepted by the Server. Returning an
+// error answers the DATA command with a temporary failure, so the sending
+// MTA retries later.
+type HandlerFunc func(envelopeTo []string, data []byte) error
+
+// Server is a receive-only SMTP server for the bounce mailbox. It accepts
+// any recipient under Domains and does no relaying or authentication.
+type Server struct {
+	Addr     string
+	Hostname string
+	// Domains the server accepts mail for. Empty accepts any domain.
+	Domains []string
+	// MaxSize caps message size in bytes; DSNs with the full original
+	// message attached can be large.
+	MaxSize       int64
+	MaxRecipients int
+	Timeout       time.Duration
+	Handler       HandlerFunc
+
+	mu       sync.Mutex
+	listener net.Listener
+	closed   bool
+}
+
+const (
+	defaultMaxSize       = 10 << 20
+	defaultMaxRecipients = 50
+	defaultTimeout       = 5 * time.Minute
+)
+
+var ErrServerClosed = errors.New("bounce: server closed")
+
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Close is called.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops accepting connections. Sessions in progress run to
+// completion.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+func (s *Server) acceptsDomain(address string) bool {
+	if len(s.Domains) == 0 {
+		return true
+	}
+	_, domain, ok := strings.Cut(address, "@")
+	if !ok {
+		return false
+	}
+	for _, d := range s.Domains {
+		if strings.EqualFold(domain, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathArg extracts the address from "FROM:<addr> PARAMS" or "TO:<addr>".
+func pathArg(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	rest := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(rest, "<") {
+		return "", false
+	}
+	end := strings.Index(rest, ">")
+	if end < 0 {
+		return "", false
+	}
+	return rest[1:end], true
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	hostname := s.Hostname
+	if hostname == "" {
+		hostname = "localhost"
+	}
+	maxSize := s.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxSize
+	}
+	maxRcpts := s.MaxRecipients
+	if maxRcpts <= 0 {
+		maxRcpts = defaultMaxRecipients
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	tc := textproto.NewConn(conn)
+	reply := func(code int, msg string) error {
+		conn.SetWriteDeadline(time.Now().Add(timeout))
+		return tc.PrintfLine("%d %s", code, msg)
+	}
+
+	if err := reply(220, hostname+" ESMTP bounce processor"); err != nil {
+		return
+	}
+
+	var from *string
+	var rcpts []string
+	reset := func() {
+		from = nil
+		rcpts = nil
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			reset()
+			err = reply(250, hostname)
+		case "EHLO":
+			reset()
+			conn.SetWriteDeadline(time.Now().Add(timeout))
+			err = tc.PrintfLine("250-%s\r\n250-8BITMIME\r\n250-PIPELINING\r\n250 SIZE %d", hostname, maxSize)
+		case "MAIL":
+			addr, ok := pathArg(arg, "FROM:")
+			if !ok {
+				err = reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+				break
+			}
+			reset()
+			from = &addr
+			err = reply(250, "2.1.0 OK")
+		case "RCPT":
+			addr, ok := pathArg(arg, "TO:")
+			switch {
+			case from == nil:
+				err = reply(503, "5.5.1 MAIL first")
+			case !ok:
+				err = reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+			case !s.acceptsDomain(addr):
+				err = reply(550, "5.1.1 Mailbox unavailable")
+			case len(rcpts) >= maxRcpts:
+				err = reply(452, "4.5.3 Too many recipients")
+			default:
+				rcpts = append(rcpts, addr)
+				err = reply(250, "2.1.5 OK")
+			}
+		case "DATA":
+			if len(rcpts) == 0 {
+				err = reply(503, "5.5.1 RCPT first")
+				break
+			}
+			if err = reply(354, "End data with <CR><LF>.<CR><LF>"); err != nil {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(timeout))
+			dot := tc.DotReader()
+			data, readErr := io.ReadAll(io.LimitReader(dot, maxSize+1))
+			switch {
+			case readErr != nil:
+				return
+			case int64(len(data)) > maxSize:
+				// Drain the rest so the session stays in sync.
+				if _, err := io.Copy(io.Discard, dot); err != nil {
+					return
+				}
+				err = reply(552, "5.3.4 Message too big")
+			default:
+				if handleErr := s.Handler(rcpts, data); handleErr != nil {
+					log.Printf("bounce: failed to process message: %v", handleErr)
+					err = reply(451, "4.3.0 Processing failed, try again later")
+				} else {
+					err = reply(250, "2.0.0 OK")
+				}
+			}
+			reset()
+		case "RSET":
+			reset()
+			err = reply(250, "2.0.0 OK")
+		case "NOOP":
+			err = reply(250, "2.0.0 OK")
+		case "VRFY":
+			err = reply(252, "2.5.2 Cannot verify")
+		case "QUIT":
+			reply(221, "2.0.0 Bye")
+			return
+		default:
+			err = reply(502, fmt.Sprintf("5.5.2 Command %q not implemented", verb))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
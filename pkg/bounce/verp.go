@@ -0,0 +1,72 @@
+package bounce
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ReturnPath builds VERP return paths of the form
+// "<local>+<email id>-<signature>@<domain>", so a bounce can be matched to
+// the email it is about from its envelope recipient alone. The signature
+// keeps third parties from forging bounces for arbitrary emails. It is hex
+// because some MTAs change the case of local parts.
+type ReturnPath struct {
+	local  string
+	domain string
+	key    []byte
+}
+
+// NewReturnPath derives return paths from address, the mailbox the bounce
+// processor receives mail for, e.g. "bounces@mail.example.com".
+func NewReturnPath(address string, key []byte) (*ReturnPath, error) {
+	if len(key) < 32 {
+		return nil, errors.New("bounce signing key must be at least 32 bytes")
+	}
+	local, domain, ok := strings.Cut(address, "@")
+	if !ok || local == "" || domain == "" || strings.Contains(local, "+") {
+		return nil, fmt.Errorf("invalid bounce address %q", address)
+	}
+	return &ReturnPath{local: local, domain: strings.ToLower(domain), key: key}, nil
+}
+
+func (p *ReturnPath) sign(emailID int) string {
+	m := hmac.New(sha256.New, p.key)
+	fmt.Fprintf(m, "verp:v1:%d", emailID)
+	return hex.EncodeToString(m.Sum(nil)[:8])
+}
+
+// Address returns the return path for an email.
+func (p *ReturnPath) Address(emailID int) string {
+	return fmt.Sprintf("%s+%d-%s@%s", p.local, emailID, p.sign(emailID), p.domain)
+}
+
+// EmailID extracts the email ID from a return path, reporting false when
+// the address is not one of ours or its signature does not match.
+func (p *ReturnPath) EmailID(address string) (int, bool) {
+	address = strings.Trim(strings.TrimSpace(address), "<>")
+	local, domain, ok := strings.Cut(address, "@")
+	if !ok || !strings.EqualFold(domain, p.domain) {
+		return 0, false
+	}
+	base, tag, ok := strings.Cut(local, "+")
+	if !ok || !strings.EqualFold(base, p.local) {
+		return 0, false
+	}
+	id, sig, ok := strings.Cut(tag, "-")
+	if !ok {
+		return 0, false
+	}
+	emailID, err := strconv.Atoi(id)
+	if err != nil || emailID < 1 {
+		return 0, false
+	}
+	if !hmac.Equal([]byte(strings.ToLower(sig)), []byte(p.sign(emailID))) {
+		return 0, false
+	}
+	return emailID, true
+}
@@ -0,0 +1,82 @@
+package bounce
+
+import (
+	"strings"
+	"testing"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestReturnPathRoundTrip(t *testing.T) {
+	p, err := NewReturnPath("bounces@Mail.Example.com", testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := p.Address(4480)
+	if !strings.HasPrefix(addr, "bounces+4480-") || !strings.HasSuffix(addr, "@mail.example.com") {
+		t.Fatalf("Address(4480) = %q", addr)
+	}
+
+	for _, candidate := range []string{
+		addr,
+		"<" + addr + ">",
+		" " + addr + " ",
+		// MTAs may change the case of the whole address.
+		strings.ToUpper(addr),
+	} {
+		id, ok := p.EmailID(candidate)
+		if !ok || id != 4480 {
+			t.Errorf("EmailID(%q) = %d, %t, want 4480, true", candidate, id, ok)
+		}
+	}
+}
+
+func TestReturnPathRejects(t *testing.T) {
+	p, err := NewReturnPath("bounces@mail.example.com", testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewReturnPath("bounces@mail.example.com", []byte("another key of at least 32 bytes!!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := p.Address(7)
+	_, sig, _ := strings.Cut(strings.TrimSuffix(valid, "@mail.example.com"), "-")
+	tampered := []byte(sig)
+	if tampered[0] == '0' {
+		tampered[0] = '1'
+	} else {
+		tampered[0] = '0'
+	}
+
+	for name, addr := range map[string]string{
+		"wrong signature":   "bounces+7-" + string(tampered) + "@mail.example.com",
+		"other id":          strings.Replace(valid, "+7-", "+8-", 1),
+		"signed by other":   other.Address(7),
+		"wrong domain":      strings.Replace(valid, "@mail.example.com", "@example.org", 1),
+		"wrong mailbox":     strings.Replace(valid, "bounces+", "postmaster+", 1),
+		"no tag":            "bounces@mail.example.com",
+		"no signature":      "bounces+7@mail.example.com",
+		"non-numeric id":    "bounces+x-" + sig + "@mail.example.com",
+		"zero id":           "bounces+0-" + p.sign(0) + "@mail.example.com",
+		"not an address":    "bounces",
+		"truncated address": strings.TrimSuffix(valid, "@mail.example.com"),
+	} {
+		if id, ok := p.EmailID(addr); ok {
+			t.Errorf("%s: EmailID(%q) = %d, true, want false", name, addr, id)
+		}
+	}
+}
+
+func TestNewReturnPathValidates(t *testing.T) {
+	if _, err := NewReturnPath("bounces@mail.example.com", []byte("short")); err == nil {
+		t.Error("accepted a short key")
+	}
+	for _, addr := range []string{"bounces", "@mail.example.com", "bounces@", "bounces+x@mail.example.com"} {
+		if _, err := NewReturnPath(addr, testKey); err == nil {
+			t.Errorf("accepted address %q", addr)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Statuses set by asynchronous delivery reports, after an email was
+// accepted by the relay.
+const (
+	EmailStatusDelivered  = "delivered"
+	EmailStatusBounced    = "bounced"
+	EmailStatusComplained = "complained"
+)
+
+// reportTransitions lists the statuses each report status may replace.
+// Reports can arrive out of order, so a later stage is never overwritten
+// by an earlier one: a complaint may follow a bounce but not vice versa.
+var reportTransitions = map[string][]string{
+	EmailStatusDelivered:  {"sent"},
+	EmailStatusBounced:    {"sent", EmailStatusDelivered},
+	EmailStatusComplained: {"sent", EmailStatusDelivered, EmailStatusBounced},
+}
+
+// SetEmailMessageID records the Message-ID an email was sent with, so
+// delivery reports quoting it can be matched back to the email.
+func SetEmailMessageID(emailID int, messageID string) error {
+	_, err := DB.Exec(`UPDATE emails SET message_id = $1 WHERE id = $2`, messageID, emailID)
+	return err
+}
+
+// SetEmailProviderMessageID records the ID a sending provider such as
+// Resend assigned to an email, which its webhooks refer to.
+func SetEmailProviderMessageID(emailID int, providerMessageID string) error {
+	_, err := DB.Exec(`UPDATE emails SET provider_message_id = $1 WHERE id = $2`, providerMessageID, emailID)
+	return err
+}
+
+// FindReportedEmail looks up the email a delivery report refers to, by ID
+// when known (from a VERP return path) and otherwise by Message-ID. Only
+// the fields needed to act on the report are filled in. It returns
+// sql.ErrNoRows when nothing matches.
+func FindReportedEmail(emailID int, messageID string) (*Email, error) {
+	query := `SELECT id, customer_id, to_email, status FROM emails WHERE id = $1`
+	var arg interface{} = emailID
+	if emailID == 0 {
+		query = `SELECT id, customer_id, to_email, status FROM emails WHERE message_id = $1`
+		arg = messageID
+	}
+	var email Email
+	err := DB.QueryRow(query, arg).Scan(&email.ID, &email.CustomerID, &email.ToEmail, &email.Status)
+	if err != nil {
+		return nil, err
+	}
+	return &email, nil
+}
+
+// RecordDeliveryReport moves a sent email to delivered, bounced or
+// complained. changed is false when the transition is not allowed, e.g.
+// for a repeated report.
+func RecordDeliveryReport(emailID int, status, details string) (changed bool, err error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if changed, err = applyDeliveryReport(tx, emailID, status, details); err != nil {
+		return false, err
+	}
+	return changed, tx.Commit()
+}
+
+// applyDeliveryReport updates the status within tx. Bounces and complaints
+// are counted in the hour the email was sent, like RebuildEmailStats.
+func applyDeliveryReport(tx *sql.Tx, emailID int, status, details string) (bool, error) {
+	var subject statsSubject
+	var sentAt *time.Time
+	err := tx.QueryRow(`
+		UPDATE emails e
+		SET status = $1, error_message = COALESCE(NULLIF($2, ''), e.error_message)
+		FROM (SELECT id FROM emails WHERE id = $3 AND status = ANY($4) FOR UPDATE) prev
+		WHERE e.id = prev.id
+		RETURNING e.customer_id, e.smtp_config_id, e.to_email, COALESCE(e.tags, '{}'), e.sent_at
+	`, status, details, emailID, pq.Array(reportTransitions[status])).Scan(
+		&subject.CustomerID, &subject.SMTPConfigID, &subject.ToEmail, pq.Array(&subject.Tags), &sentAt,
+	)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var event string
+	switch status {
+	case EmailStatusBounced:
+		event = StatsEventBounced
+	case EmailStatusComplained:
+		event = StatsEventComplained
+	default:
+		return true, nil
+	}
+	at := time.Now().UTC()
+	if sentAt != nil {
+		at = *sentAt
+	}
+	if err := recordStatsEvent(tx, subject, event, at, 0); err != nil {
+		return false, err
+	}
+	return true, nil
+}
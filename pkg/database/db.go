@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/Gatete-Bruno/besend/pkg/secrets"
 	_ "github.com/lib/pq"
 )
 
@@ -19,6 +20,16 @@ type Config struct {
 
 var DB *sql.DB
 
+// Keyring encrypts secrets such as SMTP passwords before they are stored.
+var Keyring *secrets.Keyring
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
 func Connect(cfg Config) error {
 	connStr := fmt.Sprintf(
 		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
@@ -39,71 +50,6 @@ func Connect(cfg Config) error {
 	return nil
 }
 
-func InitSchema() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS customers (
-		id SERIAL PRIMARY KEY,
-		email VARCHAR(255) UNIQUE NOT NULL,
-		password_hash VARCHAR(255) NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		plan VARCHAR(50) DEFAULT 'starter',
-		monthly_quota INTEGER DEFAULT 1000,
-		emails_sent_this_month INTEGER DEFAULT 0,
-		stripe_customer_id VARCHAR(255),
-		active BOOLEAN DEFAULT true
-	);
-
-	CREATE TABLE IF NOT EXISTS api_keys (
-		id SERIAL PRIMARY KEY,
-		customer_id INTEGER REFERENCES customers(id) ON DELETE CASCADE,
-		key_hash VARCHAR(64) UNIQUE NOT NULL,
-		name VARCHAR(255),
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		last_used_at TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS smtp_configs (
-		id SERIAL PRIMARY KEY,
-		customer_id INTEGER REFERENCES customers(id) ON DELETE CASCADE,
-		name VARCHAR(255) NOT NULL,
-		smtp_host VARCHAR(255) NOT NULL,
-		smtp_port INTEGER NOT NULL,
-		username VARCHAR(255) NOT NULL,
-		password VARCHAR(255) NOT NULL,
-		from_email VARCHAR(255) NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		UNIQUE(customer_id, name)
-	);
-
-	CREATE TABLE IF NOT EXISTS emails (
-		id SERIAL PRIMARY KEY,
-		customer_id INTEGER REFERENCES customers(id) ON DELETE CASCADE,
-		smtp_config_id INTEGER REFERENCES smtp_configs(id) ON DELETE SET NULL,
-		to_email VARCHAR(255) NOT NULL,
-		subject VARCHAR(500) NOT NULL,
-		body TEXT NOT NULL,
-		status VARCHAR(50) DEFAULT 'pending',
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		sent_at TIMESTAMP,
-		error_message TEXT
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_emails_customer_id ON emails(customer_id);
-	CREATE INDEX IF NOT EXISTS idx_emails_status ON emails(status);
-	CREATE INDEX IF NOT EXISTS idx_smtp_configs_customer_id ON smtp_configs(customer_id);
-	CREATE INDEX IF NOT EXISTS idx_api_keys_customer_id ON api_keys(customer_id);
-	CREATE INDEX IF NOT EXISTS idx_api_keys_hash ON api_keys(key_hash);
-	`
-
-	_, err := DB.Exec(schema)
-	if err != nil {
-		return fmt.Errorf("failed to initialize schema: %w", err)
-	}
-
-	log.Println("Database schema initialized")
-	return nil
-}
-
 func Close() error {
 	if DB != nil {
 		return DB.Close()
@@ -0,0 +1,42 @@
+package database
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/Gatete-Bruno/besend/pkg/dkim"
+)
+
+// DKIMSigner returns a signer for the active key of a customer's sending
+// domain, or nil when the customer has no such domain or it has no active
+// key. Keys are created and rotated by the smtp-manager service, which
+// shares this database and its SECRETS_KEK.
+func DKIMSigner(customerID int, domain string) (*dkim.Signer, error) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	var selector, sealed string
+	err := DB.QueryRow(`
+		SELECT k.selector, k.private_key
+		FROM dkim_keys k
+		JOIN sending_domains d ON d.id = k.domain_id
+		JOIN organizations o ON o.id = d.organization_id
+		WHERE o.customer_id = $1 AND LOWER(d.domain) = $2 AND k.status = 'active'
+	`, strconv.Itoa(customerID), domain).Scan(&selector, &sealed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pem, err := decryptSecret(sealed)
+	if err != nil {
+		return nil, err
+	}
+	key, err := dkim.ParsePrivateKey([]byte(pem))
+	if err != nil {
+		return nil, err
+	}
+	return &dkim.Signer{Domain: domain, Selector: selector, Key: key}, nil
+}
@@ -0,0 +1,224 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// exportFetchSize is the number of rows fetched from the server-side cursor
+// per round trip.
+const exportFetchSize = 500
+
+// StreamEmails calls fn for every email matching f, oldest first. Rows are
+// read through a server-side cursor so memory use does not grow with the
+// result set. The bodies are only loaded when includeBody is set.
+func StreamEmails(ctx context.Context, customerID int, f EmailFilter, includeBody bool, fn func(*Email) error) error {
+	tx, err := DB.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	body := "'', ''"
+	if includeBody {
+		body = "body, COALESCE(html_body, '')"
+	}
+	var args []interface{}
+	where := emailWhere(customerID, f, &args)
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+		DECLARE email_export NO SCROLL CURSOR FOR
+		SELECT id, customer_id, smtp_config_id, to_email, subject, %s, status, COALESCE(tags, '{}'),
+			created_at, sent_at, error_message
+		FROM emails
+		WHERE %s
+		ORDER BY created_at, id
+	`, body, where), args...)
+	if err != nil {
+		return err
+	}
+
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM email_export", exportFetchSize)
+	for {
+		rows, err := tx.QueryContext(ctx, fetch)
+		if err != nil {
+			return err
+		}
+		n := 0
+		for rows.Next() {
+			n++
+			var email Email
+			err := rows.Scan(
+				&email.ID, &email.CustomerID, &email.SMTPConfigID, &email.ToEmail,
+				&email.Subject, &email.Body, &email.HTMLBody, &email.Status, pq.Array(&email.Tags),
+				&email.CreatedAt, &email.SentAt, &email.ErrorMessage,
+			)
+			if err == nil {
+				err = fn(&email)
+			}
+			if err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+		if n < exportFetchSize {
+			return nil
+		}
+	}
+}
+
+// Export job states.
+const (
+	ExportJobPending   = "pending"
+	ExportJobRunning   = "running"
+	ExportJobCompleted = "completed"
+	ExportJobFailed    = "failed"
+)
+
+// ExportJob is an asynchronous export. The output is stored in Postgres as
+// ordered chunks so any API replica can serve the download. Params holds the
+// request options so the job can be described and rerun.
+type ExportJob struct {
+	ID           int             `json:"id"`
+	CustomerID   int             `json:"customer_id"`
+	Status       string          `json:"status"`
+	Format       string          `json:"format"`
+	Params       json.RawMessage `json:"params"`
+	RowCount     int64           `json:"row_count"`
+	SizeBytes    int64           `json:"size_bytes"`
+	ErrorMessage *string         `json:"error_message,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	CompletedAt  *time.Time      `json:"completed_at,omitempty"`
+	ExpiresAt    *time.Time      `json:"expires_at,omitempty"`
+}
+
+func CreateExportJob(customerID int, format string, params json.RawMessage) (*ExportJob, error) {
+	job := &ExportJob{CustomerID: customerID, Status: ExportJobPending, Format: format, Params: params}
+	err := DB.QueryRow(`
+		INSERT INTO export_jobs (customer_id, status, format, params)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at
+	`, customerID, job.Status, format, string(params)).Scan(&job.ID, &job.CreatedAt, &job.UpdatedAt)
+	return job, err
+}
+
+func GetExportJob(customerID, jobID int) (*ExportJob, error) {
+	var job ExportJob
+	var params string
+	err := DB.QueryRow(`
+		SELECT id, customer_id, status, format, params, row_count, size_bytes, error_message,
+			created_at, updated_at, completed_at, expires_at
+		FROM export_jobs
+		WHERE id = $1 AND customer_id = $2
+	`, jobID, customerID).Scan(
+		&job.ID, &job.CustomerID, &job.Status, &job.Format, &params, &job.RowCount, &job.SizeBytes,
+		&job.ErrorMessage, &job.CreatedAt, &job.UpdatedAt, &job.CompletedAt, &job.ExpiresAt,
+	)
+	job.Params = json.RawMessage(params)
+	return &job, err
+}
+
+func StartExportJob(jobID int) error {
+	_, err := DB.Exec(`
+		UPDATE export_jobs SET status = $1, updated_at = $2 WHERE id = $3
+	`, ExportJobRunning, time.Now().UTC(), jobID)
+	return err
+}
+
+// AppendExportChunk stores the next chunk of a job's output and bumps its
+// progress, which also serves as the job's heartbeat.
+func AppendExportChunk(jobID, seq int, data []byte, rowCount int64) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT INTO export_job_chunks (job_id, seq, data) VALUES ($1, $2, $3)
+	`, jobID, seq, data); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		UPDATE export_jobs
+		SET row_count = $1, size_bytes = size_bytes + $2, updated_at = $3
+		WHERE id = $4
+	`, rowCount, len(data), time.Now().UTC(), jobID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// FinishExportJob records the outcome of a job. A non-nil jobErr marks it
+// failed and discards any output written so far.
+func FinishExportJob(jobID int, rowCount int64, ttl time.Duration, jobErr error) error {
+	now := time.Now().UTC()
+	status := ExportJobCompleted
+	var errorMsg *string
+	if jobErr != nil {
+		status = ExportJobFailed
+		msg := jobErr.Error()
+		errorMsg = &msg
+		if _, err := DB.Exec(`DELETE FROM export_job_chunks WHERE job_id = $1`, jobID); err != nil {
+			return err
+		}
+	}
+	_, err := DB.Exec(`
+		UPDATE export_jobs
+		SET status = $1, row_count = $2, error_message = $3, updated_at = $4, completed_at = $4, expires_at = $5
+		WHERE id = $6
+	`, status, rowCount, errorMsg, now, now.Add(ttl), jobID)
+	return err
+}
+
+// StreamExportChunks calls fn with each chunk of a job's output in order,
+// loading one chunk at a time.
+func StreamExportChunks(ctx context.Context, jobID int, fn func([]byte) error) error {
+	for seq := 0; ; seq++ {
+		var data []byte
+		err := DB.QueryRowContext(ctx, `
+			SELECT data FROM export_job_chunks WHERE job_id = $1 AND seq = $2
+		`, jobID, seq).Scan(&data)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+}
+
+// ExpireExportJobs deletes jobs past their expiry and fails jobs whose
+// heartbeat is older than staleAfter, which happens when the replica running
+// them stopped. It returns the number of jobs failed and deleted.
+func ExpireExportJobs(staleAfter time.Duration) (failed, deleted int64, err error) {
+	now := time.Now().UTC()
+	res, err := DB.Exec(`
+		UPDATE export_jobs
+		SET status = $1, error_message = 'export was interrupted', updated_at = $2, completed_at = $2, expires_at = $2
+		WHERE status IN ($3, $4) AND updated_at < $5
+	`, ExportJobFailed, now, ExportJobPending, ExportJobRunning, now.Add(-staleAfter))
+	if err != nil {
+		return 0, 0, err
+	}
+	failed, _ = res.RowsAffected()
+
+	res, err = DB.Exec(`DELETE FROM export_jobs WHERE expires_at < $1`, now)
+	if err != nil {
+		return failed, 0, err
+	}
+	deleted, _ = res.RowsAffected()
+	return failed, deleted, nil
+}
@@ -0,0 +1,221 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// EmailFilter narrows an email listing. Zero values are ignored.
+type EmailFilter struct {
+	Statuses        []string
+	Recipient       string
+	SubjectContains string
+	CreatedAfter    *time.Time
+	CreatedBefore   *time.Time
+	SMTPConfigID    *int
+	Tag             string
+}
+
+// EmailQuery selects a page of emails ordered by Sort, a column name
+// optionally prefixed with "-" for descending order.
+type EmailQuery struct {
+	Filter      EmailFilter
+	Sort        string
+	Limit       int
+	Cursor      string
+	IncludeBody bool
+}
+
+type EmailPage struct {
+	Emails     []Email
+	NextCursor string
+	HasMore    bool
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// emailSortColumns lists the columns emails can be ordered by. Each is paired
+// with id so keyset pagination stays stable when rows share a value.
+var emailSortColumns = map[string]bool{
+	"created_at": true,
+	"to_email":   true,
+	"subject":    true,
+	"status":     true,
+}
+
+// ValidEmailSort reports whether sort names a sortable column.
+func ValidEmailSort(sort string) bool {
+	return emailSortColumns[strings.TrimPrefix(sort, "-")]
+}
+
+type emailCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+func encodeEmailCursor(c emailCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeEmailCursor(s string) (*emailCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c emailCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// emailWhere builds the WHERE clause shared by listings and exports. args is
+// extended in place so callers can keep adding placeholders.
+func emailWhere(customerID int, f EmailFilter, args *[]interface{}) string {
+	arg := func(v interface{}) string {
+		*args = append(*args, v)
+		return fmt.Sprintf("$%d", len(*args))
+	}
+
+	conds := []string{"customer_id = " + arg(customerID)}
+	if len(f.Statuses) > 0 {
+		conds = append(conds, "status = ANY("+arg(pq.Array(f.Statuses))+")")
+	}
+	if f.Recipient != "" {
+		conds = append(conds, "LOWER(to_email) = LOWER("+arg(f.Recipient)+")")
+	}
+	if f.SubjectContains != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(f.SubjectContains)
+		conds = append(conds, "subject ILIKE "+arg("%"+escaped+"%"))
+	}
+	if f.CreatedAfter != nil {
+		conds = append(conds, "created_at >= "+arg(*f.CreatedAfter))
+	}
+	if f.CreatedBefore != nil {
+		conds = append(conds, "created_at < "+arg(*f.CreatedBefore))
+	}
+	if f.SMTPConfigID != nil {
+		conds = append(conds, "smtp_config_id = "+arg(*f.SMTPConfigID))
+	}
+	if f.Tag != "" {
+		conds = append(conds, "tags @> "+arg(pq.Array([]string{f.Tag})))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// ListEmails returns one page of a customer's emails using keyset pagination,
+// so pages do not shift when new emails are inserted.
+func ListEmails(customerID int, q EmailQuery) (*EmailPage, error) {
+	sort := q.Sort
+	if sort == "" {
+		sort = "-created_at"
+	}
+	if !ValidEmailSort(sort) {
+		return nil, fmt.Errorf("unsupported sort %q", sort)
+	}
+	column := strings.TrimPrefix(sort, "-")
+	direction, cmp := "ASC", ">"
+	if strings.HasPrefix(sort, "-") {
+		direction, cmp = "DESC", "<"
+	}
+
+	var args []interface{}
+	where := emailWhere(customerID, q.Filter, &args)
+
+	if q.Cursor != "" {
+		cursor, err := decodeEmailCursor(q.Cursor)
+		if err != nil || cursor.Sort != sort {
+			return nil, ErrInvalidCursor
+		}
+		var value interface{} = cursor.Value
+		if column == "created_at" {
+			t, err := time.Parse(time.RFC3339Nano, cursor.Value)
+			if err != nil {
+				return nil, ErrInvalidCursor
+			}
+			value = t
+		}
+		args = append(args, value, cursor.ID)
+		where += fmt.Sprintf(" AND (%s, id) %s ($%d, $%d)", column, cmp, len(args)-1, len(args))
+	}
+
+	body := "'', ''"
+	if q.IncludeBody {
+		body = "body, COALESCE(html_body, '')"
+	}
+	args = append(args, q.Limit+1)
+	rows, err := DB.Query(fmt.Sprintf(`
+		SELECT id, customer_id, smtp_config_id, to_email, subject, %s, status, COALESCE(tags, '{}'),
+			created_at, sent_at, error_message
+		FROM emails
+		WHERE %s
+		ORDER BY %s %s, id %s
+		LIMIT $%d
+	`, body, where, column, direction, direction, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &EmailPage{Emails: []Email{}}
+	for rows.Next() {
+		var email Email
+		err := rows.Scan(
+			&email.ID, &email.CustomerID, &email.SMTPConfigID, &email.ToEmail,
+			&email.Subject, &email.Body, &email.HTMLBody, &email.Status, pq.Array(&email.Tags),
+			&email.CreatedAt, &email.SentAt, &email.ErrorMessage,
+		)
+		if err != nil {
+			return nil, err
+		}
+		page.Emails = append(page.Emails, email)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Emails) > q.Limit {
+		page.Emails = page.Emails[:q.Limit]
+		page.HasMore = true
+		last := page.Emails[len(page.Emails)-1]
+		page.NextCursor = encodeEmailCursor(emailCursor{Sort: sort, Value: emailSortValue(last, column), ID: last.ID})
+	}
+	return page, nil
+}
+
+func emailSortValue(e Email, column string) string {
+	switch column {
+	case "to_email":
+		return e.ToEmail
+	case "subject":
+		return e.Subject
+	case "status":
+		return e.Status
+	default:
+		return e.CreatedAt.Format(time.RFC3339Nano)
+	}
+}
+
+// GetEmail returns a single email with its body.
+func GetEmail(customerID, emailID int) (*Email, error) {
+	var email Email
+	err := DB.QueryRow(`
+		SELECT id, customer_id, smtp_config_id, to_email, subject, body, COALESCE(html_body, ''), status, COALESCE(tags, '{}'),
+			created_at, sent_at, error_message
+		FROM emails
+		WHERE id = $1 AND customer_id = $2
+	`, emailID, customerID).Scan(
+		&email.ID, &email.CustomerID, &email.SMTPConfigID, &email.ToEmail,
+		&email.Subject, &email.Body, &email.HTMLBody, &email.Status, pq.Array(&email.Tags),
+		&email.CreatedAt, &email.SentAt, &email.ErrorMessage,
+	)
+	return &email, err
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Email event types. Opens and clicks come from our own tracking or from a
+// provider's webhooks; the others only from provider webhooks.
+const (
+	EmailEventOpen            = "open"
+	EmailEventClick           = "click"
+	EmailEventDelivered       = "delivered"
+	EmailEventDeliveryDelayed = "delivery_delayed"
+	EmailEventBounce          = "bounce"
+	EmailEventComplaint       = "complaint"
+)
+
+// EmailEvent is a recorded event in an email's history. URL is set for
+// clicks; Details carries provider-specific information such as a bounce
+// message.
+type EmailEvent struct {
+	ID        int64     `json:"id"`
+	EmailID   int       `json:"email_id"`
+	Type      string    `json:"type"`
+	URL       string    `json:"url,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Details   string    `json:"details,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RecordEmailEvent stores an open or click. The first event of each type
+// for an email is also counted in the stats rollup, so stats report unique
+// opens and clicks. It returns sql.ErrNoRows when the email does not exist.
+func RecordEmailEvent(emailID int, eventType, url, userAgent string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the email so concurrent first events are counted once.
+	var subject statsSubject
+	err = tx.QueryRow(`
+		SELECT customer_id, smtp_config_id, to_email, COALESCE(tags, '{}')
+		FROM emails
+		WHERE id = $1
+		FOR UPDATE
+	`, emailID).Scan(&subject.CustomerID, &subject.SMTPConfigID, &subject.ToEmail, pq.Array(&subject.Tags))
+	if err != nil {
+		return err
+	}
+
+	event := EmailEvent{EmailID: emailID, Type: eventType, URL: url, UserAgent: userAgent, CreatedAt: time.Now().UTC()}
+	if _, err := insertEmailEvent(tx, subject, event, ""); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecordProviderEvent stores an event reported by a provider webhook for
+// the email with the given provider message ID and, when status is set,
+// applies it as with RecordDeliveryReport. providerEventID deduplicates
+// webhook retries: recorded is false when the event was already stored.
+// It returns sql.ErrNoRows when no email has that provider message ID.
+func RecordProviderEvent(providerMessageID, providerEventID string, event EmailEvent, status string) (email *Email, recorded bool, err error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	email = &Email{}
+	var subject statsSubject
+	err = tx.QueryRow(`
+		SELECT id, customer_id, smtp_config_id, to_email, COALESCE(tags, '{}'), status
+		FROM emails
+		WHERE provider_message_id = $1
+		FOR UPDATE
+	`, providerMessageID).Scan(
+		&email.ID, &subject.CustomerID, &subject.SMTPConfigID, &subject.ToEmail, pq.Array(&subject.Tags), &email.Status,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	email.CustomerID, email.SMTPConfigID, email.ToEmail, email.Tags = subject.CustomerID, subject.SMTPConfigID, subject.ToEmail, subject.Tags
+
+	event.EmailID = email.ID
+	if recorded, err = insertEmailEvent(tx, subject, event, providerEventID); err != nil || !recorded {
+		return email, false, err
+	}
+	if status != "" {
+		changed, err := applyDeliveryReport(tx, email.ID, status, event.Details)
+		if err != nil {
+			return nil, false, err
+		}
+		if changed {
+			email.Status = status
+		}
+	}
+	return email, true, tx.Commit()
+}
+
+// insertEmailEvent stores an event for an email locked by tx and queues it
+// for the customer's webhooks. The first open and first click are counted
+// in the stats rollup.
+func insertEmailEvent(tx *sql.Tx, subject statsSubject, e EmailEvent, providerEventID string) (bool, error) {
+	var first bool
+	err := tx.QueryRow(`
+		SELECT NOT EXISTS (SELECT 1 FROM email_events WHERE email_id = $1 AND type = $2)
+	`, e.EmailID, e.Type).Scan(&first)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO email_events (email_id, customer_id, type, url, user_agent, details, provider_event_id, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), $8)
+		ON CONFLICT (provider_event_id) WHERE provider_event_id IS NOT NULL DO NOTHING
+	`, e.EmailID, subject.CustomerID, e.Type, e.URL, e.UserAgent, e.Details, providerEventID, e.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	if err := enqueueWebhooks(tx, subject, e); err != nil {
+		return false, err
+	}
+
+	if first && (e.Type == EmailEventOpen || e.Type == EmailEventClick) {
+		event := StatsEventOpened
+		if e.Type == EmailEventClick {
+			event = StatsEventClicked
+		}
+		if err := recordStatsEvent(tx, subject, event, e.CreatedAt, 0); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// ListEmailEvents returns an email's events, oldest first.
+func ListEmailEvents(emailID int) ([]EmailEvent, error) {
+	rows, err := DB.Query(`
+		SELECT id, email_id, type, COALESCE(url, ''), COALESCE(user_agent, ''), COALESCE(details, ''), created_at
+		FROM email_events
+		WHERE email_id = $1
+		ORDER BY created_at, id
+	`, emailID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []EmailEvent{}
+	for rows.Next() {
+		var e EmailEvent
+		if err := rows.Scan(&e.ID, &e.EmailID, &e.Type, &e.URL, &e.UserAgent, &e.Details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
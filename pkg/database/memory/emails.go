@@ -0,0 +1,264 @@
+package memory
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Gatete-Bruno/besend/pkg/database"
+)
+
+type emails struct{ *store }
+
+func (r emails) Create(customerID int, smtpConfigID *int, toEmail, subject, body, htmlBody string, tags []string) (*database.Email, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e := &database.Email{
+		ID:           r.nextID("emails"),
+		CustomerID:   customerID,
+		SMTPConfigID: copyInt(smtpConfigID),
+		ToEmail:      toEmail,
+		Subject:      subject,
+		Body:         body,
+		HTMLBody:     htmlBody,
+		Status:       "pending",
+		Tags:         append([]string{}, tags...),
+		CreatedAt:    r.now(),
+	}
+	r.emails[e.ID] = e
+	return copyEmail(e), nil
+}
+
+// UpdateStatus leaves out the stats rollup, which this package does not
+// keep.
+func (r emails) UpdateStatus(emailID int, status string, errorMsg *string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.emails[emailID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	sentAt := r.now()
+	e.Status = status
+	e.SentAt = &sentAt
+	e.ErrorMessage = nil
+	if errorMsg != nil {
+		msg := *errorMsg
+		e.ErrorMessage = &msg
+	}
+	return nil
+}
+
+func (r emails) SetMessageID(emailID int, messageID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, existing := range r.messageIDs {
+		if existing == messageID && id != emailID {
+			return database.ErrConflict
+		}
+	}
+	if _, ok := r.emails[emailID]; ok {
+		r.messageIDs[emailID] = messageID
+	}
+	return nil
+}
+
+func (r emails) Get(customerID, emailID int) (*database.Email, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.emails[emailID]
+	if !ok || e.CustomerID != customerID {
+		return nil, sql.ErrNoRows
+	}
+	return copyEmail(e), nil
+}
+
+// List pages through emails as the Postgres repository does, ordered by
+// the sort column and then ID. Its cursor names the last email returned.
+func (r emails) List(customerID int, q database.EmailQuery) (*database.EmailPage, error) {
+	sortBy := q.Sort
+	if sortBy == "" {
+		sortBy = "-created_at"
+	}
+	if !database.ValidEmailSort(sortBy) {
+		return nil, fmt.Errorf("unsupported sort %q", sortBy)
+	}
+	column := strings.TrimPrefix(sortBy, "-")
+	descending := strings.HasPrefix(sortBy, "-")
+	before := func(a, b *database.Email) bool {
+		c := compareEmails(a, b, column)
+		if c == 0 {
+			c = a.ID - b.ID
+		}
+		if descending {
+			return c > 0
+		}
+		return c < 0
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var after *database.Email
+	if q.Cursor != "" {
+		cursorSort, id, err := decodeCursor(q.Cursor)
+		if err != nil || cursorSort != sortBy {
+			return nil, database.ErrInvalidCursor
+		}
+		e, ok := r.emails[id]
+		if !ok {
+			return nil, database.ErrInvalidCursor
+		}
+		after = e
+	}
+
+	var matched []*database.Email
+	for _, e := range r.emails {
+		if e.CustomerID != customerID || !matches(e, q.Filter) {
+			continue
+		}
+		if after != nil && !before(after, e) {
+			continue
+		}
+		matched = append(matched, e)
+	}
+	sort.Slice(matched, func(i, j int) bool { return before(matched[i], matched[j]) })
+
+	page := &database.EmailPage{Emails: []database.Email{}}
+	for i, e := range matched {
+		if i == q.Limit {
+			page.HasMore = true
+			page.NextCursor = encodeCursor(sortBy, matched[i-1].ID)
+			break
+		}
+		copied := copyEmail(e)
+		if !q.IncludeBody {
+			copied.Body, copied.HTMLBody = "", ""
+		}
+		page.Emails = append(page.Emails, *copied)
+	}
+	return page, nil
+}
+
+// Events returns no events: opens, clicks and provider reports are
+// recorded outside the repositories.
+func (r emails) Events(emailID int) ([]database.EmailEvent, error) {
+	return []database.EmailEvent{}, nil
+}
+
+func (r emails) Stats(customerID int) (map[string]interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var sent, pending, failed int64
+	for _, e := range r.emails {
+		if e.CustomerID != customerID {
+			continue
+		}
+		switch e.Status {
+		case "sent":
+			sent++
+		case "pending":
+			pending++
+		case "failed":
+			failed++
+		}
+	}
+	return map[string]interface{}{
+		"sent":    sent,
+		"pending": pending,
+		"failed":  failed,
+	}, nil
+}
+
+// matches applies an email filter as the Postgres query does.
+func matches(e *database.Email, f database.EmailFilter) bool {
+	if len(f.Statuses) > 0 && !contains(f.Statuses, e.Status) {
+		return false
+	}
+	if f.Recipient != "" && !strings.EqualFold(e.ToEmail, f.Recipient) {
+		return false
+	}
+	if f.SubjectContains != "" && !strings.Contains(strings.ToLower(e.Subject), strings.ToLower(f.SubjectContains)) {
+		return false
+	}
+	if f.CreatedAfter != nil && e.CreatedAt.Before(*f.CreatedAfter) {
+		return false
+	}
+	if f.CreatedBefore != nil && !e.CreatedAt.Before(*f.CreatedBefore) {
+		return false
+	}
+	if f.SMTPConfigID != nil && (e.SMTPConfigID == nil || *e.SMTPConfigID != *f.SMTPConfigID) {
+		return false
+	}
+	if f.Tag != "" && !contains(e.Tags, f.Tag) {
+		return false
+	}
+	return true
+}
+
+func compareEmails(a, b *database.Email, column string) int {
+	switch column {
+	case "to_email":
+		return strings.Compare(a.ToEmail, b.ToEmail)
+	case "subject":
+		return strings.Compare(a.Subject, b.Subject)
+	case "status":
+		return strings.Compare(a.Status, b.Status)
+	default:
+		return a.CreatedAt.Compare(b.CreatedAt)
+	}
+}
+
+func encodeCursor(sortBy string, id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(sortBy + ":" + strconv.Itoa(id)))
+}
+
+func decodeCursor(cursor string) (string, int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", 0, err
+	}
+	sortBy, id, _ := strings.Cut(string(b), ":")
+	n, err := strconv.Atoi(id)
+	return sortBy, n, err
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func copyInt(p *int) *int {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+func copyEmail(e *database.Email) *database.Email {
+	copied := *e
+	copied.SMTPConfigID = copyInt(e.SMTPConfigID)
+	copied.Tags = append([]string{}, e.Tags...)
+	if e.SentAt != nil {
+		sentAt := *e.SentAt
+		copied.SentAt = &sentAt
+	}
+	if e.ErrorMessage != nil {
+		msg := *e.ErrorMessage
+		copied.ErrorMessage = &msg
+	}
+	return &copied
+}
@@ -0,0 +1,354 @@
+// Package memory keeps the API's repositories in memory, so handlers can be
+// exercised without a database. Everything is lost when the process exits.
+package memory
+
+import (
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Gatete-Bruno/besend/pkg/database"
+)
+
+// defaultMonthlyQuota matches the customers table's default.
+const defaultMonthlyQuota = 1000
+
+// store holds every repository's rows behind one lock, as the tables of a
+// single database.
+type store struct {
+	mu   sync.Mutex
+	ids  map[string]int
+	last time.Time
+
+	customers   map[int]*database.Customer
+	apiKeys     map[int]*database.APIKey
+	smtpConfigs map[int]*database.SMTPConfig
+	emails      map[int]*database.Email
+	messageIDs  map[int]string
+	audit       []database.AuditEvent
+}
+
+// New returns empty repositories sharing one store.
+func New() database.Repositories {
+	s := &store{
+		ids:         map[string]int{},
+		customers:   map[int]*database.Customer{},
+		apiKeys:     map[int]*database.APIKey{},
+		smtpConfigs: map[int]*database.SMTPConfig{},
+		emails:      map[int]*database.Email{},
+		messageIDs:  map[int]string{},
+	}
+	return database.Repositories{
+		Customers:   customers{s},
+		APIKeys:     apiKeys{s},
+		SMTPConfigs: smtpConfigs{s},
+		Emails:      emails{s},
+		Audit:       auditLog{s},
+	}
+}
+
+// nextID returns the next ID for a table, like its sequence.
+func (s *store) nextID(table string) int {
+	s.ids[table]++
+	return s.ids[table]
+}
+
+// now returns the current time at the precision Postgres keeps, always
+// later than the last, so every update gets a new version.
+func (s *store) now() time.Time {
+	t := time.Now().UTC().Truncate(time.Microsecond)
+	if !t.After(s.last) {
+		t = s.last.Add(time.Microsecond)
+	}
+	s.last = t
+	return t
+}
+
+type customers struct{ *store }
+
+func (r customers) Create(email, passwordHash, plan string) (*database.Customer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.customers {
+		if c.Email == email {
+			return nil, database.ErrConflict
+		}
+	}
+	c := &database.Customer{
+		ID:           r.nextID("customers"),
+		Email:        email,
+		PasswordHash: passwordHash,
+		CreatedAt:    r.now(),
+		Plan:         plan,
+		MonthlyQuota: defaultMonthlyQuota,
+	}
+	r.customers[c.ID] = c
+	copied := *c
+	return &copied, nil
+}
+
+func (r customers) Get(id int) (*database.Customer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.customers[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *c
+	return &copied, nil
+}
+
+func (r customers) GetByEmail(email string) (*database.Customer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.customers {
+		if c.Email == email {
+			copied := *c
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r customers) GetByAPIKey(keyHash string) (*database.Customer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, k := range r.apiKeys {
+		if k.KeyHash == keyHash {
+			if c, ok := r.customers[k.CustomerID]; ok {
+				copied := *c
+				return &copied, nil
+			}
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+type apiKeys struct{ *store }
+
+func (r apiKeys) Create(customerID int, keyHash, name string) (*database.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, k := range r.apiKeys {
+		if k.KeyHash == keyHash {
+			return nil, database.ErrConflict
+		}
+	}
+	now := r.now()
+	k := &database.APIKey{
+		ID:         r.nextID("api_keys"),
+		CustomerID: customerID,
+		KeyHash:    keyHash,
+		Name:       name,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	r.apiKeys[k.ID] = k
+	copied := *k
+	return &copied, nil
+}
+
+// owned returns one of a customer's keys. The caller holds the lock.
+func (r apiKeys) owned(customerID, keyID int) (*database.APIKey, bool) {
+	k, ok := r.apiKeys[keyID]
+	if !ok || k.CustomerID != customerID {
+		return nil, false
+	}
+	return k, true
+}
+
+// Get leaves out the key hash, as the Postgres repository does.
+func (r apiKeys) Get(customerID, keyID int) (*database.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k, ok := r.owned(customerID, keyID)
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *k
+	copied.KeyHash = ""
+	return &copied, nil
+}
+
+func (r apiKeys) List(customerID int) ([]database.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var keys []database.APIKey
+	for _, k := range r.apiKeys {
+		if k.CustomerID == customerID {
+			copied := *k
+			copied.KeyHash = ""
+			keys = append(keys, copied)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID > keys[j].ID })
+	return keys, nil
+}
+
+func (r apiKeys) Update(customerID, keyID int, name, description *string, expectedUpdatedAt *time.Time) (*database.APIKey, *database.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k, ok := r.owned(customerID, keyID)
+	if !ok {
+		return nil, nil, sql.ErrNoRows
+	}
+	before := *k
+	before.KeyHash = ""
+	if expectedUpdatedAt != nil && !database.SameVersion(before.UpdatedAt, *expectedUpdatedAt) {
+		return &before, nil, database.ErrPreconditionFailed
+	}
+
+	if name != nil {
+		k.Name = *name
+	}
+	if description != nil {
+		k.Description = *description
+	}
+	k.UpdatedAt = r.now()
+	after := *k
+	after.KeyHash = ""
+	return &before, &after, nil
+}
+
+func (r apiKeys) Delete(customerID, keyID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.owned(customerID, keyID); ok {
+		delete(r.apiKeys, keyID)
+	}
+	return nil
+}
+
+type smtpConfigs struct{ *store }
+
+// nameTaken reports whether another of the customer's configs has name.
+// The caller holds the lock.
+func (r smtpConfigs) nameTaken(customerID, configID int, name string) bool {
+	for _, c := range r.smtpConfigs {
+		if c.CustomerID == customerID && c.ID != configID && c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (r smtpConfigs) Create(customerID int, name, host string, port int, username, password, fromEmail string, trackOpens, trackClicks bool) (*database.SMTPConfig, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.nameTaken(customerID, 0, name) {
+		return nil, database.ErrConflict
+	}
+	now := r.now()
+	c := &database.SMTPConfig{
+		ID:          r.nextID("smtp_configs"),
+		CustomerID:  customerID,
+		Name:        name,
+		SMTPHost:    host,
+		SMTPPort:    port,
+		Username:    username,
+		Password:    password,
+		FromEmail:   fromEmail,
+		TrackOpens:  trackOpens,
+		TrackClicks: trackClicks,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	r.smtpConfigs[c.ID] = c
+	copied := *c
+	return &copied, nil
+}
+
+func (r smtpConfigs) Get(customerID, configID int) (*database.SMTPConfig, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.smtpConfigs[configID]
+	if !ok || c.CustomerID != customerID {
+		return nil, sql.ErrNoRows
+	}
+	copied := *c
+	return &copied, nil
+}
+
+func (r smtpConfigs) List(customerID int) ([]database.SMTPConfig, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var configs []database.SMTPConfig
+	for _, c := range r.smtpConfigs {
+		if c.CustomerID == customerID {
+			copied := *c
+			copied.Password = ""
+			configs = append(configs, copied)
+		}
+	}
+	sort.Slice(configs, func(i, j int) bool { return configs[i].ID > configs[j].ID })
+	return configs, nil
+}
+
+func (r smtpConfigs) Update(customerID, configID int, upd database.SMTPConfigUpdate, expectedUpdatedAt *time.Time) (*database.SMTPConfig, *database.SMTPConfig, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.smtpConfigs[configID]
+	if !ok || c.CustomerID != customerID {
+		return nil, nil, sql.ErrNoRows
+	}
+	before := *c
+	if expectedUpdatedAt != nil && !database.SameVersion(before.UpdatedAt, *expectedUpdatedAt) {
+		return &before, nil, database.ErrPreconditionFailed
+	}
+	if upd.Name != nil && r.nameTaken(customerID, configID, *upd.Name) {
+		return nil, nil, database.ErrConflict
+	}
+
+	after := before
+	if upd.Name != nil {
+		after.Name = *upd.Name
+	}
+	if upd.SMTPHost != nil {
+		after.SMTPHost = *upd.SMTPHost
+	}
+	if upd.SMTPPort != nil {
+		after.SMTPPort = *upd.SMTPPort
+	}
+	if upd.Username != nil {
+		after.Username = *upd.Username
+	}
+	if upd.Password != nil {
+		after.Password = *upd.Password
+	}
+	if upd.FromEmail != nil {
+		after.FromEmail = *upd.FromEmail
+	}
+	if upd.TrackOpens != nil {
+		after.TrackOpens = *upd.TrackOpens
+	}
+	if upd.TrackClicks != nil {
+		after.TrackClicks = *upd.TrackClicks
+	}
+	after.UpdatedAt = r.now()
+	*c = after
+	return &before, &after, nil
+}
+
+func (r smtpConfigs) Delete(customerID, configID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c, ok := r.smtpConfigs[configID]; ok && c.CustomerID == customerID {
+		delete(r.smtpConfigs, configID)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+
+	"github.com/Gatete-Bruno/besend/pkg/migrate"
+)
+
+// migrationFiles holds the schema of every service sharing the database,
+// so whichever starts first brings it up to date.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// NewMigrator returns a migrator for the embedded migrations on DB.
+func NewMigrator() (*migrate.Migrator, error) {
+	migrations, err := migrate.Load(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return &migrate.Migrator{DB: DB, Migrations: migrations}, nil
+}
+
+// Migrate applies any pending migrations.
+func Migrate(ctx context.Context) error {
+	m, err := NewMigrator()
+	if err != nil {
+		return err
+	}
+	if _, err := m.Up(ctx); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+	return nil
+}
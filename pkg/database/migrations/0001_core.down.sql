@@ -0,0 +1,14 @@
+-- Dropping the tables also drops audit_events' append-only trigger.
+DROP TABLE IF EXISTS sender_route_backends;
+DROP TABLE IF EXISTS sender_routes;
+DROP TABLE IF EXISTS email_events;
+DROP TABLE IF EXISTS suppressions;
+DROP TABLE IF EXISTS export_job_chunks;
+DROP TABLE IF EXISTS export_jobs;
+DROP TABLE IF EXISTS email_stats_hourly;
+DROP TABLE IF EXISTS audit_events;
+DROP TABLE IF EXISTS emails;
+DROP TABLE IF EXISTS smtp_configs;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS customers;
+DROP FUNCTION IF EXISTS audit_events_append_only();
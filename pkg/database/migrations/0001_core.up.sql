@@ -0,0 +1,204 @@
+-- Baseline: the schema the API created at startup before migrations.
+-- It stays idempotent so databases set up that way adopt it unchanged;
+-- later changes belong in new migrations.
+
+CREATE TABLE IF NOT EXISTS customers (
+	id SERIAL PRIMARY KEY,
+	email VARCHAR(255) UNIQUE NOT NULL,
+	password_hash VARCHAR(255) NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	plan VARCHAR(50) DEFAULT 'starter',
+	monthly_quota INTEGER DEFAULT 1000,
+	emails_sent_this_month INTEGER DEFAULT 0,
+	stripe_customer_id VARCHAR(255),
+	active BOOLEAN DEFAULT true
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+	id SERIAL PRIMARY KEY,
+	customer_id INTEGER REFERENCES customers(id) ON DELETE CASCADE,
+	key_hash VARCHAR(64) UNIQUE NOT NULL,
+	name VARCHAR(255),
+	description TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	last_used_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS smtp_configs (
+	id SERIAL PRIMARY KEY,
+	customer_id INTEGER REFERENCES customers(id) ON DELETE CASCADE,
+	name VARCHAR(255) NOT NULL,
+	smtp_host VARCHAR(255) NOT NULL,
+	smtp_port INTEGER NOT NULL,
+	username VARCHAR(255) NOT NULL,
+	password TEXT NOT NULL,
+	from_email VARCHAR(255) NOT NULL,
+	track_opens BOOLEAN NOT NULL DEFAULT FALSE,
+	track_clicks BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE(customer_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS emails (
+	id SERIAL PRIMARY KEY,
+	customer_id INTEGER REFERENCES customers(id) ON DELETE CASCADE,
+	smtp_config_id INTEGER REFERENCES smtp_configs(id) ON DELETE SET NULL,
+	to_email VARCHAR(255) NOT NULL,
+	subject VARCHAR(500) NOT NULL,
+	body TEXT NOT NULL,
+	html_body TEXT,
+	status VARCHAR(50) DEFAULT 'pending',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	sent_at TIMESTAMP,
+	error_message TEXT,
+	tags TEXT[] DEFAULT '{}',
+	message_id VARCHAR(255),
+	provider_message_id VARCHAR(255)
+);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+	id BIGSERIAL PRIMARY KEY,
+	customer_id INTEGER REFERENCES customers(id) ON DELETE CASCADE,
+	actor VARCHAR(255) NOT NULL,
+	action VARCHAR(100) NOT NULL,
+	resource_type VARCHAR(50) NOT NULL,
+	resource_id VARCHAR(64),
+	changes JSONB,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS email_stats_hourly (
+	customer_id INTEGER NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+	bucket TIMESTAMP NOT NULL,
+	dimension VARCHAR(20) NOT NULL,
+	dimension_value VARCHAR(255) NOT NULL DEFAULT '',
+	sent BIGINT NOT NULL DEFAULT 0,
+	failed BIGINT NOT NULL DEFAULT 0,
+	bounced BIGINT NOT NULL DEFAULT 0,
+	opened BIGINT NOT NULL DEFAULT 0,
+	clicked BIGINT NOT NULL DEFAULT 0,
+	complained BIGINT NOT NULL DEFAULT 0,
+	send_latency_hist BIGINT[] NOT NULL DEFAULT '{}',
+	PRIMARY KEY (customer_id, dimension, dimension_value, bucket)
+);
+
+CREATE TABLE IF NOT EXISTS export_jobs (
+	id SERIAL PRIMARY KEY,
+	customer_id INTEGER NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+	status VARCHAR(20) NOT NULL DEFAULT 'pending',
+	format VARCHAR(20) NOT NULL,
+	params JSONB NOT NULL DEFAULT '{}',
+	row_count BIGINT NOT NULL DEFAULT 0,
+	size_bytes BIGINT NOT NULL DEFAULT 0,
+	error_message TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	completed_at TIMESTAMP,
+	expires_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS export_job_chunks (
+	job_id INTEGER NOT NULL REFERENCES export_jobs(id) ON DELETE CASCADE,
+	seq INTEGER NOT NULL,
+	data BYTEA NOT NULL,
+	PRIMARY KEY (job_id, seq)
+);
+
+CREATE TABLE IF NOT EXISTS suppressions (
+	id SERIAL PRIMARY KEY,
+	customer_id INTEGER NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+	email VARCHAR(255) NOT NULL,
+	category VARCHAR(64) NOT NULL DEFAULT '',
+	reason VARCHAR(20) NOT NULL,
+	details TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS email_events (
+	id BIGSERIAL PRIMARY KEY,
+	email_id INTEGER NOT NULL REFERENCES emails(id) ON DELETE CASCADE,
+	customer_id INTEGER NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+	type VARCHAR(20) NOT NULL,
+	url TEXT,
+	user_agent TEXT,
+	details TEXT,
+	provider_event_id VARCHAR(255),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS sender_routes (
+	id SERIAL PRIMARY KEY,
+	customer_id INTEGER NOT NULL REFERENCES customers(id) ON DELETE CASCADE,
+	name VARCHAR(255) NOT NULL,
+	strategy VARCHAR(20) NOT NULL DEFAULT 'priority',
+	from_email VARCHAR(255) NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE(customer_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS sender_route_backends (
+	route_id INTEGER NOT NULL REFERENCES sender_routes(id) ON DELETE CASCADE,
+	smtp_config_id INTEGER NOT NULL REFERENCES smtp_configs(id) ON DELETE CASCADE,
+	priority INTEGER NOT NULL DEFAULT 0,
+	weight INTEGER NOT NULL DEFAULT 1,
+	PRIMARY KEY (route_id, smtp_config_id)
+);
+
+ALTER TABLE smtp_configs ALTER COLUMN password TYPE TEXT;
+ALTER TABLE smtp_configs ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS description TEXT;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS tags TEXT[] DEFAULT '{}';
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS html_body TEXT;
+ALTER TABLE smtp_configs ADD COLUMN IF NOT EXISTS track_opens BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE smtp_configs ADD COLUMN IF NOT EXISTS track_clicks BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE email_stats_hourly ADD COLUMN IF NOT EXISTS clicked BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS message_id VARCHAR(255);
+ALTER TABLE email_stats_hourly ADD COLUMN IF NOT EXISTS complained BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE emails ADD COLUMN IF NOT EXISTS provider_message_id VARCHAR(255);
+ALTER TABLE email_events ADD COLUMN IF NOT EXISTS details TEXT;
+ALTER TABLE email_events ADD COLUMN IF NOT EXISTS provider_event_id VARCHAR(255);
+ALTER TABLE suppressions ADD COLUMN IF NOT EXISTS category VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE suppressions DROP CONSTRAINT IF EXISTS suppressions_customer_id_email_key;
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS ip_address VARCHAR(45);
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS user_agent TEXT;
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS prev_hash VARCHAR(64);
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS hash VARCHAR(64);
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_emails_customer_id ON emails(customer_id);
+CREATE INDEX IF NOT EXISTS idx_emails_status ON emails(status);
+CREATE INDEX IF NOT EXISTS idx_emails_customer_created ON emails(customer_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_emails_customer_status ON emails(customer_id, status, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_emails_customer_recipient ON emails(customer_id, LOWER(to_email));
+CREATE INDEX IF NOT EXISTS idx_emails_customer_smtp_config ON emails(customer_id, smtp_config_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_emails_tags ON emails USING GIN (tags);
+CREATE INDEX IF NOT EXISTS idx_emails_subject_trgm ON emails USING GIN (subject gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_smtp_configs_customer_id ON smtp_configs(customer_id);
+CREATE INDEX IF NOT EXISTS idx_api_keys_customer_id ON api_keys(customer_id);
+CREATE INDEX IF NOT EXISTS idx_api_keys_hash ON api_keys(key_hash);
+CREATE INDEX IF NOT EXISTS idx_audit_events_customer ON audit_events(customer_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_events_customer_id ON audit_events(customer_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_email_stats_hourly_range ON email_stats_hourly(customer_id, dimension, bucket);
+CREATE INDEX IF NOT EXISTS idx_export_jobs_customer ON export_jobs(customer_id, created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_suppressions_address ON suppressions(customer_id, email, category);
+CREATE INDEX IF NOT EXISTS idx_email_events_email ON email_events(email_id, type);
+CREATE INDEX IF NOT EXISTS idx_email_events_customer ON email_events(customer_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_suppressions_customer_reason ON suppressions(customer_id, reason, id DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_emails_message_id ON emails(message_id) WHERE message_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_emails_provider_message_id ON emails(provider_message_id) WHERE provider_message_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_email_events_provider_event ON email_events(provider_event_id) WHERE provider_event_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_sender_route_backends_config ON sender_route_backends(smtp_config_id);
+
+-- The audit log is append-only.
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE TRIGGER audit_events_append_only
+	BEFORE UPDATE OR DELETE ON audit_events
+	FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
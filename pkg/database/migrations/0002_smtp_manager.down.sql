@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS submission_queue;
+DROP TABLE IF EXISTS email_audit_logs;
+DROP TABLE IF EXISTS dkim_keys;
+DROP TABLE IF EXISTS smtp_credentials;
+DROP TABLE IF EXISTS sending_domains;
+DROP TABLE IF EXISTS organizations;
@@ -0,0 +1,116 @@
+-- Baseline: the schema smtp-manager created at startup before
+-- migrations, including email_audit_logs. It stays idempotent so
+-- databases set up that way adopt it unchanged.
+
+CREATE TABLE IF NOT EXISTS organizations (
+	id VARCHAR(36) PRIMARY KEY,
+	customer_id VARCHAR(36) NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS sending_domains (
+	id VARCHAR(36) PRIMARY KEY,
+	organization_id VARCHAR(36) NOT NULL REFERENCES organizations(id),
+	domain VARCHAR(255) NOT NULL UNIQUE,
+	verified BOOLEAN DEFAULT FALSE,
+	spf_record TEXT,
+	dkim_record TEXT,
+	dmarc_record TEXT,
+	verification_token VARCHAR(64),
+	verification_results JSONB,
+	verified_at TIMESTAMP,
+	last_checked_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT NOW()
+);
+
+ALTER TABLE sending_domains ADD COLUMN IF NOT EXISTS verification_token VARCHAR(64);
+ALTER TABLE sending_domains ADD COLUMN IF NOT EXISTS verification_results JSONB;
+ALTER TABLE sending_domains ADD COLUMN IF NOT EXISTS verified_at TIMESTAMP;
+ALTER TABLE sending_domains ADD COLUMN IF NOT EXISTS last_checked_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS smtp_credentials (
+	id VARCHAR(36) PRIMARY KEY,
+	organization_id VARCHAR(36) NOT NULL REFERENCES organizations(id),
+	username VARCHAR(255) NOT NULL UNIQUE,
+	password VARCHAR(255) NOT NULL,
+	description TEXT,
+	previous_password VARCHAR(255),
+	previous_expires_at TIMESTAMP,
+	revoked_at TIMESTAMP,
+	last_used_at TIMESTAMP,
+	last_used_ip VARCHAR(45),
+	allowed_domains TEXT[],
+	allowed_cidrs TEXT[],
+	hourly_limit INTEGER,
+	created_at TIMESTAMP DEFAULT NOW()
+);
+
+ALTER TABLE smtp_credentials ADD COLUMN IF NOT EXISTS description TEXT;
+ALTER TABLE smtp_credentials ADD COLUMN IF NOT EXISTS previous_password VARCHAR(255);
+ALTER TABLE smtp_credentials ADD COLUMN IF NOT EXISTS previous_expires_at TIMESTAMP;
+ALTER TABLE smtp_credentials ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP;
+ALTER TABLE smtp_credentials ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP;
+ALTER TABLE smtp_credentials ADD COLUMN IF NOT EXISTS last_used_ip VARCHAR(45);
+ALTER TABLE smtp_credentials ADD COLUMN IF NOT EXISTS allowed_domains TEXT[];
+ALTER TABLE smtp_credentials ADD COLUMN IF NOT EXISTS allowed_cidrs TEXT[];
+ALTER TABLE smtp_credentials ADD COLUMN IF NOT EXISTS hourly_limit INTEGER;
+
+CREATE TABLE IF NOT EXISTS dkim_keys (
+	id VARCHAR(36) PRIMARY KEY,
+	domain_id VARCHAR(36) NOT NULL REFERENCES sending_domains(id) ON DELETE CASCADE,
+	selector VARCHAR(63) NOT NULL,
+	algorithm VARCHAR(16) NOT NULL,
+	private_key TEXT NOT NULL,
+	dns_record TEXT NOT NULL,
+	status VARCHAR(16) NOT NULL DEFAULT 'pending',
+	created_at TIMESTAMP DEFAULT NOW(),
+	activated_at TIMESTAMP,
+	retired_at TIMESTAMP,
+	UNIQUE (domain_id, selector)
+);
+
+CREATE TABLE IF NOT EXISTS email_audit_logs (
+	id VARCHAR(36) PRIMARY KEY,
+	customer_id VARCHAR(36) NOT NULL,
+	organization_id VARCHAR(36),
+	credential_id VARCHAR(36),
+	queue_id VARCHAR(36),
+	postal_message_id VARCHAR(255) NOT NULL,
+	sender_email VARCHAR(255),
+	recipient_email VARCHAR(255),
+	subject TEXT,
+	status VARCHAR(20) NOT NULL,
+	error TEXT,
+	created_at TIMESTAMP DEFAULT NOW(),
+	delivered_at TIMESTAMP
+);
+
+ALTER TABLE email_audit_logs ADD COLUMN IF NOT EXISTS organization_id VARCHAR(36);
+ALTER TABLE email_audit_logs ADD COLUMN IF NOT EXISTS credential_id VARCHAR(36);
+ALTER TABLE email_audit_logs ADD COLUMN IF NOT EXISTS queue_id VARCHAR(36);
+ALTER TABLE email_audit_logs ADD COLUMN IF NOT EXISTS error TEXT;
+
+CREATE TABLE IF NOT EXISTS submission_queue (
+	id VARCHAR(36) PRIMARY KEY,
+	organization_id VARCHAR(36) NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+	credential_id VARCHAR(36),
+	envelope_from VARCHAR(255) NOT NULL,
+	from_domain VARCHAR(255) NOT NULL,
+	recipients TEXT[] NOT NULL,
+	message BYTEA NOT NULL,
+	message_id VARCHAR(255) NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	last_error TEXT,
+	created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_org_customer ON organizations(customer_id);
+CREATE INDEX IF NOT EXISTS idx_domain_org ON sending_domains(organization_id);
+CREATE INDEX IF NOT EXISTS idx_creds_org ON smtp_credentials(organization_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_dkim_active ON dkim_keys(domain_id) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_audit_customer ON email_audit_logs(customer_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_queue ON email_audit_logs(queue_id);
+CREATE INDEX IF NOT EXISTS idx_audit_credential ON email_audit_logs(credential_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_submission_due ON submission_queue(next_attempt_at);
@@ -0,0 +1,3 @@
+ALTER TABLE audit_events DROP CONSTRAINT IF EXISTS audit_events_customer_id_fkey;
+ALTER TABLE audit_events ADD CONSTRAINT audit_events_customer_id_fkey
+	FOREIGN KEY (customer_id) REFERENCES customers(id) ON DELETE CASCADE;
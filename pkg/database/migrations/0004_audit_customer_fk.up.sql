@@ -0,0 +1,8 @@
+-- Deleting a customer used to cascade to their audit events, which the
+-- append-only trigger refuses, so the delete failed anyway. The cascade is
+-- dropped on purpose: audit events outlive what they record, so a customer
+-- with audit history cannot be hard-deleted.
+
+ALTER TABLE audit_events DROP CONSTRAINT IF EXISTS audit_events_customer_id_fkey;
+ALTER TABLE audit_events ADD CONSTRAINT audit_events_customer_id_fkey
+	FOREIGN KEY (customer_id) REFERENCES customers(id);
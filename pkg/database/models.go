@@ -1,7 +1,10 @@
 package database
 
 import (
+	"errors"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type Customer struct {
@@ -20,87 +23,101 @@ type SMTPConfig struct {
 	SMTPHost  string
 	SMTPPort  int
 	Username  string
-	Password  string
+	Password  string `json:"-"`
 	FromEmail string
+	// TrackOpens and TrackClicks are the tracking defaults for sends
+	// through this config.
+	TrackOpens  bool
+	TrackClicks bool
 	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
 type Email struct {
 	ID           int
 	CustomerID   int
-	SMTPConfigID int
+	SMTPConfigID *int
 	ToEmail      string
 	Subject      string
-	Body         string
+	Body         string `json:",omitempty"`
+	HTMLBody     string `json:",omitempty"`
 	Status       string
+	Tags         []string
 	CreatedAt    time.Time
 	SentAt       *time.Time
 	ErrorMessage *string
+	Events       []EmailEvent `json:",omitempty"`
 }
 
 func GetSMTPConfigByID(customerID, configID int) (*SMTPConfig, error) {
 	var config SMTPConfig
 	err := DB.QueryRow(`
-		SELECT id, customer_id, name, smtp_host, smtp_port, username, password, from_email, created_at
+		SELECT id, customer_id, name, smtp_host, smtp_port, username, password, from_email,
+			track_opens, track_clicks, created_at, COALESCE(updated_at, created_at)
 		FROM smtp_configs
 		WHERE id = $1 AND customer_id = $2
 	`, configID, customerID).Scan(
 		&config.ID, &config.CustomerID, &config.Name, &config.SMTPHost,
-		&config.SMTPPort, &config.Username, &config.Password, &config.FromEmail, &config.CreatedAt,
+		&config.SMTPPort, &config.Username, &config.Password, &config.FromEmail,
+		&config.TrackOpens, &config.TrackClicks, &config.CreatedAt, &config.UpdatedAt,
 	)
+	if err != nil {
+		return &config, err
+	}
+	config.Password, err = decryptSecret(config.Password)
 	return &config, err
 }
 
-func CreateEmail(customerID int, smtpConfigID *int, toEmail, subject, body string) (*Email, error) {
+func CreateEmail(customerID int, smtpConfigID *int, toEmail, subject, body, htmlBody string, tags []string) (*Email, error) {
+	if tags == nil {
+		tags = []string{}
+	}
 	var email Email
 	err := DB.QueryRow(`
-		INSERT INTO emails (customer_id, smtp_config_id, to_email, subject, body, status)
-		VALUES ($1, $2, $3, $4, $5, 'pending')
-		RETURNING id, customer_id, smtp_config_id, to_email, subject, body, status, created_at
-	`, customerID, smtpConfigID, toEmail, subject, body).Scan(
+		INSERT INTO emails (customer_id, smtp_config_id, to_email, subject, body, html_body, status, tags)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), 'pending', $7)
+		RETURNING id, customer_id, smtp_config_id, to_email, subject, body, COALESCE(html_body, ''), status, tags, created_at
+	`, customerID, smtpConfigID, toEmail, subject, body, htmlBody, pq.Array(tags)).Scan(
 		&email.ID, &email.CustomerID, &email.SMTPConfigID, &email.ToEmail,
-		&email.Subject, &email.Body, &email.Status, &email.CreatedAt,
+		&email.Subject, &email.Body, &email.HTMLBody, &email.Status, pq.Array(&email.Tags), &email.CreatedAt,
 	)
 	return &email, err
 }
 
+// UpdateEmailStatus sets an email's status and, when the status changes to
+// sent or failed, updates the stats rollup in the same transaction.
 func UpdateEmailStatus(emailID int, status string, errorMsg *string) error {
-	sentAt := time.Now()
-	_, err := DB.Exec(`
-		UPDATE emails
-		SET status = $1, sent_at = $2, error_message = $3
-		WHERE id = $4
-	`, status, sentAt, errorMsg, emailID)
-	return err
-}
+	sentAt := time.Now().UTC()
 
-func GetEmailsByCustomer(customerID int, limit, offset int) ([]Email, error) {
-	rows, err := DB.Query(`
-		SELECT id, customer_id, smtp_config_id, to_email, subject, body, status, created_at, sent_at, error_message
-		FROM emails
-		WHERE customer_id = $1
-		ORDER BY created_at DESC
-		LIMIT $2 OFFSET $3
-	`, customerID, limit, offset)
+	tx, err := DB.Begin()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	var emails []Email
-	for rows.Next() {
-		var email Email
-		err := rows.Scan(
-			&email.ID, &email.CustomerID, &email.SMTPConfigID, &email.ToEmail,
-			&email.Subject, &email.Body, &email.Status, &email.CreatedAt,
-			&email.SentAt, &email.ErrorMessage,
-		)
-		if err != nil {
-			return nil, err
+	var previous string
+	var subject statsSubject
+	var createdAt time.Time
+	err = tx.QueryRow(`
+		UPDATE emails e
+		SET status = $1, sent_at = $2, error_message = $3
+		FROM (SELECT id, status FROM emails WHERE id = $4 FOR UPDATE) prev
+		WHERE e.id = prev.id
+		RETURNING prev.status, e.customer_id, e.smtp_config_id, e.to_email, COALESCE(e.tags, '{}'), e.created_at
+	`, status, sentAt, errorMsg, emailID).Scan(
+		&previous, &subject.CustomerID, &subject.SMTPConfigID, &subject.ToEmail,
+		pq.Array(&subject.Tags), &createdAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	if previous != status && (status == StatsEventSent || status == StatsEventFailed) {
+		if err := recordStatsEvent(tx, subject, status, sentAt, sentAt.Sub(createdAt)); err != nil {
+			return err
 		}
-		emails = append(emails, email)
 	}
-	return emails, nil
+	return tx.Commit()
 }
 
 func GetEmailStats(customerID int) (map[string]interface{}, error) {
@@ -133,6 +150,17 @@ func DeleteSMTPConfig(customerID, configID int) error {
 	return err
 }
 
+// CreateCustomer inserts a customer. A taken email is a unique violation.
+func CreateCustomer(email, passwordHash, plan string) (*Customer, error) {
+	var c Customer
+	err := DB.QueryRow(`
+		INSERT INTO customers (email, password_hash, plan)
+		VALUES ($1, $2, $3)
+		RETURNING id, email, password_hash, created_at, plan, monthly_quota
+	`, email, passwordHash, plan).Scan(&c.ID, &c.Email, &c.PasswordHash, &c.CreatedAt, &c.Plan, &c.MonthlyQuota)
+	return &c, err
+}
+
 func GetCustomerByEmail(email string) (*Customer, error) {
 	var c Customer
 	err := DB.QueryRow(`
@@ -165,11 +193,13 @@ func GetCustomerByAPIKey(keyHash string) (*Customer, error) {
 }
 
 type APIKey struct {
-	ID        int
-	CustomerID int
-	KeyHash   string
-	Name      string
-	CreatedAt time.Time
+	ID          int
+	CustomerID  int
+	KeyHash     string
+	Name        string
+	Description string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
 }
 
 func CreateAPIKey(customerID int, keyHash, name string) (*APIKey, error) {
@@ -177,14 +207,15 @@ func CreateAPIKey(customerID int, keyHash, name string) (*APIKey, error) {
 	err := DB.QueryRow(`
 		INSERT INTO api_keys (customer_id, key_hash, name)
 		VALUES ($1, $2, $3)
-		RETURNING id, customer_id, key_hash, name, created_at
-	`, customerID, keyHash, name).Scan(&ak.ID, &ak.CustomerID, &ak.KeyHash, &ak.Name, &ak.CreatedAt)
+		RETURNING id, customer_id, key_hash, name, created_at, updated_at
+	`, customerID, keyHash, name).Scan(&ak.ID, &ak.CustomerID, &ak.KeyHash, &ak.Name, &ak.CreatedAt, &ak.UpdatedAt)
 	return &ak, err
 }
 
 func GetAPIKeysByCustomer(customerID int) ([]APIKey, error) {
 	rows, err := DB.Query(`
-		SELECT id, customer_id, name, created_at
+		SELECT id, customer_id, COALESCE(name, ''), COALESCE(description, ''), created_at,
+			COALESCE(updated_at, created_at)
 		FROM api_keys
 		WHERE customer_id = $1
 		ORDER BY created_at DESC
@@ -197,7 +228,7 @@ func GetAPIKeysByCustomer(customerID int) ([]APIKey, error) {
 	var keys []APIKey
 	for rows.Next() {
 		var ak APIKey
-		err := rows.Scan(&ak.ID, &ak.CustomerID, &ak.Name, &ak.CreatedAt)
+		err := rows.Scan(&ak.ID, &ak.CustomerID, &ak.Name, &ak.Description, &ak.CreatedAt, &ak.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -206,30 +237,41 @@ func GetAPIKeysByCustomer(customerID int) ([]APIKey, error) {
 	return keys, nil
 }
 
-func DeleteAPIKey(keyID int) error {
+func DeleteAPIKey(customerID, keyID int) error {
 	_, err := DB.Exec(`
 		DELETE FROM api_keys
-		WHERE id = $1
-	`, keyID)
+		WHERE id = $1 AND customer_id = $2
+	`, keyID, customerID)
 	return err
 }
 
-func CreateSMTPConfig(customerID int, name, host string, port int, username, password, fromEmail string) (*SMTPConfig, error) {
+func CreateSMTPConfig(customerID int, name, host string, port int, username, password, fromEmail string, trackOpens, trackClicks bool) (*SMTPConfig, error) {
+	sealed, err := encryptSecret(password)
+	if err != nil {
+		return nil, err
+	}
+
 	var config SMTPConfig
-	err := DB.QueryRow(`
-		INSERT INTO smtp_configs (customer_id, name, smtp_host, smtp_port, username, password, from_email)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
-		RETURNING id, customer_id, name, smtp_host, smtp_port, username, password, from_email, created_at
-	`, customerID, name, host, port, username, password, fromEmail).Scan(
+	err = DB.QueryRow(`
+		INSERT INTO smtp_configs (customer_id, name, smtp_host, smtp_port, username, password, from_email,
+			track_opens, track_clicks)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, customer_id, name, smtp_host, smtp_port, username, from_email,
+			track_opens, track_clicks, created_at, updated_at
+	`, customerID, name, host, port, username, sealed, fromEmail, trackOpens, trackClicks).Scan(
 		&config.ID, &config.CustomerID, &config.Name, &config.SMTPHost,
-		&config.SMTPPort, &config.Username, &config.Password, &config.FromEmail, &config.CreatedAt,
+		&config.SMTPPort, &config.Username, &config.FromEmail,
+		&config.TrackOpens, &config.TrackClicks, &config.CreatedAt, &config.UpdatedAt,
 	)
+	config.Password = password
 	return &config, err
 }
 
+// GetSMTPConfigsByCustomer lists a customer's configs. Passwords are not loaded.
 func GetSMTPConfigsByCustomer(customerID int) ([]SMTPConfig, error) {
 	rows, err := DB.Query(`
-		SELECT id, customer_id, name, smtp_host, smtp_port, username, password, from_email, created_at
+		SELECT id, customer_id, name, smtp_host, smtp_port, username, from_email,
+			track_opens, track_clicks, created_at, COALESCE(updated_at, created_at)
 		FROM smtp_configs
 		WHERE customer_id = $1
 		ORDER BY created_at DESC
@@ -244,7 +286,8 @@ func GetSMTPConfigsByCustomer(customerID int) ([]SMTPConfig, error) {
 		var config SMTPConfig
 		err := rows.Scan(
 			&config.ID, &config.CustomerID, &config.Name, &config.SMTPHost,
-			&config.SMTPPort, &config.Username, &config.Password, &config.FromEmail, &config.CreatedAt,
+			&config.SMTPPort, &config.Username, &config.FromEmail,
+			&config.TrackOpens, &config.TrackClicks, &config.CreatedAt, &config.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -253,3 +296,171 @@ func GetSMTPConfigsByCustomer(customerID int) ([]SMTPConfig, error) {
 	}
 	return configs, nil
 }
+
+// ErrPreconditionFailed is returned when an update's expected version no
+// longer matches the stored row.
+var ErrPreconditionFailed = errors.New("resource has been modified")
+
+// SMTPConfigUpdate holds the fields to change on an SMTP config. Nil fields
+// are left untouched.
+type SMTPConfigUpdate struct {
+	Name      *string
+	SMTPHost  *string
+	SMTPPort  *int
+	Username  *string
+	Password    *string
+	FromEmail   *string
+	TrackOpens  *bool
+	TrackClicks *bool
+}
+
+// UpdateSMTPConfig applies upd to a config inside a transaction. When
+// expectedUpdatedAt is set the row must not have changed since then, otherwise
+// ErrPreconditionFailed is returned. It returns the config before and after
+// the change.
+func UpdateSMTPConfig(customerID, configID int, upd SMTPConfigUpdate, expectedUpdatedAt *time.Time) (*SMTPConfig, *SMTPConfig, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var before SMTPConfig
+	err = tx.QueryRow(`
+		SELECT id, customer_id, name, smtp_host, smtp_port, username, password, from_email,
+			track_opens, track_clicks, created_at, COALESCE(updated_at, created_at)
+		FROM smtp_configs
+		WHERE id = $1 AND customer_id = $2
+		FOR UPDATE
+	`, configID, customerID).Scan(
+		&before.ID, &before.CustomerID, &before.Name, &before.SMTPHost,
+		&before.SMTPPort, &before.Username, &before.Password, &before.FromEmail,
+		&before.TrackOpens, &before.TrackClicks, &before.CreatedAt, &before.UpdatedAt,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	if expectedUpdatedAt != nil && !SameVersion(before.UpdatedAt, *expectedUpdatedAt) {
+		return &before, nil, ErrPreconditionFailed
+	}
+
+	after := before
+	sealed := before.Password
+	if upd.Name != nil {
+		after.Name = *upd.Name
+	}
+	if upd.SMTPHost != nil {
+		after.SMTPHost = *upd.SMTPHost
+	}
+	if upd.SMTPPort != nil {
+		after.SMTPPort = *upd.SMTPPort
+	}
+	if upd.Username != nil {
+		after.Username = *upd.Username
+	}
+	if upd.FromEmail != nil {
+		after.FromEmail = *upd.FromEmail
+	}
+	if upd.TrackOpens != nil {
+		after.TrackOpens = *upd.TrackOpens
+	}
+	if upd.TrackClicks != nil {
+		after.TrackClicks = *upd.TrackClicks
+	}
+	if upd.Password != nil {
+		if sealed, err = encryptSecret(*upd.Password); err != nil {
+			return nil, nil, err
+		}
+		after.Password = sealed
+	}
+
+	err = tx.QueryRow(`
+		UPDATE smtp_configs
+		SET name = $1, smtp_host = $2, smtp_port = $3, username = $4, password = $5, from_email = $6,
+			track_opens = $7, track_clicks = $8, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $9
+		RETURNING updated_at
+	`, after.Name, after.SMTPHost, after.SMTPPort, after.Username, sealed, after.FromEmail,
+		after.TrackOpens, after.TrackClicks, configID).Scan(&after.UpdatedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return &before, &after, nil
+}
+
+// GetAPIKey returns one of a customer's API keys.
+func GetAPIKey(customerID, keyID int) (*APIKey, error) {
+	var ak APIKey
+	err := DB.QueryRow(`
+		SELECT id, customer_id, COALESCE(name, ''), COALESCE(description, ''), created_at,
+			COALESCE(updated_at, created_at)
+		FROM api_keys
+		WHERE id = $1 AND customer_id = $2
+	`, keyID, customerID).Scan(&ak.ID, &ak.CustomerID, &ak.Name, &ak.Description, &ak.CreatedAt, &ak.UpdatedAt)
+	return &ak, err
+}
+
+// UpdateAPIKey renames an API key or changes its description. Nil fields are
+// left untouched; expectedUpdatedAt behaves as in UpdateSMTPConfig.
+func UpdateAPIKey(customerID, keyID int, name, description *string, expectedUpdatedAt *time.Time) (*APIKey, *APIKey, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var before APIKey
+	err = tx.QueryRow(`
+		SELECT id, customer_id, COALESCE(name, ''), COALESCE(description, ''), created_at,
+			COALESCE(updated_at, created_at)
+		FROM api_keys
+		WHERE id = $1 AND customer_id = $2
+		FOR UPDATE
+	`, keyID, customerID).Scan(&before.ID, &before.CustomerID, &before.Name, &before.Description, &before.CreatedAt, &before.UpdatedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if expectedUpdatedAt != nil && !SameVersion(before.UpdatedAt, *expectedUpdatedAt) {
+		return &before, nil, ErrPreconditionFailed
+	}
+
+	after := before
+	if name != nil {
+		after.Name = *name
+	}
+	if description != nil {
+		after.Description = *description
+	}
+
+	err = tx.QueryRow(`
+		UPDATE api_keys
+		SET name = $1, description = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $3
+		RETURNING updated_at
+	`, after.Name, after.Description, keyID).Scan(&after.UpdatedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return &before, &after, nil
+}
+
+// SameVersion compares two updated_at values at the microsecond precision
+// Postgres stores.
+func SameVersion(a, b time.Time) bool {
+	return a.UnixMicro() == b.UnixMicro()
+}
+
+// IsUniqueViolation reports whether err is a Postgres unique constraint
+// error, or ErrConflict from another repository.
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" || errors.Is(err, ErrConflict)
+}
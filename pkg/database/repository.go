@@ -0,0 +1,190 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrConflict is returned by repositories other than Postgres when a
+// write would break a uniqueness rule. IsUniqueViolation recognizes it.
+var ErrConflict = errors.New("already exists")
+
+// CustomerRepository stores customer accounts.
+type CustomerRepository interface {
+	Create(email, passwordHash, plan string) (*Customer, error)
+	Get(id int) (*Customer, error)
+	GetByEmail(email string) (*Customer, error)
+	// GetByAPIKey returns the owner of the API key with the given hash.
+	GetByAPIKey(keyHash string) (*Customer, error)
+}
+
+// APIKeyRepository stores customers' API keys, by hash.
+type APIKeyRepository interface {
+	Create(customerID int, keyHash, name string) (*APIKey, error)
+	Get(customerID, keyID int) (*APIKey, error)
+	List(customerID int) ([]APIKey, error)
+	// Update behaves as UpdateAPIKey.
+	Update(customerID, keyID int, name, description *string, expectedUpdatedAt *time.Time) (before, after *APIKey, err error)
+	Delete(customerID, keyID int) error
+}
+
+// SMTPConfigRepository stores SMTP configs. Passwords go in and come out
+// in plaintext; how they are kept is up to the implementation.
+type SMTPConfigRepository interface {
+	Create(customerID int, name, host string, port int, username, password, fromEmail string, trackOpens, trackClicks bool) (*SMTPConfig, error)
+	Get(customerID, configID int) (*SMTPConfig, error)
+	// List returns a customer's configs, newest first, without passwords.
+	List(customerID int) ([]SMTPConfig, error)
+	// Update behaves as UpdateSMTPConfig.
+	Update(customerID, configID int, upd SMTPConfigUpdate, expectedUpdatedAt *time.Time) (before, after *SMTPConfig, err error)
+	Delete(customerID, configID int) error
+}
+
+// EmailRepository stores sent emails and their events.
+type EmailRepository interface {
+	Create(customerID int, smtpConfigID *int, toEmail, subject, body, htmlBody string, tags []string) (*Email, error)
+	UpdateStatus(emailID int, status string, errorMsg *string) error
+	SetMessageID(emailID int, messageID string) error
+	Get(customerID, emailID int) (*Email, error)
+	// List returns a page of emails. Cursors are only meaningful to the
+	// implementation that issued them.
+	List(customerID int, q EmailQuery) (*EmailPage, error)
+	Events(emailID int) ([]EmailEvent, error)
+	Stats(customerID int) (map[string]interface{}, error)
+}
+
+// AuditLog stores audit events.
+type AuditLog interface {
+	Record(event *AuditEvent) error
+	List(customerID int, filter AuditFilter, beforeID int64, limit int) ([]AuditEvent, error)
+	Verify(customerID int) (*AuditChainReport, error)
+}
+
+// Repositories are the stores the API's handlers work through. Whatever
+// the implementation, lookups that match nothing return sql.ErrNoRows and
+// stale expected versions ErrPreconditionFailed, as the functions in this
+// package do.
+type Repositories struct {
+	Customers   CustomerRepository
+	APIKeys     APIKeyRepository
+	SMTPConfigs SMTPConfigRepository
+	Emails      EmailRepository
+	Audit       AuditLog
+}
+
+// Postgres returns the repositories backed by DB.
+func Postgres() Repositories {
+	return Repositories{
+		Customers:   postgresCustomers{},
+		APIKeys:     postgresAPIKeys{},
+		SMTPConfigs: postgresSMTPConfigs{},
+		Emails:      postgresEmails{},
+		Audit:       postgresAuditLog{},
+	}
+}
+
+type postgresCustomers struct{}
+
+func (postgresCustomers) Create(email, passwordHash, plan string) (*Customer, error) {
+	return CreateCustomer(email, passwordHash, plan)
+}
+
+func (postgresCustomers) Get(id int) (*Customer, error) {
+	return GetCustomer(id)
+}
+
+func (postgresCustomers) GetByEmail(email string) (*Customer, error) {
+	return GetCustomerByEmail(email)
+}
+
+func (postgresCustomers) GetByAPIKey(keyHash string) (*Customer, error) {
+	return GetCustomerByAPIKey(keyHash)
+}
+
+type postgresAPIKeys struct{}
+
+func (postgresAPIKeys) Create(customerID int, keyHash, name string) (*APIKey, error) {
+	return CreateAPIKey(customerID, keyHash, name)
+}
+
+func (postgresAPIKeys) Get(customerID, keyID int) (*APIKey, error) {
+	return GetAPIKey(customerID, keyID)
+}
+
+func (postgresAPIKeys) List(customerID int) ([]APIKey, error) {
+	return GetAPIKeysByCustomer(customerID)
+}
+
+func (postgresAPIKeys) Update(customerID, keyID int, name, description *string, expectedUpdatedAt *time.Time) (*APIKey, *APIKey, error) {
+	return UpdateAPIKey(customerID, keyID, name, description, expectedUpdatedAt)
+}
+
+func (postgresAPIKeys) Delete(customerID, keyID int) error {
+	return DeleteAPIKey(customerID, keyID)
+}
+
+type postgresSMTPConfigs struct{}
+
+func (postgresSMTPConfigs) Create(customerID int, name, host string, port int, username, password, fromEmail string, trackOpens, trackClicks bool) (*SMTPConfig, error) {
+	return CreateSMTPConfig(customerID, name, host, port, username, password, fromEmail, trackOpens, trackClicks)
+}
+
+func (postgresSMTPConfigs) Get(customerID, configID int) (*SMTPConfig, error) {
+	return GetSMTPConfigByID(customerID, configID)
+}
+
+func (postgresSMTPConfigs) List(customerID int) ([]SMTPConfig, error) {
+	return GetSMTPConfigsByCustomer(customerID)
+}
+
+func (postgresSMTPConfigs) Update(customerID, configID int, upd SMTPConfigUpdate, expectedUpdatedAt *time.Time) (*SMTPConfig, *SMTPConfig, error) {
+	return UpdateSMTPConfig(customerID, configID, upd, expectedUpdatedAt)
+}
+
+func (postgresSMTPConfigs) Delete(customerID, configID int) error {
+	return DeleteSMTPConfig(customerID, configID)
+}
+
+type postgresEmails struct{}
+
+func (postgresEmails) Create(customerID int, smtpConfigID *int, toEmail, subject, body, htmlBody string, tags []string) (*Email, error) {
+	return CreateEmail(customerID, smtpConfigID, toEmail, subject, body, htmlBody, tags)
+}
+
+func (postgresEmails) UpdateStatus(emailID int, status string, errorMsg *string) error {
+	return UpdateEmailStatus(emailID, status, errorMsg)
+}
+
+func (postgresEmails) SetMessageID(emailID int, messageID string) error {
+	return SetEmailMessageID(emailID, messageID)
+}
+
+func (postgresEmails) Get(customerID, emailID int) (*Email, error) {
+	return GetEmail(customerID, emailID)
+}
+
+func (postgresEmails) List(customerID int, q EmailQuery) (*EmailPage, error) {
+	return ListEmails(customerID, q)
+}
+
+func (postgresEmails) Events(emailID int) ([]EmailEvent, error) {
+	return ListEmailEvents(emailID)
+}
+
+func (postgresEmails) Stats(customerID int) (map[string]interface{}, error) {
+	return GetEmailStats(customerID)
+}
+
+type postgresAuditLog struct{}
+
+func (postgresAuditLog) Record(event *AuditEvent) error {
+	return RecordAuditEvent(event)
+}
+
+func (postgresAuditLog) List(customerID int, filter AuditFilter, beforeID int64, limit int) ([]AuditEvent, error) {
+	return ListAuditEvents(customerID, filter, beforeID, limit)
+}
+
+func (postgresAuditLog) Verify(customerID int) (*AuditChainReport, error) {
+	return VerifyAuditChain(customerID)
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrUnknownSMTPConfig is returned when a route names an SMTP config the
+// customer does not own.
+var ErrUnknownSMTPConfig = errors.New("unknown SMTP config")
+
+// SenderRoute sends through one of several SMTP configs, failing over
+// between them. Strategy is priority or weighted, as for composite
+// EmailSenderConfigs. Mail is sent as FromEmail whichever backend is used.
+type SenderRoute struct {
+	ID         int                  `json:"id"`
+	CustomerID int                  `json:"customer_id"`
+	Name       string               `json:"name"`
+	Strategy   string               `json:"strategy"`
+	FromEmail  string               `json:"from_email"`
+	Backends   []SenderRouteBackend `json:"backends"`
+	CreatedAt  time.Time            `json:"created_at"`
+}
+
+// SenderRouteBackend is an SMTP config a route sends through.
+type SenderRouteBackend struct {
+	SMTPConfigID int    `json:"smtp_config_id"`
+	Name         string `json:"name"`
+	Priority     int    `json:"priority"`
+	Weight       int    `json:"weight"`
+}
+
+// CreateSenderRoute stores a route and its backends. It returns
+// ErrUnknownSMTPConfig when a backend is not one of the customer's configs.
+func CreateSenderRoute(customerID int, name, strategy, fromEmail string, backends []SenderRouteBackend) (*SenderRoute, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	route := &SenderRoute{CustomerID: customerID, Name: name, Strategy: strategy, FromEmail: fromEmail}
+	err = tx.QueryRow(`
+		INSERT INTO sender_routes (customer_id, name, strategy, from_email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`, customerID, name, strategy, fromEmail).Scan(&route.ID, &route.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range backends {
+		// Only configs owned by the customer are inserted.
+		err := tx.QueryRow(`
+			INSERT INTO sender_route_backends (route_id, smtp_config_id, priority, weight)
+			SELECT $1, id, $3, $4 FROM smtp_configs WHERE id = $2 AND customer_id = $5
+			RETURNING (SELECT name FROM smtp_configs WHERE id = $2)
+		`, route.ID, b.SMTPConfigID, b.Priority, b.Weight, customerID).Scan(&b.Name)
+		if err == sql.ErrNoRows {
+			return nil, ErrUnknownSMTPConfig
+		}
+		if err != nil {
+			return nil, err
+		}
+		route.Backends = append(route.Backends, b)
+	}
+	return route, tx.Commit()
+}
+
+// GetSenderRoute returns sql.ErrNoRows when the customer has no such route.
+func GetSenderRoute(customerID, routeID int) (*SenderRoute, error) {
+	route := &SenderRoute{}
+	err := DB.QueryRow(`
+		SELECT id, customer_id, name, strategy, from_email, created_at
+		FROM sender_routes
+		WHERE id = $1 AND customer_id = $2
+	`, routeID, customerID).Scan(&route.ID, &route.CustomerID, &route.Name, &route.Strategy, &route.FromEmail, &route.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := loadRouteBackends([]*SenderRoute{route}); err != nil {
+		return nil, err
+	}
+	return route, nil
+}
+
+// ListSenderRoutes returns the customer's routes by name.
+func ListSenderRoutes(customerID int) ([]*SenderRoute, error) {
+	rows, err := DB.Query(`
+		SELECT id, customer_id, name, strategy, from_email, created_at
+		FROM sender_routes
+		WHERE customer_id = $1
+		ORDER BY name
+	`, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	routes := []*SenderRoute{}
+	for rows.Next() {
+		route := &SenderRoute{}
+		if err := rows.Scan(&route.ID, &route.CustomerID, &route.Name, &route.Strategy, &route.FromEmail, &route.CreatedAt); err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return routes, loadRouteBackends(routes)
+}
+
+// loadRouteBackends fills in the backends of routes, in priority order.
+func loadRouteBackends(routes []*SenderRoute) error {
+	if len(routes) == 0 {
+		return nil
+	}
+	byID := make(map[int]*SenderRoute, len(routes))
+	ids := make([]int64, 0, len(routes))
+	for _, r := range routes {
+		r.Backends = []SenderRouteBackend{}
+		byID[r.ID] = r
+		ids = append(ids, int64(r.ID))
+	}
+
+	rows, err := DB.Query(`
+		SELECT b.route_id, b.smtp_config_id, c.name, b.priority, b.weight
+		FROM sender_route_backends b
+		JOIN smtp_configs c ON c.id = b.smtp_config_id
+		WHERE b.route_id = ANY($1)
+		ORDER BY b.route_id, b.priority, b.smtp_config_id
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var routeID int
+		var b SenderRouteBackend
+		if err := rows.Scan(&routeID, &b.SMTPConfigID, &b.Name, &b.Priority, &b.Weight); err != nil {
+			return err
+		}
+		byID[routeID].Backends = append(byID[routeID].Backends, b)
+	}
+	return rows.Err()
+}
+
+// DeleteSenderRoute returns sql.ErrNoRows when the customer has no such
+// route.
+func DeleteSenderRoute(customerID, routeID int) error {
+	result, err := DB.Exec(`DELETE FROM sender_routes WHERE id = $1 AND customer_id = $2`, routeID, customerID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SetEmailSMTPConfig records the SMTP config an email was sent through,
+// for emails sent via a route.
+func SetEmailSMTPConfig(emailID, smtpConfigID int) error {
+	_, err := DB.Exec(`UPDATE emails SET smtp_config_id = $1 WHERE id = $2`, smtpConfigID, emailID)
+	return err
+}
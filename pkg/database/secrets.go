@@ -0,0 +1,116 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/Gatete-Bruno/besend/pkg/secrets"
+)
+
+func encryptSecret(plaintext string) (string, error) {
+	if Keyring == nil {
+		return "", errors.New("database keyring not configured")
+	}
+	sealed, err := Keyring.EncryptString(plaintext)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt secret: %w", err)
+	}
+	return sealed, nil
+}
+
+// decryptSecret opens a stored secret. Rows written before encryption was
+// introduced are returned as-is until ReencryptSecrets migrates them.
+func decryptSecret(stored string) (string, error) {
+	if !secrets.IsSealed(stored) {
+		return stored, nil
+	}
+	if Keyring == nil {
+		return "", errors.New("database keyring not configured")
+	}
+	plaintext, err := Keyring.DecryptString(stored)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+	return plaintext, nil
+}
+
+// encryptedColumns are the columns holding secrets sealed with Keyring.
+var encryptedColumns = []struct{ table, column, label string }{
+	{"smtp_configs", "password", "smtp config"},
+	{"webhook_endpoints", "secret", "webhook endpoint"},
+}
+
+// ReencryptSecrets encrypts any plaintext SMTP passwords and re-wraps
+// secrets sealed with a non-active key. It returns the number of rows updated.
+func ReencryptSecrets() (int, error) {
+	if Keyring == nil {
+		return 0, errors.New("database keyring not configured")
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	total := 0
+	for _, col := range encryptedColumns {
+		n, err := reencryptColumn(tx, col.table, col.column, col.label)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+func reencryptColumn(tx *sql.Tx, table, column, label string) (int, error) {
+	rows, err := tx.Query(fmt.Sprintf(`SELECT id, %s FROM %s FOR UPDATE`, column, table))
+	if err != nil {
+		return 0, err
+	}
+
+	type pending struct {
+		id     int
+		sealed string
+	}
+	var updates []pending
+	for rows.Next() {
+		var id int
+		var stored string
+		if err := rows.Scan(&id, &stored); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !Keyring.NeedsRotation(stored) {
+			continue
+		}
+		plaintext, err := decryptSecret(stored)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("%s %d: %w", label, id, err)
+		}
+		sealed, err := encryptSecret(plaintext)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("%s %d: %w", label, id, err)
+		}
+		updates = append(updates, pending{id: id, sealed: sealed})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, u := range updates {
+		if _, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE id = $2`, table, column), u.sealed, u.id); err != nil {
+			return 0, fmt.Errorf("%s %d: %w", label, u.id, err)
+		}
+	}
+	return len(updates), nil
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/Gatete-Bruno/besend/pkg/secrets"
+)
+
+// withKeyring sets Keyring for the test to a ring of the given key IDs.
+// Each ID always maps to the same key.
+func withKeyring(t *testing.T, activeID string, ids ...string) {
+	t.Helper()
+	keys := map[string][]byte{}
+	for _, id := range ids {
+		keys[id] = bytes.Repeat([]byte(id[len(id)-1:]), 32)
+	}
+	k, err := secrets.NewKeyring(activeID, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := Keyring
+	Keyring = k
+	t.Cleanup(func() { Keyring = previous })
+}
+
+func TestDecryptSecret(t *testing.T) {
+	withKeyring(t, "k1", "k1")
+	sealed, err := encryptSecret("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !secrets.IsSealed(sealed) {
+		t.Fatalf("encryptSecret stored %q unsealed", sealed)
+	}
+
+	tests := []struct {
+		name     string
+		activeID string
+		keyIDs   []string
+		stored   string
+		want     string
+		wantErr  bool
+	}{
+		{"legacy plaintext", "k1", []string{"k1"}, "legacy", "legacy", false},
+		{"sealed", "k1", []string{"k1"}, sealed, "s3cret", false},
+		{"old key still in the ring", "k2", []string{"k1", "k2"}, sealed, "s3cret", false},
+		{"key removed from the ring", "k2", []string{"k2"}, sealed, "", true},
+		{"tampered", "k1", []string{"k1"}, tamper(sealed), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withKeyring(t, tt.activeID, tt.keyIDs...)
+			got, err := decryptSecret(tt.stored)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("decryptSecret = %q, %v; want %q, error %t", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+// tamper changes one character in the ciphertext of a sealed value.
+func tamper(sealed string) string {
+	i := len(sealed) - 10
+	c := byte('A')
+	if sealed[i] == c {
+		c = 'B'
+	}
+	return sealed[:i] + string(c) + sealed[i+1:]
+}
+
+func TestSecretsWithoutKeyring(t *testing.T) {
+	previous := Keyring
+	Keyring = nil
+	defer func() { Keyring = previous }()
+
+	if _, err := encryptSecret("s3cret"); err == nil {
+		t.Error("encryptSecret succeeded without a keyring")
+	}
+	if got, err := decryptSecret("legacy"); err != nil || got != "legacy" {
+		t.Errorf("decryptSecret(legacy) = %q, %v; want it returned as-is", got, err)
+	}
+}
@@ -0,0 +1,23 @@
+package database
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// SendingDomainVerified reports whether domain is one of the customer's
+// sending domains and its DNS records have been verified by smtp-manager.
+func SendingDomainVerified(customerID int, domain string) (bool, error) {
+	var verified bool
+	err := DB.QueryRow(`
+		SELECT d.verified
+		FROM sending_domains d
+		JOIN organizations o ON o.id = d.organization_id
+		WHERE o.customer_id = $1 AND LOWER(d.domain) = $2
+	`, strconv.Itoa(customerID), strings.ToLower(strings.TrimSuffix(domain, "."))).Scan(&verified)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return verified, err
+}
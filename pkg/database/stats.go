@@ -0,0 +1,368 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Stats dimensions stored in email_stats_hourly. Every event is counted once
+// under StatsDimensionAll and once per value of each other dimension.
+const (
+	StatsDimensionAll        = "all"
+	StatsDimensionSMTPConfig = "smtp_config"
+	StatsDimensionTag        = "tag"
+	StatsDimensionDomain     = "domain"
+)
+
+// Stats events counted by the rollup.
+const (
+	StatsEventSent       = "sent"
+	StatsEventFailed     = "failed"
+	StatsEventBounced    = "bounced"
+	StatsEventComplained = "complained"
+	StatsEventOpened     = "opened"
+	StatsEventClicked    = "clicked"
+)
+
+// sendLatencyBounds are the upper bounds, in milliseconds, of the
+// time-to-send histogram buckets. A final bucket catches everything slower.
+var sendLatencyBounds = []int64{100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000, 300000}
+
+// statsSubject carries the fields of an email the rollup is keyed on.
+type statsSubject struct {
+	CustomerID   int
+	SMTPConfigID *int
+	ToEmail      string
+	Tags         []string
+}
+
+func (s statsSubject) dimensions() [][2]string {
+	dims := [][2]string{{StatsDimensionAll, ""}}
+	if s.SMTPConfigID != nil {
+		dims = append(dims, [2]string{StatsDimensionSMTPConfig, strconv.Itoa(*s.SMTPConfigID)})
+	}
+	if _, domain, ok := strings.Cut(s.ToEmail, "@"); ok && domain != "" {
+		dims = append(dims, [2]string{StatsDimensionDomain, strings.ToLower(domain)})
+	}
+	for _, tag := range s.Tags {
+		dims = append(dims, [2]string{StatsDimensionTag, tag})
+	}
+	return dims
+}
+
+func latencyBucket(latency time.Duration) int {
+	ms := latency.Milliseconds()
+	for i, bound := range sendLatencyBounds {
+		if ms <= bound {
+			return i
+		}
+	}
+	return len(sendLatencyBounds)
+}
+
+// recordStatsEvent increments the hourly rollup for one event. latency is
+// only used for StatsEventSent.
+func recordStatsEvent(q queryer, subject statsSubject, event string, at time.Time, latency time.Duration) error {
+	var sent, failed, bounced, complained, opened, clicked int
+	hist := make([]int64, len(sendLatencyBounds)+1)
+	switch event {
+	case StatsEventSent:
+		sent = 1
+		hist[latencyBucket(latency)] = 1
+	case StatsEventFailed:
+		failed = 1
+	case StatsEventBounced:
+		bounced = 1
+	case StatsEventComplained:
+		complained = 1
+	case StatsEventOpened:
+		opened = 1
+	case StatsEventClicked:
+		clicked = 1
+	default:
+		return fmt.Errorf("unknown stats event %q", event)
+	}
+
+	for _, dim := range subject.dimensions() {
+		_, err := q.Exec(`
+			INSERT INTO email_stats_hourly
+				(customer_id, bucket, dimension, dimension_value, sent, failed, bounced, complained, opened, clicked,
+				send_latency_hist)
+			VALUES ($1, date_trunc('hour', $2::timestamp), $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			ON CONFLICT (customer_id, dimension, dimension_value, bucket) DO UPDATE SET
+				sent = email_stats_hourly.sent + EXCLUDED.sent,
+				failed = email_stats_hourly.failed + EXCLUDED.failed,
+				bounced = email_stats_hourly.bounced + EXCLUDED.bounced,
+				complained = email_stats_hourly.complained + EXCLUDED.complained,
+				opened = email_stats_hourly.opened + EXCLUDED.opened,
+				clicked = email_stats_hourly.clicked + EXCLUDED.clicked,
+				send_latency_hist = (
+					SELECT array_agg(COALESCE(a, 0) + COALESCE(b, 0) ORDER BY i)
+					FROM unnest(email_stats_hourly.send_latency_hist, EXCLUDED.send_latency_hist)
+						WITH ORDINALITY AS h(a, b, i)
+				)
+		`, subject.CustomerID, at.UTC(), dim[0], dim[1], sent, failed, bounced, complained, opened, clicked, pq.Array(hist))
+		if err != nil {
+			return fmt.Errorf("failed to update stats rollup: %w", err)
+		}
+	}
+	return nil
+}
+
+// RebuildEmailStats recomputes the rollup from the emails table. It is meant
+// for backfilling after the rollup is introduced or repairing drift, and is
+// run with the API's rebuild-stats command. The table is replaced in one
+// transaction, so readers see either the old or the new rollup. Events
+// recorded while it runs can make it fail with a conflict; run it again or
+// while sending is paused.
+func RebuildEmailStats() error {
+	sentCond := "e.status IN ('sent', 'delivered', 'bounced', 'complained')"
+	latency := "EXTRACT(EPOCH FROM (e.sent_at - e.created_at)) * 1000"
+	var hist []string
+	lower := "NULL"
+	for _, bound := range sendLatencyBounds {
+		cond := fmt.Sprintf("%s <= %d", latency, bound)
+		if lower != "NULL" {
+			cond = fmt.Sprintf("%s > %s AND %s", latency, lower, cond)
+		}
+		hist = append(hist, fmt.Sprintf("COUNT(*) FILTER (WHERE %s AND %s)", sentCond, cond))
+		lower = strconv.FormatInt(bound, 10)
+	}
+	hist = append(hist, fmt.Sprintf("COUNT(*) FILTER (WHERE %s AND %s > %s)", sentCond, latency, lower))
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// dimensions expands each email into one row per stats dimension.
+	dimensions := fmt.Sprintf(`CROSS JOIN LATERAL (
+			SELECT '%s', ''
+			UNION ALL SELECT '%s', e.smtp_config_id::text WHERE e.smtp_config_id IS NOT NULL
+			UNION ALL SELECT '%s', LOWER(split_part(e.to_email, '@', 2)) WHERE e.to_email LIKE '%%@%%'
+			UNION ALL SELECT '%s', t FROM unnest(COALESCE(e.tags, '{}')) AS t
+		) AS d(dimension, dimension_value)`,
+		StatsDimensionAll, StatsDimensionSMTPConfig, StatsDimensionDomain, StatsDimensionTag)
+
+	if _, err := tx.Exec(`DELETE FROM email_stats_hourly`); err != nil {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf(`
+		INSERT INTO email_stats_hourly
+			(customer_id, bucket, dimension, dimension_value, sent, failed, bounced, complained, send_latency_hist)
+		SELECT e.customer_id, date_trunc('hour', COALESCE(e.sent_at, e.created_at)), d.dimension, d.dimension_value,
+			COUNT(*) FILTER (WHERE %s),
+			COUNT(*) FILTER (WHERE e.status = 'failed'),
+			COUNT(*) FILTER (WHERE e.status = 'bounced'),
+			COUNT(*) FILTER (WHERE e.status = 'complained'),
+			ARRAY[%s]
+		FROM emails e
+		%s
+		WHERE e.status IN ('sent', 'delivered', 'failed', 'bounced', 'complained')
+		GROUP BY 1, 2, 3, 4
+	`, sentCond, strings.Join(hist, ", "), dimensions))
+	if err != nil {
+		return fmt.Errorf("failed to rebuild stats rollup: %w", err)
+	}
+
+	// Opens and clicks are counted once per email, in the hour of the first
+	// event, matching RecordEmailEvent.
+	_, err = tx.Exec(fmt.Sprintf(`
+		INSERT INTO email_stats_hourly
+			(customer_id, bucket, dimension, dimension_value, opened, clicked)
+		SELECT e.customer_id, date_trunc('hour', ev.first_at), d.dimension, d.dimension_value,
+			COUNT(*) FILTER (WHERE ev.type = 'open'),
+			COUNT(*) FILTER (WHERE ev.type = 'click')
+		FROM (
+			SELECT email_id, type, MIN(created_at) AS first_at
+			FROM email_events
+			GROUP BY email_id, type
+		) ev
+		JOIN emails e ON e.id = ev.email_id
+		%s
+		GROUP BY 1, 2, 3, 4
+		ON CONFLICT (customer_id, dimension, dimension_value, bucket) DO UPDATE SET
+			opened = EXCLUDED.opened,
+			clicked = EXCLUDED.clicked
+	`, dimensions))
+	if err != nil {
+		return fmt.Errorf("failed to rebuild stats rollup: %w", err)
+	}
+	return tx.Commit()
+}
+
+// TimeSeriesQuery selects rollup data between From (inclusive) and To
+// (exclusive), bucketed by Interval ("hour" or "day") and optionally grouped
+// by a dimension.
+type TimeSeriesQuery struct {
+	From     time.Time
+	To       time.Time
+	Interval string
+	GroupBy  string
+}
+
+// TimeSeriesPoint holds the counts for one bucket. DeliveryRate and
+// MedianTimeToSendMS are null when there is nothing to derive them from.
+type TimeSeriesPoint struct {
+	Bucket             time.Time `json:"bucket"`
+	Sent               int64     `json:"sent"`
+	Failed             int64     `json:"failed"`
+	Bounced            int64     `json:"bounced"`
+	Complained         int64     `json:"complained"`
+	Opened             int64     `json:"opened"`
+	Clicked            int64     `json:"clicked"`
+	DeliveryRate       *float64  `json:"delivery_rate"`
+	MedianTimeToSendMS *float64  `json:"median_time_to_send_ms"`
+	sendLatencyHist    []int64
+}
+
+// TimeSeries is one group's points. Group is empty when not grouping.
+type TimeSeries struct {
+	Group  string            `json:"group,omitempty"`
+	Points []TimeSeriesPoint `json:"points"`
+	Totals TimeSeriesPoint   `json:"totals"`
+}
+
+// GetEmailTimeSeries reads the hourly rollup and folds it into the requested
+// interval. Empty buckets are returned as zeros.
+func GetEmailTimeSeries(customerID int, q TimeSeriesQuery) ([]TimeSeries, error) {
+	dimension := StatsDimensionAll
+	if q.GroupBy != "" {
+		dimension = q.GroupBy
+	}
+
+	rows, err := DB.Query(`
+		SELECT dimension_value, bucket, sent, failed, bounced, complained, opened, clicked, send_latency_hist
+		FROM email_stats_hourly
+		WHERE customer_id = $1 AND dimension = $2 AND bucket >= $3 AND bucket < $4
+	`, customerID, dimension, q.From.UTC(), q.To.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	truncate := func(t time.Time) time.Time {
+		if q.Interval == "day" {
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		}
+		return t.UTC().Truncate(time.Hour)
+	}
+
+	groups := map[string]map[time.Time]*TimeSeriesPoint{}
+	for rows.Next() {
+		var value string
+		var bucket time.Time
+		var p TimeSeriesPoint
+		var hist pq.Int64Array
+		if err := rows.Scan(&value, &bucket, &p.Sent, &p.Failed, &p.Bounced, &p.Complained, &p.Opened, &p.Clicked, &hist); err != nil {
+			return nil, err
+		}
+		p.sendLatencyHist = hist
+
+		if groups[value] == nil {
+			groups[value] = map[time.Time]*TimeSeriesPoint{}
+		}
+		key := truncate(bucket)
+		if existing := groups[value][key]; existing != nil {
+			existing.add(&p)
+		} else {
+			p.Bucket = key
+			groups[value][key] = &p
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	step := time.Hour
+	if q.Interval == "day" {
+		step = 24 * time.Hour
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 && q.GroupBy == "" {
+		names = []string{""}
+	}
+
+	series := make([]TimeSeries, 0, len(names))
+	for _, name := range names {
+		s := TimeSeries{Group: name, Points: []TimeSeriesPoint{}}
+		for t := truncate(q.From); t.Before(q.To); t = t.Add(step) {
+			p := TimeSeriesPoint{Bucket: t}
+			if existing := groups[name][t]; existing != nil {
+				p = *existing
+			}
+			p.finish()
+			s.Points = append(s.Points, p)
+			s.Totals.add(&p)
+		}
+		s.Totals.Bucket = truncate(q.From)
+		s.Totals.finish()
+		series = append(series, s)
+	}
+	return series, nil
+}
+
+func (p *TimeSeriesPoint) add(o *TimeSeriesPoint) {
+	p.Sent += o.Sent
+	p.Failed += o.Failed
+	p.Bounced += o.Bounced
+	p.Complained += o.Complained
+	p.Opened += o.Opened
+	p.Clicked += o.Clicked
+	if p.sendLatencyHist == nil {
+		p.sendLatencyHist = make([]int64, len(sendLatencyBounds)+1)
+	}
+	for i := 0; i < len(o.sendLatencyHist) && i < len(p.sendLatencyHist); i++ {
+		p.sendLatencyHist[i] += o.sendLatencyHist[i]
+	}
+}
+
+// finish derives the delivery rate, (sent - bounced) / (sent + failed), and
+// the median time-to-send, interpolated within its histogram bucket.
+func (p *TimeSeriesPoint) finish() {
+	p.DeliveryRate = nil
+	if attempted := p.Sent + p.Failed; attempted > 0 {
+		rate := float64(p.Sent-p.Bounced) / float64(attempted)
+		p.DeliveryRate = &rate
+	}
+
+	p.MedianTimeToSendMS = nil
+	var total int64
+	for _, n := range p.sendLatencyHist {
+		total += n
+	}
+	if total == 0 {
+		return
+	}
+	half := float64(total) / 2
+	var seen int64
+	for i, n := range p.sendLatencyHist {
+		if n == 0 || float64(seen+n) < half {
+			seen += n
+			continue
+		}
+		var lower, upper float64
+		if i > 0 {
+			lower = float64(sendLatencyBounds[i-1])
+		}
+		if i < len(sendLatencyBounds) {
+			upper = float64(sendLatencyBounds[i])
+		} else {
+			upper = lower
+		}
+		median := lower + (upper-lower)*(half-float64(seen))/float64(n)
+		p.MedianTimeToSendMS = &median
+		return
+	}
+}
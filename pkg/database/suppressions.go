@@ -0,0 +1,230 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// EmailStatusSuppressed marks an email that was not sent because its
+// recipient is on the suppression list.
+const EmailStatusSuppressed = "suppressed"
+
+// Suppression reasons.
+const (
+	SuppressionBounce      = "bounce"
+	SuppressionComplaint   = "complaint"
+	SuppressionUnsubscribe = "unsubscribe"
+	SuppressionManual      = "manual"
+)
+
+var suppressionReasons = map[string]bool{
+	SuppressionBounce:      true,
+	SuppressionComplaint:   true,
+	SuppressionUnsubscribe: true,
+	SuppressionManual:      true,
+}
+
+// ValidSuppressionReason reports whether reason is a known suppression reason.
+func ValidSuppressionReason(reason string) bool {
+	return suppressionReasons[reason]
+}
+
+// Suppression is an address a customer must not send to. Addresses are
+// stored lower-cased so lookups are case-insensitive. A non-empty Category
+// only blocks emails carrying that tag; an empty one blocks all mail.
+type Suppression struct {
+	ID         int       `json:"id"`
+	CustomerID int       `json:"customer_id"`
+	Email      string    `json:"email"`
+	Category   string    `json:"category,omitempty"`
+	Reason     string    `json:"reason"`
+	Details    *string   `json:"details,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+const suppressionColumns = "id, customer_id, email, category, reason, details, created_at"
+
+func scanSuppression(row interface{ Scan(...interface{}) error }, s *Suppression) error {
+	return row.Scan(&s.ID, &s.CustomerID, &s.Email, &s.Category, &s.Reason, &s.Details, &s.CreatedAt)
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// AddSuppression suppresses an address, for all mail when category is
+// empty. An existing entry is kept as is and returned with created set to
+// false.
+func AddSuppression(customerID int, email, category, reason string, details *string) (s *Suppression, created bool, err error) {
+	s = &Suppression{}
+	err = scanSuppression(DB.QueryRow(`
+		INSERT INTO suppressions (customer_id, email, category, reason, details)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (customer_id, email, category) DO NOTHING
+		RETURNING `+suppressionColumns,
+		customerID, normalizeEmail(email), category, reason, details), s)
+	if err == sql.ErrNoRows {
+		s, err = GetSuppression(customerID, email, category)
+		return s, false, err
+	}
+	return s, err == nil, err
+}
+
+// GetSuppression returns sql.ErrNoRows when the address is not suppressed
+// for category.
+func GetSuppression(customerID int, email, category string) (*Suppression, error) {
+	var s Suppression
+	err := scanSuppression(DB.QueryRow(`
+		SELECT `+suppressionColumns+`
+		FROM suppressions
+		WHERE customer_id = $1 AND email = $2 AND category = $3
+	`, customerID, normalizeEmail(email), category), &s)
+	return &s, err
+}
+
+// CheckSuppression is used on the send path. It returns the entry blocking
+// an email to the address with the given tags, preferring a suppression of
+// all mail, or nil when the email may be sent.
+func CheckSuppression(customerID int, email string, tags []string) (*Suppression, error) {
+	if tags == nil {
+		tags = []string{}
+	}
+	var s Suppression
+	err := scanSuppression(DB.QueryRow(`
+		SELECT `+suppressionColumns+`
+		FROM suppressions
+		WHERE customer_id = $1 AND email = $2 AND (category = '' OR category = ANY($3))
+		ORDER BY category
+		LIMIT 1
+	`, customerID, normalizeEmail(email), pq.Array(tags)), &s)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ListAddressSuppressions returns every entry for one address.
+func ListAddressSuppressions(customerID int, email string) ([]Suppression, error) {
+	rows, err := DB.Query(`
+		SELECT `+suppressionColumns+`
+		FROM suppressions
+		WHERE customer_id = $1 AND email = $2
+		ORDER BY category
+	`, customerID, normalizeEmail(email))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Suppression{}
+	for rows.Next() {
+		var s Suppression
+		if err := scanSuppression(rows, &s); err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+func DeleteSuppression(customerID int, email, category string) (bool, error) {
+	res, err := DB.Exec(`
+		DELETE FROM suppressions WHERE customer_id = $1 AND email = $2 AND category = $3
+	`, customerID, normalizeEmail(email), category)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ListSuppressions returns up to limit entries, newest first, starting after
+// the entry with ID afterID when it is non-zero.
+func ListSuppressions(customerID int, reason string, afterID, limit int) ([]Suppression, error) {
+	rows, err := DB.Query(`
+		SELECT `+suppressionColumns+`
+		FROM suppressions
+		WHERE customer_id = $1
+			AND ($2 = '' OR reason = $2)
+			AND ($3 = 0 OR id < $3)
+		ORDER BY id DESC
+		LIMIT $4
+	`, customerID, reason, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Suppression{}
+	for rows.Next() {
+		var s Suppression
+		if err := scanSuppression(rows, &s); err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+// StreamSuppressions calls fn for every entry, oldest first.
+func StreamSuppressions(customerID int, reason string, fn func(*Suppression) error) error {
+	rows, err := DB.Query(`
+		SELECT `+suppressionColumns+`
+		FROM suppressions
+		WHERE customer_id = $1 AND ($2 = '' OR reason = $2)
+		ORDER BY id
+	`, customerID, reason)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s Suppression
+		if err := scanSuppression(rows, &s); err != nil {
+			return err
+		}
+		if err := fn(&s); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ImportSuppressions adds entries in one transaction, skipping addresses
+// that are already suppressed. It returns the number of entries added.
+func ImportSuppressions(customerID int, entries []Suppression) (int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO suppressions (customer_id, email, category, reason, details)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (customer_id, email, category) DO NOTHING
+	`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	added := 0
+	for _, e := range entries {
+		res, err := stmt.Exec(customerID, normalizeEmail(e.Email), e.Category, e.Reason, e.Details)
+		if err != nil {
+			return 0, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			added++
+		}
+	}
+	return added, tx.Commit()
+}
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Webhook event types, and the email events they are sent for.
+const (
+	WebhookEventEmailOpened  = "email.opened"
+	WebhookEventEmailClicked = "email.clicked"
+)
+
+var webhookEventTypes = map[string]string{
+	EmailEventOpen:  WebhookEventEmailOpened,
+	EmailEventClick: WebhookEventEmailClicked,
+}
+
+// WebhookEventTypes lists the event types an endpoint can subscribe to.
+func WebhookEventTypes() []string {
+	return []string{WebhookEventEmailOpened, WebhookEventEmailClicked}
+}
+
+// WebhookEndpoint is a URL a customer's events are posted to. Secret signs
+// the requests; it is only filled in where it is needed to sign or when
+// the endpoint is created.
+type WebhookEndpoint struct {
+	ID         int       `json:"id"`
+	CustomerID int       `json:"customer_id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"-"`
+	EventTypes []string  `json:"event_types"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// WebhookPayload is the body posted for an event.
+type WebhookPayload struct {
+	Type      string           `json:"type"`
+	CreatedAt time.Time        `json:"created_at"`
+	Data      WebhookEmailData `json:"data"`
+}
+
+// WebhookEmailData describes the email an event is about. URL is set for
+// clicks.
+type WebhookEmailData struct {
+	EmailID   int      `json:"email_id"`
+	To        string   `json:"to"`
+	Tags      []string `json:"tags"`
+	URL       string   `json:"url,omitempty"`
+	UserAgent string   `json:"user_agent,omitempty"`
+}
+
+// WebhookDelivery is a pending post of an event to an endpoint, as claimed
+// by ClaimWebhookDeliveries. Attempts includes the current one.
+type WebhookDelivery struct {
+	ID        int64
+	EventType string
+	Payload   []byte
+	Attempts  int
+	URL       string
+	Secret    string
+}
+
+// CreateWebhookEndpoint stores an endpoint with its signing secret
+// encrypted.
+func CreateWebhookEndpoint(customerID int, url, secret string, eventTypes []string) (*WebhookEndpoint, error) {
+	sealed, err := encryptSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := &WebhookEndpoint{CustomerID: customerID, URL: url, Secret: secret, EventTypes: eventTypes}
+	err = DB.QueryRow(`
+		INSERT INTO webhook_endpoints (customer_id, url, secret, event_types)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`, customerID, url, sealed, pq.Array(eventTypes)).Scan(&endpoint.ID, &endpoint.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return endpoint, nil
+}
+
+// GetWebhookEndpoint returns sql.ErrNoRows when the customer has no such
+// endpoint. The secret is left out.
+func GetWebhookEndpoint(customerID, endpointID int) (*WebhookEndpoint, error) {
+	e := &WebhookEndpoint{}
+	err := DB.QueryRow(`
+		SELECT id, customer_id, url, event_types, created_at
+		FROM webhook_endpoints
+		WHERE id = $1 AND customer_id = $2
+	`, endpointID, customerID).Scan(&e.ID, &e.CustomerID, &e.URL, pq.Array(&e.EventTypes), &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// ListWebhookEndpoints returns the customer's endpoints, oldest first,
+// without their secrets.
+func ListWebhookEndpoints(customerID int) ([]WebhookEndpoint, error) {
+	rows, err := DB.Query(`
+		SELECT id, customer_id, url, event_types, created_at
+		FROM webhook_endpoints
+		WHERE customer_id = $1
+		ORDER BY id
+	`, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	endpoints := []WebhookEndpoint{}
+	for rows.Next() {
+		var e WebhookEndpoint
+		if err := rows.Scan(&e.ID, &e.CustomerID, &e.URL, pq.Array(&e.EventTypes), &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, rows.Err()
+}
+
+// DeleteWebhookEndpoint deletes an endpoint and its pending deliveries. It
+// returns sql.ErrNoRows when the customer has no such endpoint.
+func DeleteWebhookEndpoint(customerID, endpointID int) error {
+	result, err := DB.Exec(`DELETE FROM webhook_endpoints WHERE id = $1 AND customer_id = $2`, endpointID, customerID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// enqueueWebhooks queues the event for every endpoint of the customer
+// subscribed to it, in the transaction that records the event, so that
+// an event is delivered if and only if it was stored.
+func enqueueWebhooks(tx *sql.Tx, subject statsSubject, e EmailEvent) error {
+	eventType, ok := webhookEventTypes[e.Type]
+	if !ok {
+		return nil
+	}
+	payload, err := json.Marshal(WebhookPayload{
+		Type:      eventType,
+		CreatedAt: e.CreatedAt,
+		Data: WebhookEmailData{
+			EmailID:   e.EmailID,
+			To:        subject.ToEmail,
+			Tags:      append([]string{}, subject.Tags...),
+			URL:       e.URL,
+			UserAgent: e.UserAgent,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO webhook_deliveries (endpoint_id, event_type, payload, next_attempt_at)
+		SELECT id, $2, $3, $4
+		FROM webhook_endpoints
+		WHERE customer_id = $1 AND $2 = ANY(event_types)
+	`, subject.CustomerID, eventType, payload, e.CreatedAt)
+	return err
+}
+
+// ClaimWebhookDeliveries returns up to limit deliveries that are due and
+// counts an attempt for each. A claimed delivery is not due again until
+// lease has passed, so replicas polling at the same time never send the
+// same one, and a delivery whose replica stopped is retried.
+func ClaimWebhookDeliveries(limit int, lease time.Duration) ([]WebhookDelivery, error) {
+	now := time.Now().UTC()
+	rows, err := DB.Query(`
+		UPDATE webhook_deliveries d
+		SET attempts = d.attempts + 1, next_attempt_at = $2
+		FROM webhook_endpoints e
+		WHERE e.id = d.endpoint_id AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= $1
+			ORDER BY next_attempt_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.id, d.event_type, d.payload, d.attempts, e.url, e.secret
+	`, now, now.Add(lease), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		var sealed string
+		if err := rows.Scan(&d.ID, &d.EventType, &d.Payload, &d.Attempts, &d.URL, &sealed); err != nil {
+			return nil, err
+		}
+		if d.Secret, err = decryptSecret(sealed); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// FinishWebhookDelivery records the outcome of an attempt. A failed
+// attempt is retried at retryAt, or given up on when retryAt is nil.
+func FinishWebhookDelivery(deliveryID int64, deliveryErr error, retryAt *time.Time) error {
+	now := time.Now().UTC()
+	if deliveryErr == nil {
+		_, err := DB.Exec(`
+			UPDATE webhook_deliveries SET delivered_at = $1, last_error = NULL WHERE id = $2
+		`, now, deliveryID)
+		return err
+	}
+	if retryAt == nil {
+		_, err := DB.Exec(`
+			UPDATE webhook_deliveries SET failed_at = $1, last_error = $2 WHERE id = $3
+		`, now, deliveryErr.Error(), deliveryID)
+		return err
+	}
+	_, err := DB.Exec(`
+		UPDATE webhook_deliveries SET next_attempt_at = $1, last_error = $2 WHERE id = $3
+	`, retryAt.UTC(), deliveryErr.Error(), deliveryID)
+	return err
+}
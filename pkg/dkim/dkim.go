@@ -0,0 +1,129 @@
+// Package dkim generates DKIM keys, signs messages with relaxed/relaxed
+// canonicalization (RFC 6376, with Ed25519 from RFC 8463) and verifies
+// signatures against DNS records looked up by the caller.
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Key algorithms, as named in the k= tag of a DKIM record.
+const (
+	AlgorithmRSA     = "rsa"
+	AlgorithmEd25519 = "ed25519"
+)
+
+// rsaKeyBits is the size of generated RSA keys. 1024 bits is still common
+// but no longer considered safe; larger keys do not fit some DNS hosts.
+const rsaKeyBits = 2048
+
+// Key is a DKIM private key.
+type Key struct {
+	Algorithm string
+	signer    crypto.Signer
+}
+
+// GenerateKey creates a new RSA-2048 or Ed25519 key.
+func GenerateKey(algorithm string) (*Key, error) {
+	switch algorithm {
+	case AlgorithmRSA:
+		key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		return &Key{Algorithm: AlgorithmRSA, signer: key}, nil
+	case AlgorithmEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return &Key{Algorithm: AlgorithmEd25519, signer: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported DKIM algorithm %q", algorithm)
+	}
+}
+
+// MarshalPrivateKey encodes the key as a PKCS #8 PEM block.
+func (k *Key) MarshalPrivateKey() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.signer)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// ParsePrivateKey decodes a key from MarshalPrivateKey. PKCS #1 RSA keys,
+// as produced by openssl genrsa, are accepted too.
+func ParsePrivateKey(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block in DKIM private key")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return &Key{Algorithm: AlgorithmRSA, signer: key}, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &Key{Algorithm: AlgorithmRSA, signer: key}, nil
+	case ed25519.PrivateKey:
+		return &Key{Algorithm: AlgorithmEd25519, signer: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported DKIM key type %T", parsed)
+	}
+}
+
+// publicKeyData is the p= value of the key's DNS record: the DER
+// SubjectPublicKeyInfo for RSA and the raw 32-byte key for Ed25519.
+func (k *Key) publicKeyData() (string, error) {
+	if pub, ok := k.signer.Public().(ed25519.PublicKey); ok {
+		return base64.StdEncoding.EncodeToString(pub), nil
+	}
+	der, err := x509.MarshalPKIXPublicKey(k.signer.Public())
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(der), nil
+}
+
+// DNSRecord returns the TXT record value that publishes the public key.
+// RSA records are longer than 255 bytes; most DNS hosts split them into
+// several strings automatically, others need it done by hand.
+func (k *Key) DNSRecord() (string, error) {
+	p, err := k.publicKeyData()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("v=DKIM1; k=%s; p=%s", k.Algorithm, p), nil
+}
+
+// RecordName returns the DNS name the record for selector is published at.
+func RecordName(selector, domain string) string {
+	return selector + "._domainkey." + strings.TrimSuffix(domain, ".")
+}
+
+// NewSelector returns a selector for a key created at t. Selectors are
+// dated so a rotated key is easy to tell from the one it replaces, with a
+// random suffix so keys created the same day do not collide.
+func NewSelector(t time.Time) string {
+	b := make([]byte, 2)
+	rand.Read(b)
+	return fmt.Sprintf("bs%s%x", t.UTC().Format("20060102"), b)
+}
@@ -0,0 +1,268 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultHeaders are the headers signed when present. From is always
+// signed, and signed once more than it occurs so that another From cannot
+// be added without breaking the signature.
+var DefaultHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type",
+	"Content-Transfer-Encoding", "List-Unsubscribe", "List-Unsubscribe-Post",
+}
+
+// Signer signs messages for a domain with the key published under
+// Selector.
+type Signer struct {
+	Domain   string
+	Selector string
+	Key      *Key
+	// Headers lists the headers to sign; DefaultHeaders when nil.
+	Headers []string
+}
+
+// Sign returns msg with a DKIM-Signature header prepended. Line endings are
+// normalized to CRLF first, since the signature covers the message as it
+// is transmitted.
+func (s *Signer) Sign(msg []byte, now time.Time) ([]byte, error) {
+	if s.Key == nil || s.Domain == "" || s.Selector == "" {
+		return nil, errors.New("dkim: signer needs a domain, selector and key")
+	}
+	msg = normalizeCRLF(msg)
+	headers, body, err := splitMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	names := s.Headers
+	if names == nil {
+		names = DefaultHeaders
+	}
+	var signed []string
+	for _, name := range names {
+		count := 0
+		for _, h := range headers {
+			if strings.EqualFold(h.name, name) {
+				count++
+			}
+		}
+		if strings.EqualFold(name, "From") {
+			count++
+		}
+		for i := 0; i < count; i++ {
+			signed = append(signed, strings.ToLower(name))
+		}
+	}
+
+	algorithm := "rsa-sha256"
+	if s.Key.Algorithm == AlgorithmEd25519 {
+		algorithm = "ed25519-sha256"
+	}
+	value := fmt.Sprintf("v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s;\r\n\tt=%d; h=%s;\r\n\tbh=%s;\r\n\tb=",
+		algorithm, s.Domain, s.Selector, now.Unix(), foldList(signed),
+		base64.StdEncoding.EncodeToString(bodyHash[:]))
+
+	hash := sha256.New()
+	for _, h := range selectHeaders(headers, signed) {
+		hash.Write([]byte(relaxedHeader(h.name, h.value) + "\r\n"))
+	}
+	hash.Write([]byte(relaxedHeader("DKIM-Signature", value)))
+	sig, err := s.Key.sign(hash.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString("DKIM-Signature: " + value + foldBase64(base64.StdEncoding.EncodeToString(sig)) + "\r\n")
+	out.Write(msg)
+	return out.Bytes(), nil
+}
+
+// sign signs a SHA-256 digest. Ed25519 signs the digest itself as its
+// message, as RFC 8463 specifies.
+func (k *Key) sign(digest []byte) ([]byte, error) {
+	if _, ok := k.signer.(ed25519.PrivateKey); ok {
+		return k.signer.Sign(rand.Reader, digest, crypto.Hash(0))
+	}
+	return k.signer.Sign(rand.Reader, digest, crypto.SHA256)
+}
+
+type header struct {
+	name  string
+	value string
+}
+
+// splitMessage parses the header block, keeping each header's raw value
+// including folding, and returns the body.
+func splitMessage(msg []byte) ([]header, []byte, error) {
+	end := bytes.Index(msg, []byte("\r\n\r\n"))
+	var block, body []byte
+	switch {
+	case end >= 0:
+		block, body = msg[:end+2], msg[end+4:]
+	case bytes.HasSuffix(msg, []byte("\r\n")):
+		block = msg
+	default:
+		return nil, nil, errors.New("dkim: message has no header section")
+	}
+
+	var headers []header
+	for _, line := range strings.SplitAfter(string(block), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(headers) == 0 {
+				return nil, nil, errors.New("dkim: message starts with a continuation line")
+			}
+			headers[len(headers)-1].value += line
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, nil, fmt.Errorf("dkim: malformed header line %q", strings.TrimSpace(line))
+		}
+		headers = append(headers, header{name: name, value: value})
+	}
+	for i := range headers {
+		headers[i].value = strings.TrimSuffix(headers[i].value, "\r\n")
+	}
+	return headers, body, nil
+}
+
+// selectHeaders picks the headers named in signed. Repeated names take
+// instances from the bottom up, and names with no instance left sign as
+// nothing (RFC 6376 section 5.4.2).
+func selectHeaders(headers []header, signed []string) []header {
+	used := make([]bool, len(headers))
+	var out []header
+	for _, name := range signed {
+		for i := len(headers) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(headers[i].name, name) {
+				used[i] = true
+				out = append(out, headers[i])
+				break
+			}
+		}
+	}
+	return out
+}
+
+// relaxedHeader canonicalizes a header: lowercase name, unfolded value with
+// runs of whitespace reduced to one space and none around the colon or at
+// the end.
+func relaxedHeader(name, value string) string {
+	value = strings.NewReplacer("\r\n", "").Replace(value)
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.TrimSpace(collapseWSP(value))
+}
+
+// relaxedBody canonicalizes a body: whitespace runs reduced to one space,
+// trailing whitespace on each line and empty lines at the end removed.
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(collapseWSP(line), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// simpleBody canonicalizes a body with the simple algorithm: only empty
+// lines at the end are removed, and an empty body becomes a single CRLF.
+func simpleBody(body []byte) []byte {
+	for bytes.HasSuffix(body, []byte("\r\n\r\n")) {
+		body = body[:len(body)-2]
+	}
+	if len(body) == 0 || !bytes.HasSuffix(body, []byte("\r\n")) {
+		body = append(append([]byte(nil), body...), "\r\n"...)
+	}
+	return body
+}
+
+func collapseWSP(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// normalizeCRLF turns bare LF and bare CR into CRLF.
+func normalizeCRLF(msg []byte) []byte {
+	var out bytes.Buffer
+	out.Grow(len(msg))
+	for i := 0; i < len(msg); i++ {
+		switch c := msg[i]; {
+		case c == '\r' && i+1 < len(msg) && msg[i+1] == '\n':
+			out.WriteString("\r\n")
+			i++
+		case c == '\r' || c == '\n':
+			out.WriteString("\r\n")
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.Bytes()
+}
+
+// foldList joins signed header names, folding the line before it grows
+// past 78 characters.
+func foldList(names []string) string {
+	var b strings.Builder
+	line := 0
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(':')
+			line++
+			if line+len(name) > 60 {
+				b.WriteString("\r\n\t")
+				line = 0
+			}
+		}
+		b.WriteString(name)
+		line += len(name)
+	}
+	return b.String()
+}
+
+// foldBase64 breaks a signature into lines. The folding whitespace is part
+// of the b= value, which verifiers remove before hashing.
+func foldBase64(s string) string {
+	var b strings.Builder
+	for len(s) > 72 {
+		b.WriteString(s[:72] + "\r\n\t")
+		s = s[72:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
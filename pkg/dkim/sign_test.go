@@ -0,0 +1,148 @@
+package dkim
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMessage = "From: Sender <sender@example.test>\r\n" +
+	"To: rcpt@example.org\r\n" +
+	"Subject: Quarterly report is ready\r\n" +
+	"Date: Mon, 02 Sep 2024 10:00:00 +0000\r\n" +
+	"Message-ID: <besend.1.abc@example.test>\r\n" +
+	"\r\n" +
+	"Hello,\r\n" +
+	"\r\n" +
+	"The report is attached.\r\n"
+
+// testSigner returns a signer for example.test with a new key of the
+// given algorithm, and a lookup that serves its DNS record.
+func testSigner(t *testing.T, algorithm string) (*Signer, LookupTXT) {
+	t.Helper()
+	key, err := GenerateKey(algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := key.DNSRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &Signer{Domain: "example.test", Selector: "bs1", Key: key}
+	lookup := func(name string) ([]string, error) {
+		if name != RecordName("bs1", "example.test") {
+			return nil, fmt.Errorf("no TXT record at %s", name)
+		}
+		return []string{record}, nil
+	}
+	return signer, lookup
+}
+
+func signAndVerify(t *testing.T, signer *Signer, lookup LookupTXT, alter func(string) string) error {
+	t.Helper()
+	signed, err := signer.Sign([]byte(testMessage), time.Now())
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	results, err := Verify([]byte(alter(string(signed))), lookup)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d verifications, want 1", len(results))
+	}
+	if results[0].Domain != "example.test" || results[0].Selector != "bs1" {
+		t.Errorf("verified d=%s s=%s, want example.test and bs1", results[0].Domain, results[0].Selector)
+	}
+	return results[0].Err
+}
+
+func TestSignVerify(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmRSA, AlgorithmEd25519} {
+		t.Run(algorithm, func(t *testing.T) {
+			signer, lookup := testSigner(t, algorithm)
+			unchanged := func(s string) string { return s }
+			if err := signAndVerify(t, signer, lookup, unchanged); err != nil {
+				t.Errorf("signature did not verify: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyDetectsChanges(t *testing.T) {
+	tests := []struct {
+		name  string
+		alter func(string) string
+	}{
+		{"subject changed", func(s string) string {
+			return strings.Replace(s, "Subject: Quarterly report is ready", "Subject: Quarterly report is late", 1)
+		}},
+		{"from added", func(s string) string {
+			return strings.Replace(s, "To: rcpt@example.org\r\n", "To: rcpt@example.org\r\nFrom: attacker@evil.test\r\n", 1)
+		}},
+		{"body changed", func(s string) string {
+			return strings.Replace(s, "The report is attached.", "The report is attached!", 1)
+		}},
+		{"body line added", func(s string) string {
+			return s + "P.S. Wire the money.\r\n"
+		}},
+	}
+	for _, algorithm := range []string{AlgorithmRSA, AlgorithmEd25519} {
+		signer, lookup := testSigner(t, algorithm)
+		for _, tt := range tests {
+			t.Run(algorithm+"/"+tt.name, func(t *testing.T) {
+				if err := signAndVerify(t, signer, lookup, tt.alter); err == nil {
+					t.Error("altered message still verified")
+				}
+			})
+		}
+	}
+}
+
+// TestVerifyRelaxedCanonicalization checks that changes relaxed/relaxed
+// canonicalization ignores, as relays commonly make them, leave the
+// signature valid.
+func TestVerifyRelaxedCanonicalization(t *testing.T) {
+	tests := []struct {
+		name  string
+		alter func(string) string
+	}{
+		{"header refolded", func(s string) string {
+			return strings.Replace(s, "Subject: Quarterly report is ready", "Subject: Quarterly report\r\n\tis ready", 1)
+		}},
+		{"header whitespace and name case", func(s string) string {
+			return strings.Replace(s, "Subject: Quarterly report is ready", "SUBJECT:   Quarterly  report is ready  ", 1)
+		}},
+		{"trailing whitespace in body", func(s string) string {
+			return strings.Replace(s, "Hello,\r\n", "Hello,  \t\r\n", 1)
+		}},
+		{"whitespace run in body", func(s string) string {
+			return strings.Replace(s, "The report is attached.", "The report   is attached.", 1)
+		}},
+		{"trailing blank lines", func(s string) string {
+			return s + "\r\n\r\n"
+		}},
+		{"bare LF line endings", func(s string) string {
+			return strings.ReplaceAll(s, "\r\n", "\n")
+		}},
+	}
+	for _, algorithm := range []string{AlgorithmRSA, AlgorithmEd25519} {
+		signer, lookup := testSigner(t, algorithm)
+		for _, tt := range tests {
+			t.Run(algorithm+"/"+tt.name, func(t *testing.T) {
+				if err := signAndVerify(t, signer, lookup, tt.alter); err != nil {
+					t.Errorf("signature no longer verifies: %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestVerifyUnsigned(t *testing.T) {
+	_, lookup := testSigner(t, AlgorithmEd25519)
+	if _, err := Verify([]byte(testMessage), lookup); !errors.Is(err, ErrNoSignature) {
+		t.Errorf("Verify() error = %v, want ErrNoSignature", err)
+	}
+}
@@ -0,0 +1,200 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrNoSignature is returned by Verify for a message without a
+// DKIM-Signature header.
+var ErrNoSignature = errors.New("dkim: message is not signed")
+
+// LookupTXT returns the TXT records at a DNS name, like net.LookupTXT.
+type LookupTXT func(name string) ([]string, error)
+
+// Verification is the outcome of checking one DKIM-Signature header.
+type Verification struct {
+	Domain   string
+	Selector string
+	Err      error
+}
+
+// Verify checks every DKIM-Signature header of msg and reports each one.
+// It supports the rsa-sha256 and ed25519-sha256 algorithms with simple and
+// relaxed canonicalization, which is enough to check what Sign and common
+// senders produce; it does not enforce x= expiry or l= body limits.
+func Verify(msg []byte, lookup LookupTXT) ([]Verification, error) {
+	headers, body, err := splitMessage(normalizeCRLF(msg))
+	if err != nil {
+		return nil, err
+	}
+	var results []Verification
+	for i, h := range headers {
+		if !strings.EqualFold(h.name, "DKIM-Signature") {
+			continue
+		}
+		v := Verification{}
+		v.Domain, v.Selector, v.Err = verifySignature(headers[:i], headers[i+1:], h, body, lookup)
+		results = append(results, v)
+	}
+	if len(results) == 0 {
+		return nil, ErrNoSignature
+	}
+	return results, nil
+}
+
+var bTagValue = regexp.MustCompile(`(^|;)(\s*b\s*=)[^;]*`)
+
+func verifySignature(above, below []header, sigHeader header, body []byte, lookup LookupTXT) (string, string, error) {
+	tags, err := parseTags(sigHeader.value)
+	if err != nil {
+		return "", "", err
+	}
+	domain, selector := tags["d"], tags["s"]
+	if tags["v"] != "1" || domain == "" || selector == "" || tags["h"] == "" || tags["bh"] == "" || tags["b"] == "" {
+		return domain, selector, errors.New("dkim: signature is missing required tags")
+	}
+
+	headerCanon, bodyCanon, _ := strings.Cut(tags["c"], "/")
+	if headerCanon == "" {
+		headerCanon = "simple"
+	}
+	if bodyCanon == "" {
+		bodyCanon = "simple"
+	}
+	if (headerCanon != "simple" && headerCanon != "relaxed") || (bodyCanon != "simple" && bodyCanon != "relaxed") {
+		return domain, selector, fmt.Errorf("dkim: unsupported canonicalization %q", tags["c"])
+	}
+
+	canonBody := simpleBody(body)
+	if bodyCanon == "relaxed" {
+		canonBody = relaxedBody(body)
+	}
+	bodyHash := sha256.Sum256(canonBody)
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != stripWSP(tags["bh"]) {
+		return domain, selector, errors.New("dkim: body hash does not match")
+	}
+
+	// Every other header is a candidate, in message order, so that
+	// selectHeaders picks repeated ones from the bottom up.
+	candidates := append(append([]header(nil), above...), below...)
+	hash := sha256.New()
+	for _, h := range selectHeaders(candidates, strings.Split(stripWSP(tags["h"]), ":")) {
+		hash.Write([]byte(canonHeader(headerCanon, h) + "\r\n"))
+	}
+	unsigned := header{name: sigHeader.name, value: bTagValue.ReplaceAllString(sigHeader.value, "$1$2")}
+	hash.Write([]byte(canonHeader(headerCanon, unsigned)))
+
+	signature, err := base64.StdEncoding.DecodeString(stripWSP(tags["b"]))
+	if err != nil {
+		return domain, selector, errors.New("dkim: signature is not base64")
+	}
+	key, err := lookupKey(lookup, selector, domain)
+	if err != nil {
+		return domain, selector, err
+	}
+	digest := hash.Sum(nil)
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if tags["a"] != "rsa-sha256" {
+			return domain, selector, fmt.Errorf("dkim: algorithm %q does not match an RSA key", tags["a"])
+		}
+		err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, signature)
+	case ed25519.PublicKey:
+		if tags["a"] != "ed25519-sha256" {
+			return domain, selector, fmt.Errorf("dkim: algorithm %q does not match an Ed25519 key", tags["a"])
+		}
+		if !ed25519.Verify(pub, digest, signature) {
+			err = errors.New("verification failed")
+		}
+	}
+	if err != nil {
+		return domain, selector, fmt.Errorf("dkim: signature does not verify: %w", err)
+	}
+	return domain, selector, nil
+}
+
+func canonHeader(canon string, h header) string {
+	if canon == "relaxed" {
+		return relaxedHeader(h.name, h.value)
+	}
+	return h.name + ":" + h.value
+}
+
+// lookupKey fetches and parses the public key record for a selector.
+func lookupKey(lookup LookupTXT, selector, domain string) (crypto.PublicKey, error) {
+	records, err := lookup(RecordName(selector, domain))
+	if err != nil {
+		return nil, fmt.Errorf("dkim: key lookup failed: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, errors.New("dkim: no key record")
+	}
+	tags, err := parseTags(records[0])
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := tags["v"]; ok && v != "DKIM1" {
+		return nil, fmt.Errorf("dkim: unsupported key record version %q", v)
+	}
+	data, err := base64.StdEncoding.DecodeString(stripWSP(tags["p"]))
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("dkim: key record has no usable public key")
+	}
+	switch tags["k"] {
+	case "", AlgorithmRSA:
+		key, err := x509.ParsePKIXPublicKey(data)
+		if err != nil {
+			// Some records hold a bare PKCS #1 key.
+			if rsaKey, err := x509.ParsePKCS1PublicKey(data); err == nil {
+				return rsaKey, nil
+			}
+			return nil, fmt.Errorf("dkim: invalid RSA key: %w", err)
+		}
+		if _, ok := key.(*rsa.PublicKey); !ok {
+			return nil, errors.New("dkim: key record is not an RSA key")
+		}
+		return key, nil
+	case AlgorithmEd25519:
+		if len(data) != ed25519.PublicKeySize {
+			return nil, errors.New("dkim: invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(data), nil
+	default:
+		return nil, fmt.Errorf("dkim: unsupported key type %q", tags["k"])
+	}
+}
+
+// parseTags parses a tag=value list as used in signatures and records.
+func parseTags(s string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("dkim: malformed tag %q", part)
+		}
+		tags[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return tags, nil
+}
+
+func stripWSP(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, s)
+}
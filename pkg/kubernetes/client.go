@@ -9,6 +9,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -87,3 +88,57 @@ func (k *K8sClient) ListEmails(namespace string) ([]emailv1alpha1.Email, error)
 
 	return emailList.Items, nil
 }
+
+// maxDeliveryEvents caps the events kept in an Email's status.
+const maxDeliveryEvents = 20
+
+// deliveryTransitions lists the statuses each webhook-reported status may
+// replace, so events arriving out of order never move an Email backwards.
+var deliveryTransitions = map[string][]string{
+	"Delivered":  {"Sent"},
+	"Bounced":    {"Sent", "Delivered"},
+	"Complained": {"Sent", "Delivered", "Bounced"},
+}
+
+// RecordDeliveryEvent appends a provider event to the Email labelled with
+// providerMessageID, in any namespace, and moves it to deliveryStatus when
+// that is a later stage. It reports false when no Email matches.
+func (k *K8sClient) RecordDeliveryEvent(ctx context.Context, providerMessageID string, event emailv1alpha1.DeliveryEvent, deliveryStatus string) (bool, error) {
+	emailList := &emailv1alpha1.EmailList{}
+	if err := k.client.List(ctx, emailList, client.MatchingLabels{
+		emailv1alpha1.ProviderMessageIDLabel: providerMessageID,
+	}); err != nil {
+		return false, fmt.Errorf("failed to list emails: %w", err)
+	}
+	if len(emailList.Items) == 0 {
+		return false, nil
+	}
+
+	key := client.ObjectKeyFromObject(&emailList.Items[0])
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		email := &emailv1alpha1.Email{}
+		if err := k.client.Get(ctx, key, email); err != nil {
+			return err
+		}
+		for _, e := range email.Status.Events {
+			if e.Type == event.Type && e.Timestamp.Equal(&event.Timestamp) {
+				return nil
+			}
+		}
+		email.Status.Events = append(email.Status.Events, event)
+		if n := len(email.Status.Events); n > maxDeliveryEvents {
+			email.Status.Events = email.Status.Events[n-maxDeliveryEvents:]
+		}
+		for _, from := range deliveryTransitions[deliveryStatus] {
+			if email.Status.DeliveryStatus == from {
+				email.Status.DeliveryStatus = deliveryStatus
+				break
+			}
+		}
+		return k.client.Status().Update(ctx, email)
+	})
+	if err != nil {
+		return true, fmt.Errorf("failed to update email status: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,69 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Usage describes the arguments Run accepts.
+const Usage = "migrate [up | down [steps] | status]"
+
+// Run carries out a migrate subcommand: "up" (the default) applies pending
+// migrations, "down" reverts the last one or the given number of steps,
+// and "status" lists every migration. It reports to w.
+func Run(ctx context.Context, m *Migrator, args []string, w io.Writer) error {
+	command := "up"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "up":
+		if len(args) > 0 {
+			return fmt.Errorf("usage: %s", Usage)
+		}
+		n, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Applied %d migrations\n", n)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			return fmt.Errorf("usage: %s", Usage)
+		}
+		if len(args) == 1 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				return fmt.Errorf("steps must be a positive number, got %q", args[0])
+			}
+			steps = n
+		}
+		n, err := m.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Reverted %d migrations\n", n)
+	case "status":
+		if len(args) > 0 {
+			return fmt.Errorf("usage: %s", Usage)
+		}
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = "applied " + s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\n", s.Migration, applied)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q (usage: %s)", command, Usage)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// sealedPrefix marks a value produced by Keyring.Encrypt. Values without it are
+// treated as legacy plaintext.
+const sealedPrefix = "enc:v1:"
+
+var ErrUnknownKey = errors.New("unknown key-encryption key")
+
+// Keyring holds the key-encryption keys (KEKs) used for envelope encryption.
+// Every value is encrypted with a fresh data key, and that data key is wrapped
+// with the active KEK. The KEK ID is stored alongside the ciphertext so older
+// keys can stay in the ring for decryption while values are re-encrypted.
+type Keyring struct {
+	activeID string
+	keys     map[string][]byte
+}
+
+// NewKeyring builds a keyring from raw 32-byte AES keys. activeID selects the
+// key used for new encryptions.
+func NewKeyring(activeID string, keys map[string][]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no key-encryption keys provided")
+	}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %q must be 32 bytes, got %d", id, len(key))
+		}
+	}
+	if _, ok := keys[activeID]; !ok {
+		return nil, fmt.Errorf("active key %q not found in keyring", activeID)
+	}
+	return &Keyring{activeID: activeID, keys: keys}, nil
+}
+
+// LoadKeyring reads KEKs from SECRETS_KEK, or from the file named by
+// SECRETS_KEK_FILE. Both use the form "id:base64key", separated by commas or
+// newlines. SECRETS_ACTIVE_KEY_ID picks the active key and defaults to the
+// first one listed.
+func LoadKeyring() (*Keyring, error) {
+	spec := os.Getenv("SECRETS_KEK")
+	if path := os.Getenv("SECRETS_KEK_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		spec = string(data)
+	}
+	if strings.TrimSpace(spec) == "" {
+		return nil, errors.New("no key-encryption keys configured (set SECRETS_KEK or SECRETS_KEK_FILE)")
+	}
+
+	keys := make(map[string][]byte)
+	var first string
+	for _, entry := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid key entry %q, expected id:base64key", entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 for key %q: %w", id, err)
+		}
+		if _, dup := keys[id]; dup {
+			return nil, fmt.Errorf("duplicate key id %q", id)
+		}
+		if first == "" {
+			first = id
+		}
+		keys[id] = key
+	}
+
+	activeID := os.Getenv("SECRETS_ACTIVE_KEY_ID")
+	if activeID == "" {
+		activeID = first
+	}
+	return NewKeyring(activeID, keys)
+}
+
+// ActiveKeyID returns the ID of the key used for new encryptions.
+func (k *Keyring) ActiveKeyID() string {
+	return k.activeID
+}
+
+// KeyIDs returns the IDs of all keys in the ring, sorted.
+func (k *Keyring) KeyIDs() []string {
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Encrypt seals plaintext under a new data key wrapped with the active KEK.
+func (k *Keyring) Encrypt(plaintext []byte) (string, error) {
+	dek := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	wrapped, err := seal(k.keys[k.activeID], dek, []byte(k.activeID))
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	ciphertext, err := seal(dek, plaintext, wrapped)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt value: %w", err)
+	}
+
+	return sealedPrefix + k.activeID + ":" +
+		base64.RawStdEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// EncryptString is a convenience wrapper around Encrypt.
+func (k *Keyring) EncryptString(plaintext string) (string, error) {
+	return k.Encrypt([]byte(plaintext))
+}
+
+// Decrypt opens a value produced by Encrypt.
+func (k *Keyring) Decrypt(sealed string) ([]byte, error) {
+	id, wrapped, ciphertext, err := parse(sealed)
+	if err != nil {
+		return nil, err
+	}
+	kek, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+
+	dek, err := open(kek, wrapped, []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	plaintext, err := open(dek, ciphertext, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value: %w", err)
+	}
+	return plaintext, nil
+}
+
+// DecryptString is a convenience wrapper around Decrypt.
+func (k *Keyring) DecryptString(sealed string) (string, error) {
+	plaintext, err := k.Decrypt(sealed)
+	return string(plaintext), err
+}
+
+// NeedsRotation reports whether value is legacy plaintext or sealed with a
+// key other than the active one.
+func (k *Keyring) NeedsRotation(value string) bool {
+	id, ok := KeyID(value)
+	return !ok || id != k.activeID
+}
+
+// IsSealed reports whether value was produced by Encrypt.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// KeyID returns the ID of the KEK a sealed value was wrapped with.
+func KeyID(value string) (string, bool) {
+	id, _, _, err := parse(value)
+	if err != nil {
+		return "", false
+	}
+	return id, true
+}
+
+func parse(sealed string) (string, []byte, []byte, error) {
+	if !IsSealed(sealed) {
+		return "", nil, nil, errors.New("value is not encrypted")
+	}
+	parts := strings.Split(strings.TrimPrefix(sealed, sealedPrefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, errors.New("malformed encrypted value")
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("malformed data key: %w", err)
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("malformed ciphertext: %w", err)
+	}
+	return parts[0], wrapped, ciphertext, nil
+}
+
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
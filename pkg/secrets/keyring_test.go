@@ -0,0 +1,210 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testKey returns a 32-byte key filled with b.
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func testKeyring(t *testing.T, activeID string, keys map[string][]byte) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(activeID, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	k := testKeyring(t, "k1", map[string][]byte{"k1": testKey(1)})
+	tests := []struct {
+		name      string
+		plaintext string
+	}{
+		{"empty", ""},
+		{"password", "s3cret"},
+		{"separators", "a:b:c enc:v1:"},
+		{"binary", "\x00\xff\n\r"},
+		{"long", strings.Repeat("x", 10000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed, err := k.EncryptString(tt.plaintext)
+			if err != nil {
+				t.Fatalf("EncryptString: %v", err)
+			}
+			if !IsSealed(sealed) {
+				t.Errorf("%q is not marked as sealed", sealed)
+			}
+			if id, ok := KeyID(sealed); !ok || id != "k1" {
+				t.Errorf("KeyID = %q, %t; want k1", id, ok)
+			}
+			if tt.plaintext != "" && strings.Contains(sealed, tt.plaintext) {
+				t.Errorf("sealed value %q contains the plaintext", sealed)
+			}
+			got, err := k.DecryptString(sealed)
+			if err != nil {
+				t.Fatalf("DecryptString: %v", err)
+			}
+			if got != tt.plaintext {
+				t.Errorf("DecryptString = %q, want %q", got, tt.plaintext)
+			}
+		})
+	}
+
+	// Every value gets its own data key and nonces.
+	a, _ := k.EncryptString("same")
+	b, _ := k.EncryptString("same")
+	if a == b {
+		t.Error("encrypting the same value twice gave the same result")
+	}
+}
+
+func TestKeyringDecryptWithWrongKey(t *testing.T) {
+	sealed, err := testKeyring(t, "k1", map[string][]byte{"k1": testKey(1)}).EncryptString("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		keyring *Keyring
+		unknown bool
+	}{
+		{"missing key id", testKeyring(t, "k2", map[string][]byte{"k2": testKey(2)}), true},
+		{"same id, different key", testKeyring(t, "k1", map[string][]byte{"k1": testKey(2)}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.keyring.DecryptString(sealed)
+			if err == nil {
+				t.Fatal("DecryptString succeeded, want an error")
+			}
+			if got := errors.Is(err, ErrUnknownKey); got != tt.unknown {
+				t.Errorf("errors.Is(%v, ErrUnknownKey) = %t, want %t", err, got, tt.unknown)
+			}
+		})
+	}
+}
+
+func TestKeyringDetectsTampering(t *testing.T) {
+	k := testKeyring(t, "k1", map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+	sealed, err := k.EncryptString("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(strings.TrimPrefix(sealed, sealedPrefix), ":")
+
+	// flip changes one byte of a base64 field.
+	flip := func(field string, i int) string {
+		raw, err := base64.RawStdEncoding.DecodeString(field)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw[i] ^= 0x01
+		return base64.RawStdEncoding.EncodeToString(raw)
+	}
+	flipLast := func(field string) string {
+		raw, _ := base64.RawStdEncoding.DecodeString(field)
+		return flip(field, len(raw)-1)
+	}
+	tests := []struct {
+		name   string
+		sealed string
+	}{
+		{"ciphertext byte", sealedPrefix + parts[0] + ":" + parts[1] + ":" + flip(parts[2], len(parts[2])/2)},
+		{"nonce", sealedPrefix + parts[0] + ":" + parts[1] + ":" + flip(parts[2], 0)},
+		{"authentication tag", sealedPrefix + parts[0] + ":" + parts[1] + ":" + flipLast(parts[2])},
+		{"wrapped data key", sealedPrefix + parts[0] + ":" + flip(parts[1], 20) + ":" + parts[2]},
+		{"key id swapped", sealedPrefix + "k2:" + parts[1] + ":" + parts[2]},
+		{"ciphertext truncated", sealedPrefix + parts[0] + ":" + parts[1] + ":" + parts[2][:8]},
+		{"field missing", sealedPrefix + parts[0] + ":" + parts[2]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := k.DecryptString(tt.sealed); err == nil {
+				t.Errorf("DecryptString = %q, want an error", got)
+			}
+		})
+	}
+}
+
+// TestKeyringRotation re-encrypts a value the way ReencryptSecrets does
+// after a new KEK is made active, with the old key kept for decryption.
+func TestKeyringRotation(t *testing.T) {
+	old := testKeyring(t, "k1", map[string][]byte{"k1": testKey(1)})
+	rotated := testKeyring(t, "k2", map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+	retired := testKeyring(t, "k2", map[string][]byte{"k2": testKey(2)})
+
+	sealed, err := old.EncryptString("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		keyring *Keyring
+		value   string
+		want    bool
+	}{
+		{"legacy plaintext", rotated, "s3cret", true},
+		{"sealed with the old key", rotated, sealed, true},
+		{"sealed with the active key", old, sealed, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.keyring.NeedsRotation(tt.value); got != tt.want {
+				t.Errorf("NeedsRotation = %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	plaintext, err := rotated.DecryptString(sealed)
+	if err != nil {
+		t.Fatalf("decrypting under the old key: %v", err)
+	}
+	resealed, err := rotated.EncryptString(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := KeyID(resealed); id != "k2" {
+		t.Errorf("re-encrypted under %q, want k2", id)
+	}
+	if rotated.NeedsRotation(resealed) {
+		t.Error("re-encrypted value still needs rotation")
+	}
+
+	// Once every value is re-encrypted the old key can be dropped.
+	if got, err := retired.DecryptString(resealed); err != nil || got != "s3cret" {
+		t.Errorf("without the old key: DecryptString = %q, %v; want s3cret", got, err)
+	}
+	if _, err := retired.DecryptString(sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("value not re-encrypted: error = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestNewKeyringRejectsInvalidKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		activeID string
+		keys     map[string][]byte
+	}{
+		{"no keys", "k1", nil},
+		{"short key", "k1", map[string][]byte{"k1": testKey(1)[:16]}},
+		{"colon in id", "k:1", map[string][]byte{"k:1": testKey(1)}},
+		{"empty id", "", map[string][]byte{"": testKey(1)}},
+		{"active key missing", "k2", map[string][]byte{"k1": testKey(1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKeyring(tt.activeID, tt.keys); err == nil {
+				t.Error("NewKeyring succeeded, want an error")
+			}
+		})
+	}
+}
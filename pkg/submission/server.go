@@ -0,0 +1,479 @@
+// Package submission is an SMTP submission server (RFC 6409) for mail
+// user agents and applications. Clients must authenticate, over TLS, before
+// they may send; what an account may send and what happens to accepted
+// messages is up to the caller.
+package submission
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Account is the authenticated sender of a session.
+type Account struct {
+	Username       string
+	CredentialID   string
+	OrganizationID string
+	CustomerID     string
+}
+
+// AuthFunc checks a username and password given by the client at remote.
+// It returns ErrInvalidCredentials when they do not match, or a Rejection
+// to refuse them with its own reply; other errors are treated as
+// temporary.
+type AuthFunc func(remote net.Addr, username, password string) (*Account, error)
+
+// SenderFunc decides whether account may use from as its envelope sender.
+type SenderFunc func(account *Account, from string) error
+
+// HandlerFunc receives one message accepted from account, with CRLF line
+// endings and dot-stuffing removed. The message has been handed over once
+// it returns nil.
+type HandlerFunc func(account *Account, from string, to []string, data []byte) error
+
+// ErrInvalidCredentials is returned by an AuthFunc for a wrong username or
+// password.
+var ErrInvalidCredentials = errors.New("submission: invalid credentials")
+
+// Rejection is an error that is answered with its own reply, usually a
+// permanent refusal. Errors of other types returned by a SenderFunc or
+// HandlerFunc are answered with a generic temporary failure, so the client
+// retries later.
+type Rejection struct {
+	Code    int
+	Message string
+}
+
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("%d %s", r.Code, r.Message)
+}
+
+// Server accepts authenticated submissions.
+type Server struct {
+	Addr     string
+	Hostname string
+	// TLSConfig enables STARTTLS. AUTH is refused until TLS is active
+	// unless AllowInsecureAuth is set.
+	TLSConfig *tls.Config
+	// AllowInsecureAuth permits AUTH without TLS. It is meant for local
+	// testing, as the password is sent in the clear.
+	AllowInsecureAuth bool
+	MaxSize           int64
+	MaxRecipients     int
+	Timeout           time.Duration
+	Auth              AuthFunc
+	CheckSender       SenderFunc
+	Handler           HandlerFunc
+
+	mu       sync.Mutex
+	listener net.Listener
+	closed   bool
+}
+
+const (
+	defaultMaxSize       = 25 << 20
+	defaultMaxRecipients = 100
+	defaultTimeout       = 5 * time.Minute
+	// maxAuthFailures is how many failed AUTH attempts end a session.
+	maxAuthFailures = 3
+)
+
+var ErrServerClosed = errors.New("submission: server closed")
+
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Close is called.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops accepting connections. Sessions in progress run to
+// completion.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+// session is the state of one connection.
+type session struct {
+	s        *Server
+	conn     net.Conn
+	text     *textproto.Conn
+	timeout  time.Duration
+	hostname string
+	maxSize  int64
+	tls      bool
+
+	account      *Account
+	authFailures int
+	from         *string
+	rcpts        []string
+}
+
+func (c *session) reply(code int, msg string) error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return c.text.PrintfLine("%d %s", code, msg)
+}
+
+// replyError answers a failed SenderFunc or HandlerFunc.
+func (c *session) replyError(err error, what string) error {
+	var rejection *Rejection
+	if errors.As(err, &rejection) {
+		return c.reply(rejection.Code, rejection.Message)
+	}
+	log.Printf("submission: %s failed: %v", what, err)
+	return c.reply(451, "4.3.0 Temporary failure, try again later")
+}
+
+func (c *session) readLine() (string, error) {
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	return c.text.ReadLine()
+}
+
+func (c *session) reset() {
+	c.from = nil
+	c.rcpts = nil
+}
+
+func (c *session) authAllowed() bool {
+	return c.tls || c.s.AllowInsecureAuth
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() { conn.Close() }()
+
+	c := &session{
+		s:        s,
+		conn:     conn,
+		text:     textproto.NewConn(conn),
+		timeout:  s.Timeout,
+		hostname: s.Hostname,
+		maxSize:  s.MaxSize,
+	}
+	if c.hostname == "" {
+		c.hostname = "localhost"
+	}
+	if c.maxSize <= 0 {
+		c.maxSize = defaultMaxSize
+	}
+	if c.timeout <= 0 {
+		c.timeout = defaultTimeout
+	}
+	maxRcpts := s.MaxRecipients
+	if maxRcpts <= 0 {
+		maxRcpts = defaultMaxRecipients
+	}
+
+	if err := c.reply(220, c.hostname+" ESMTP submission"); err != nil {
+		return
+	}
+
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			c.reset()
+			err = c.reply(250, c.hostname)
+		case "EHLO":
+			c.reset()
+			lines := []string{c.hostname, "PIPELINING", "8BITMIME", "ENHANCEDSTATUSCODES", fmt.Sprintf("SIZE %d", c.maxSize)}
+			if s.TLSConfig != nil && !c.tls {
+				lines = append(lines, "STARTTLS")
+			}
+			if c.authAllowed() {
+				lines = append(lines, "AUTH PLAIN LOGIN")
+			}
+			var b strings.Builder
+			for i, l := range lines {
+				sep := "-"
+				if i == len(lines)-1 {
+					sep = " "
+				}
+				fmt.Fprintf(&b, "250%s%s\r\n", sep, l)
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+			_, err = c.text.W.WriteString(b.String())
+			if err == nil {
+				err = c.text.W.Flush()
+			}
+		case "STARTTLS":
+			switch {
+			case s.TLSConfig == nil:
+				err = c.reply(502, "5.5.1 STARTTLS not available")
+			case c.tls:
+				err = c.reply(503, "5.5.1 TLS already active")
+			default:
+				if err = c.reply(220, "2.0.0 Ready to start TLS"); err != nil {
+					return
+				}
+				tlsConn := tls.Server(c.conn, s.TLSConfig)
+				tlsConn.SetDeadline(time.Now().Add(c.timeout))
+				if err := tlsConn.Handshake(); err != nil {
+					return
+				}
+				// The session starts over, as if newly connected
+				// (RFC 3207 section 4.2).
+				c.conn = tlsConn
+				conn = tlsConn
+				c.text = textproto.NewConn(tlsConn)
+				c.tls = true
+				c.account = nil
+				c.reset()
+			}
+		case "AUTH":
+			err = c.auth(arg)
+			if c.authFailures >= maxAuthFailures {
+				c.reply(421, "4.7.0 Too many failed authentication attempts")
+				return
+			}
+		case "MAIL":
+			addr, ok := pathArg(arg, "FROM:")
+			switch {
+			case c.account == nil:
+				err = c.reply(530, "5.7.0 Authentication required")
+			case !ok:
+				err = c.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+			case addr == "":
+				err = c.reply(553, "5.7.1 A sender address is required")
+			case declaredSize(arg) > c.maxSize:
+				err = c.reply(552, "5.3.4 Message too big")
+			default:
+				if senderErr := s.CheckSender(c.account, addr); senderErr != nil {
+					err = c.replyError(senderErr, "sender check")
+					break
+				}
+				c.reset()
+				c.from = &addr
+				err = c.reply(250, "2.1.0 OK")
+			}
+		case "RCPT":
+			addr, ok := pathArg(arg, "TO:")
+			switch {
+			case c.from == nil:
+				err = c.reply(503, "5.5.1 MAIL first")
+			case !ok || !strings.Contains(addr, "@"):
+				err = c.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+			case len(c.rcpts) >= maxRcpts:
+				err = c.reply(452, "4.5.3 Too many recipients")
+			default:
+				c.rcpts = append(c.rcpts, addr)
+				err = c.reply(250, "2.1.5 OK")
+			}
+		case "DATA":
+			if len(c.rcpts) == 0 {
+				err = c.reply(503, "5.5.1 RCPT first")
+				break
+			}
+			if err = c.reply(354, "End data with <CR><LF>.<CR><LF>"); err != nil {
+				return
+			}
+			c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+			dot := c.text.DotReader()
+			data, readErr := io.ReadAll(io.LimitReader(dot, c.maxSize+1))
+			switch {
+			case readErr != nil:
+				return
+			case int64(len(data)) > c.maxSize:
+				// Drain the rest so the session stays in sync.
+				if _, err := io.Copy(io.Discard, dot); err != nil {
+					return
+				}
+				err = c.reply(552, "5.3.4 Message too big")
+			default:
+				// The dot reader leaves bare LF line endings.
+				data = bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+				if handleErr := s.Handler(c.account, *c.from, c.rcpts, data); handleErr != nil {
+					err = c.replyError(handleErr, "handling message")
+				} else {
+					err = c.reply(250, "2.0.0 OK queued")
+				}
+			}
+			c.reset()
+		case "RSET":
+			c.reset()
+			err = c.reply(250, "2.0.0 OK")
+		case "NOOP":
+			err = c.reply(250, "2.0.0 OK")
+		case "VRFY":
+			err = c.reply(252, "2.5.2 Cannot verify")
+		case "QUIT":
+			c.reply(221, "2.0.0 Bye")
+			return
+		default:
+			err = c.reply(502, fmt.Sprintf("5.5.2 Command %q not implemented", verb))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// auth runs an AUTH PLAIN or AUTH LOGIN exchange (RFC 4954).
+func (c *session) auth(arg string) error {
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	switch {
+	case !c.authAllowed():
+		return c.reply(538, "5.7.11 Encryption required for requested authentication mechanism")
+	case c.account != nil:
+		return c.reply(503, "5.5.1 Already authenticated")
+	case c.from != nil:
+		return c.reply(503, "5.5.1 AUTH not permitted during a mail transaction")
+	}
+
+	var username, password string
+	switch strings.ToUpper(mechanism) {
+	case "PLAIN":
+		response, err := c.challenge(initial, "")
+		if err != nil {
+			return err
+		}
+		if response == nil {
+			return c.reply(501, "5.7.0 Authentication cancelled or malformed")
+		}
+		// authzid NUL authcid NUL passwd
+		parts := bytes.Split(response, []byte{0})
+		if len(parts) != 3 || (len(parts[0]) > 0 && !bytes.Equal(parts[0], parts[1])) {
+			return c.reply(501, "5.5.2 Malformed PLAIN response")
+		}
+		username, password = string(parts[1]), string(parts[2])
+	case "LOGIN":
+		user, err := c.challenge(initial, "Username:")
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return c.reply(501, "5.7.0 Authentication cancelled or malformed")
+		}
+		pass, err := c.challenge("", "Password:")
+		if err != nil {
+			return err
+		}
+		if pass == nil {
+			return c.reply(501, "5.7.0 Authentication cancelled or malformed")
+		}
+		username, password = string(user), string(pass)
+	default:
+		return c.reply(504, "5.5.4 Unrecognized authentication type")
+	}
+
+	account, err := c.s.Auth(c.conn.RemoteAddr(), username, password)
+	var rejection *Rejection
+	switch {
+	case errors.Is(err, ErrInvalidCredentials):
+		c.authFailures++
+		return c.reply(535, "5.7.8 Authentication credentials invalid")
+	case errors.As(err, &rejection):
+		c.authFailures++
+		return c.reply(rejection.Code, rejection.Message)
+	}
+	if err != nil {
+		log.Printf("submission: authentication failed: %v", err)
+		return c.reply(454, "4.7.0 Temporary authentication failure")
+	}
+	c.account = account
+	return c.reply(235, "2.7.0 Authentication successful")
+}
+
+// challenge returns the client's decoded response, sending prompt as a
+// 334 challenge unless an initial response was given. It returns nil when
+// the client cancels or the response is not valid base64.
+func (c *session) challenge(initial, prompt string) ([]byte, error) {
+	response := initial
+	if response == "" {
+		if err := c.reply(334, base64.StdEncoding.EncodeToString([]byte(prompt))); err != nil {
+			return nil, err
+		}
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		response = strings.TrimSpace(line)
+	}
+	if response == "*" {
+		return nil, nil
+	}
+	if response == "=" {
+		return []byte{}, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(response)
+	if err != nil {
+		return nil, nil
+	}
+	return decoded, nil
+}
+
+// pathArg extracts the address from "FROM:<addr> PARAMS" or "TO:<addr>".
+func pathArg(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	rest := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(rest, "<") {
+		return "", false
+	}
+	end := strings.Index(rest, ">")
+	if end < 0 {
+		return "", false
+	}
+	return rest[1:end], true
+}
+
+// declaredSize returns the SIZE parameter of a MAIL command, or zero.
+func declaredSize(arg string) int64 {
+	for _, param := range strings.Fields(arg) {
+		if name, value, ok := strings.Cut(param, "="); ok && strings.EqualFold(name, "SIZE") {
+			size, _ := strconv.ParseInt(value, 10, 64)
+			return size
+		}
+	}
+	return 0
+}
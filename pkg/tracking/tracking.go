@@ -0,0 +1,107 @@
+// Package tracking instruments HTML email bodies with an open pixel and
+// signed click redirects, and verifies the resulting URLs when they are
+// requested.
+package tracking
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidToken = errors.New("invalid tracking token")
+
+// Signer builds tracking URLs under BaseURL, the public address of the API
+// without a trailing slash.
+type Signer struct {
+	key     []byte
+	baseURL string
+}
+
+func NewSigner(key []byte, baseURL string) (*Signer, error) {
+	if len(key) < 32 {
+		return nil, errors.New("tracking signing key must be at least 32 bytes")
+	}
+	if baseURL == "" {
+		return nil, errors.New("tracking requires a public base URL")
+	}
+	return &Signer{key: key, baseURL: strings.TrimRight(baseURL, "/")}, nil
+}
+
+// Tokens are "<email id>.<signature>"; click signatures also cover the
+// target URL so redirects cannot be pointed elsewhere.
+func (s *Signer) token(kind string, emailID int, target string) string {
+	m := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(m, "tracking:v1:%s:%d:%s", kind, emailID, target)
+	return strconv.Itoa(emailID) + "." + base64.RawURLEncoding.EncodeToString(m.Sum(nil)[:16])
+}
+
+func (s *Signer) verify(kind, token, target string) (int, error) {
+	id, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, ErrInvalidToken
+	}
+	emailID, err := strconv.Atoi(id)
+	if err != nil || emailID < 1 {
+		return 0, ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(token), []byte(s.token(kind, emailID, target))) {
+		return 0, ErrInvalidToken
+	}
+	return emailID, nil
+}
+
+func (s *Signer) OpenURL(emailID int) string {
+	return fmt.Sprintf("%s/t/o/%s", s.baseURL, s.token("open", emailID, ""))
+}
+
+func (s *Signer) ClickURL(emailID int, target string) string {
+	return fmt.Sprintf("%s/t/c/%s?u=%s", s.baseURL, s.token("click", emailID, target), url.QueryEscape(target))
+}
+
+// VerifyOpen returns the email ID of a valid open token.
+func (s *Signer) VerifyOpen(token string) (int, error) {
+	return s.verify("open", token, "")
+}
+
+// VerifyClick returns the email ID of a valid click token for target.
+func (s *Signer) VerifyClick(token, target string) (int, error) {
+	return s.verify("click", token, target)
+}
+
+var (
+	linkPattern = regexp.MustCompile(`(?i)(<a\s[^>]*?\bhref\s*=\s*)(["'])(https?://[^"']+)(["'])`)
+	bodyClose   = regexp.MustCompile(`(?i)</body\s*>`)
+)
+
+// Instrument rewrites http(s) links in an HTML body through click redirects
+// when clicks is set, and adds an open pixel when opens is set. Links under
+// skipPrefix, such as unsubscribe links, are left alone.
+func (s *Signer) Instrument(body string, emailID int, opens, clicks bool, skipPrefix string) string {
+	if clicks {
+		body = linkPattern.ReplaceAllStringFunc(body, func(match string) string {
+			m := linkPattern.FindStringSubmatch(match)
+			target := html.UnescapeString(m[3])
+			if skipPrefix != "" && strings.HasPrefix(target, skipPrefix) {
+				return match
+			}
+			return m[1] + m[2] + html.EscapeString(s.ClickURL(emailID, target)) + m[4]
+		})
+	}
+	if opens {
+		pixel := fmt.Sprintf(`<img src="%s" width="1" height="1" alt="" style="display:none">`, html.EscapeString(s.OpenURL(emailID)))
+		if loc := bodyClose.FindStringIndex(body); loc != nil {
+			body = body[:loc[0]] + pixel + body[loc[0]:]
+		} else {
+			body += pixel
+		}
+	}
+	return body
+}
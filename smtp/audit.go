@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+
+	"github.com/Gatete-Bruno/besend/pkg/database"
+	"github.com/gin-gonic/gin"
+)
+
+const redacted = "[redacted]"
+
+// recordAudit writes an event to the API's audit log for the authenticated
+// customer. As in the API, failures are only logged, since the change has
+// already been made.
+func recordAudit(c *gin.Context, action, resourceType, resourceID string, changes map[string]database.AuditChange) {
+	customer := c.MustGet("customer").(*database.Customer)
+	event := &database.AuditEvent{
+		CustomerID:   customer.ID,
+		Actor:        fmt.Sprintf("customer:%d via %s", customer.ID, c.GetString("auth_method")),
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Changes:      changes,
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+	}
+	if err := database.RecordAuditEvent(event); err != nil {
+		log.Printf("Failed to record audit event %s for %s %s: %v", action, resourceType, resourceID, err)
+	}
+}
+
+// auditFields turns field values into changes: from nothing for a created
+// resource, or to nothing for a deleted one.
+func auditFields(fields map[string]interface{}, deleted bool) map[string]database.AuditChange {
+	changes := map[string]database.AuditChange{}
+	for field, value := range fields {
+		if deleted {
+			changes[field] = database.AuditChange{From: value}
+		} else {
+			changes[field] = database.AuditChange{To: value}
+		}
+	}
+	return changes
+}
+
+// addChange records a field in changes when its value differs.
+func addChange(changes map[string]database.AuditChange, field string, from, to interface{}) {
+	if !reflect.DeepEqual(from, to) {
+		changes[field] = database.AuditChange{From: from, To: to}
+	}
+}
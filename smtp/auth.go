@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Gatete-Bruno/besend/pkg/database"
+	"github.com/gin-gonic/gin"
+)
+
+// Routes are authenticated with the API's middleware, which sets the
+// customer from an API key or bearer token. Organizations and domains of
+// other customers are reported as not found rather than forbidden, so
+// their IDs cannot be probed.
+
+// currentCustomerID returns the authenticated customer's ID as stored in
+// organizations.customer_id.
+func currentCustomerID(c *gin.Context) string {
+	customer := c.MustGet("customer").(*database.Customer)
+	return strconv.Itoa(customer.ID)
+}
+
+// requireCustomer guards the routes that still take the customer ID in the
+// path, which must be the authenticated customer.
+func requireCustomer(c *gin.Context) {
+	if c.Param("id") != currentCustomerID(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// requireOrganization checks that the organization in the path belongs to
+// the authenticated customer.
+func requireOrganization(c *gin.Context) {
+	var exists bool
+	err := db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM organizations WHERE id = $1 AND customer_id = $2)",
+		c.Param("id"), currentCustomerID(c),
+	).Scan(&exists)
+	if err != nil {
+		log.Printf("Error querying organization: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch organization"})
+		c.Abort()
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// requireDomain checks that the sending domain in the path belongs to an
+// organization of the authenticated customer.
+func requireDomain(c *gin.Context) {
+	var exists bool
+	err := db.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM sending_domains d JOIN organizations o ON o.id = d.organization_id
+		WHERE d.id = $1 AND o.customer_id = $2)`,
+		c.Param("id"), currentCustomerID(c),
+	).Scan(&exists)
+	if err != nil {
+		log.Printf("Error querying domain: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch domain"})
+		c.Abort()
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found"})
+		c.Abort()
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Gatete-Bruno/besend/pkg/database"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	credentialActive  = "active"
+	credentialRevoked = "revoked"
+)
+
+// Rotation keeps the replaced password working for an overlap period, so
+// clients can be updated without failed sends.
+const (
+	defaultRotationOverlap = 24 * time.Hour
+	maxRotationOverlap     = 7 * 24 * time.Hour
+)
+
+// CredentialRestrictions limit what an SMTP credential may do. Empty
+// fields do not restrict.
+type CredentialRestrictions struct {
+	// AllowedDomains are the sending domains the credential may use,
+	// among its organization's verified ones.
+	AllowedDomains []string `json:"allowed_domains,omitempty"`
+	// AllowedCIDRs are the networks the credential may log in from.
+	AllowedCIDRs []string `json:"allowed_cidrs,omitempty"`
+	// HourlyLimit caps the recipients sent to in any hour.
+	HourlyLimit int `json:"hourly_limit,omitempty"`
+}
+
+// normalize lower-cases domains and checks the networks, turning single
+// addresses into /32 or /128 networks.
+func (r *CredentialRestrictions) normalize() error {
+	for i, domain := range r.AllowedDomains {
+		domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+		if domain == "" || strings.ContainsAny(domain, "@ ") {
+			return fmt.Errorf("invalid allowed domain %q", r.AllowedDomains[i])
+		}
+		r.AllowedDomains[i] = domain
+	}
+	for i, cidr := range r.AllowedCIDRs {
+		cidr = strings.TrimSpace(cidr)
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			cidr = fmt.Sprintf("%s/%d", ip, bits)
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid allowed CIDR %q", r.AllowedCIDRs[i])
+		}
+		r.AllowedCIDRs[i] = network.String()
+	}
+	if r.HourlyLimit < 0 {
+		return fmt.Errorf("hourly_limit must not be negative")
+	}
+	return nil
+}
+
+// allowsDomain reports whether the restrictions permit sending from domain.
+func (r *CredentialRestrictions) allowsDomain(domain string) bool {
+	if len(r.AllowedDomains) == 0 {
+		return true
+	}
+	for _, allowed := range r.AllowedDomains {
+		if strings.EqualFold(allowed, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsIP reports whether the restrictions permit logging in from ip.
+func (r *CredentialRestrictions) allowsIP(ip net.IP) bool {
+	if len(r.AllowedCIDRs) == 0 {
+		return true
+	}
+	for _, cidr := range r.AllowedCIDRs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// rotateCredential replaces a credential's password, keeping its username.
+// The old password keeps working for the requested overlap, 24h by
+// default; an overlap of 0s ends it at once.
+func rotateCredential(c *gin.Context) {
+	var req struct {
+		Overlap string `json:"overlap"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err.Error() != "EOF" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	overlap := defaultRotationOverlap
+	if req.Overlap != "" {
+		d, err := time.ParseDuration(req.Overlap)
+		if err != nil || d < 0 || d > maxRotationOverlap {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("overlap must be a duration between 0s and %s", maxRotationOverlap)})
+			return
+		}
+		overlap = d
+	}
+
+	password, _ := generateSecurePassword()
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		log.Printf("Error hashing password: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate credential"})
+		return
+	}
+
+	var username string
+	var expiresAt sql.NullTime
+	err = db.QueryRow(
+		`UPDATE smtp_credentials SET password = $1,
+			previous_password = CASE WHEN $2::FLOAT8 > 0 THEN password END,
+			previous_expires_at = CASE WHEN $2::FLOAT8 > 0 THEN NOW() + make_interval(secs => $2::FLOAT8) END
+		WHERE organization_id = $3 AND id = $4 AND revoked_at IS NULL
+		RETURNING username, previous_expires_at`,
+		hashedPassword, overlap.Seconds(), c.Param("id"), c.Param("credentialId"),
+	).Scan(&username, &expiresAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No active credential with this ID"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error rotating credential: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate credential"})
+		return
+	}
+
+	changes := map[string]database.AuditChange{"password": {From: redacted, To: redacted}}
+	if expiresAt.Valid {
+		changes["previous_password_expires_at"] = database.AuditChange{To: expiresAt.Time.Format(time.RFC3339)}
+	}
+	recordAudit(c, "smtp_credential.rotate", "smtp_credential", c.Param("credentialId"), changes)
+
+	resp := gin.H{
+		"id":       c.Param("credentialId"),
+		"username": username,
+		"password": password,
+		"note":     "Save this password securely. You cannot retrieve it later.",
+	}
+	if expiresAt.Valid {
+		resp["previous_password_expires_at"] = expiresAt.Time.Format(time.RFC3339)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// revokeCredential stops a credential from logging in, including with a
+// password still in its rotation overlap. Revoked credentials are kept so
+// audit logs can still refer to them.
+func revokeCredential(c *gin.Context) {
+	result, err := db.Exec(
+		`UPDATE smtp_credentials SET revoked_at = NOW(), previous_password = NULL, previous_expires_at = NULL
+		WHERE organization_id = $1 AND id = $2 AND revoked_at IS NULL`,
+		c.Param("id"), c.Param("credentialId"),
+	)
+	if err != nil {
+		log.Printf("Error revoking credential: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke credential"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No active credential with this ID"})
+		return
+	}
+
+	recordAudit(c, "smtp_credential.revoke", "smtp_credential", c.Param("credentialId"), map[string]database.AuditChange{
+		"status": {From: credentialActive, To: credentialRevoked},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"id": c.Param("credentialId"), "status": credentialRevoked})
+}
+
+// updateCredentialRestrictions replaces a credential's restrictions.
+func updateCredentialRestrictions(c *gin.Context) {
+	var req CredentialRestrictions
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if err := req.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var before CredentialRestrictions
+	err := db.QueryRow(
+		`WITH prior AS (
+			SELECT id, allowed_domains, allowed_cidrs, hourly_limit FROM smtp_credentials
+			WHERE organization_id = $4 AND id = $5 AND revoked_at IS NULL FOR UPDATE
+		)
+		UPDATE smtp_credentials c SET allowed_domains = $1, allowed_cidrs = $2, hourly_limit = NULLIF($3, 0)
+		FROM prior WHERE c.id = prior.id
+		RETURNING prior.allowed_domains, prior.allowed_cidrs, COALESCE(prior.hourly_limit, 0)`,
+		pq.Array(req.AllowedDomains), pq.Array(req.AllowedCIDRs), req.HourlyLimit, c.Param("id"), c.Param("credentialId"),
+	).Scan(pq.Array(&before.AllowedDomains), pq.Array(&before.AllowedCIDRs), &before.HourlyLimit)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No active credential with this ID"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating credential restrictions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update credential"})
+		return
+	}
+
+	changes := map[string]database.AuditChange{}
+	addChange(changes, "allowed_domains", nonNil(before.AllowedDomains), nonNil(req.AllowedDomains))
+	addChange(changes, "allowed_cidrs", nonNil(before.AllowedCIDRs), nonNil(req.AllowedCIDRs))
+	addChange(changes, "hourly_limit", before.HourlyLimit, req.HourlyLimit)
+	recordAudit(c, "smtp_credential.update_restrictions", "smtp_credential", c.Param("credentialId"), changes)
+
+	c.JSON(http.StatusOK, req)
+}
+
+// nonNil makes an unset list compare equal to an empty one.
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Gatete-Bruno/besend/internal/provider"
+	"github.com/lib/pq"
+)
+
+const (
+	deliveryBatch        = 20
+	deliveryPollInterval = 15 * time.Second
+	deliveryTimeout      = 2 * time.Minute
+	// deliveryLease is how long a claimed message is left alone before
+	// another worker may retry it, should this one stop mid-delivery.
+	deliveryLease = 15 * time.Minute
+)
+
+// deliveryBackoff is the wait before each retry of deferred recipients.
+// Recipients still deferred after the last one fail.
+var deliveryBackoff = []time.Duration{
+	time.Minute, 5 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 2 * time.Hour, 4 * time.Hour, 8 * time.Hour,
+}
+
+var deliveryWake = make(chan struct{}, 1)
+
+// wakeDelivery starts a delivery run without waiting for the next poll.
+func wakeDelivery() {
+	select {
+	case deliveryWake <- struct{}{}:
+	default:
+	}
+}
+
+type queuedMessage struct {
+	id           string
+	orgID        string
+	envelopeFrom string
+	fromDomain   string
+	recipients   []string
+	message      []byte
+	messageID    string
+	attempts     int
+}
+
+// newDeliveryProvider builds the provider submitted mail is sent through,
+// from the same registry the API and operator use. DELIVERY_PROVIDER
+// defaults to direct-mx; a relay is configured with DELIVERY_HOST,
+// DELIVERY_PORT, DELIVERY_USERNAME, DELIVERY_PASSWORD and
+// DELIVERY_TLS_MODE, and provider options with DELIVERY_OPTIONS as JSON.
+func newDeliveryProvider() (provider.Provider, error) {
+	cfg := &provider.Config{
+		Provider: os.Getenv("DELIVERY_PROVIDER"),
+		Host:     os.Getenv("DELIVERY_HOST"),
+		Username: os.Getenv("DELIVERY_USERNAME"),
+		Password: os.Getenv("DELIVERY_PASSWORD"),
+		TLSMode:  os.Getenv("DELIVERY_TLS_MODE"),
+		Pools:    provider.NewSMTPPools(),
+		PoolKey:  "submission",
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = provider.DirectMX
+	}
+	if port := os.Getenv("DELIVERY_PORT"); port != "" {
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Port = p
+	}
+	if options := os.Getenv("DELIVERY_OPTIONS"); options != "" {
+		cfg.Options = json.RawMessage(options)
+	}
+	return provider.NewProvider(cfg)
+}
+
+// runDelivery sends queued submissions as they arrive and retries deferred
+// ones when due.
+func runDelivery(p provider.Provider) {
+	ticker := time.NewTicker(deliveryPollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := deliverDue(p)
+			if err != nil {
+				log.Printf("Error delivering queued mail: %v", err)
+			}
+			if err != nil || n < deliveryBatch {
+				break
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-deliveryWake:
+		}
+	}
+}
+
+// deliverDue claims a batch of due messages and delivers them, returning
+// how many were claimed.
+func deliverDue(p provider.Provider) (int, error) {
+	rows, err := db.Query(
+		`UPDATE submission_queue SET attempts = attempts + 1, next_attempt_at = $1
+		WHERE id IN (
+			SELECT id FROM submission_queue WHERE next_attempt_at <= NOW()
+			ORDER BY next_attempt_at LIMIT $2 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, organization_id, envelope_from, from_domain, recipients, message, message_id, attempts`,
+		time.Now().Add(deliveryLease), deliveryBatch,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var batch []queuedMessage
+	for rows.Next() {
+		var m queuedMessage
+		if err := rows.Scan(&m.id, &m.orgID, &m.envelopeFrom, &m.fromDomain, pq.Array(&m.recipients), &m.message, &m.messageID, &m.attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for i := range batch {
+		if err := deliverQueued(p, &batch[i]); err != nil {
+			log.Printf("Error delivering %s: %v", batch[i].messageID, err)
+		}
+	}
+	return len(batch), nil
+}
+
+// deliverQueued signs a message with its domain's active DKIM key and sends
+// it to each remaining recipient, recording the outcome in the audit log.
+func deliverQueued(p provider.Provider, m *queuedMessage) error {
+	signer, err := activeSigner(m.orgID, m.fromDomain)
+	if err != nil {
+		return m.retryLater(err.Error(), m.recipients)
+	}
+	msg := m.message
+	if signer != nil {
+		if msg, err = signer.Sign(msg, time.Now()); err != nil {
+			return m.retryLater(err.Error(), m.recipients)
+		}
+	}
+
+	var deferred []string
+	var lastError string
+	for _, rcpt := range m.recipients {
+		ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout)
+		_, sendErr := p.Send(ctx, &provider.EmailRequest{
+			MessageID:    m.messageID,
+			From:         m.envelopeFrom,
+			To:           rcpt,
+			EnvelopeFrom: m.envelopeFrom,
+			Raw:          msg,
+		})
+		cancel()
+
+		if sendErr != nil && !provider.IsPermanentFailure(sendErr) {
+			deferred = append(deferred, rcpt)
+			lastError = sendErr.Error()
+			continue
+		}
+		status, errorMsg := auditSent, ""
+		if sendErr != nil {
+			status, errorMsg = auditFailed, sendErr.Error()
+		}
+		m.audit(rcpt, status, errorMsg)
+	}
+
+	if len(deferred) == 0 {
+		_, err := db.Exec("DELETE FROM submission_queue WHERE id = $1", m.id)
+		return err
+	}
+	return m.retryLater(lastError, deferred)
+}
+
+// retryLater schedules the next attempt for recipients, or fails them once
+// every retry has been used.
+func (m *queuedMessage) retryLater(reason string, recipients []string) error {
+	if m.attempts > len(deliveryBackoff) {
+		for _, rcpt := range recipients {
+			m.audit(rcpt, auditFailed, reason)
+		}
+		_, err := db.Exec("DELETE FROM submission_queue WHERE id = $1", m.id)
+		return err
+	}
+	for _, rcpt := range recipients {
+		m.audit(rcpt, auditDeferred, reason)
+	}
+	_, err := db.Exec(
+		"UPDATE submission_queue SET recipients = $1, last_error = $2, next_attempt_at = $3 WHERE id = $4",
+		pq.Array(recipients), reason, time.Now().Add(deliveryBackoff[m.attempts-1]), m.id,
+	)
+	return err
+}
+
+// audit records a recipient's delivery status.
+func (m *queuedMessage) audit(recipient, status, errorMsg string) {
+	_, err := db.Exec(
+		`UPDATE email_audit_logs SET status = $1, error = NULLIF($2, ''),
+			delivered_at = CASE WHEN $1 = 'sent' THEN NOW() END
+		WHERE queue_id = $3 AND recipient_email = $4`,
+		status, errorMsg, m.id, recipient,
+	)
+	if err != nil {
+		log.Printf("Error updating audit log for %s: %v", m.messageID, err)
+	}
+}
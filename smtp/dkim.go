@@ -0,0 +1,258 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Gatete-Bruno/besend/pkg/database"
+	"github.com/Gatete-Bruno/besend/pkg/dkim"
+	"github.com/gin-gonic/gin"
+)
+
+// DKIM key states. A rotated-in key stays pending until its DNS record is
+// published and it is activated; the key it replaces is then retired, and
+// its record should stay published until mail signed with it has arrived.
+const (
+	dkimPending = "pending"
+	dkimActive  = "active"
+	dkimRetired = "retired"
+)
+
+type DKIMKey struct {
+	ID          string  `json:"id"`
+	Selector    string  `json:"selector"`
+	Algorithm   string  `json:"algorithm"`
+	Host        string  `json:"host"`
+	Record      string  `json:"record"`
+	Status      string  `json:"status"`
+	CreatedAt   string  `json:"created_at"`
+	ActivatedAt *string `json:"activated_at,omitempty"`
+	RetiredAt   *string `json:"retired_at,omitempty"`
+
+	privateKey []byte
+}
+
+// newDKIMKey generates a key under a fresh selector.
+func newDKIMKey(algorithm string) (*DKIMKey, error) {
+	if algorithm == "" {
+		algorithm = dkim.AlgorithmRSA
+	}
+	key, err := dkim.GenerateKey(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	record, err := key.DNSRecord()
+	if err != nil {
+		return nil, err
+	}
+	private, err := key.MarshalPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return &DKIMKey{
+		ID:         generateID(),
+		Selector:   dkim.NewSelector(time.Now()),
+		Algorithm:  algorithm,
+		Record:     record,
+		Status:     dkimPending,
+		CreatedAt:  time.Now().Format(time.RFC3339),
+		privateKey: private,
+	}, nil
+}
+
+// insert stores the key with its private key encrypted.
+func (k *DKIMKey) insert(tx *sql.Tx, domainID string) error {
+	sealed, err := keyring.Encrypt(k.privateKey)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO dkim_keys (id, domain_id, selector, algorithm, private_key, dns_record, status, created_at, activated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, CASE WHEN $7 = 'active' THEN NOW() END)`,
+		k.ID, domainID, k.Selector, k.Algorithm, sealed, k.Record, k.Status, k.CreatedAt,
+	)
+	return err
+}
+
+func lookupDomainName(domainID string) (string, error) {
+	var name string
+	err := db.QueryRow("SELECT domain FROM sending_domains WHERE id = $1", domainID).Scan(&name)
+	return name, err
+}
+
+func listDKIMKeys(c *gin.Context) {
+	domainID := c.Param("id")
+	domain, err := lookupDomainName(domainID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying domain: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch DKIM keys"})
+		return
+	}
+
+	rows, err := db.Query(
+		`SELECT id, selector, algorithm, dns_record, status, created_at, activated_at, retired_at
+		FROM dkim_keys WHERE domain_id = $1 ORDER BY created_at DESC`,
+		domainID,
+	)
+	if err != nil {
+		log.Printf("Error querying DKIM keys: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch DKIM keys"})
+		return
+	}
+	defer rows.Close()
+
+	keys := []DKIMKey{}
+	for rows.Next() {
+		var key DKIMKey
+		if err := rows.Scan(&key.ID, &key.Selector, &key.Algorithm, &key.Record, &key.Status, &key.CreatedAt, &key.ActivatedAt, &key.RetiredAt); err != nil {
+			log.Printf("Error scanning DKIM key: %v", err)
+			continue
+		}
+		key.Host = dkim.RecordName(key.Selector, domain)
+		keys = append(keys, key)
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// rotateDKIMKey creates a pending key under a new selector. Signing keeps
+// using the active key until the new one is activated, which should only
+// happen once its record is visible in DNS.
+func rotateDKIMKey(c *gin.Context) {
+	domainID := c.Param("id")
+
+	var req struct {
+		Algorithm string `json:"algorithm"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && err.Error() != "EOF" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	domain, err := lookupDomainName(domainID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying domain: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate DKIM key"})
+		return
+	}
+
+	key, err := newDKIMKey(req.Algorithm)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err == nil {
+		defer tx.Rollback()
+		// Only one key waits for activation at a time.
+		_, err = tx.Exec("DELETE FROM dkim_keys WHERE domain_id = $1 AND status = $2", domainID, dkimPending)
+	}
+	if err == nil {
+		err = key.insert(tx, domainID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error rotating DKIM key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate DKIM key"})
+		return
+	}
+
+	recordAudit(c, "dkim_key.rotate", "dkim_key", key.ID, auditFields(map[string]interface{}{
+		"domain":    domain,
+		"selector":  key.Selector,
+		"algorithm": key.Algorithm,
+		"status":    key.Status,
+	}, false))
+
+	key.Host = dkim.RecordName(key.Selector, domain)
+	c.JSON(http.StatusCreated, key)
+}
+
+// activateDKIMKey makes a pending key the one mail is signed with and
+// retires the previous one.
+func activateDKIMKey(c *gin.Context) {
+	domainID := c.Param("id")
+	selector := c.Param("selector")
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error activating DKIM key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to activate DKIM key"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"UPDATE dkim_keys SET status = $1, retired_at = NOW() WHERE domain_id = $2 AND status = $3",
+		dkimRetired, domainID, dkimActive,
+	); err != nil {
+		log.Printf("Error activating DKIM key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to activate DKIM key"})
+		return
+	}
+	var keyID, record string
+	err = tx.QueryRow(
+		"UPDATE dkim_keys SET status = $1, activated_at = NOW() WHERE domain_id = $2 AND selector = $3 AND status = $4 RETURNING id, dns_record",
+		dkimActive, domainID, selector, dkimPending,
+	).Scan(&keyID, &record)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No pending DKIM key with this selector"})
+		return
+	}
+	if err == nil {
+		_, err = tx.Exec("UPDATE sending_domains SET dkim_record = $1 WHERE id = $2", record, domainID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error activating DKIM key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to activate DKIM key"})
+		return
+	}
+	recordAudit(c, "dkim_key.activate", "dkim_key", keyID, map[string]database.AuditChange{
+		"status": {From: dkimPending, To: dkimActive},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"selector": selector, "status": dkimActive})
+}
+
+// activeSigner returns a signer for the active key of an organization's
+// sending domain, or nil when it has none.
+func activeSigner(orgID, domain string) (*dkim.Signer, error) {
+	var selector, sealed string
+	err := db.QueryRow(
+		`SELECT k.selector, k.private_key
+		FROM dkim_keys k JOIN sending_domains d ON d.id = k.domain_id
+		WHERE d.organization_id = $1 AND LOWER(d.domain) = LOWER($2) AND k.status = $3`,
+		orgID, domain, dkimActive,
+	).Scan(&selector, &sealed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	private, err := keyring.Decrypt(sealed)
+	if err != nil {
+		return nil, err
+	}
+	key, err := dkim.ParsePrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	return &dkim.Signer{Domain: domain, Selector: selector, Key: key}, nil
+}
@@ -1,9 +0,0 @@
-module smtp-manager
-
-go 1.21
-
-require (
-	github.com/gin-gonic/gin v1.9.1
-	github.com/lib/pq v1.10.9
-	golang.org/x/crypto v0.15.0
-)
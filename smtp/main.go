@@ -1,17 +1,22 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/base64"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"time"
 
+	"github.com/Gatete-Bruno/besend/pkg/api/middleware"
+	"github.com/Gatete-Bruno/besend/pkg/database"
+	"github.com/Gatete-Bruno/besend/pkg/dkim"
+	"github.com/Gatete-Bruno/besend/pkg/migrate"
+	"github.com/Gatete-Bruno/besend/pkg/secrets"
 	"github.com/gin-gonic/gin"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -29,8 +34,14 @@ type Domain struct {
 	Verified       bool   `json:"verified"`
 	SPF            string `json:"spf_record"`
 	DKIM           string `json:"dkim_record"`
+	DKIMHost       string `json:"dkim_host"`
+	DKIMSelector   string `json:"dkim_selector"`
 	DMARC          string `json:"dmarc_record"`
-	CreatedAt      string `json:"created_at"`
+	// VerificationHost and VerificationRecord are the TXT record that
+	// proves ownership of the domain.
+	VerificationHost   string `json:"verification_host"`
+	VerificationRecord string `json:"verification_record"`
+	CreatedAt          string `json:"created_at"`
 }
 
 type SMTPCredential struct {
@@ -38,11 +49,24 @@ type SMTPCredential struct {
 	OrganizationID string `json:"organization_id"`
 	Username       string `json:"username"`
 	Password       string `json:"-"`
-	CreatedAt      string `json:"created_at"`
+	Description    string `json:"description"`
+	Status         string `json:"status"`
+	// PreviousPasswordExpiresAt is when the password replaced by the last
+	// rotation stops working.
+	PreviousPasswordExpiresAt *string `json:"previous_password_expires_at,omitempty"`
+	RevokedAt                 *string `json:"revoked_at,omitempty"`
+	LastUsedAt                *string `json:"last_used_at,omitempty"`
+	LastUsedIP                *string `json:"last_used_ip,omitempty"`
+	CredentialRestrictions
+	CreatedAt string `json:"created_at"`
 }
 
 var db *sql.DB
 
+// keyring encrypts DKIM private keys. The API decrypts them to sign mail,
+// so both must be given the same SECRETS_KEK.
+var keyring *secrets.Keyring
+
 func init() {
 	dbURL := os.Getenv("DATABASE_URL")
 	if dbURL == "" {
@@ -58,47 +82,11 @@ func init() {
 	if err := db.Ping(); err != nil {
 		log.Fatalf("Failed to ping database: %v", err)
 	}
-
-	createTables()
-}
-
-func createTables() {
-	schema := `
-	CREATE TABLE IF NOT EXISTS organizations (
-		id VARCHAR(36) PRIMARY KEY,
-		customer_id VARCHAR(36) NOT NULL,
-		name VARCHAR(255) NOT NULL,
-		created_at TIMESTAMP DEFAULT NOW()
-	);
-
-	CREATE TABLE IF NOT EXISTS sending_domains (
-		id VARCHAR(36) PRIMARY KEY,
-		organization_id VARCHAR(36) NOT NULL REFERENCES organizations(id),
-		domain VARCHAR(255) NOT NULL UNIQUE,
-		verified BOOLEAN DEFAULT FALSE,
-		spf_record TEXT,
-		dkim_record TEXT,
-		dmarc_record TEXT,
-		created_at TIMESTAMP DEFAULT NOW()
-	);
-
-	CREATE TABLE IF NOT EXISTS smtp_credentials (
-		id VARCHAR(36) PRIMARY KEY,
-		organization_id VARCHAR(36) NOT NULL REFERENCES organizations(id),
-		username VARCHAR(255) NOT NULL UNIQUE,
-		password VARCHAR(255) NOT NULL,
-		created_at TIMESTAMP DEFAULT NOW()
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_org_customer ON organizations(customer_id);
-	CREATE INDEX IF NOT EXISTS idx_domain_org ON sending_domains(organization_id);
-	CREATE INDEX IF NOT EXISTS idx_creds_org ON smtp_credentials(organization_id);
-	`
-
-	if _, err := db.Exec(schema); err != nil {
-		log.Fatalf("Failed to create tables: %v", err)
-	}
-	log.Println("Database tables initialized")
+	// The API's auth middleware looks customers and API keys up through
+	// the database package, and audit events are written through it; they
+	// live in the same database.
+	database.DB = db
+	database.AuditHashChain = os.Getenv("AUDIT_HASH_CHAIN") == "true"
 }
 
 func generateSecurePassword() (string, error) {
@@ -116,8 +104,7 @@ func hashPassword(password string) (string, error) {
 
 func createOrganization(c *gin.Context) {
 	var req struct {
-		CustomerID string `json:"customer_id" binding:"required"`
-		Name       string `json:"name" binding:"required"`
+		Name string `json:"name" binding:"required"`
 	}
 
 	if err := c.BindJSON(&req); err != nil {
@@ -126,11 +113,12 @@ func createOrganization(c *gin.Context) {
 	}
 
 	id := generateID()
+	customerID := currentCustomerID(c)
 	createdAt := time.Now().Format(time.RFC3339)
 
 	_, err := db.Exec(
 		"INSERT INTO organizations (id, customer_id, name, created_at) VALUES ($1, $2, $3, $4)",
-		id, req.CustomerID, req.Name, createdAt,
+		id, customerID, req.Name, createdAt,
 	)
 
 	if err != nil {
@@ -138,17 +126,18 @@ func createOrganization(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create organization"})
 		return
 	}
+	recordAudit(c, "organization.create", "organization", id, auditFields(map[string]interface{}{"name": req.Name}, false))
 
 	c.JSON(http.StatusCreated, Organization{
 		ID:         id,
-		CustomerID: req.CustomerID,
+		CustomerID: customerID,
 		Name:       req.Name,
 		CreatedAt:  createdAt,
 	})
 }
 
 func listOrganizations(c *gin.Context) {
-	customerID := c.Param("id")
+	customerID := currentCustomerID(c)
 
 	rows, err := db.Query(
 		"SELECT id, customer_id, name, created_at FROM organizations WHERE customer_id = $1",
@@ -161,7 +150,7 @@ func listOrganizations(c *gin.Context) {
 	}
 	defer rows.Close()
 
-	var orgs []Organization
+	orgs := []Organization{}
 	for rows.Next() {
 		var org Organization
 		if err := rows.Scan(&org.ID, &org.CustomerID, &org.Name, &org.CreatedAt); err != nil {
@@ -179,6 +168,9 @@ func addDomain(c *gin.Context) {
 
 	var req struct {
 		Domain string `json:"domain" binding:"required"`
+		// DKIMAlgorithm is rsa (the default) or ed25519. Ed25519 keys are
+		// short but not yet verified by every receiver.
+		DKIMAlgorithm string `json:"dkim_algorithm"`
 	}
 
 	if err := c.BindJSON(&req); err != nil {
@@ -186,46 +178,148 @@ func addDomain(c *gin.Context) {
 		return
 	}
 
+	key, err := newDKIMKey(req.DKIMAlgorithm)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	id := generateID()
+	token := newVerificationToken()
 	createdAt := time.Now().Format(time.RFC3339)
 
-	_, err := db.Exec(
-		"INSERT INTO sending_domains (id, organization_id, domain, created_at) VALUES ($1, $2, $3, $4)",
-		id, orgID, req.Domain, createdAt,
-	)
-
+	tx, err := db.Begin()
 	if err != nil {
 		log.Printf("Error adding domain: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add domain"})
 		return
 	}
+	defer tx.Rollback()
 
-	spf := fmt.Sprintf("v=spf1 ip4:YOUR_IP include:besend.rw ~all")
-	dkim := "TODO: Generate DKIM public key"
-	dmarc := fmt.Sprintf("v=DMARC1; p=quarantine; rua=mailto:admin@%s", req.Domain)
+	_, err = tx.Exec(
+		"INSERT INTO sending_domains (id, organization_id, domain, spf_record, dkim_record, dmarc_record, verification_token, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		id, orgID, req.Domain, spfRecord(), key.Record, dmarcRecord(req.Domain), token, createdAt,
+	)
+	if err == nil {
+		// A new domain has nothing signed with an older key, so its first
+		// key is active at once.
+		key.Status = dkimActive
+		err = key.insert(tx, id)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("Error adding domain: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add domain"})
+		return
+	}
+	recordAudit(c, "domain.create", "sending_domain", id, auditFields(map[string]interface{}{
+		"organization_id": orgID,
+		"domain":          req.Domain,
+		"dkim_selector":   key.Selector,
+	}, false))
 
 	c.JSON(http.StatusCreated, Domain{
-		ID:             id,
-		OrganizationID: orgID,
-		Domain:         req.Domain,
-		Verified:       false,
-		SPF:            spf,
-		DKIM:           dkim,
-		DMARC:          dmarc,
-		CreatedAt:      createdAt,
+		ID:                 id,
+		OrganizationID:     orgID,
+		Domain:             req.Domain,
+		Verified:           false,
+		SPF:                spfRecord(),
+		DKIM:               key.Record,
+		DKIMHost:           dkim.RecordName(key.Selector, req.Domain),
+		DKIMSelector:       key.Selector,
+		DMARC:              dmarcRecord(req.Domain),
+		VerificationHost:   ownershipHost(req.Domain),
+		VerificationRecord: ownershipPrefix + token,
+		CreatedAt:          createdAt,
 	})
 }
 
+func listDomains(c *gin.Context) {
+	orgID := c.Param("id")
+
+	rows, err := db.Query(
+		`SELECT d.id, d.organization_id, d.domain, d.verified, d.spf_record, d.dkim_record, d.dmarc_record,
+			d.verification_token, d.created_at, k.selector
+		FROM sending_domains d LEFT JOIN dkim_keys k ON k.domain_id = d.id AND k.status = $2
+		WHERE d.organization_id = $1 ORDER BY d.created_at`,
+		orgID, dkimActive,
+	)
+	if err != nil {
+		log.Printf("Error querying domains: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch domains"})
+		return
+	}
+	defer rows.Close()
+
+	domains := []Domain{}
+	for rows.Next() {
+		var d Domain
+		var spf, dkimRecord, dmarc, token, selector sql.NullString
+		if err := rows.Scan(&d.ID, &d.OrganizationID, &d.Domain, &d.Verified, &spf, &dkimRecord, &dmarc, &token, &d.CreatedAt, &selector); err != nil {
+			log.Printf("Error scanning domain: %v", err)
+			continue
+		}
+		d.SPF, d.DKIM, d.DMARC = spf.String, dkimRecord.String, dmarc.String
+		if selector.Valid {
+			d.DKIMSelector = selector.String
+			d.DKIMHost = dkim.RecordName(selector.String, d.Domain)
+		}
+		if token.Valid {
+			d.VerificationHost = ownershipHost(d.Domain)
+			d.VerificationRecord = ownershipPrefix + token.String
+		}
+		domains = append(domains, d)
+	}
+
+	c.JSON(http.StatusOK, domains)
+}
+
+// deleteDomain removes a sending domain and its DKIM keys. Mail from it is
+// refused from then on.
+func deleteDomain(c *gin.Context) {
+	var id, name string
+	err := db.QueryRow(
+		"DELETE FROM sending_domains WHERE organization_id = $1 AND LOWER(domain) = LOWER($2) RETURNING id, domain",
+		c.Param("id"), c.Param("domain"),
+	).Scan(&id, &name)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting domain: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete domain"})
+		return
+	}
+	recordAudit(c, "domain.delete", "sending_domain", id, auditFields(map[string]interface{}{
+		"organization_id": c.Param("id"),
+		"domain":          name,
+	}, true))
+
+	c.JSON(http.StatusOK, gin.H{"message": "Domain deleted"})
+}
+
 func createSMTPCredential(c *gin.Context) {
 	orgID := c.Param("id")
 
 	var req struct {
 		Description string `json:"description"`
+		CredentialRestrictions
 	}
 
-	if err := c.BindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil && err.Error() != "EOF" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if req.Description == "" {
 		req.Description = "Default credential"
 	}
+	if err := req.CredentialRestrictions.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	username := generateID()
 	password, _ := generateSecurePassword()
@@ -241,8 +335,10 @@ func createSMTPCredential(c *gin.Context) {
 	createdAt := time.Now().Format(time.RFC3339)
 
 	_, err = db.Exec(
-		"INSERT INTO smtp_credentials (id, organization_id, username, password, created_at) VALUES ($1, $2, $3, $4, $5)",
-		id, orgID, username, hashedPassword, createdAt,
+		`INSERT INTO smtp_credentials (id, organization_id, username, password, description, allowed_domains, allowed_cidrs, hourly_limit, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0), $9)`,
+		id, orgID, username, hashedPassword, req.Description,
+		pq.Array(req.AllowedDomains), pq.Array(req.AllowedCIDRs), req.HourlyLimit, createdAt,
 	)
 
 	if err != nil {
@@ -250,13 +346,23 @@ func createSMTPCredential(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create credential"})
 		return
 	}
+	recordAudit(c, "smtp_credential.create", "smtp_credential", id, auditFields(map[string]interface{}{
+		"organization_id": orgID,
+		"username":        username,
+		"description":     req.Description,
+		"allowed_domains": req.AllowedDomains,
+		"allowed_cidrs":   req.AllowedCIDRs,
+		"hourly_limit":    req.HourlyLimit,
+	}, false))
 
 	c.JSON(http.StatusCreated, gin.H{
-		"id":         id,
-		"username":   username,
-		"password":   password,
-		"created_at": createdAt,
-		"note":       "Save this password securely. You cannot retrieve it later.",
+		"id":           id,
+		"username":     username,
+		"password":     password,
+		"description":  req.Description,
+		"restrictions": req.CredentialRestrictions,
+		"created_at":   createdAt,
+		"note":         "Save this password securely. You cannot retrieve it later.",
 	})
 }
 
@@ -264,7 +370,10 @@ func listCredentials(c *gin.Context) {
 	orgID := c.Param("id")
 
 	rows, err := db.Query(
-		"SELECT id, organization_id, username, created_at FROM smtp_credentials WHERE organization_id = $1",
+		`SELECT id, organization_id, username, COALESCE(description, ''), created_at,
+			CASE WHEN previous_expires_at > NOW() THEN previous_expires_at END,
+			revoked_at, last_used_at, last_used_ip, allowed_domains, allowed_cidrs, COALESCE(hourly_limit, 0)
+		FROM smtp_credentials WHERE organization_id = $1 ORDER BY created_at`,
 		orgID,
 	)
 	if err != nil {
@@ -274,13 +383,21 @@ func listCredentials(c *gin.Context) {
 	}
 	defer rows.Close()
 
-	var creds []SMTPCredential
+	creds := []SMTPCredential{}
 	for rows.Next() {
 		var cred SMTPCredential
-		if err := rows.Scan(&cred.ID, &cred.OrganizationID, &cred.Username, &cred.CreatedAt); err != nil {
+		if err := rows.Scan(
+			&cred.ID, &cred.OrganizationID, &cred.Username, &cred.Description, &cred.CreatedAt,
+			&cred.PreviousPasswordExpiresAt, &cred.RevokedAt, &cred.LastUsedAt, &cred.LastUsedIP,
+			pq.Array(&cred.AllowedDomains), pq.Array(&cred.AllowedCIDRs), &cred.HourlyLimit,
+		); err != nil {
 			log.Printf("Error scanning credential: %v", err)
 			continue
 		}
+		cred.Status = credentialActive
+		if cred.RevokedAt != nil {
+			cred.Status = credentialRevoked
+		}
 		creds = append(creds, cred)
 	}
 
@@ -288,12 +405,12 @@ func listCredentials(c *gin.Context) {
 }
 
 func getAuditLogs(c *gin.Context) {
-	customerID := c.Param("id")
+	customerID := currentCustomerID(c)
 	limit := c.DefaultQuery("limit", "100")
 	offset := c.DefaultQuery("offset", "0")
 
 	rows, err := db.Query(
-		`SELECT id, customer_id, postal_message_id, sender_email, recipient_email, subject, status, created_at, delivered_at FROM email_audit_logs WHERE customer_id = $1 ORDER BY created_at DESC LIMIT $2 OFFSET $3`,
+		`SELECT id, customer_id, postal_message_id, sender_email, recipient_email, subject, status, error, created_at, delivered_at FROM email_audit_logs WHERE customer_id = $1 ORDER BY created_at DESC LIMIT $2 OFFSET $3`,
 		customerID, limit, offset,
 	)
 	if err != nil {
@@ -313,11 +430,12 @@ func getAuditLogs(c *gin.Context) {
 			recipientEmail sql.NullString
 			subject        sql.NullString
 			status         string
+			errorMsg       sql.NullString
 			createdAt      string
 			deliveredAt    sql.NullString
 		)
 
-		if err := rows.Scan(&id, &customerId, &messageId, &senderEmail, &recipientEmail, &subject, &status, &createdAt, &deliveredAt); err != nil {
+		if err := rows.Scan(&id, &customerId, &messageId, &senderEmail, &recipientEmail, &subject, &status, &errorMsg, &createdAt, &deliveredAt); err != nil {
 			log.Printf("Error scanning audit log: %v", err)
 			continue
 		}
@@ -330,6 +448,7 @@ func getAuditLogs(c *gin.Context) {
 			"recipient":    recipientEmail.String,
 			"subject":      subject.String,
 			"status":       status,
+			"error":        errorMsg.String,
 			"created_at":   createdAt,
 			"delivered_at": deliveredAt.String,
 		}
@@ -357,15 +476,81 @@ func generateID() string {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if os.Args[1] != "migrate" {
+			log.Fatalf("Unknown command %q (usage: smtp-manager [%s])", os.Args[1], migrate.Usage)
+		}
+		migrator, err := database.NewMigrator()
+		if err != nil {
+			log.Fatalf("Failed to load migrations: %v", err)
+		}
+		if err := migrate.Run(context.Background(), migrator, os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	// The tables are shared with the API, which applies the same
+	// migrations; whichever starts first does the work.
+	if os.Getenv("AUTO_MIGRATE") != "false" {
+		if err := database.Migrate(context.Background()); err != nil {
+			log.Fatalf("Failed to create tables: %v", err)
+		}
+	}
+
+	var err error
+	keyring, err = secrets.LoadKeyring()
+	if err != nil {
+		log.Fatalf("Failed to load encryption keys: %v", err)
+	}
+
 	router := gin.Default()
 
 	router.GET("/health", healthCheck)
-	router.POST("/api/organizations", createOrganization)
-	router.GET("/api/customers/:id/organizations", listOrganizations)
-	router.POST("/api/organizations/:id/domains", addDomain)
-	router.POST("/api/organizations/:id/credentials", createSMTPCredential)
-	router.GET("/api/organizations/:id/credentials", listCredentials)
-	router.GET("/api/customers/:id/audit-logs", getAuditLogs)
+
+	api := router.Group("/api", middleware.AuthMiddleware())
+	api.POST("/organizations", createOrganization)
+	api.GET("/organizations", listOrganizations)
+	api.GET("/audit-logs", getAuditLogs)
+	// Earlier paths that name the customer.
+	api.GET("/customers/:id/organizations", requireCustomer, listOrganizations)
+	api.GET("/customers/:id/audit-logs", requireCustomer, getAuditLogs)
+
+	org := api.Group("/organizations/:id", requireOrganization)
+	org.GET("/domains", listDomains)
+	org.POST("/domains", addDomain)
+	org.DELETE("/domains/:domain", deleteDomain)
+	org.POST("/domains/:domain/verify", verifyDomainHandler)
+	org.GET("/credentials", listCredentials)
+	org.POST("/credentials", createSMTPCredential)
+	// Deleting a credential revokes it: the row stays for the audit logs
+	// that refer to it.
+	org.DELETE("/credentials/:credentialId", revokeCredential)
+	org.POST("/credentials/:credentialId/rotate", rotateCredential)
+	org.POST("/credentials/:credentialId/revoke", revokeCredential)
+	org.PUT("/credentials/:credentialId/restrictions", updateCredentialRestrictions)
+
+	domain := api.Group("/domains/:id", requireDomain)
+	domain.GET("/dkim", listDKIMKeys)
+	domain.POST("/dkim/rotate", rotateDKIMKey)
+	domain.POST("/dkim/:selector/activate", activateDKIMKey)
+
+	recheckInterval := time.Hour
+	if v := os.Getenv("DOMAIN_RECHECK_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid DOMAIN_RECHECK_INTERVAL: %q", v)
+		}
+		recheckInterval = d
+	}
+	go recheckDomains(recheckInterval)
+
+	deliveryProvider, err := newDeliveryProvider()
+	if err != nil {
+		log.Fatalf("Invalid delivery configuration: %v", err)
+	}
+	go runDelivery(deliveryProvider)
+	startSubmission()
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"database/sql"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Gatete-Bruno/besend/pkg/submission"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Audit statuses of a submitted message, one row per recipient.
+const (
+	auditQueued   = "queued"
+	auditSent     = "sent"
+	auditDeferred = "deferred"
+	auditFailed   = "failed"
+)
+
+// dummyHash is compared against when a username does not exist, so that
+// unknown and known usernames take as long to reject.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("besend"), bcrypt.DefaultCost)
+
+// startSubmission runs the submission server on SUBMISSION_ADDR (default
+// :587). It needs a certificate for STARTTLS, as credentials are only
+// accepted over TLS; without SUBMISSION_TLS_CERT and SUBMISSION_TLS_KEY it
+// does not start, unless SUBMISSION_ALLOW_INSECURE_AUTH is set for local
+// testing.
+func startSubmission() {
+	server := &submission.Server{
+		Addr:              os.Getenv("SUBMISSION_ADDR"),
+		Hostname:          os.Getenv("SUBMISSION_HOSTNAME"),
+		AllowInsecureAuth: os.Getenv("SUBMISSION_ALLOW_INSECURE_AUTH") == "true",
+		Auth:              authenticateSubmission,
+		CheckSender:       checkSubmissionSender,
+		Handler:           enqueueSubmission,
+	}
+	if server.Addr == "" {
+		server.Addr = ":587"
+	}
+	if server.Hostname == "" {
+		server.Hostname, _ = os.Hostname()
+	}
+
+	certFile, keyFile := os.Getenv("SUBMISSION_TLS_CERT"), os.Getenv("SUBMISSION_TLS_KEY")
+	if _, err := os.Stat(certFile); certFile != "" && os.IsNotExist(err) {
+		// The certificate secret is optional in the deployment.
+		log.Printf("Submission certificate %s not found", certFile)
+		certFile = ""
+	}
+	if certFile != "" && keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			log.Fatalf("Failed to load submission certificate: %v", err)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	} else if !server.AllowInsecureAuth {
+		log.Println("SUBMISSION_TLS_CERT or SUBMISSION_TLS_KEY not set; SMTP submission is disabled")
+		return
+	}
+
+	go func() {
+		log.Printf("SMTP submission listening on %s", server.Addr)
+		if err := server.ListenAndServe(); err != nil {
+			log.Fatalf("SMTP submission server failed: %v", err)
+		}
+	}()
+}
+
+// authenticateSubmission checks a credential's current password, or the
+// one it replaced while the rotation overlap lasts, and that it is used
+// from an allowed network.
+func authenticateSubmission(remote net.Addr, username, password string) (*submission.Account, error) {
+	account := &submission.Account{Username: username}
+	var hash string
+	var previous sql.NullString
+	var previousValid, revoked bool
+	var restrictions CredentialRestrictions
+	err := db.QueryRow(
+		`SELECT c.id, c.organization_id, c.password, c.previous_password,
+			COALESCE(c.previous_expires_at > NOW(), false), c.revoked_at IS NOT NULL, c.allowed_cidrs, o.customer_id
+		FROM smtp_credentials c JOIN organizations o ON o.id = c.organization_id
+		WHERE c.username = $1`,
+		username,
+	).Scan(&account.CredentialID, &account.OrganizationID, &hash, &previous, &previousValid, &revoked,
+		pq.Array(&restrictions.AllowedCIDRs), &account.CustomerID)
+	if err == sql.ErrNoRows || revoked {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, submission.ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	matched := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	if !matched && previous.Valid && previousValid {
+		matched = bcrypt.CompareHashAndPassword([]byte(previous.String), []byte(password)) == nil
+	}
+	if !matched {
+		return nil, submission.ErrInvalidCredentials
+	}
+
+	ip := remoteIP(remote)
+	if !restrictions.allowsIP(ip) {
+		log.Printf("SMTP credential %s refused from %s", account.CredentialID, ip)
+		return nil, &submission.Rejection{Code: 535, Message: "5.7.1 Authentication not permitted from this address"}
+	}
+	if _, err := db.Exec(
+		"UPDATE smtp_credentials SET last_used_at = NOW(), last_used_ip = $1 WHERE id = $2",
+		ip.String(), account.CredentialID,
+	); err != nil {
+		log.Printf("Error recording use of SMTP credential %s: %v", account.CredentialID, err)
+	}
+	return account, nil
+}
+
+func remoteIP(addr net.Addr) net.IP {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP
+	}
+	host, _, _ := net.SplitHostPort(addr.String())
+	return net.ParseIP(host)
+}
+
+// checkSubmissionSender only lets an account send from verified domains of
+// its organization that its credential is allowed to use. It also refuses
+// credentials revoked since the session logged in.
+func checkSubmissionSender(account *submission.Account, from string) error {
+	_, domain, ok := strings.Cut(from, "@")
+	if !ok {
+		return &submission.Rejection{Code: 553, Message: "5.1.7 Invalid sender address"}
+	}
+	var verified sql.NullBool
+	var revoked bool
+	var restrictions CredentialRestrictions
+	err := db.QueryRow(
+		`SELECT d.verified, c.revoked_at IS NOT NULL, c.allowed_domains
+		FROM smtp_credentials c
+		LEFT JOIN sending_domains d ON d.organization_id = c.organization_id AND LOWER(d.domain) = LOWER($2)
+		WHERE c.id = $1`,
+		account.CredentialID, domain,
+	).Scan(&verified, &revoked, pq.Array(&restrictions.AllowedDomains))
+	if err == sql.ErrNoRows || revoked {
+		return &submission.Rejection{Code: 535, Message: "5.7.8 Credential is no longer valid"}
+	}
+	if err != nil {
+		return err
+	}
+	if !verified.Bool {
+		return &submission.Rejection{Code: 550, Message: fmt.Sprintf("5.7.1 %s is not a verified sending domain of this account", domain)}
+	}
+	if !restrictions.allowsDomain(domain) {
+		return &submission.Rejection{Code: 550, Message: fmt.Sprintf("5.7.1 This credential may not send from %s", domain)}
+	}
+	return nil
+}
+
+// enqueueSubmission checks an accepted message and queues it for delivery.
+// Its From addresses must also be verified domains, so that a credential
+// cannot pass mail off as coming from elsewhere. As RFC 6409 section 8
+// allows, a Message-ID and Date are added when the client left them out,
+// and any Bcc header is removed so that the blind recipients are not shown
+// to everyone else.
+func enqueueSubmission(account *submission.Account, from string, to []string, data []byte) error {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return &submission.Rejection{Code: 550, Message: "5.6.0 Message headers could not be parsed"}
+	}
+	authors, err := msg.Header.AddressList("From")
+	if err != nil || len(authors) == 0 {
+		return &submission.Rejection{Code: 550, Message: "5.6.0 Message has no valid From header"}
+	}
+	for _, author := range authors {
+		if err := checkSubmissionSender(account, author.Address); err != nil {
+			return err
+		}
+	}
+	_, fromDomain, _ := strings.Cut(authors[0].Address, "@")
+
+	var added bytes.Buffer
+	messageID := strings.Trim(msg.Header.Get("Message-ID"), "<> ")
+	if messageID == "" {
+		messageID = fmt.Sprintf("%s@%s", generateID(), fromDomain)
+		fmt.Fprintf(&added, "Message-ID: <%s>\r\n", messageID)
+	}
+	if msg.Header.Get("Date") == "" {
+		fmt.Fprintf(&added, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	}
+	data = append(added.Bytes(), stripHeader(data, "Bcc")...)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkHourlyLimit(tx, account.CredentialID, len(to)); err != nil {
+		return err
+	}
+
+	queueID := generateID()
+	_, err = tx.Exec(
+		`INSERT INTO submission_queue (id, organization_id, credential_id, envelope_from, from_domain, recipients, message, message_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		queueID, account.OrganizationID, account.CredentialID, from, strings.ToLower(fromDomain), pq.Array(to), data, messageID,
+	)
+	if err != nil {
+		return err
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+	for _, rcpt := range to {
+		if _, err := tx.Exec(
+			`INSERT INTO email_audit_logs (id, customer_id, organization_id, credential_id, queue_id, postal_message_id, sender_email, recipient_email, subject, status)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+			generateID(), account.CustomerID, account.OrganizationID, account.CredentialID, queueID, messageID, from, rcpt, subject, auditQueued,
+		); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	wakeDelivery()
+	return nil
+}
+
+// stripHeader removes every field called name, with its continuation
+// lines, from the header section of msg. The body is left as is.
+func stripHeader(msg []byte, name string) []byte {
+	out := make([]byte, 0, len(msg))
+	dropping := false
+	for rest := msg; len(rest) > 0; {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+		}
+		rest = rest[len(line):]
+
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			// The blank line ending the header section.
+			out = append(out, line...)
+			return append(out, rest...)
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			field, _, _ := bytes.Cut(line, []byte(":"))
+			dropping = strings.EqualFold(strings.TrimSpace(string(field)), name)
+		}
+		if !dropping {
+			out = append(out, line...)
+		}
+	}
+	return out
+}
+
+// checkHourlyLimit refuses a message that would take its credential over
+// its hourly limit, counted in recipients. The credential row stays locked
+// until tx ends, so concurrent sessions cannot both slip under the limit.
+func checkHourlyLimit(tx *sql.Tx, credentialID string, recipients int) error {
+	var limit sql.NullInt64
+	if err := tx.QueryRow(
+		"SELECT hourly_limit FROM smtp_credentials WHERE id = $1 FOR UPDATE", credentialID,
+	).Scan(&limit); err != nil {
+		return err
+	}
+	if !limit.Valid || limit.Int64 <= 0 {
+		return nil
+	}
+	var sent int64
+	if err := tx.QueryRow(
+		"SELECT COUNT(*) FROM email_audit_logs WHERE credential_id = $1 AND created_at > NOW() - INTERVAL '1 hour'",
+		credentialID,
+	).Scan(&sent); err != nil {
+		return err
+	}
+	if sent+int64(recipients) > limit.Int64 {
+		return &submission.Rejection{Code: 451, Message: fmt.Sprintf("4.7.1 Hourly sending limit of %d recipients reached for this credential", limit.Int64)}
+	}
+	return nil
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Gatete-Bruno/besend/pkg/dkim"
+	"github.com/gin-gonic/gin"
+)
+
+// TXTResolver looks up TXT records. It is satisfied by *net.Resolver and
+// replaced in tests by a fake zone.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// resolver is used for verification lookups. DNS_SERVER points it at a
+// specific server, e.g. 1.1.1.1:53, instead of the cluster's caching one,
+// so newly published records are seen sooner.
+var resolver TXTResolver = net.DefaultResolver
+
+// spfInclude is the SPF mechanism customers add so our servers may send
+// for them.
+var spfInclude = "include:besend.rw"
+
+const (
+	ownershipPrefix = "besend-verification="
+	lookupTimeout   = 10 * time.Second
+)
+
+// RecordCheck is the outcome of checking one DNS record. Diff lists, one
+// per line, what is missing from the published record (prefixed "-") and
+// what it has instead (prefixed "+").
+type RecordCheck struct {
+	Record   string   `json:"record"`
+	Host     string   `json:"host"`
+	Expected string   `json:"expected"`
+	Found    []string `json:"found"`
+	Pass     bool     `json:"pass"`
+	Diff     string   `json:"diff,omitempty"`
+	Error    string   `json:"error,omitempty"`
+
+	// temporary is set when a lookup failed, leaving the outcome unknown.
+	temporary bool
+}
+
+type DomainVerification struct {
+	Domain    string        `json:"domain"`
+	Verified  bool          `json:"verified"`
+	CheckedAt string        `json:"checked_at"`
+	Records   []RecordCheck `json:"records"`
+}
+
+func init() {
+	if server := os.Getenv("DNS_SERVER"); server != "" {
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+	if include := os.Getenv("SPF_INCLUDE"); include != "" {
+		spfInclude = "include:" + strings.ToLower(include)
+	}
+}
+
+func newVerificationToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func spfRecord() string {
+	return "v=spf1 " + spfInclude + " ~all"
+}
+
+func dmarcRecord(domain string) string {
+	return fmt.Sprintf("v=DMARC1; p=quarantine; rua=mailto:admin@%s", domain)
+}
+
+func ownershipHost(domain string) string {
+	return "_besend." + domain
+}
+
+// verifyDomain checks the records of a sending domain and stores the
+// outcome. A domain is verified when all of them pass. When a lookup fails
+// for a reason other than the record not existing, the previous state is
+// kept, so a DNS outage does not block a verified domain.
+func verifyDomain(ctx context.Context, domainID string) (*DomainVerification, error) {
+	var domain string
+	var token sql.NullString
+	err := db.QueryRowContext(ctx,
+		"SELECT domain, verification_token FROM sending_domains WHERE id = $1", domainID,
+	).Scan(&domain, &token)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		// Domains added before verification existed get their token on
+		// first check.
+		token.String = newVerificationToken()
+		if _, err := db.ExecContext(ctx,
+			"UPDATE sending_domains SET verification_token = $1 WHERE id = $2", token.String, domainID,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	var selector, dkimRecord string
+	err = db.QueryRowContext(ctx,
+		"SELECT selector, dns_record FROM dkim_keys WHERE domain_id = $1 AND status = $2", domainID, dkimActive,
+	).Scan(&selector, &dkimRecord)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	result := &DomainVerification{
+		Domain:    domain,
+		CheckedAt: time.Now().Format(time.RFC3339),
+		Records: []RecordCheck{
+			checkOwnership(ctx, domain, token.String),
+			checkSPF(ctx, domain),
+			checkDKIM(ctx, domain, selector, dkimRecord),
+			checkDMARC(ctx, domain),
+		},
+	}
+	result.Verified = true
+	inconclusive := false
+	for _, check := range result.Records {
+		result.Verified = result.Verified && check.Pass
+		inconclusive = inconclusive || check.temporary
+	}
+
+	results, err := json.Marshal(result.Records)
+	if err != nil {
+		return nil, err
+	}
+	if inconclusive {
+		_, err = db.ExecContext(ctx,
+			"UPDATE sending_domains SET verification_results = $1, last_checked_at = NOW() WHERE id = $2",
+			results, domainID,
+		)
+		if err == nil {
+			err = db.QueryRowContext(ctx, "SELECT verified FROM sending_domains WHERE id = $1", domainID).Scan(&result.Verified)
+		}
+	} else {
+		_, err = db.ExecContext(ctx,
+			`UPDATE sending_domains SET verified = $1, verification_results = $2, last_checked_at = NOW(),
+				verified_at = CASE WHEN $1 AND NOT verified THEN NOW() WHEN $1 THEN verified_at END
+			WHERE id = $3`,
+			result.Verified, results, domainID,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// lookup fetches TXT records, treating a missing name as no records. Other
+// failures are recorded on check as temporary.
+func lookup(ctx context.Context, check *RecordCheck) []string {
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	records, err := resolver.LookupTXT(ctx, check.Host)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return nil
+	}
+	if err != nil {
+		check.Error = fmt.Sprintf("lookup failed: %v", err)
+		check.temporary = true
+		return nil
+	}
+	return records
+}
+
+func checkOwnership(ctx context.Context, domain, token string) RecordCheck {
+	check := RecordCheck{Record: "ownership", Host: ownershipHost(domain), Expected: ownershipPrefix + token}
+	for _, record := range lookup(ctx, &check) {
+		if strings.HasPrefix(record, ownershipPrefix) {
+			check.Found = append(check.Found, record)
+			check.Pass = check.Pass || record == check.Expected
+		}
+	}
+	if !check.Pass && check.Error == "" {
+		check.Diff = diffTokens([]string{check.Expected}, check.Found)
+	}
+	return check
+}
+
+func checkSPF(ctx context.Context, domain string) RecordCheck {
+	check := RecordCheck{Record: "spf", Host: domain, Expected: spfRecord()}
+	for _, record := range lookup(ctx, &check) {
+		if record == "v=spf1" || strings.HasPrefix(strings.ToLower(record), "v=spf1 ") {
+			check.Found = append(check.Found, record)
+		}
+	}
+	switch {
+	case check.Error != "":
+	case len(check.Found) > 1:
+		// Receivers treat several SPF records as a permanent error.
+		check.Error = "more than one SPF record is published"
+	case len(check.Found) == 1:
+		got := strings.Fields(strings.ToLower(check.Found[0]))
+		for _, term := range got {
+			check.Pass = check.Pass || term == spfInclude || term == "+"+spfInclude
+		}
+		if !check.Pass {
+			check.Diff = diffTokens(strings.Fields(check.Expected), got)
+		}
+	default:
+		check.Diff = diffTokens(strings.Fields(check.Expected), nil)
+	}
+	return check
+}
+
+func checkDKIM(ctx context.Context, domain, selector, expected string) RecordCheck {
+	check := RecordCheck{Record: "dkim", Expected: expected}
+	if selector == "" {
+		check.Error = "the domain has no active DKIM key; rotate and activate one"
+		return check
+	}
+	check.Host = dkim.RecordName(selector, domain)
+	want := dkimTags(expected)
+	for _, record := range lookup(ctx, &check) {
+		check.Found = append(check.Found, record)
+		got := dkimTags(record)
+		if strings.ReplaceAll(got["p"], " ", "") == want["p"] && (got["k"] == want["k"] || (got["k"] == "" && want["k"] == dkim.AlgorithmRSA)) {
+			check.Pass = true
+		}
+	}
+	if !check.Pass && check.Error == "" {
+		var found []string
+		if len(check.Found) > 0 {
+			found = splitTags(check.Found[0])
+		}
+		check.Diff = diffTokens(splitTags(expected), found)
+	}
+	return check
+}
+
+// checkDMARC passes for any DMARC policy; the expected record is only a
+// suggestion.
+func checkDMARC(ctx context.Context, domain string) RecordCheck {
+	check := RecordCheck{Record: "dmarc", Host: "_dmarc." + domain, Expected: dmarcRecord(domain)}
+	for _, record := range lookup(ctx, &check) {
+		if strings.HasPrefix(strings.ToUpper(strings.ReplaceAll(record, " ", "")), "V=DMARC1;") {
+			check.Found = append(check.Found, record)
+			check.Pass = dkimTags(record)["p"] != ""
+		}
+	}
+	if len(check.Found) > 1 {
+		check.Pass = false
+		check.Error = "more than one DMARC record is published"
+	}
+	if !check.Pass && check.Error == "" {
+		var found []string
+		if len(check.Found) > 0 {
+			found = splitTags(check.Found[0])
+		}
+		check.Diff = diffTokens(splitTags(check.Expected), found)
+	}
+	return check
+}
+
+func splitTags(record string) []string {
+	var tags []string
+	for _, tag := range strings.Split(record, ";") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func dkimTags(record string) map[string]string {
+	tags := map[string]string{}
+	for _, tag := range splitTags(record) {
+		if name, value, ok := strings.Cut(tag, "="); ok {
+			tags[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	return tags
+}
+
+// diffTokens lists the tokens of want missing from got and the tokens of
+// got not in want.
+func diffTokens(want, got []string) string {
+	in := func(list []string, s string) bool {
+		for _, t := range list {
+			if strings.EqualFold(t, s) {
+				return true
+			}
+		}
+		return false
+	}
+	var lines []string
+	for _, t := range want {
+		if !in(got, t) {
+			lines = append(lines, "-"+t)
+		}
+	}
+	for _, t := range got {
+		if !in(want, t) {
+			lines = append(lines, "+"+t)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func verifyDomainHandler(c *gin.Context) {
+	orgID := c.Param("id")
+	name := c.Param("domain")
+
+	var domainID string
+	err := db.QueryRow(
+		"SELECT id FROM sending_domains WHERE organization_id = $1 AND LOWER(domain) = LOWER($2)",
+		orgID, name,
+	).Scan(&domainID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Domain not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying domain: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify domain"})
+		return
+	}
+
+	result, err := verifyDomain(c.Request.Context(), domainID)
+	if err != nil {
+		log.Printf("Error verifying domain %s: %v", name, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify domain"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// recheckDomains verifies every domain each interval, so that new domains
+// become verified once their records are published and domains whose
+// records are removed stop being verified.
+func recheckDomains(interval time.Duration) {
+	for range time.Tick(interval) {
+		rows, err := db.Query("SELECT id FROM sending_domains ORDER BY last_checked_at NULLS FIRST")
+		if err != nil {
+			log.Printf("Error listing domains to verify: %v", err)
+			continue
+		}
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err == nil {
+				ids = append(ids, id)
+			}
+		}
+		rows.Close()
+
+		for _, id := range ids {
+			if _, err := verifyDomain(context.Background(), id); err != nil {
+				log.Printf("Error verifying domain %s: %v", id, err)
+			}
+		}
+	}
+}